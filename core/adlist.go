@@ -86,8 +86,69 @@ func (l *List) listInsertNode(oldNode *listNode, value interface{}, after int) *
 	node := new(listNode)
 	node.value = value
 	if after > 0 {
-
+		node.prev = oldNode
+		node.next = oldNode.next
+		if l.tail == oldNode {
+			l.tail = node
+		}
+	} else {
+		node.next = oldNode
+		node.prev = oldNode.prev
+		if l.head == oldNode {
+			l.head = node
+		}
+	}
+	if node.prev != nil {
+		node.prev.next = node
+	}
+	if node.next != nil {
+		node.next.prev = node
 	}
 	l.len++
 	return l
 }
+
+// listIndex 返回下标为index的节点 负数表示从尾部开始 -1为最后一个 越界返回nil
+func (l *List) listIndex(index int) *listNode {
+	var n *listNode
+	if index < 0 {
+		index = (-index) - 1
+		n = l.tail
+		for ; index > 0 && n != nil; index-- {
+			n = n.prev
+		}
+	} else {
+		n = l.head
+		for ; index > 0 && n != nil; index-- {
+			n = n.next
+		}
+	}
+	return n
+}
+
+// listDelNode 从链表中删除节点
+func (l *List) listDelNode(node *listNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		l.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		l.tail = node.prev
+	}
+	node.prev = nil
+	node.next = nil
+	l.len--
+}
+
+// listSearchKey 查找值等于key的第一个节点 不存在返回nil
+func (l *List) listSearchKey(key interface{}) *listNode {
+	for node := l.head; node != nil; node = node.next {
+		if node.value == key {
+			return node
+		}
+	}
+	return nil
+}
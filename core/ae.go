@@ -0,0 +1,50 @@
+package core
+
+import "time"
+
+// 事件循环 参考redis的ae
+// 每个连接由独立的goroutine读写网络 但命令的执行、阻塞客户端的唤醒与超时、
+// 以及serverCron都投递到同一个goroutine中串行执行
+// 因此db、pubsub、aof等服务端状态无需加锁
+
+// aeEvent 投递到事件循环中执行的任务
+type aeEvent struct {
+	proc func()
+	done chan struct{}
+}
+
+// CreateEventLoop 创建事件循环的任务队列 需在AeMain和Exec之前调用
+func (s *Server) CreateEventLoop() {
+	s.events = make(chan *aeEvent)
+}
+
+// AeMain 事件循环 依次执行投递的任务 并按Hz的频率执行serverCron
+// 每处理完一个任务或serverCron后执行beforeSleep
+func (s *Server) AeMain() {
+	hz := s.Hz
+	ticker := time.NewTicker(time.Second / time.Duration(hz))
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-s.events:
+			ev.proc()
+			close(ev.done)
+		case <-ticker.C:
+			s.ServerCron()
+		}
+		s.beforeSleep()
+		/* The hz may be changed by CONFIG SET. */
+		if hz != s.Hz {
+			hz = s.Hz
+			ticker.Reset(time.Second / time.Duration(hz))
+		}
+	}
+}
+
+// Exec 将proc投递到事件循环中执行 并等待其执行完成
+// 所有访问服务端状态的代码都必须通过Exec执行
+func (s *Server) Exec(proc func()) {
+	ev := &aeEvent{proc: proc, done: make(chan struct{})}
+	s.events <- ev
+	<-ev.done
+}
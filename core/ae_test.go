@@ -0,0 +1,286 @@
+package core
+
+import (
+	"fmt"
+	"godis/core/proto"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServer 切换到临时目录并初始化服务端实例 测试结束时恢复工作目录
+func newTestServer(t *testing.T) *Server {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+	return createTestServer()
+}
+
+// createTestServer 在当前目录初始化服务端实例 与godis-server的initServer相同
+// 可以用来模拟重启后重新加载数据
+func createTestServer() *Server {
+	s := new(Server)
+	s.InitServerConfig()
+	s.Pid = os.Getpid()
+	s.Db = make([]*GodisDb, s.DbNum)
+	for i := 0; i < s.DbNum; i++ {
+		s.Db[i] = s.CreateDb(i)
+	}
+	s.CreateEventLoop()
+	s.Start = mstime()
+	s.AofSelectedDb = -1
+	s.AofRewriteTimeStart = -1
+	s.RdbSaveTimeStart = -1
+	s.Lastsave = time.Now().Unix()
+	s.PopulateCommandTable()
+	tmp := make(map[string]*List)
+	s.PubSubChannels = &tmp
+	s.BioInit()
+	return s
+}
+
+// startTestServer 加载数据 打开aof并启动事件循环
+func startTestServer(t *testing.T, s *Server) {
+	if s.AofEnabled {
+		s.AofState = AOF_ON
+	}
+	s.AofLoadManifestFromDisk()
+	if s.AofState == AOF_ON {
+		if ret := s.LoadAppendOnlyFiles(); ret != AOF_OK && ret != AOF_NOT_EXIST {
+			t.Fatalf("LoadAppendOnlyFiles: %d", ret)
+		}
+	}
+	if err := s.OpenAppendOnlyFile(); err != nil {
+		t.Fatal(err)
+	}
+	s.AofDelHistoryFiles()
+	go s.AeMain()
+}
+
+// waitForChild 等待后台保存或重写结束 其结果由serverCron处理
+func waitForChild(t *testing.T, s *Server) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var active bool
+		s.Exec(func() {
+			active = hasActiveChildProcess(s) || s.AofRewriteScheduled || s.RdbBgsaveScheduled
+		})
+		if !active {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the background job")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testConn 测试用的客户端连接
+type testConn struct {
+	conn net.Conn
+	enc  *proto.Encoder
+	dec  *proto.Decoder
+}
+
+// dialTestServer 通过内存中的连接连接到服务端
+func dialTestServer(s *Server) *testConn {
+	client, server := net.Pipe()
+	go s.ServeClient(server)
+	return &testConn{conn: client, enc: proto.NewEncoder(client), dec: proto.NewDecoder(client)}
+}
+
+// send 发送一条命令
+func (tc *testConn) send(args ...string) error {
+	multi := make([]*proto.Resp, len(args))
+	for i, arg := range args {
+		multi[i] = proto.NewBulkBytes([]byte(arg))
+	}
+	return tc.enc.EncodeMultiBulk(multi, true)
+}
+
+// do 发送一条命令并读取回复 出错时结束测试 不能在测试的goroutine之外调用
+func (tc *testConn) do(t *testing.T, args ...string) *proto.Resp {
+	t.Helper()
+	if err := tc.send(args...); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	r, err := tc.dec.Decode()
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	if r.Type == proto.TypeError {
+		t.Fatalf("%v: %s", args, r.Value)
+	}
+	return r
+}
+
+// call 与do相同 但返回错误而不是结束测试 用于其它goroutine
+func (tc *testConn) call(args ...string) (*proto.Resp, error) {
+	if err := tc.send(args...); err != nil {
+		return nil, err
+	}
+	r, err := tc.dec.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if r.Type == proto.TypeError {
+		return nil, fmt.Errorf("%v: %s", args, r.Value)
+	}
+	return r, nil
+}
+
+// TestConcurrentClients 多个客户端并发读写 同时执行后台保存、重写以及打开关闭aof
+// 所有状态只在事件循环中访问 用go test -race运行时不应出现数据竞争
+func TestConcurrentClients(t *testing.T) {
+	s := newTestServer(t)
+	startTestServer(t, s)
+
+	const writers = 8
+	const iterations = 200
+	const consumers = 4
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers+consumers+2)
+
+	/* Subscriber: every message published by the writers is received. */
+	sub := dialTestServer(s)
+	defer sub.conn.Close()
+	if err := sub.send("subscribe", "news"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.dec.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writers*iterations; i++ {
+			if _, err := sub.dec.Decode(); err != nil {
+				errs <- fmt.Errorf("subscriber: %v", err)
+				return
+			}
+		}
+	}()
+
+	/* Consumers: BLPOP until all the pushed elements are popped. */
+	popped := make(chan string, writers*iterations)
+	for i := 0; i < consumers; i++ {
+		tc := dialTestServer(s)
+		defer tc.conn.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				r, err := tc.call("blpop", "queue", "2")
+				if err != nil {
+					errs <- fmt.Errorf("consumer: %v", err)
+					return
+				}
+				if r.Array == nil {
+					/* Timeout: the writers are done. */
+					return
+				}
+				popped <- string(r.Array[1].Value)
+			}
+		}()
+	}
+
+	/* Writers */
+	for i := 0; i < writers; i++ {
+		tc := dialTestServer(s)
+		defer tc.conn.Close()
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				key := "key:" + strconv.Itoa(rand.Intn(50))
+				cmds := [][]string{
+					{"set", key, strconv.Itoa(j)},
+					{"incr", "counter"},
+					{"lpush", "queue", fmt.Sprintf("%d-%d", id, j)},
+					{"zadd", "zset", strconv.Itoa(j), key},
+					{"sadd", "set", key},
+					{"hset", "hash", key, strconv.Itoa(j)},
+					{"expire", key, "100"},
+					{"publish", "news", key},
+				}
+				for _, cmd := range cmds {
+					if _, err := tc.call(cmd...); err != nil {
+						errs <- fmt.Errorf("writer %d: %v", id, err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+
+	/* Persistence in the background while the clients are running. */
+	admin := dialTestServer(s)
+	defer admin.conn.Close()
+	done := make(chan struct{})
+	var adminWg sync.WaitGroup
+	adminWg.Add(1)
+	go func() {
+		defer adminWg.Done()
+		cmds := [][]string{
+			{"bgrewriteaof"},
+			{"bgsave", "schedule"},
+			{"config", "set", "appendonly", "no"},
+			{"config", "set", "appendonly", "yes"},
+		}
+		for n := 0; ; n++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := admin.call(cmds[n%len(cmds)]...); err != nil {
+				/* A rewrite or a save may be already in progress. */
+				if n%len(cmds) >= 2 {
+					errs <- fmt.Errorf("admin: %v", err)
+					return
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	adminWg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		return
+	}
+
+	/* Every command was executed exactly once. */
+	tc := dialTestServer(s)
+	defer tc.conn.Close()
+	if r := tc.do(t, "get", "counter"); string(r.Value) != strconv.Itoa(writers*iterations) {
+		t.Errorf("counter = %s, want %d", r.Value, writers*iterations)
+	}
+	if n := len(popped); n != writers*iterations {
+		t.Errorf("popped %d elements, want %d", n, writers*iterations)
+	}
+	if r := tc.do(t, "llen", "queue"); string(r.Value) != "0" {
+		t.Errorf("llen queue = %s, want 0", r.Value)
+	}
+
+	/* Leave the server idle: the event loop keeps running after the test. */
+	waitForChild(t, s)
+	tc.do(t, "config", "set", "save", "")
+	tc.do(t, "config", "set", "appendonly", "no")
+}
@@ -1,43 +1,1689 @@
 package core
 
 import (
-	"bytes"
+	"bufio"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"godis/core/proto"
+	"io"
 	"os"
-	"syscall"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-//AppendToFile 写文件
-func AppendToFile(fileName string, content string) error {
-	// 以只写的模式，打开文件
-	f, err := os.OpenFile(fileName, os.O_WRONLY|syscall.O_CREAT, 0644)
+// aof的状态
+const AOF_OFF = 0          /* AOF is off */
+const AOF_ON = 1           /* AOF is on */
+const AOF_WAIT_REWRITE = 2 /* AOF waits rewrite to start appending */
+
+/* Return values of LoadAppendOnlyFiles() */
+const AOF_OK = 0
+const AOF_NOT_EXIST = 1
+const AOF_EMPTY = 2
+const AOF_TRUNCATED = 3
+
+// appendfsync的取值
+const AOF_FSYNC_NO = 0
+const AOF_FSYNC_ALWAYS = 1
+const AOF_FSYNC_EVERYSEC = 2
+
+const AOF_WRITE_LOG_ERROR_RATE = 30 /* Seconds between errors logging. */
+
+// 写磁盘出错的类型 出错时拒绝写命令
+const DISK_ERROR_TYPE_AOF = 1  /* Don't accept writes: AOF errors. */
+const DISK_ERROR_TYPE_RDB = 2  /* Don't accept writes: RDB errors. */
+const DISK_ERROR_TYPE_NONE = 0 /* No problems, we can accept writes. */
+
+/*-----------------------------------------------------------------------------
+ * AOF Manifest file implementation.
+ *
+ * The following code implements the read/write logic of AOF manifest file, which
+ * is used to track and manage all AOF files.
+ *
+ * Append-only files consist of three types:
+ *
+ * BASE: Represents a Godis snapshot from the time of last AOF rewrite. The manifest
+ * file contains at most a single BASE file, which will always be the first file in the
+ * list.
+ *
+ * INCR: Represents all write commands executed by Godis following the last successful
+ * AOF rewrite. In some cases it is possible to have several ordered INCR files. For
+ * example:
+ *   - During an on-going AOF rewrite
+ *   - After an AOF rewrite was aborted/failed, and before the next one succeeded.
+ *
+ * HISTORY: After a successful rewrite, the previous BASE and INCR become HISTORY files.
+ * They will be automatically removed.
+ *
+ * The following is a possible AOF manifest file content:
+ *
+ * file godis.aof.2.base.rdb seq 2 type b
+ * file godis.aof.1.incr.aof seq 1 type h
+ * file godis.aof.2.incr.aof seq 2 type h
+ * file godis.aof.3.incr.aof seq 3 type h
+ * file godis.aof.4.incr.aof seq 4 type i
+ * file godis.aof.5.incr.aof seq 5 type i
+ * ------------------------------------------------------------------------- */
+
+// manifest中aof文件的类型
+const AOF_FILE_TYPE_BASE = 'b' /* BASE file */
+const AOF_FILE_TYPE_HIST = 'h' /* HISTORY file */
+const AOF_FILE_TYPE_INCR = 'i' /* INCR file */
+
+/* AOF manifest key. */
+const AOF_MANIFEST_KEY_FILE_NAME = "file"
+const AOF_MANIFEST_KEY_FILE_SEQ = "seq"
+const AOF_MANIFEST_KEY_FILE_TYPE = "type"
+
+const MANIFEST_MAX_LINE = 1024
+
+// aof文件名的组成部分
+const BASE_FILE_SUFFIX = ".base"
+const INCR_FILE_SUFFIX = ".incr"
+const RDB_FORMAT_SUFFIX = ".rdb"
+const AOF_FORMAT_SUFFIX = ".aof"
+const MANIFEST_NAME_SUFFIX = ".manifest"
+const TEMP_FILE_NAME_PREFIX = "temp-"
+
+// aofInfo manifest中记录的一个aof文件
+type aofInfo struct {
+	fileName string // 文件名 位于appenddirname目录中
+	fileSeq  int64  // 文件的序号
+	fileType byte   // AOF_FILE_TYPE_*
+}
+
+// aofManifest 组成aof的所有文件
+// 修改时先复制一份 持久化成功之后再替换server中的manifest
+type aofManifest struct {
+	baseAofInfo     *aofInfo   // BASE文件 没有时为nil
+	incrAofList     []*aofInfo // INCR文件 重写失败时可能有多个 按加载的顺序排列
+	historyAofList  []*aofInfo // 重写成功后之前的BASE和INCR文件 等待删除
+	currBaseFileSeq int64      // 当前BASE文件的序号
+	currIncrFileSeq int64      // 最后一个INCR文件的序号
+	dirty           bool       // 与磁盘上的manifest不一致 需要持久化
+}
+
+/* Format aofInfo as a string and it will be a line in the manifest. */
+func aofInfoFormat(ai *aofInfo) string {
+	filename := ai.fileName
+	if needsRepr(filename) {
+		filename = catRepr(filename)
+	}
+	return fmt.Sprintf("%s %s %s %d %s %c\n",
+		AOF_MANIFEST_KEY_FILE_NAME, filename,
+		AOF_MANIFEST_KEY_FILE_SEQ, ai.fileSeq,
+		AOF_MANIFEST_KEY_FILE_TYPE, ai.fileType)
+}
+
+/* Method to duplicate an AOF manifest, the aofInfo are duplicated as well
+ * so that the copy can be modified without touching the original. */
+func aofManifestDup(orig *aofManifest) *aofManifest {
+	dupInfo := func(ai *aofInfo) *aofInfo {
+		ret := *ai
+		return &ret
+	}
+	am := *orig
+	if orig.baseAofInfo != nil {
+		am.baseAofInfo = dupInfo(orig.baseAofInfo)
+	}
+	am.incrAofList = make([]*aofInfo, len(orig.incrAofList))
+	for i, ai := range orig.incrAofList {
+		am.incrAofList[i] = dupInfo(ai)
+	}
+	am.historyAofList = make([]*aofInfo, len(orig.historyAofList))
+	for i, ai := range orig.historyAofList {
+		am.historyAofList[i] = dupInfo(ai)
+	}
+	return &am
+}
+
+/* Convert aofManifest to a string. */
+func getAofManifestAsString(am *aofManifest) string {
+	var buf strings.Builder
+
+	/* 1. Add BASE File information, it is always at the beginning
+	 * of the manifest file. */
+	if am.baseAofInfo != nil {
+		buf.WriteString(aofInfoFormat(am.baseAofInfo))
+	}
+
+	/* 2. Add HISTORY type AOF information. */
+	for _, ai := range am.historyAofList {
+		buf.WriteString(aofInfoFormat(ai))
+	}
+
+	/* 3. Add INCR type AOF information. */
+	for _, ai := range am.incrAofList {
+		buf.WriteString(aofInfoFormat(ai))
+	}
+	return buf.String()
+}
+
+// getAofManifestFileName manifest的文件名
+func getAofManifestFileName(s *Server) string {
+	return s.AofFilename + MANIFEST_NAME_SUFFIX
+}
+
+// getTempAofManifestFileName 持久化manifest时使用的临时文件名
+func getTempAofManifestFileName(s *Server) string {
+	return TEMP_FILE_NAME_PREFIX + s.AofFilename + MANIFEST_NAME_SUFFIX
+}
+
+// aofFilePath aof目录中的文件的路径
+func aofFilePath(s *Server, filename string) string {
+	return filepath.Join(s.AofDirname, filename)
+}
+
+/* Load the manifest information from the disk to s.aofManifest
+ * when the server starts.
+ *
+ * During loading, this function does strict error checking and will abort
+ * the entire Godis server process on error (I/O error, invalid format, etc.)
+ *
+ * If the AOF directory or manifest file do not exist, this will be ignored
+ * in order to support seamless upgrades from previous versions which did not
+ * use them. */
+func (s *Server) AofLoadManifestFromDisk() {
+	s.aofManifest = new(aofManifest)
+	if !dirExists(s.AofDirname) {
+		serverLog(s, LL_DEBUG, "The AOF directory %s doesn't exist", s.AofDirname)
+		return
+	}
+
+	amName := getAofManifestFileName(s)
+	amFilepath := aofFilePath(s, amName)
+	if !fileExist(amFilepath) {
+		serverLog(s, LL_DEBUG, "The AOF manifest file %s doesn't exist", amName)
+		return
+	}
+
+	am, err := aofLoadManifestFromFile(amFilepath)
 	if err != nil {
-		log.Println("aof file open failed" + err.Error())
-	} else {
-		n, _ := f.Seek(0, os.SEEK_END)
-		_, err = f.WriteAt([]byte(content), n)
+		serverLog(s, LL_WARNING, "*** FATAL AOF MANIFEST FILE ERROR ***")
+		serverLog(s, LL_WARNING, "%s", err)
+		os.Exit(1)
+	}
+	s.aofManifest = am
+}
+
+// AofManifestFiles 读取manifest 返回需要按顺序加载的BASE文件和INCR文件 没有BASE文件时为空
+// 供godis-check-aof检查多个文件组成的aof
+func AofManifestFiles(amFilepath string) (string, []string, error) {
+	am, err := aofLoadManifestFromFile(amFilepath)
+	if err != nil {
+		return "", nil, err
+	}
+	base := ""
+	if am.baseAofInfo != nil {
+		base = am.baseAofInfo.fileName
+	}
+	incrs := make([]string, len(am.incrAofList))
+	for i, ai := range am.incrAofList {
+		incrs[i] = ai.fileName
+	}
+	return base, incrs, nil
+}
+
+/* Generic manifest loading function, used by AofLoadManifestFromDisk()
+ * and godis-check-aof. The returned error reports the offending line. */
+func aofLoadManifestFromFile(amFilepath string) (*aofManifest, error) {
+	f, err := os.Open(amFilepath)
+	if err != nil {
+		return nil, fmt.Errorf("Fatal error: can't open the AOF manifest %s for reading: %s",
+			amFilepath, strerror(err))
 	}
 	defer f.Close()
-	return err
+
+	am := new(aofManifest)
+	r := bufio.NewReaderSize(f, MANIFEST_MAX_LINE+1)
+	var maxseq int64
+	linenum := 0
+	for {
+		buf, err := r.ReadSlice('\n')
+		if len(buf) == 0 && err == io.EOF {
+			break
+		}
+		linenum++
+		loaderr := func(desc string) (*aofManifest, error) {
+			return nil, fmt.Errorf("Reading the manifest file, at line %d\n>>> '%s'\n%s",
+				linenum, strings.TrimRight(string(buf), "\r\n"), desc)
+		}
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, fmt.Errorf("Read AOF manifest failed: %s", strerror(err))
+		}
+
+		/* Skip comments lines */
+		if buf[0] == '#' {
+			if err == bufio.ErrBufferFull {
+				/* Discard the rest of the long comment line. */
+				for err == bufio.ErrBufferFull {
+					_, err = r.ReadSlice('\n')
+				}
+			}
+			continue
+		}
+
+		if err != nil {
+			return loaderr("The AOF manifest file contains too long line")
+		}
+
+		line := strings.Trim(string(buf), " \t\r\n")
+		if len(line) == 0 {
+			return loaderr("Invalid AOF manifest file format")
+		}
+
+		argv, ok := splitArgs(line)
+		/* 'argc < 6' was done for forward compatibility. */
+		if !ok || len(argv) < 6 || len(argv)%2 != 0 {
+			return loaderr("Invalid AOF manifest file format")
+		}
+
+		ai := new(aofInfo)
+		for i := 0; i < len(argv); i += 2 {
+			switch strings.ToLower(argv[i]) {
+			case AOF_MANIFEST_KEY_FILE_NAME:
+				ai.fileName = argv[i+1]
+				if filepath.Base(ai.fileName) != ai.fileName {
+					return loaderr("File can't be a path, just a filename")
+				}
+			case AOF_MANIFEST_KEY_FILE_SEQ:
+				ai.fileSeq, _ = strconv.ParseInt(argv[i+1], 10, 64)
+			case AOF_MANIFEST_KEY_FILE_TYPE:
+				ai.fileType = argv[i+1][0]
+			}
+			/* else if other keys, ignored for forward compatibility. */
+		}
+
+		/* We have to make sure we load all the information. */
+		if ai.fileName == "" || ai.fileSeq == 0 || ai.fileType == 0 {
+			return loaderr("Invalid AOF manifest file format")
+		}
+
+		switch ai.fileType {
+		case AOF_FILE_TYPE_BASE:
+			if am.baseAofInfo != nil {
+				return loaderr("Found duplicate base file information")
+			}
+			am.baseAofInfo = ai
+			am.currBaseFileSeq = ai.fileSeq
+		case AOF_FILE_TYPE_HIST:
+			am.historyAofList = append(am.historyAofList, ai)
+		case AOF_FILE_TYPE_INCR:
+			if ai.fileSeq <= maxseq {
+				return loaderr("Found a non-monotonic sequence number")
+			}
+			am.incrAofList = append(am.incrAofList, ai)
+			am.currIncrFileSeq = ai.fileSeq
+			maxseq = ai.fileSeq
+		default:
+			return loaderr("Unknown AOF file type")
+		}
+	}
+	return am, nil
+}
+
+/* Get a new BASE file name, and mark the previous (if we have)
+ * as the HISTORY type.
+ *
+ * BASE file naming rules: `appendfilename`.seq.base.format
+ *
+ * for example:
+ *  godis.aof.1.base.aof  (aof-use-rdb-preamble is no)
+ *  godis.aof.1.base.rdb  (aof-use-rdb-preamble is yes) */
+func getNewBaseFileNameAndMarkPreAsHistory(s *Server, am *aofManifest) string {
+	if am.baseAofInfo != nil {
+		am.baseAofInfo.fileType = AOF_FILE_TYPE_HIST
+		am.historyAofList = append(am.historyAofList, am.baseAofInfo)
+	}
+
+	format := AOF_FORMAT_SUFFIX
+	if s.AofUseRdbPreamble {
+		format = RDB_FORMAT_SUFFIX
+	}
+	am.currBaseFileSeq++
+	am.baseAofInfo = &aofInfo{
+		fileName: fmt.Sprintf("%s.%d%s%s", s.AofFilename, am.currBaseFileSeq, BASE_FILE_SUFFIX, format),
+		fileSeq:  am.currBaseFileSeq,
+		fileType: AOF_FILE_TYPE_BASE,
+	}
+	am.dirty = true
+	return am.baseAofInfo.fileName
+}
+
+/* Get a new INCR type AOF name.
+ *
+ * INCR AOF naming rules: `appendfilename`.seq.incr.aof
+ *
+ * for example:
+ *  godis.aof.1.incr.aof */
+func getNewIncrAofName(s *Server, am *aofManifest) string {
+	am.currIncrFileSeq++
+	ai := &aofInfo{
+		fileName: fmt.Sprintf("%s.%d%s%s", s.AofFilename, am.currIncrFileSeq, INCR_FILE_SUFFIX, AOF_FORMAT_SUFFIX),
+		fileSeq:  am.currIncrFileSeq,
+		fileType: AOF_FILE_TYPE_INCR,
+	}
+	am.incrAofList = append(am.incrAofList, ai)
+	am.dirty = true
+	return ai.fileName
+}
+
+/* Get temp INCR type AOF name. */
+func getTempIncrAofName(s *Server) string {
+	return TEMP_FILE_NAME_PREFIX + s.AofFilename + INCR_FILE_SUFFIX
+}
+
+/* Get the last INCR AOF name or create a new one. */
+func getLastIncrAofName(s *Server, am *aofManifest) string {
+	/* If 'incrAofList' is empty, just create a new one. */
+	if len(am.incrAofList) == 0 {
+		return getNewIncrAofName(s, am)
+	}
+
+	/* Or return the last one. */
+	return am.incrAofList[len(am.incrAofList)-1].fileName
+}
+
+/* Called after the rewrite succeeded: all the INCR files except the one the
+ * server is currently writing are now part of the new BASE, so they are
+ * moved to the HISTORY list. When AOF is disabled there are no new writes,
+ * so all the INCR files are moved. */
+func markRewrittenIncrAofAsHistory(s *Server, am *aofManifest) {
+	if len(am.incrAofList) == 0 {
+		return
+	}
+
+	keep := 0
+	if s.AofFd != nil {
+		/* AOF enabled, we must skip the last INCR AOF because this file
+		 * is our currently writing. */
+		keep = 1
+	}
+	n := len(am.incrAofList) - keep
+	for _, ai := range am.incrAofList[:n] {
+		ai.fileType = AOF_FILE_TYPE_HIST
+		am.historyAofList = append(am.historyAofList, ai)
+	}
+	am.incrAofList = append([]*aofInfo(nil), am.incrAofList[n:]...)
+	am.dirty = true
+}
+
+/* Write the formatted manifest string to disk: the content goes to a temp
+ * file that is fsynced and renamed, so that the manifest is always either
+ * the old or the new one. */
+func writeAofManifestFile(s *Server, buf string) int {
+	amName := getAofManifestFileName(s)
+	amFilepath := aofFilePath(s, amName)
+	tmpAmName := getTempAofManifestFileName(s)
+	tmpAmFilepath := aofFilePath(s, tmpAmName)
+
+	f, err := os.OpenFile(tmpAmFilepath, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		serverLog(s, LL_WARNING, "Can't open the AOF manifest file %s: %s", tmpAmName, strerror(err))
+		return C_ERR
+	}
+	if _, err := f.WriteString(buf); err != nil {
+		serverLog(s, LL_WARNING, "Error trying to write the temporary AOF manifest file %s: %s", tmpAmName, strerror(err))
+		f.Close()
+		return C_ERR
+	}
+	if err := f.Sync(); err != nil {
+		serverLog(s, LL_WARNING, "Fail to fsync the temp AOF file %s: %s.", tmpAmName, strerror(err))
+		f.Close()
+		return C_ERR
+	}
+	f.Close()
+
+	if err := os.Rename(tmpAmFilepath, amFilepath); err != nil {
+		serverLog(s, LL_WARNING, "Error trying to rename the temporary AOF manifest file %s into %s: %s",
+			tmpAmName, amName, strerror(err))
+		return C_ERR
+	}
+
+	/* Also sync the AOF directory as new AOF files may be added in the directory */
+	if err := fsyncFileDir(amFilepath); err != nil {
+		serverLog(s, LL_WARNING, "Fail to fsync AOF directory %s: %s.", amFilepath, strerror(err))
+		return C_ERR
+	}
+	return C_OK
+}
+
+/* Persist the aofManifest information pointed to by am to disk. */
+func persistAofManifest(s *Server, am *aofManifest) int {
+	if !am.dirty {
+		return C_OK
+	}
+
+	if writeAofManifestFile(s, getAofManifestAsString(am)) == C_OK {
+		am.dirty = false
+		return C_OK
+	}
+	return C_ERR
+}
+
+/* Called in LoadAppendOnlyFiles when we upgrade from an old godis version.
+ *
+ * 1) Create the AOF directory use 'appenddirname' as the name.
+ * 2) Use 'appendfilename' to construct a BASE type aofInfo and add it to
+ *    aofManifest, then persist the manifest file to AOF directory.
+ * 3) Move the old AOF file (appendfilename) to AOF directory.
+ *
+ * If any of the above steps fails, the server process will exit. */
+func aofUpgradePrepare(s *Server, am *aofManifest) {
+	/* Create AOF directory use 'appenddirname' as the name. */
+	if err := dirCreateIfMissing(s.AofDirname); err != nil {
+		serverLog(s, LL_WARNING, "Can't open or create append-only dir %s: %s", s.AofDirname, strerror(err))
+		os.Exit(1)
+	}
+
+	/* Manually construct a BASE type aofInfo and add it to aofManifest. */
+	am.baseAofInfo = &aofInfo{
+		fileName: s.AofFilename,
+		fileSeq:  1,
+		fileType: AOF_FILE_TYPE_BASE,
+	}
+	am.currBaseFileSeq = 1
+	am.dirty = true
+
+	/* Persist the manifest file to AOF directory. */
+	if persistAofManifest(s, am) != C_OK {
+		os.Exit(1)
+	}
+
+	/* Move the old AOF file to AOF directory. */
+	if err := os.Rename(s.AofFilename, aofFilePath(s, s.AofFilename)); err != nil {
+		serverLog(s, LL_WARNING, "Error trying to move the old AOF file %s into dir %s: %s",
+			s.AofFilename, s.AofDirname, strerror(err))
+		os.Exit(1)
+	}
+	serverLog(s, LL_NOTICE, "Successfully migrated an old-style AOF into the AOF directory %s.", s.AofDirname)
+}
+
+/* When AOFRW success, the previous BASE and INCR AOFs will
+ * become HISTORY type and be moved into 'historyAofList'.
+ *
+ * The function will traverse the 'historyAofList' and submit
+ * the delete task to the bio goroutine. */
+func (s *Server) AofDelHistoryFiles() int {
+	if s.aofManifest == nil || len(s.aofManifest.historyAofList) == 0 {
+		return C_OK
+	}
+
+	for _, ai := range s.aofManifest.historyAofList {
+		serverLog(s, LL_NOTICE, "Removing the history file %s in the background", ai.fileName)
+		bgUnlink(s, aofFilePath(s, ai.fileName))
+	}
+	s.aofManifest.historyAofList = nil
+	s.aofManifest.dirty = true
+	return persistAofManifest(s, s.aofManifest)
+}
+
+/* Used to clean up temp INCR AOF when AOFRW fails. */
+func aofDelTempIncrAofFile(s *Server) {
+	aofName := getTempIncrAofName(s)
+	serverLog(s, LL_NOTICE, "Removing the temp incr aof file %s in the background", aofName)
+	bgUnlink(s, aofFilePath(s, aofName))
+}
+
+/* Unlink the file, if it's still open the blocks are released only when
+ * the last descriptor is closed, which may take a long time for a big file.
+ * So the file is opened before the unlink and the descriptor is closed by
+ * the bio goroutine. */
+func bgUnlink(s *Server, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		/* Can't open the file? Fall back to unlinking in the main thread. */
+		return os.Remove(filename)
+	}
+	if err := os.Remove(filename); err != nil {
+		f.Close()
+		return err
+	}
+	bioCreateCloseJob(s, f, false)
+	return nil
+}
+
+/* Return the size of the AOF file in the AOF directory. */
+func getAppendOnlyFileSize(s *Server, filename string) (int64, error) {
+	fi, err := os.Stat(aofFilePath(s, filename))
+	if err != nil {
+		serverLog(s, LL_WARNING, "Unable to obtain the AOF file %s length. stat: %s", filename, strerror(err))
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+/* Return the total size of the BASE and INCR files. */
+func getBaseAndIncrAppendOnlyFilesSize(s *Server, am *aofManifest) (int64, error) {
+	var size int64
+	if am.baseAofInfo != nil {
+		n, err := getAppendOnlyFileSize(s, am.baseAofInfo.fileName)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	for _, ai := range am.incrAofList {
+		n, err := getAppendOnlyFileSize(s, ai.fileName)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+/* Called after loading the data when the server starts. If AOF is on:
+ * 1) Force create a BASE file when the server starts with an empty AOF:
+ *    the dataset (maybe loaded from the RDB) is written in it.
+ * 2) Open the last opened INCR type AOF for writing, if not, create a new one.
+ * 3) Synchronously update the manifest file to the disk. */
+func (s *Server) OpenAppendOnlyFile() error {
+	if s.AofState != AOF_ON {
+		return nil
+	}
+	if err := dirCreateIfMissing(s.AofDirname); err != nil {
+		return fmt.Errorf("Can't open or create append-only dir %s: %s", s.AofDirname, strerror(err))
+	}
+
+	/* If we start with an empty dataset, we will force create a BASE file. */
+	incrAofLen := len(s.aofManifest.incrAofList)
+	if s.aofManifest.baseAofInfo == nil && incrAofLen == 0 {
+		baseName := getNewBaseFileNameAndMarkPreAsHistory(s, s.aofManifest)
+		tmpfile := aofRewriteTempFileName(s)
+		size, err := rewriteAppendOnlyFile(createSnapshot(s, false), tmpfile, s.AofUseRdbPreamble)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmpfile, aofFilePath(s, baseName)); err != nil {
+			os.Remove(tmpfile)
+			return fmt.Errorf("Error trying to rename the temporary AOF base file %s into %s: %s",
+				tmpfile, baseName, strerror(err))
+		}
+		s.AofCurrentSize = size
+		s.AofRewriteBaseSize = s.AofCurrentSize
+		serverLog(s, LL_NOTICE, "Creating AOF base file %s on server start", baseName)
+	}
+
+	aofName := getLastIncrAofName(s, s.aofManifest)
+	f, err := os.OpenFile(aofFilePath(s, aofName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Can't open the append-only file %s: %s", aofName, strerror(err))
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	/* Persist our changes. */
+	if persistAofManifest(s, s.aofManifest) != C_OK {
+		f.Close()
+		return errors.New("Can't persist the AOF manifest")
+	}
+
+	s.AofFd = f
+	s.AofLastIncrSize = fi.Size()
+	s.AofFsyncOffset = s.AofLastIncrSize
+	s.AofLastFsync = time.Now().Unix()
+	if incrAofLen > 0 {
+		serverLog(s, LL_NOTICE, "Opening AOF incr file %s on server start", aofName)
+	} else {
+		serverLog(s, LL_NOTICE, "Creating AOF incr file %s on server start", aofName)
+	}
+	return nil
+}
+
+/* Return true if an AOF fsync is currently already in progress in a
+ * BIO thread. */
+func aofFsyncInProgress(s *Server) bool {
+	return bioPendingJobsOfType(s, BIO_AOF_FSYNC) != 0
+}
+
+/* Starts a background task that performs fsync() against the specified
+ * file descriptor (the one of the AOF file) in another thread. */
+func aofBackgroundFsync(s *Server, fd *os.File) {
+	bioCreateFsyncJob(s, fd)
 }
 
-func ReadAof(fileName string) []string {
-	f, err := os.Open(fileName)
+/* Write the append only file buffer on disk.
+ *
+ * Since we are required to write the AOF before replying to the client,
+ * and the only way the client socket can get a write is entering when
+ * the event loop, we accumulate all the AOF writes in a memory
+ * buffer and write it on disk using this function just before entering
+ * the event loop again.
+ *
+ * About the 'force' argument:
+ *
+ * When the fsync policy is set to 'everysec' we may delay the flush if there
+ * is still an fsync() going on in the background thread, since for instance
+ * on Linux write(2) will be blocked by the background fsync anyway.
+ * When this happens we remember that there is some aof buffer to be
+ * flushed ASAP, and will try to do that in the serverCron() function.
+ *
+ * However if force is set to true we'll write regardless of the background
+ * fsync. */
+func flushAppendOnlyFile(s *Server, force bool) {
+	syncInProgress := false
+	now := time.Now().Unix()
+
+	if len(s.AofBuf) == 0 {
+		/* Check if we need to do fsync even the aof buffer is empty,
+		 * because previously in AOF_FSYNC_EVERYSEC mode, fsync is
+		 * called only when aof buffer is not empty, so if users
+		 * stop write commands before fsync called in one second,
+		 * the data in page cache cannot be flushed in time. */
+		if s.AofFsync == AOF_FSYNC_EVERYSEC &&
+			s.AofFsyncOffset != s.AofLastIncrSize &&
+			now > s.AofLastFsync {
+			syncInProgress = aofFsyncInProgress(s)
+			if !syncInProgress {
+				tryFsync(s, now, syncInProgress)
+			}
+		}
+		return
+	}
+
+	if s.AofFsync == AOF_FSYNC_EVERYSEC {
+		syncInProgress = aofFsyncInProgress(s)
+	}
+
+	if s.AofFsync == AOF_FSYNC_EVERYSEC && !force {
+		/* With this append fsync policy we do background fsyncing.
+		 * If the fsync is still in progress we can try to delay
+		 * the write for a couple of seconds. */
+		if syncInProgress {
+			if s.AofFlushPostponedStart == 0 {
+				/* No previous write postponing, remember that we are
+				 * postponing the flush and return. */
+				s.AofFlushPostponedStart = now
+				return
+			} else if now-s.AofFlushPostponedStart < 2 {
+				/* We were already waiting for fsync to finish, but for less
+				 * than two seconds this is still ok. Postpone again. */
+				return
+			}
+			/* Otherwise fall through, and go write since we can't wait
+			 * over two seconds. */
+			s.AofDelayedFsync++
+			serverLog(s, LL_NOTICE, "Asynchronous AOF fsync is taking too long (disk is busy?). "+
+				"Writing the AOF buffer without waiting for fsync to complete, this may slow down Godis.")
+		}
+	}
+
+	/* We want to perform a single write. This should be guaranteed atomic
+	 * at least if the filesystem we are writing is a real physical one.
+	 * While this will save us against the server being killed I don't think
+	 * there is much to do about the whole server stopping for power problems
+	 * or alike */
+	nwritten, err := s.AofFd.Write(s.AofBuf)
+
+	/* We performed the write so reset the postponed flush sentinel to zero. */
+	s.AofFlushPostponedStart = 0
+
 	if err != nil {
-		fmt.Println("aof file open failed" + err.Error())
+		canLog := false
+
+		/* Limit logging rate to 1 line per AOF_WRITE_LOG_ERROR_RATE seconds. */
+		if now-s.aofLastWriteErrorLog > AOF_WRITE_LOG_ERROR_RATE {
+			canLog = true
+			s.aofLastWriteErrorLog = now
+		}
+
+		/* Log the AOF write error and record the error code. */
+		if nwritten == 0 {
+			if canLog {
+				serverLog(s, LL_WARNING, "Error writing to the AOF file: %s", strerror(err))
+			}
+		} else {
+			if canLog {
+				serverLog(s, LL_WARNING, "Short write while writing to the AOF file: (nwritten=%d, expected=%d)",
+					nwritten, len(s.AofBuf))
+			}
+
+			if terr := s.AofFd.Truncate(s.AofLastIncrSize); terr != nil {
+				if canLog {
+					serverLog(s, LL_WARNING, "Could not remove short write from the append-only file. "+
+						"Godis may refuse to load the AOF the next time it starts. ftruncate: %s", strerror(terr))
+				}
+			} else {
+				/* If the ftruncate() succeeded we can set nwritten to
+				 * 0 since there is no longer partial data into the AOF. */
+				nwritten = 0
+			}
+		}
+		s.AofLastWriteErr = strerror(err)
+
+		/* Handle the AOF write error. */
+		if s.AofFsync == AOF_FSYNC_ALWAYS {
+			/* We can't recover when the fsync policy is ALWAYS since the reply
+			 * for the client is already in the output buffers (both writes and
+			 * reads), and the changes to the db can't be rolled back. Since we
+			 * have a contract with the user that on acknowledged or observed
+			 * writes are is synced on disk, we must exit. */
+			serverLog(s, LL_WARNING, "Can't recover from AOF write error when the AOF fsync policy is 'always'. Exiting...")
+			os.Exit(1)
+		}
+
+		/* Recover from failed write leaving data into the buffer. However
+		 * set an error to stop accepting writes as long as the error
+		 * condition is not cleared. */
+		s.AofLastWriteStatus = C_ERR
+
+		/* Trim the buffer if there was a partial write, and there
+		 * was no way to undo it with ftruncate(2). */
+		if nwritten > 0 {
+			s.AofCurrentSize += int64(nwritten)
+			s.AofLastIncrSize += int64(nwritten)
+			s.AofBuf = s.AofBuf[nwritten:]
+		}
+		return /* We'll try again on the next call... */
+	}
+
+	/* Successful write(2). If AOF was in error state, restore the
+	 * OK state and log the event. */
+	if s.AofLastWriteStatus == C_ERR {
+		serverLog(s, LL_WARNING, "AOF write error looks solved, Godis can write again.")
+		s.AofLastWriteStatus = C_OK
+	}
+	s.AofCurrentSize += int64(nwritten)
+	s.AofLastIncrSize += int64(nwritten)
+
+	/* Re-use AOF buffer when it is small enough. The maximum comes from the
+	 * arena size of 4k minus some overhead (but is otherwise arbitrary). */
+	if cap(s.AofBuf) < 4000 {
+		s.AofBuf = s.AofBuf[:0]
+	} else {
+		s.AofBuf = nil
+	}
+
+	tryFsync(s, now, syncInProgress)
+}
+
+// tryFsync 按appendfsync的策略fsync aof文件
+func tryFsync(s *Server, now int64, syncInProgress bool) {
+	/* Don't fsync if no-appendfsync-on-rewrite is set to yes and there are
+	 * children doing I/O in the background. */
+	if s.AofNoFsyncOnRewrite && hasActiveChildProcess(s) {
+		return
+	}
+
+	/* Perform the fsync if needed. */
+	if s.AofFsync == AOF_FSYNC_ALWAYS {
+		/* Let's try to get this data on the disk. To guarantee data safe when
+		 * the AOF fsync policy is 'always', we should exit if failed to fsync
+		 * AOF (see comment next to the exit(1) after write error above). */
+		if err := s.AofFd.Sync(); err != nil {
+			serverLog(s, LL_WARNING, "Can't persist AOF for fsync error when the AOF fsync policy is 'always': %s. Exiting...",
+				strerror(err))
+			os.Exit(1)
+		}
+		s.AofFsyncOffset = s.AofLastIncrSize
+		s.AofLastFsync = now
+	} else if s.AofFsync == AOF_FSYNC_EVERYSEC && now > s.AofLastFsync {
+		if !syncInProgress {
+			aofBackgroundFsync(s, s.AofFd)
+			s.AofFsyncOffset = s.AofLastIncrSize
+		}
+		s.AofLastFsync = now
+	}
+}
+
+/* Return DISK_ERROR_TYPE_NONE if the server can accept write commands,
+ * otherwise the type of the error: the last background save failed while
+ * stop-writes-on-bgsave-error is set, or the last write or the last
+ * background fsync of the AOF failed. */
+func writeCommandsDeniedByDiskError(s *Server) int {
+	if s.StopWritesOnBgsaveErr && len(s.SaveParams) > 0 && s.LastbgsaveStatus == C_ERR {
+		return DISK_ERROR_TYPE_RDB
+	} else if s.AofState != AOF_OFF {
+		if s.AofLastWriteStatus == C_ERR {
+			return DISK_ERROR_TYPE_AOF
+		}
+		/* AOF fsync error. */
+		if atomic.LoadInt32(&s.aofBioFsyncStatus) == C_ERR {
+			s.AofLastWriteErr, _ = s.aofBioFsyncErr.Load().(string)
+			return DISK_ERROR_TYPE_AOF
+		}
+	}
+	return DISK_ERROR_TYPE_NONE
+}
+
+// writeCommandsGetDiskErrorMessage 拒绝写命令时回复的错误
+func writeCommandsGetDiskErrorMessage(s *Server, errorCode int) string {
+	if errorCode == DISK_ERROR_TYPE_RDB {
+		return "MISCONF Godis is configured to save RDB snapshots, but it's currently unable to persist to disk. " +
+			"Commands that may modify the data set are disabled, because this instance is configured to report " +
+			"errors during writes if RDB snapshotting fails (stop-writes-on-bgsave-error option). " +
+			"Please check the Godis logs for details about the RDB error."
+	}
+	return "MISCONF Errors writing to the AOF file: " + s.AofLastWriteErr
+}
+
+// feedAppendOnlyFile 将命令按协议格式追加到aof缓冲区 在beforeSleep中写入当前的INCR文件
+// 命令所在的db与aof中最后选择的db不同时 先写入SELECT命令
+func feedAppendOnlyFile(s *Server, dictid int, argv []*GodisObject) {
+	if s.AofState == AOF_OFF {
+		return
+	}
+	var buf []byte
+
+	/* The DB this command was targeting is not the same as the last command
+	 * we appended. To issue a SELECT command is needed. */
+	if dictid != s.AofSelectedDb {
+		buf = append(buf, catAppendOnlyGenericCommand([]*GodisObject{
+			CreateObject(ObjectTypeString, "select"),
+			CreateObject(ObjectTypeString, strconv.Itoa(dictid)),
+		})...)
+		s.AofSelectedDb = dictid
+	}
+	buf = append(buf, catAppendOnlyGenericCommand(argv)...)
+
+	/* Append to the AOF buffer. This will be flushed on disk just before
+	 * of re-entering the event loop, so before the client will get a
+	 * positive reply about the operation performed.
+	 *
+	 * While the AOF is waiting for the rewrite that turns it on, the commands
+	 * executed after the rewrite started go to the temporary INCR file, the
+	 * ones before are part of the new BASE. */
+	if s.AofState == AOF_ON ||
+		(s.AofState == AOF_WAIT_REWRITE && s.ChildType == CHILD_TYPE_AOF) {
+		s.AofBuf = append(s.AofBuf, buf...)
+	}
+}
+
+// catAppendOnlyGenericCommand 将命令编码为协议格式
+func catAppendOnlyGenericCommand(argv []*GodisObject) string {
+	multi := make([]*proto.Resp, len(argv))
+	for i, arg := range argv {
+		multi[i] = bulkString(getStringFromObject(arg))
+	}
+	buf, err := proto.EncodeToBytes(proto.NewArray(multi))
+	if err != nil {
+		return ""
+	}
+	return string(buf)
+}
+
+/*-----------------------------------------------------------------------------
+ * AOF rewrite
+ *----------------------------------------------------------------------------*/
+
+// 重写时每条命令最多包含的元素个数
+const AOF_REWRITE_ITEMS_PER_CMD = 64
+
+// rioWriteBulkCount 写入多条批量回复的头部 如"*3\r\n"
+func rioWriteBulkCount(r *rio, prefix byte, count int) {
+	r.WriteByte(prefix)
+	r.WriteString(strconv.Itoa(count))
+	r.WriteString("\r\n")
+}
+
+// rioWriteBulkString 写入一个批量回复
+func rioWriteBulkString(r *rio, str string) {
+	rioWriteBulkCount(r, '$', len(str))
+	r.WriteString(str)
+	r.WriteString("\r\n")
+}
+
+/* Emit the commands needed to rebuild a list object. Big objects are
+ * split in commands of AOF_REWRITE_ITEMS_PER_CMD items. */
+func rewriteListObject(r *rio, key string, o *GodisObject) {
+	count, items := 0, listTypeLength(o)
+	for ln := o.Ptr.(*List).listFirst(); ln != nil; ln = ln.listNextNode() {
+		if count == 0 {
+			cmdItems := items
+			if cmdItems > AOF_REWRITE_ITEMS_PER_CMD {
+				cmdItems = AOF_REWRITE_ITEMS_PER_CMD
+			}
+			rioWriteBulkCount(r, '*', 2+cmdItems)
+			rioWriteBulkString(r, "RPUSH")
+			rioWriteBulkString(r, key)
+		}
+		rioWriteBulkString(r, ln.listNodeValue().(string))
+		if count++; count == AOF_REWRITE_ITEMS_PER_CMD {
+			count = 0
+		}
+		items--
+	}
+}
+
+/* Emit the commands needed to rebuild a set object. */
+func rewriteSetObject(r *rio, key string, o *GodisObject) {
+	count, items := 0, setTypeSize(o)
+	setTypeForEachUnsafe(o, func(ele string) bool {
+		if count == 0 {
+			cmdItems := items
+			if cmdItems > AOF_REWRITE_ITEMS_PER_CMD {
+				cmdItems = AOF_REWRITE_ITEMS_PER_CMD
+			}
+			rioWriteBulkCount(r, '*', 2+cmdItems)
+			rioWriteBulkString(r, "SADD")
+			rioWriteBulkString(r, key)
+		}
+		rioWriteBulkString(r, ele)
+		if count++; count == AOF_REWRITE_ITEMS_PER_CMD {
+			count = 0
+		}
+		items--
+		return true
+	})
+}
+
+/* Emit the commands needed to rebuild a sorted set object. */
+func rewriteSortedSetObject(r *rio, key string, o *GodisObject) {
+	count, items := 0, int(zsetLength(o))
+	for ln := o.Ptr.(*zSet).zsl.header.level[0].forward; ln != nil; ln = ln.level[0].forward {
+		if count == 0 {
+			cmdItems := items
+			if cmdItems > AOF_REWRITE_ITEMS_PER_CMD {
+				cmdItems = AOF_REWRITE_ITEMS_PER_CMD
+			}
+			rioWriteBulkCount(r, '*', 2+cmdItems*2)
+			rioWriteBulkString(r, "ZADD")
+			rioWriteBulkString(r, key)
+		}
+		rioWriteBulkString(r, formatDouble(ln.score))
+		rioWriteBulkString(r, ln.ele)
+		if count++; count == AOF_REWRITE_ITEMS_PER_CMD {
+			count = 0
+		}
+		items--
+	}
+}
+
+/* Emit the commands needed to rebuild a hash object. */
+func rewriteHashObject(r *rio, key string, o *GodisObject) {
+	count, items := 0, hashTypeLength(o)
+	hashTypeForEachUnsafe(o, func(field string, value string) bool {
+		if count == 0 {
+			cmdItems := items
+			if cmdItems > AOF_REWRITE_ITEMS_PER_CMD {
+				cmdItems = AOF_REWRITE_ITEMS_PER_CMD
+			}
+			rioWriteBulkCount(r, '*', 2+cmdItems*2)
+			rioWriteBulkString(r, "HMSET")
+			rioWriteBulkString(r, key)
+		}
+		rioWriteBulkString(r, field)
+		rioWriteBulkString(r, value)
+		if count++; count == AOF_REWRITE_ITEMS_PER_CMD {
+			count = 0
+		}
+		items--
+		return true
+	})
+}
+
+/* Write a sequence of commands able to fully rebuild the snapshot into r:
+ * a SELECT for every non empty DB, followed by the minimal commands
+ * needed to rebuild every key and its expire. */
+func rewriteAppendOnlyFileRio(snap *snapshot, r *rio) error {
+	for _, ds := range snap.dbs {
+		/* SELECT the new DB */
+		rioWriteBulkCount(r, '*', 2)
+		rioWriteBulkString(r, "SELECT")
+		rioWriteBulkString(r, strconv.Itoa(ds.id))
+
+		/* Iterate this DB writing every entry */
+		for i, key := range ds.keys {
+			if err := snapshotCheck(snap, r); err != nil {
+				return err
+			}
+			o := ds.vals[i]
+
+			/* Save the key and associated value */
+			switch o.ObjectType {
+			case ObjectTypeString:
+				/* Emit a SET command */
+				rioWriteBulkCount(r, '*', 3)
+				rioWriteBulkString(r, "SET")
+				rioWriteBulkString(r, key)
+				rioWriteBulkString(r, getStringFromObject(o))
+			case OBJ_LIST:
+				rewriteListObject(r, key, o)
+			case OBJ_SET:
+				rewriteSetObject(r, key, o)
+			case OBJ_ZSET:
+				rewriteSortedSetObject(r, key, o)
+			case OBJ_HASH:
+				rewriteHashObject(r, key, o)
+			default:
+				panic("Unknown object type")
+			}
+
+			/* Save the expire time */
+			if expiretime := ds.expires[i]; expiretime != -1 {
+				rioWriteBulkCount(r, '*', 3)
+				rioWriteBulkString(r, "PEXPIREAT")
+				rioWriteBulkString(r, key)
+				rioWriteBulkString(r, strconv.FormatInt(expiretime, 10))
+			}
+		}
+	}
+	return r.err
+}
+
+/* Write a new BASE AOF with the content of the snapshot to filename and
+ * make sure it reached the disk. The RDB format is used when
+ * aof-use-rdb-preamble is enabled, since it is faster to produce and to
+ * load, otherwise the commands able to rebuild the dataset. Runs in the
+ * background goroutine as well, so it only uses its arguments. Returns
+ * the size of the file. */
+func rewriteAppendOnlyFile(snap *snapshot, filename string, rdbPreamble bool) (int64, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("Opening the temp file for AOF rewrite in rewriteAppendOnlyFile(): %s", strerror(err))
+	}
+
+	r := newRio(f, snap.rdbCompression)
+	if rdbPreamble {
+		err = rdbSaveRio(snap, r, RDBFLAGS_AOF_PREAMBLE)
+	} else {
+		err = rewriteAppendOnlyFileRio(snap, r)
+	}
+
+	/* Make sure data will not remain on the OS's output buffers */
+	if err == nil {
+		err = r.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(filename)
+		if err == errChildKilled {
+			return 0, err
+		}
+		return 0, fmt.Errorf("Write error writing append only file on disk: %s", strerror(err))
+	}
+	return r.processed, nil
+}
+
+/* Called by rewriteAppendOnlyFileBackground() before the rewrite starts:
+ * the commands executed from now on go to a new INCR file, while the
+ * rewrite produces the new BASE from the current dataset. This way no data
+ * needs to be copied from a file to another when the rewrite is done.
+ *
+ * When AOF is waiting for the rewrite that turns it on, the new file is a
+ * temporary INCR file that is added to the manifest only if the rewrite
+ * succeeds. */
+func openNewIncrAofForAppend(s *Server) int {
+	/* Only open new INCR AOF when AOF enabled. */
+	if s.AofState == AOF_OFF {
+		return C_OK
+	}
+
+	/* Open new AOF. */
+	var newAofName string
+	var tempAm *aofManifest
+	if s.AofState == AOF_WAIT_REWRITE {
+		/* Use a temporary INCR AOF file to accumulate data during AOF_WAIT_REWRITE. */
+		newAofName = getTempIncrAofName(s)
+	} else {
+		/* Dup a temp aofManifest to modify. */
+		tempAm = aofManifestDup(s.aofManifest)
+		newAofName = getNewIncrAofName(s, tempAm)
+	}
+	newfd, err := os.OpenFile(aofFilePath(s, newAofName), os.O_WRONLY|os.O_APPEND|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		serverLog(s, LL_WARNING, "Can't open the append-only file %s: %s", newAofName, strerror(err))
+		return C_ERR
+	}
+
+	if s.AofState == AOF_ON {
+		/* Persist AOF Manifest. */
+		if persistAofManifest(s, tempAm) == C_ERR {
+			newfd.Close()
+			return C_ERR
+		}
+	}
+
+	serverLog(s, LL_NOTICE, "Creating AOF incr file %s on background rewrite", newAofName)
+
+	/* If reaches here, we can safely modify s.aofManifest and s.AofFd. */
+
+	/* fsync and close old AofFd if needed. In fsync everysec it's ok to delay
+	 * the fsync as long as we grantee it happens, and in fsync always the file
+	 * is already synced at this point so fsync doesn't matter. */
+	if s.AofFd != nil {
+		bioCreateCloseAofJob(s, s.AofFd)
+		s.AofLastFsync = time.Now().Unix()
+	}
+	s.AofFd = newfd
+
+	/* Reset the size and the fsync offset of the current INCR file. */
+	s.AofLastIncrSize = 0
+	s.AofFsyncOffset = 0
+	if tempAm != nil {
+		s.aofManifest = tempAm
+	}
+	return C_OK
+}
+
+/* This is how rewriting of the append only file in background works:
+ *
+ * 1) The user calls BGREWRITEAOF
+ * 2) Godis flushes the AOF buffer and opens a new INCR file, the write
+ *    commands executed from now on are appended to it.
+ * 3) Godis takes a snapshot of the dataset, the point in time the new BASE
+ *    represents, and a background goroutine, the equivalent of the child
+ *    process of Redis, writes the BASE to a temp file and fsyncs it: the
+ *    RDB format or the minimal command stream able to rebuild the dataset.
+ * 4) The event loop keeps serving the clients meanwhile: the values of the
+ *    snapshot are duplicated before they are modified, so the goroutine
+ *    doesn't see the changes, that go to the new INCR file.
+ * 5) When the goroutine is done, serverCron calls the done handler that
+ *    renames the temp file in the new BASE name, and updates the manifest:
+ *    the old BASE and INCR files become HISTORY and are removed. */
+func rewriteAppendOnlyFileBackground(s *Server) int {
+	if hasActiveChildProcess(s) {
+		return C_ERR
+	}
+	if err := dirCreateIfMissing(s.AofDirname); err != nil {
+		serverLog(s, LL_WARNING, "Can't open or create append-only dir %s: %s", s.AofDirname, strerror(err))
+		s.AofLastBgrewriteStatus = C_ERR
+		return C_ERR
+	}
+
+	/* We set AofSelectedDb to -1 in order to force the next call to the
+	 * feedAppendOnlyFile() to issue a SELECT command, so the new INCR file
+	 * will start with a SELECT statement. */
+	s.AofSelectedDb = -1
+	flushAppendOnlyFile(s, true)
+	if openNewIncrAofForAppend(s) != C_OK {
+		s.AofLastBgrewriteStatus = C_ERR
+		return C_ERR
+	}
+
+	snap := createSnapshot(s, true)
+	serverLog(s, LL_NOTICE, "Background append only file rewriting started")
+	s.AofRewriteScheduled = false
+	s.AofRewriteTimeStart = time.Now().Unix()
+	s.ChildType = CHILD_TYPE_AOF
+	s.childDone = make(chan error, 1)
+	s.childKill = snap.kill
+
+	tmpfile := aofRewriteTempFileName(s)
+	rdbPreamble := s.AofUseRdbPreamble
+	done := s.childDone
+	go func() {
+		_, err := rewriteAppendOnlyFile(snap, tmpfile, rdbPreamble)
+		done <- err
+	}()
+	return C_OK
+}
+
+// aofRewriteTempFileName 后台重写生成的临时文件
+func aofRewriteTempFileName(s *Server) string {
+	return fmt.Sprintf("temp-rewriteaof-bg-%d.aof", s.Pid)
+}
+
+// aofRemoveTempFile 删除后台重写的临时文件
+func aofRemoveTempFile(s *Server) {
+	os.Remove(aofRewriteTempFileName(s))
+}
+
+/* Kill the AOF rewrite child if any. The goroutine stops at the next key,
+ * we wait for it and throw away its work. */
+func killAppendOnlyChild(s *Server) {
+	if s.ChildType != CHILD_TYPE_AOF {
+		return
+	}
+	serverLog(s, LL_NOTICE, "Killing running AOF rewrite child")
+	close(s.childKill)
+	<-s.childDone
+	aofRemoveTempFile(s)
+	s.AofRewriteTimeStart = -1
+	resetChildState(s)
+}
+
+/* Called when the user switches from "appendonly yes" to "appendonly no"
+ * at runtime using the CONFIG command. */
+func stopAppendOnly(s *Server) {
+	flushAppendOnlyFile(s, true)
+	if err := s.AofFd.Sync(); err != nil {
+		serverLog(s, LL_WARNING, "Fail to fsync the AOF file: %s", strerror(err))
+	} else {
+		s.AofLastFsync = time.Now().Unix()
+	}
+	s.AofFd.Close()
+
+	s.AofFd = nil
+	s.AofSelectedDb = -1
+	s.AofState = AOF_OFF
+	s.AofRewriteScheduled = false
+	s.AofLastIncrSize = 0
+	s.AofFsyncOffset = 0
+	killAppendOnlyChild(s)
+	s.AofBuf = nil
+}
+
+/* Called when the user switches from "appendonly no" to "appendonly yes"
+ * at runtime using the CONFIG command. */
+func startAppendOnly(s *Server) int {
+	s.AofState = AOF_WAIT_REWRITE
+	if hasActiveChildProcess(s) && s.ChildType != CHILD_TYPE_AOF {
+		s.AofRewriteScheduled = true
+		serverLog(s, LL_WARNING, "AOF was enabled but there is already another background operation. "+
+			"An AOF background was scheduled to start when possible.")
+	} else {
+		/* If there is a pending AOF rewrite, we need to switch it off and
+		 * start a new one: the old one cannot be reused because its INCR
+		 * file is not a temporary one. */
+		if s.ChildType == CHILD_TYPE_AOF {
+			serverLog(s, LL_WARNING, "AOF was enabled but there is already an AOF rewriting in background. "+
+				"Stopping background AOF and starting a rewrite now.")
+			killAppendOnlyChild(s)
+		}
+		if rewriteAppendOnlyFileBackground(s) == C_ERR {
+			s.AofState = AOF_OFF
+			serverLog(s, LL_WARNING, "Godis needs to enable the AOF but can't trigger a background AOF rewrite operation. "+
+				"Check the above logs for more info about the error.")
+			return C_ERR
+		}
+	}
+	/* We correctly switched on AOF, now wait for the rewrite to be complete
+	 * in order to append data on disk. */
+	s.AofLastFsync = time.Now().Unix()
+	return C_OK
+}
+
+// BgrewriteaofCommand 在后台重写aof
+func BgrewriteaofCommand(c *Client, s *Server) {
+	if s.ChildType == CHILD_TYPE_AOF {
+		addReplyError(c, "ERR Background append only file rewriting already in progress")
+	} else if hasActiveChildProcess(s) {
+		s.AofRewriteScheduled = true
+		addReplyStatus(c, "Background append only file rewriting scheduled")
+	} else if rewriteAppendOnlyFileBackground(s) == C_OK {
+		addReplyStatus(c, "Background append only file rewriting started")
+	} else {
+		addReplyError(c, "ERR Can't execute an AOF background rewriting. "+
+			"Please check the server logs for more information.")
+	}
+}
+
+/* A background append only file rewriting (BGREWRITEAOF) terminated its work.
+ * Handle this. */
+func backgroundRewriteDoneHandler(s *Server, err error) {
+	now := time.Now().Unix()
+	if err == nil {
+		serverLog(s, LL_NOTICE, "Background AOF rewrite terminated with success")
+
+		tmpfile := aofRewriteTempFileName(s)
+
+		/* Dup a temporary aofManifest for subsequent modifications. */
+		tempAm := aofManifestDup(s.aofManifest)
+
+		/* Get a new BASE file name and mark the previous (if we have)
+		 * as the HISTORY type. */
+		newBaseFilename := getNewBaseFileNameAndMarkPreAsHistory(s, tempAm)
+		newBaseFilepath := aofFilePath(s, newBaseFilename)
+
+		/* Rename the temporary AOF file to 'newBaseFilename'. The rename(2)
+		 * is atomic and the BASE is referenced by the manifest only after
+		 * the manifest is persisted below. */
+		if err := os.Rename(tmpfile, newBaseFilepath); err != nil {
+			serverLog(s, LL_WARNING, "Error trying to rename the temporary AOF base file %s into %s: %s",
+				tmpfile, newBaseFilename, strerror(err))
+			s.AofLastBgrewriteStatus = C_ERR
+			goto cleanup
+		}
+
+		/* Rename the temporary INCR AOF file to 'newIncrFilename'. */
+		newIncrFilepath := ""
+		if s.AofState == AOF_WAIT_REWRITE {
+			/* Get temporary INCR AOF name. */
+			tempIncrAofName := getTempIncrAofName(s)
+			tempIncrFilepath := aofFilePath(s, tempIncrAofName)
+			/* Get next new INCR AOF name. */
+			newIncrFilename := getNewIncrAofName(s, tempAm)
+			newIncrFilepath = aofFilePath(s, newIncrFilename)
+			if err := os.Rename(tempIncrFilepath, newIncrFilepath); err != nil {
+				serverLog(s, LL_WARNING, "Error trying to rename the temporary AOF incr file %s into %s: %s",
+					tempIncrAofName, newIncrFilename, strerror(err))
+				bgUnlink(s, newBaseFilepath)
+				s.AofLastBgrewriteStatus = C_ERR
+				goto cleanup
+			}
+		}
+
+		/* Change the AOF file type in 'incrAofList' from AOF_FILE_TYPE_INCR
+		 * to AOF_FILE_TYPE_HIST, and move them to the 'historyAofList'. */
+		markRewrittenIncrAofAsHistory(s, tempAm)
+
+		/* Persist our modifications. */
+		if persistAofManifest(s, tempAm) == C_ERR {
+			bgUnlink(s, newBaseFilepath)
+			if newIncrFilepath != "" {
+				bgUnlink(s, newIncrFilepath)
+			}
+			s.AofLastBgrewriteStatus = C_ERR
+			goto cleanup
+		}
+
+		/* We can safely let s.aofManifest point to 'tempAm'. */
+		s.aofManifest = tempAm
+
+		if s.AofFd != nil {
+			/* AOF enabled. */
+			baseSize, _ := getAppendOnlyFileSize(s, newBaseFilename)
+			s.AofCurrentSize = baseSize + s.AofLastIncrSize
+			s.AofRewriteBaseSize = s.AofCurrentSize
+		}
+
+		/* We don't care about the return value of AofDelHistoryFiles, because
+		 * the history deletion failure will not cause any problems. */
+		s.AofDelHistoryFiles()
+
+		s.AofLastBgrewriteStatus = C_OK
+
+		serverLog(s, LL_NOTICE, "Background AOF rewrite finished successfully")
+		/* Change state from WAIT_REWRITE to ON if needed */
+		if s.AofState == AOF_WAIT_REWRITE {
+			s.AofState = AOF_ON
+		}
+	} else {
+		s.AofLastBgrewriteStatus = C_ERR
+		serverLog(s, LL_WARNING, "Background AOF rewrite terminated with error: %s", err)
+	}
+
+cleanup:
+	aofRemoveTempFile(s)
+	/* Clear AOF buffer and delete temp INCR AOF for next rewrite. */
+	if s.AofState == AOF_WAIT_REWRITE {
+		s.AofBuf = nil
+		aofDelTempIncrAofFile(s)
+	}
+	s.AofRewriteTimeLast = now - s.AofRewriteTimeStart
+	s.AofRewriteTimeStart = -1
+	/* Schedule a new rewrite if we are waiting for it to switch the AOF ON. */
+	if s.AofState == AOF_WAIT_REWRITE {
+		s.AofRewriteScheduled = true
+	}
+}
+
+/*-----------------------------------------------------------------------------
+ * AOF loading
+ *----------------------------------------------------------------------------*/
+
+// aofCommandArgv aof中的每条命令都必须是非空的批量回复数组
+func aofCommandArgv(r *proto.Resp) ([]*GodisObject, bool) {
+	if r.Type != proto.TypeArray || len(r.Array) == 0 {
+		return nil, false
+	}
+	argv := make([]*GodisObject, len(r.Array))
+	for i, arg := range r.Array {
+		if arg.Type != proto.TypeBulkBytes || arg.Value == nil {
+			return nil, false
+		}
+		argv[i] = CreateObject(ObjectTypeString, string(arg.Value))
+	}
+	return argv, true
+}
+
+// aofErrorDescription 解析aof出错时的描述
+func aofErrorDescription(err error) string {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "premature end of file"
+	}
+	return strerror(err)
+}
+
+/* Replay an append only file: either the BASE, in the RDB format or in
+ * the commands format, or an INCR file. A file in the RDB format may also
+ * be an old style AOF made of an RDB preamble followed by commands.
+ * On success AOF_OK is returned, AOF_EMPTY when the file is zero-length,
+ * and AOF_TRUNCATED when the last file ended with an incomplete command
+ * that was removed because of aof-load-truncated.
+ * On fatal error an error message is logged and the program exists.
+ *
+ * The file is parsed command by command with a streaming decoder, so the
+ * values may contain any byte and the file doesn't need to fit in memory. */
+func loadSingleAppendOnlyFile(s *Server, filename string, lastFile bool) int {
+	aofFilepath := aofFilePath(s, filename)
+	f, err := os.Open(aofFilepath)
+	if err != nil {
+		serverLog(s, LL_WARNING, "Fatal error: can't open the append log file %s for reading: %s", filename, strerror(err))
+		os.Exit(1)
 	}
 	defer f.Close()
-	content, err := ioutil.ReadFile(fileName)
+
+	/* Handle a zero-length AOF file as a special case. An empty AOF file
+	 * is a valid AOF because an empty server with AOF enabled will create
+	 * a zero length file at startup, that will remain like that if no write
+	 * operation is received. */
+	fi, err := f.Stat()
 	if err != nil {
-		fmt.Println("aof file read failed" + err.Error())
+		serverLog(s, LL_WARNING, "Unrecoverable error reading the append only file %s: %s", filename, strerror(err))
+		os.Exit(1)
+	}
+	size := fi.Size()
+	if size == 0 {
+		return AOF_EMPTY
 	}
-	ret := bytes.Split(content, []byte{'*'})
-	var pros = make([]string, len(ret)-1)
-	for k, v := range ret[1:] {
-		v := append(v[:0], append([]byte{'*'}, v[0:]...)...)
-		pros[k] = string(v)
+
+	var validUpTo int64 /* Offset of latest well-formed command loaded. */
+
+	/* Check if the AOF file is in RDB format (it may be RDB encoded base AOF
+	 * or old style RDB-preamble AOF). In that case we need to load the RDB file
+	 * and later continue loading the AOF tail if it is an old style RDB-preamble AOF. */
+	sig := make([]byte, 5)
+	if n, _ := io.ReadFull(f, sig); n == len(sig) && string(sig) == "REDIS" {
+		/* RDB preamble. Pass loading the RDB functions. */
+		oldStyle := filename == s.AofFilename
+		if oldStyle {
+			serverLog(s, LL_NOTICE, "Reading RDB preamble from AOF file...")
+		} else {
+			serverLog(s, LL_NOTICE, "Reading RDB base file on AOF loading...")
+		}
+		f.Seek(0, io.SeekStart)
+		rdb := newRdbReader(f)
+		if err := rdbLoadRio(s, rdb, RDBFLAGS_AOF_PREAMBLE); err != nil {
+			serverLog(s, LL_WARNING, "%s", rdbLoadError(err))
+			if oldStyle {
+				serverLog(s, LL_WARNING, "Error reading the RDB preamble of the AOF file %s, AOF loading aborted", filename)
+			} else {
+				serverLog(s, LL_WARNING, "Error reading the RDB base file %s, AOF loading aborted", filename)
+			}
+			os.Exit(1)
+		}
+		/* The RDB reader is buffered: continue from the end of the RDB. */
+		validUpTo = rdb.processed
+		if validUpTo == size {
+			return AOF_OK
+		}
+		if oldStyle {
+			serverLog(s, LL_NOTICE, "Reading the remaining AOF tail...")
+		}
+	}
+	if _, err := f.Seek(validUpTo, io.SeekStart); err != nil {
+		serverLog(s, LL_WARNING, "Unrecoverable error reading the append only file %s: %s", filename, strerror(err))
+		os.Exit(1)
+	}
+
+	fakeClient := s.CreateClient(f)
+	fakeClient.FakeFlag = true
+	start := validUpTo
+
+	/* Read the actual AOF file, in REPL format, command by command. */
+	for {
+		r, err := fakeClient.decoder.Decode()
+		if err != nil {
+			if validUpTo == size && errors.Is(err, io.EOF) {
+				break
+			}
+			var perr *os.PathError
+			if errors.As(err, &perr) {
+				serverLog(s, LL_WARNING, "Unrecoverable error reading the append only file %s: %s", filename, strerror(err))
+				os.Exit(1)
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				/* If the truncated file is not the last file, we consider
+				 * this to be a fatal error. */
+				if !lastFile {
+					serverLog(s, LL_WARNING, "Fatal error: the truncated file %s is not the last file", filename)
+					os.Exit(1)
+				}
+				if s.loadTruncatedAppendOnlyFile(aofFilepath, validUpTo) {
+					return AOF_TRUNCATED
+				}
+				os.Exit(1)
+			}
+			s.aofFormatError(filename, validUpTo, aofErrorDescription(err))
+		}
+		argv, ok := aofCommandArgv(r)
+		if !ok {
+			s.aofFormatError(filename, validUpTo, "expected an array of bulk strings")
+		}
+
+		/* Command lookup */
+		name := argv[0].Ptr.(string)
+		if lookupCommand(name, s) == nil {
+			serverLog(s, LL_WARNING, "Unknown command '%s' reading the append only file %s at offset %d", name, filename, validUpTo)
+			os.Exit(1)
+		}
+
+		/* Run the command in the context of a fake client */
+		fakeClient.Argv = argv
+		fakeClient.Argc = len(argv)
+		s.ProcessCommand(fakeClient)
+		validUpTo = start + fakeClient.decoder.Offset()
+	}
+	return AOF_OK
+}
+
+/* Load the AOF files according to s.aofManifest: the BASE first, then the
+ * INCR files in order. Returns AOF_OK, or AOF_NOT_EXIST or AOF_EMPTY when
+ * there is no AOF or all the files are zero-length. On fatal error an error
+ * message is logged and the program exists. */
+func (s *Server) LoadAppendOnlyFiles() int {
+	am := s.aofManifest
+
+	/* If the 'appendfilename' file exist in the working dir, we may be
+	 * starting from an old godis version. We will enter upgrade mode in
+	 * three situations.
+	 * 1. If the 'appenddirname' directory not exist
+	 * 2. If the 'appenddirname' directory exists but the manifest file is missing
+	 * 3. If the 'appenddirname' directory exists and the manifest file it contains
+	 *    has only one base AOF record, and the file name of this base AOF is
+	 *    'appendfilename', and the 'appendfilename' file not exist in
+	 *    'appenddirname' directory */
+	if fileExist(s.AofFilename) {
+		if !dirExists(s.AofDirname) ||
+			(am.baseAofInfo == nil && len(am.incrAofList) == 0) ||
+			(am.baseAofInfo != nil && len(am.incrAofList) == 0 &&
+				am.baseAofInfo.fileName == s.AofFilename && !fileExist(aofFilePath(s, s.AofFilename))) {
+			aofUpgradePrepare(s, am)
+		}
 	}
-	return pros
+
+	if am.baseAofInfo == nil && len(am.incrAofList) == 0 {
+		return AOF_NOT_EXIST
+	}
+
+	/* Here we calculate the total size of all BASE and INCR files in
+	 * advance. If an AOF exists in the manifest but not on the disk, we
+	 * consider this to be a fatal error. */
+	totalSize, err := getBaseAndIncrAppendOnlyFilesSize(s, am)
+	if err != nil {
+		os.Exit(1)
+	} else if totalSize == 0 {
+		return AOF_EMPTY
+	}
+
+	/* Temporarily disable AOF, to prevent the commands we replay from
+	 * being fed to the same file we're about to read. */
+	oldAofState := s.AofState
+	s.AofState = AOF_OFF
+	s.Loading = true
+	defer func() {
+		s.AofState = oldAofState
+		s.Loading = false
+	}()
+
+	totalNum := len(am.incrAofList)
+	if am.baseAofInfo != nil {
+		totalNum++
+	}
+	aofNum := 0
+
+	/* Load BASE AOF if needed. */
+	var baseSize int64
+	if am.baseAofInfo != nil {
+		aofName := am.baseAofInfo.fileName
+		baseSize, _ = getAppendOnlyFileSize(s, aofName)
+		aofNum++
+		start := time.Now()
+		ret := loadSingleAppendOnlyFile(s, aofName, aofNum == totalNum)
+		if ret == AOF_OK || ret == AOF_TRUNCATED {
+			serverLog(s, LL_NOTICE, "DB loaded from base file %s: %.3f seconds", aofName, time.Since(start).Seconds())
+		}
+	}
+
+	/* Load INCR AOFs if needed. */
+	for _, ai := range am.incrAofList {
+		aofName := ai.fileName
+		aofNum++
+		start := time.Now()
+		ret := loadSingleAppendOnlyFile(s, aofName, aofNum == totalNum)
+		/* We know that (at least) one of the AOF files has data (totalSize > 0),
+		 * so empty INCR AOF file doesn't count as a AOF_EMPTY result */
+		if ret == AOF_OK || ret == AOF_TRUNCATED || ret == AOF_EMPTY {
+			serverLog(s, LL_NOTICE, "DB loaded from incr file %s: %.3f seconds", aofName, time.Since(start).Seconds())
+		}
+	}
+
+	/* The size may be changed by the truncation of the last file. */
+	if size, err := getBaseAndIncrAppendOnlyFilesSize(s, am); err == nil {
+		totalSize = size
+	}
+	s.AofCurrentSize = totalSize
+
+	/* Ideally, the AofRewriteBaseSize should hold the size of the AOF when
+	 * the last rewrite ended, this should include the size of the INCR file
+	 * that was created during the rewrite since otherwise we risk the next
+	 * automatic rewrite to happen too soon. However, since we do not persist
+	 * it anywhere, we initialize it on restart to the size of the BASE file.
+	 * This might cause the first rewrite to be executed early, but that
+	 * shouldn't be a problem since everything will be fine after it. */
+	s.AofRewriteBaseSize = baseSize
+	return AOF_OK
+}
+
+/* The AOF ends in the middle of a command, as it happens when the server
+ * crashed while writing it. With aof-load-truncated the partial command
+ * is removed and true is returned so that the server can start with the
+ * commands loaded so far. */
+func (s *Server) loadTruncatedAppendOnlyFile(filename string, validUpTo int64) bool {
+	if s.AofLoadTruncated {
+		serverLog(s, LL_WARNING, "!!! Warning: short read while loading the AOF file %s!!!", filename)
+		serverLog(s, LL_WARNING, "!!! Truncating the AOF at offset %d !!!", validUpTo)
+		if err := os.Truncate(filename, validUpTo); err != nil {
+			serverLog(s, LL_WARNING, "Error truncating the AOF file: %s", strerror(err))
+		} else {
+			serverLog(s, LL_WARNING, "AOF loaded anyway because aof-load-truncated is enabled")
+			return true
+		}
+	}
+	serverLog(s, LL_WARNING, "Unexpected end of file reading the append only file %s at offset %d. You can: "+
+		"1) Make a backup of your AOF file, then use ./godis-check-aof --fix <filename.manifest>. "+
+		"2) Alternatively you can set the 'aof-load-truncated' configuration option to yes and restart the server.",
+		filename, validUpTo)
+	return false
+}
+
+// aofFormatError aof中有无法解析的内容 记录出错的位置后退出
+func (s *Server) aofFormatError(filename string, offset int64, desc string) {
+	serverLog(s, LL_WARNING, "Bad file format reading the append only file %s at offset %d (%s): "+
+		"make a backup of your AOF file, then use ./godis-check-aof --fix <filename.manifest>", filename, offset, desc)
+	os.Exit(1)
 }
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"os"
+	"testing"
+)
+
+// incrAofSize 当前INCR文件的大小 回复客户端之前命令已经写入文件
+func incrAofSize(t *testing.T, s *Server) int64 {
+	t.Helper()
+	var filename string
+	s.Exec(func() {
+		filename = aofFilePath(s, getLastIncrAofName(s, s.aofManifest))
+	})
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+// TestAofLoadTruncated 服务端写入最后一条命令时崩溃 开启aof-load-truncated时
+// 不完整的命令被截掉 之前的命令都能加载
+func TestAofLoadTruncated(t *testing.T) {
+	s := newTestServer(t)
+	startTestServer(t, s)
+	tc := dialTestServer(s)
+	defer tc.conn.Close()
+
+	/* Values with CRLF and zero bytes go through the streaming decoder. */
+	tc.do(t, "set", "foo", "bar")
+	tc.do(t, "set", "bin", "a\r\nb\x00c")
+	tc.do(t, "lpush", "list", "1", "2", "3")
+	tc.do(t, "incr", "counter")
+	validUpTo := incrAofSize(t, s)
+	tc.do(t, "set", "partial", "value")
+	size := incrAofSize(t, s)
+	if size <= validUpTo {
+		t.Fatalf("the last command was not appended: size %d", size)
+	}
+
+	var filename string
+	s.Exec(func() {
+		filename = aofFilePath(s, getLastIncrAofName(s, s.aofManifest))
+	})
+	/* Stop writing to the AOF, as the crashed server would. */
+	tc.do(t, "config", "set", "appendonly", "no")
+	if err := os.Truncate(filename, size-3); err != nil {
+		t.Fatal(err)
+	}
+
+	/* Restart: the incomplete command is removed from the file. */
+	s = createTestServer()
+	s.AofLoadTruncated = true
+	s.AofState = AOF_ON
+	s.AofLoadManifestFromDisk()
+	if ret := s.LoadAppendOnlyFiles(); ret != AOF_OK {
+		t.Fatalf("LoadAppendOnlyFiles: %d", ret)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != validUpTo {
+		t.Errorf("AOF truncated to %d bytes, want %d", fi.Size(), validUpTo)
+	}
+
+	db := s.Db[0]
+	for key, want := range map[string]string{"foo": "bar", "bin": "a\r\nb\x00c", "counter": "1"} {
+		o := lookupKey(db, CreateObject(ObjectTypeString, key))
+		if o == nil {
+			t.Errorf("key %q not loaded", key)
+			continue
+		}
+		if got := getStringFromObject(o); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if o := lookupKey(db, CreateObject(ObjectTypeString, "list")); o == nil || listTypeLength(o) != 3 {
+		t.Errorf("list not loaded")
+	}
+	if lookupKey(db, CreateObject(ObjectTypeString, "partial")) != nil {
+		t.Errorf("the truncated command was loaded")
+	}
+}
+
+// TestAofRewriteSnapshot 后台重写期间修改数据 新的BASE是重写开始时的数据
+// 之后的修改写入新的INCR文件 重启后加载的数据与重启前相同
+func TestAofRewriteSnapshot(t *testing.T) {
+	for _, preamble := range []string{"yes", "no"} {
+		t.Run("aof-use-rdb-preamble "+preamble, func(t *testing.T) {
+			s := newTestServer(t)
+			startTestServer(t, s)
+			tc := dialTestServer(s)
+			defer tc.conn.Close()
+
+			tc.do(t, "config", "set", "aof-use-rdb-preamble", preamble)
+			fillSnapshotTestDataset(t, tc, 100)
+			tc.do(t, "bgrewriteaof")
+			modifySnapshotTestDataset(t, tc, 100)
+			waitForChild(t, s)
+			/* Modified again after the rewrite. */
+			modifySnapshotTestDataset(t, tc, 100)
+
+			var want map[string]string
+			s.Exec(func() {
+				want = dumpDb(s.Db[0])
+			})
+			s = createTestServer()
+			s.AofState = AOF_ON
+			s.AofLoadManifestFromDisk()
+			if ret := s.LoadAppendOnlyFiles(); ret != AOF_OK {
+				t.Fatalf("LoadAppendOnlyFiles: %d", ret)
+			}
+			got := dumpDb(s.Db[0])
+			if len(got) != len(want) {
+				t.Errorf("loaded %d keys, want %d", len(got), len(want))
+			}
+			for key, w := range want {
+				if got[key] != w {
+					t.Errorf("%s = %.80s, want %.80s", key, got[key], w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync/atomic"
+	"syscall"
+)
+
+// 后台I/O 参考redis的bio.c
+// 耗时的文件操作交给后台goroutine执行 避免阻塞事件循环
+// 每个goroutine按提交的顺序依次执行分配给它的任务
+
+/* Background job opcodes */
+const BIO_CLOSE_FILE = 0 /* Deferred close(2) syscall. */
+const BIO_AOF_FSYNC = 1  /* Deferred AOF fsync. */
+const BIO_CLOSE_AOF = 2  /* Deferred close for AOF files. */
+const BIO_NUM_OPS = 3
+
+// 执行后台任务的goroutine
+// aof的fsync与关闭由同一个goroutine执行 保证关闭文件时之前提交的fsync都已完成
+const BIO_WORKER_CLOSE_FILE = 0
+const BIO_WORKER_AOF_FSYNC = 1
+const BIO_WORKER_NUM = 2
+
+var bioJobToWorker = [BIO_NUM_OPS]int{
+	BIO_CLOSE_FILE: BIO_WORKER_CLOSE_FILE,
+	BIO_AOF_FSYNC:  BIO_WORKER_AOF_FSYNC,
+	BIO_CLOSE_AOF:  BIO_WORKER_AOF_FSYNC,
+}
+
+// 每个goroutine的任务队列的长度 队列满时提交任务会阻塞事件循环
+const BIO_QUEUE_LEN = 1024
+
+// bioJob 后台任务
+type bioJob struct {
+	typ       int
+	fd        *os.File
+	needFsync bool // BIO_CLOSE_FILE 关闭之前先fsync
+}
+
+// BioInit 为每个worker启动一个后台goroutine
+func (s *Server) BioInit() {
+	s.aofBioFsyncStatus = C_OK
+	for j := 0; j < BIO_WORKER_NUM; j++ {
+		s.bioJobs[j] = make(chan *bioJob, BIO_QUEUE_LEN)
+		go bioProcessBackgroundJobs(s, j)
+	}
+}
+
+// bioSubmitJob 提交一个后台任务
+func bioSubmitJob(s *Server, job *bioJob) {
+	atomic.AddInt64(&s.bioPending[job.typ], 1)
+	s.bioJobs[bioJobToWorker[job.typ]] <- job
+}
+
+// bioCreateCloseJob 在后台关闭文件 needFsync为true时先fsync
+func bioCreateCloseJob(s *Server, fd *os.File, needFsync bool) {
+	bioSubmitJob(s, &bioJob{typ: BIO_CLOSE_FILE, fd: fd, needFsync: needFsync})
+}
+
+// bioCreateCloseAofJob 在后台fsync并关闭aof文件 fsync的结果与BIO_AOF_FSYNC一样记录
+func bioCreateCloseAofJob(s *Server, fd *os.File) {
+	bioSubmitJob(s, &bioJob{typ: BIO_CLOSE_AOF, fd: fd})
+}
+
+// bioCreateFsyncJob 在后台fsync文件
+func bioCreateFsyncJob(s *Server, fd *os.File) {
+	bioSubmitJob(s, &bioJob{typ: BIO_AOF_FSYNC, fd: fd})
+}
+
+// bioPendingJobsOfType 尚未执行完的某种任务的数量
+func bioPendingJobsOfType(s *Server, typ int) int64 {
+	return atomic.LoadInt64(&s.bioPending[typ])
+}
+
+// bioProcessBackgroundJobs 后台goroutine 不访问事件循环中的状态
+// fsync的结果通过原子变量aofBioFsyncStatus返回给事件循环
+func bioProcessBackgroundJobs(s *Server, worker int) {
+	for job := range s.bioJobs[worker] {
+		switch job.typ {
+		case BIO_CLOSE_FILE:
+			if job.needFsync {
+				job.fd.Sync()
+			}
+			job.fd.Close()
+		case BIO_AOF_FSYNC, BIO_CLOSE_AOF:
+			/* The fd may be closed (for example the AOF was turned off)
+			 * before the job is processed, ignore the error in this case. */
+			err := job.fd.Sync()
+			if err != nil && !errors.Is(err, os.ErrClosed) && !errors.Is(err, syscall.EINVAL) {
+				s.aofBioFsyncErr.Store(strerror(err))
+				if atomic.SwapInt32(&s.aofBioFsyncStatus, C_ERR) == C_OK {
+					log.Printf("Fail to fsync the AOF file: %s", strerror(err))
+				}
+			} else {
+				atomic.StoreInt32(&s.aofBioFsyncStatus, C_OK)
+			}
+			if job.typ == BIO_CLOSE_AOF {
+				job.fd.Close()
+			}
+		}
+		atomic.AddInt64(&s.bioPending[job.typ], -1)
+	}
+}
@@ -0,0 +1,317 @@
+package core
+
+import (
+	"godis/core/proto"
+	"math"
+	"time"
+)
+
+/* Client block type (btype field in client structure)
+ * if CLIENT_BLOCKED flag is set. */
+const BLOCKED_NONE = 0 /* Not blocked, no CLIENT_BLOCKED flag set. */
+const BLOCKED_LIST = 1 /* BLPOP & co. */
+const BLOCKED_ZSET = 5 /* BZPOP et al. */
+
+// blockingState 客户端阻塞状态
+type blockingState struct {
+	timeout   int64          // 超时的毫秒时间戳 0 表示永久阻塞
+	keys      []*GodisObject // 阻塞等待的key
+	target    *GodisObject   // BLMOVE 的目标key
+	wherefrom int            // 弹出的位置 列表为LIST_HEAD/LIST_TAIL 有序集为ZSET_MIN/ZSET_MAX
+	whereto   int            // BLMOVE 添加到目标列表的位置
+}
+
+// getTimeoutFromObjectOrReply 解析阻塞命令的超时参数(秒 可为小数) 返回毫秒时间戳
+func getTimeoutFromObjectOrReply(c *Client, object *GodisObject, timeout *int64) int {
+	var tval float64
+	if getDoubleFromObjectOrReply(c, object, &tval, "ERR timeout is not a float or out of range") != C_OK {
+		return C_ERR
+	}
+	if tval < 0 {
+		addReplyError(c, "ERR timeout is negative")
+		return C_ERR
+	}
+	*timeout = 0
+	if tval > 0 {
+		/* The timeout is converted to an absolute time in milliseconds,
+		 * that must fit in a 64 bit integer. */
+		tval *= 1000
+		now := mstime()
+		if tval >= float64(math.MaxInt64-now) {
+			addReplyError(c, "ERR timeout is out of range")
+			return C_ERR
+		}
+		*timeout = now + int64(tval)
+	}
+	return C_OK
+}
+
+// mstime 当前毫秒时间戳
+func mstime() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+/* Set a client in blocking mode for the specified key, with the specified
+ * timeout. The 'btype' argument is the type of blocking operation, 'target'
+ * is the destination key of BLMOVE (nil for the other commands) and
+ * 'wherefrom'/'whereto' are the positions to pop from and push to. */
+func blockForKeys(c *Client, btype int, keys []*GodisObject, timeout int64, target *GodisObject, wherefrom int, whereto int) {
+	c.Bpop.timeout = timeout
+	c.Bpop.target = target
+	c.Bpop.wherefrom = wherefrom
+	c.Bpop.whereto = whereto
+	c.Bpop.keys = c.Bpop.keys[:0]
+
+	for _, key := range keys {
+		k := key.Ptr.(string)
+		/* If the key already exists in the list ignore it. */
+		duplicated := false
+		for _, added := range c.Bpop.keys {
+			if added.Ptr.(string) == k {
+				duplicated = true
+				break
+			}
+		}
+		if duplicated {
+			continue
+		}
+		c.Bpop.keys = append(c.Bpop.keys, key)
+
+		/* And in the other "side", to map keys -> clients */
+		clients := c.Db.BlockingKeys[k]
+		if clients == nil {
+			clients = listCreate()
+			c.Db.BlockingKeys[k] = clients
+		}
+		clients.listAddNodeTail(c)
+	}
+	c.Btype = btype
+	c.Flags |= CLIENT_BLOCKED
+}
+
+/* Unblock a client calling the right function depending on the kind
+ * of operation the client is blocking for. */
+func unblockClient(c *Client) {
+	for _, key := range c.Bpop.keys {
+		k := key.Ptr.(string)
+		clients := c.Db.BlockingKeys[k]
+		if clients == nil {
+			continue
+		}
+		if ln := clients.listSearchKey(c); ln != nil {
+			clients.listDelNode(ln)
+		}
+		/* If the list is empty we need to remove it to avoid wasting memory */
+		if clients.listLength() == 0 {
+			delete(c.Db.BlockingKeys, k)
+		}
+	}
+	c.Bpop.keys = c.Bpop.keys[:0]
+	c.Bpop.target = nil
+	c.Flags &^= CLIENT_BLOCKED
+	c.Btype = BLOCKED_NONE
+
+	// 唤醒等待中的连接
+	select {
+	case c.unblocked <- struct{}{}:
+	default:
+	}
+}
+
+/* If the specified key has clients blocked waiting for list pushes, this
+ * function will put the key reference into the db's ReadyKeys. Note that
+ * db.ReadyKeys is a set, so adding the same key multiple times is harmless. */
+func signalKeyAsReady(db *GodisDb, key *GodisObject) {
+	k := key.Ptr.(string)
+	/* Quick returns. */
+	if _, ok := db.BlockingKeys[k]; !ok {
+		return
+	}
+	db.ReadyKeys[k] = struct{}{}
+}
+
+/* This function should be called by Godis every time a single command,
+ * a MULTI/EXEC block, or a Lua script, terminated its execution after
+ * being called by a client. It handles serving clients blocked in
+ * lists, streams, and sorted sets, via a blocking commands.
+ *
+ * All the keys with at least one client blocked that received at least
+ * one new element via some write operation are accumulated into
+ * the ReadyKeys set of every db. This function will run the set and will
+ * serve clients accordingly. Note that the function will iterate again and
+ * again as a result of serving BLMOVE we can have new blocking clients
+ * to serve because of the PUSH side of BLMOVE. */
+func handleClientsBlockedOnKeys(s *Server) {
+	for {
+		served := false
+		for _, db := range s.Db {
+			if len(db.ReadyKeys) == 0 {
+				continue
+			}
+			/* Point ReadyKeys to a fresh set, so that while serving the
+			 * keys new ready keys are accumulated in the new set. */
+			readyKeys := db.ReadyKeys
+			db.ReadyKeys = make(map[string]struct{})
+			for k := range readyKeys {
+				serveClientsBlockedOnKey(s, db, CreateObject(ObjectTypeString, k))
+			}
+			served = true
+		}
+		if !served {
+			return
+		}
+	}
+}
+
+// serveClientsBlockedOnKey 按阻塞的先后顺序服务阻塞在key上的客户端
+func serveClientsBlockedOnKey(s *Server, db *GodisDb, key *GodisObject) {
+	clients := db.BlockingKeys[key.Ptr.(string)]
+	if clients == nil {
+		return
+	}
+	var next *listNode
+	for ln := clients.head; ln != nil; ln = next {
+		next = ln.next
+		/* If the key was deleted or emptied by a previous client there is
+		 * nothing more to serve. */
+		o := lookupKeyWrite(db, key)
+		if o == nil {
+			return
+		}
+		receiver := ln.value.(*Client)
+		switch {
+		case o.ObjectType == OBJ_LIST && receiver.Btype == BLOCKED_LIST:
+			serveClientBlockedOnList(s, receiver, key, o)
+		case o.ObjectType == OBJ_ZSET && receiver.Btype == BLOCKED_ZSET:
+			serveClientBlockedOnSortedSet(s, receiver, key, o)
+		}
+	}
+}
+
+/* This is a helper function for handleClientsBlockedOnKeys(). Its work
+ * is to serve a specific client (receiver) that is blocked on 'key'
+ * in the context of the specified 'db', doing the following:
+ *
+ * 1) Provide the client with the 'value' element.
+ * 2) If the dstkey is not nil (we are serving a BLMOVE) also push the
+ *    'value' element on the destination list (the "push" side of the command).
+ * 3) Propagate the resulting BRPOP, BLPOP and additional xPUSH if any into
+ *    the AOF. */
+func serveClientBlockedOnList(s *Server, receiver *Client, key *GodisObject, o *GodisObject) {
+	dstkey := receiver.Bpop.target
+	wherefrom := receiver.Bpop.wherefrom
+	whereto := receiver.Bpop.whereto
+
+	if dstkey == nil {
+		value := listTypePop(o, wherefrom)
+		if listTypeLength(o) == 0 {
+			dbDelete(receiver.Db, key)
+		}
+
+		/* Propagate the [LR]POP operation. */
+		cmdName := "lpop"
+		if wherefrom == LIST_TAIL {
+			cmdName = "rpop"
+		}
+		feedAppendOnlyFile(s, int(receiver.Db.ID), []*GodisObject{CreateObject(ObjectTypeString, cmdName), key})
+		s.Dirty++
+
+		addReplyArray(receiver, []*proto.Resp{bulkString(key.Ptr.(string)), bulkString(value)})
+		unblockClient(receiver)
+		return
+	}
+
+	/* BLMOVE */
+	dstobj := lookupKeyWrite(receiver.Db, dstkey)
+	if dstobj != nil && checkType(receiver, dstobj, OBJ_LIST) {
+		/* BLMOVE failed because of wrong destination type, the error is
+		 * replied to the receiver and the source list is left untouched. */
+		unblockClient(receiver)
+		return
+	}
+	value := listTypePop(o, wherefrom)
+	lmoveHandlePush(receiver, dstkey, dstobj, value, whereto)
+	if listTypeLength(o) == 0 {
+		dbDelete(receiver.Db, key)
+	}
+
+	/* Propagate the LMOVE operation. */
+	feedAppendOnlyFile(s, int(receiver.Db.ID), []*GodisObject{
+		CreateObject(ObjectTypeString, "lmove"), key, dstkey,
+		listPositionObject(wherefrom), listPositionObject(whereto),
+	})
+	s.Dirty++
+
+	addReplyBulk(receiver, value)
+	unblockClient(receiver)
+}
+
+/* Helper function for handleClientsBlockedOnKeys(). This function is called
+ * whenever a key we blocked on was modified and the client can be served
+ * by popping one element from the sorted set. */
+func serveClientBlockedOnSortedSet(s *Server, receiver *Client, key *GodisObject, zobj *GodisObject) {
+	where := receiver.Bpop.wherefrom
+	ele, score := zsetPop(zobj, where)
+	if zsetLength(zobj) == 0 {
+		dbDelete(receiver.Db, key)
+	}
+
+	/* Replicate the command as ZPOPMIN/ZPOPMAX with a single element. */
+	cmdName := "zpopmin"
+	if where == ZSET_MAX {
+		cmdName = "zpopmax"
+	}
+	feedAppendOnlyFile(s, int(receiver.Db.ID), []*GodisObject{
+		CreateObject(ObjectTypeString, cmdName), key,
+	})
+	s.Dirty++
+
+	addReplyArray(receiver, []*proto.Resp{
+		bulkString(key.Ptr.(string)), bulkString(ele), doubleValue(score),
+	})
+	unblockClient(receiver)
+}
+
+// replyToBlockedClientTimedOut 阻塞超时的回复为空数组
+func replyToBlockedClientTimedOut(c *Client) {
+	addReplyNullArray(c)
+}
+
+// WaitUnblocked 挂起阻塞中的客户端 直到被其他客户端写入唤醒、超时或连接断开
+// 在连接的goroutine中调用 超时与断开的处理投递到事件循环中执行
+// 连接在阻塞期间断开时返回false 调用者应丢弃之后已读取的命令
+func (s *Server) WaitUnblocked(c *Client) bool {
+	var timeout <-chan time.Time
+	if c.Bpop.timeout > 0 {
+		// 超时很长时换算成time.Duration会溢出 限制为Duration能表示的最大值
+		ms := c.Bpop.timeout - mstime()
+		if ms > math.MaxInt64/int64(time.Millisecond) {
+			ms = math.MaxInt64 / int64(time.Millisecond)
+		}
+		timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-c.unblocked:
+	case <-timeout:
+		s.Exec(func() {
+			// 超时的同时可能已被服务 此时不能覆盖回复
+			if c.Flags&CLIENT_BLOCKED != 0 {
+				replyToBlockedClientTimedOut(c)
+				unblockClient(c)
+			}
+		})
+		<-c.unblocked
+	case <-c.closed:
+		// 连接已断开 不再等待 避免之后写入的元素被弹出给已断开的客户端
+		s.Exec(func() {
+			if c.Flags&CLIENT_BLOCKED != 0 {
+				unblockClient(c)
+			}
+		})
+		<-c.unblocked
+		return false
+	}
+	return true
+}
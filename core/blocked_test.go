@@ -0,0 +1,49 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// TestBlockingTimeoutRange 超时换算成毫秒时间戳会溢出时报错 很长的超时不会立即到期
+func TestBlockingTimeoutRange(t *testing.T) {
+	s := newTestServer(t)
+	startTestServer(t, s)
+	tc := dialTestServer(s)
+	defer tc.conn.Close()
+
+	for _, cmd := range [][]string{
+		{"bzpopmin", "zset", "1e16"},
+		{"blpop", "list", "inf"},
+	} {
+		r, err := tc.call(cmd...)
+		if err == nil {
+			t.Errorf("%v: got %v, want an error", cmd, r)
+		}
+	}
+
+	/* About 3 years: the timer must not overflow and expire at once. */
+	blocked := dialTestServer(s)
+	defer blocked.conn.Close()
+	if err := blocked.send("blpop", "list", "99999999999"); err != nil {
+		t.Fatal(err)
+	}
+	replied := make(chan string, 1)
+	go func() {
+		r, err := blocked.dec.Decode()
+		if err != nil || len(r.Array) != 2 {
+			replied <- ""
+			return
+		}
+		replied <- string(r.Array[1].Value)
+	}()
+	select {
+	case <-replied:
+		t.Fatal("the client was unblocked before any push")
+	case <-time.After(100 * time.Millisecond):
+	}
+	tc.do(t, "lpush", "list", "a")
+	if v := <-replied; v != "a" {
+		t.Errorf("blpop = %q, want a", v)
+	}
+}
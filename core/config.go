@@ -0,0 +1,910 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"godis/core/proto"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 配置 参考redis的config.c
+// 配置文件与redis.conf的格式兼容 每行一个配置项 参数之间以空格分隔 支持引号和转义
+// 启动时依次加载配置文件、标准输入以及命令行参数 运行时可以通过CONFIG命令读取和修改
+
+const CONFIG_DEFAULT_PORT = 9736
+const CONFIG_DEFAULT_HZ = 10
+const CONFIG_MIN_HZ = 1
+const CONFIG_MAX_HZ = 500
+const CONFIG_DEFAULT_DBNUM = 16
+
+const CONFIG_REWRITE_SIGNATURE = "# Generated by CONFIG REWRITE"
+
+/* Log levels */
+const (
+	LL_DEBUG = iota
+	LL_VERBOSE
+	LL_NOTICE
+	LL_WARNING
+)
+
+// 配置项的标志
+const MODIFIABLE_CONFIG = 0       /* This is the implied default for a standard config, which is mutable. */
+const IMMUTABLE_CONFIG = (1 << 0) /* Can this value only be set at startup? */
+const MULTI_ARG_CONFIG = (1 << 1) /* This config receives multiple arguments. */
+
+// standardConfig 一个配置项 值的读写通过set/get完成 均以字符串表示
+type standardConfig struct {
+	name         string
+	alias        string // 别名 如旧版本的名字
+	flags        int
+	defaultValue string // 默认值 与get返回的格式一致
+	// set 解析参数并设置配置的值 出错时返回错误信息 且不修改当前的值
+	set func(s *Server, argv []string) error
+	// get 当前的值 用于CONFIG GET以及判断是否为默认值
+	get func(s *Server) string
+	// rewrite CONFIG REWRITE时写入配置文件的行 为nil时写入"name value"一行
+	rewrite func(s *Server) []string
+	// apply CONFIG SET修改之后调用 使新的值在运行时生效 加载配置文件时不调用
+	apply func(s *Server) error
+}
+
+var configs []*standardConfig
+
+func init() {
+	configs = []*standardConfig{
+		{
+			name: "bind", flags: IMMUTABLE_CONFIG | MULTI_ARG_CONFIG, defaultValue: "127.0.0.1",
+			set: func(s *Server, argv []string) error {
+				if len(argv) == 0 {
+					return errors.New("wrong number of arguments")
+				}
+				s.Bindaddr = append([]string{}, argv...)
+				return nil
+			},
+			get: func(s *Server) string { return strings.Join(s.Bindaddr, " ") },
+			rewrite: func(s *Server) []string {
+				return []string{"bind " + strings.Join(s.Bindaddr, " ")}
+			},
+		},
+		createIntConfig("port", "", IMMUTABLE_CONFIG, 0, 65535,
+			func(s *Server) *int { return &s.Port }, CONFIG_DEFAULT_PORT, nil),
+		createIntConfig("databases", "", IMMUTABLE_CONFIG, 1, 1<<31-1,
+			func(s *Server) *int { return &s.DbNum }, CONFIG_DEFAULT_DBNUM, nil),
+		{
+			/* The default keeps the working directory, the current one is
+			 * always written by CONFIG REWRITE. */
+			name: "dir", flags: MODIFIABLE_CONFIG, defaultValue: ".",
+			set: func(s *Server, argv []string) error {
+				if len(argv) != 1 {
+					return errors.New("wrong number of arguments")
+				}
+				/* The background save and AOF rewrite open their temp
+				 * files by relative paths: don't move them elsewhere. */
+				if hasActiveChildProcess(s) {
+					return errors.New("can't change the working directory while a background save or AOF rewrite is in progress")
+				}
+				return os.Chdir(argv[0])
+			},
+			get: func(s *Server) string {
+				dir, _ := os.Getwd()
+				return dir
+			},
+			rewrite: func(s *Server) []string {
+				dir, _ := os.Getwd()
+				return []string{"dir " + catRepr(dir)}
+			},
+		},
+		createStringConfig("dbfilename", "", MODIFIABLE_CONFIG,
+			func(s *Server) *string { return &s.RdbFilename }, "dump.rdb", isValidFilename("dbfilename")),
+		{
+			name: "save", flags: MODIFIABLE_CONFIG | MULTI_ARG_CONFIG, defaultValue: "3600 1 300 100 60 10000",
+			set: setSaveParams,
+			get: func(s *Server) string {
+				var values []string
+				for _, sp := range s.SaveParams {
+					values = append(values, fmt.Sprintf("%d %d", sp.seconds, sp.changes))
+				}
+				return strings.Join(values, " ")
+			},
+			rewrite: func(s *Server) []string {
+				/* Rewrite save parameters, or an empty 'save ""' line to
+				 * avoid the defaults from being used. */
+				if len(s.SaveParams) == 0 {
+					return []string{`save ""`}
+				}
+				var lines []string
+				for _, sp := range s.SaveParams {
+					lines = append(lines, fmt.Sprintf("save %d %d", sp.seconds, sp.changes))
+				}
+				return lines
+			},
+		},
+		createBoolConfig("rdbcompression", "", MODIFIABLE_CONFIG,
+			func(s *Server) *bool { return &s.RdbCompression }, true, nil),
+		createBoolConfig("rdbchecksum", "", IMMUTABLE_CONFIG,
+			func(s *Server) *bool { return &s.RdbChecksum }, true, nil),
+		createBoolConfig("stop-writes-on-bgsave-error", "", MODIFIABLE_CONFIG,
+			func(s *Server) *bool { return &s.StopWritesOnBgsaveErr }, true, nil),
+		createStringConfig("appendfilename", "", IMMUTABLE_CONFIG,
+			func(s *Server) *string { return &s.AofFilename }, "godis.aof", isValidFilename("appendfilename")),
+		createStringConfig("appenddirname", "", IMMUTABLE_CONFIG,
+			func(s *Server) *string { return &s.AofDirname }, "appendonlydir", isValidAOFdirname),
+		/* Unlike Redis the AOF is enabled by default, since it used to be
+		 * the only way godis persisted the data set. */
+		createBoolConfig("appendonly", "", MODIFIABLE_CONFIG,
+			func(s *Server) *bool { return &s.AofEnabled }, true, updateAppendonly),
+		createEnumConfig("appendfsync", "", MODIFIABLE_CONFIG, []string{"no", "always", "everysec"},
+			func(s *Server) *int { return &s.AofFsync }, AOF_FSYNC_EVERYSEC),
+		createBoolConfig("aof-load-truncated", "", MODIFIABLE_CONFIG,
+			func(s *Server) *bool { return &s.AofLoadTruncated }, true, nil),
+		createBoolConfig("no-appendfsync-on-rewrite", "", MODIFIABLE_CONFIG,
+			func(s *Server) *bool { return &s.AofNoFsyncOnRewrite }, false, nil),
+		createBoolConfig("aof-use-rdb-preamble", "", MODIFIABLE_CONFIG,
+			func(s *Server) *bool { return &s.AofUseRdbPreamble }, true, nil),
+		createIntConfig("auto-aof-rewrite-percentage", "", MODIFIABLE_CONFIG, 0, 1<<31-1,
+			func(s *Server) *int { return &s.AofRewritePerc }, 100, nil),
+		createIntConfig("auto-aof-rewrite-min-size", "", MODIFIABLE_CONFIG, 0, 1<<63-1,
+			func(s *Server) *int { return &s.AofRewriteMinSize }, 64*1024*1024, nil),
+		createStringConfig("logfile", "", IMMUTABLE_CONFIG,
+			func(s *Server) *string { return &s.Logfile }, "", nil),
+		createEnumConfig("loglevel", "", MODIFIABLE_CONFIG, []string{"debug", "verbose", "notice", "warning"},
+			func(s *Server) *int { return &s.Verbosity }, LL_NOTICE),
+		createIntConfig("hz", "", MODIFIABLE_CONFIG, 0, 1<<31-1,
+			func(s *Server) *int { return &s.Hz }, CONFIG_DEFAULT_HZ, updateHZ),
+		createIntConfig("hash-max-ziplist-entries", "hash-max-listpack-entries", MODIFIABLE_CONFIG, 0, 1<<31-1,
+			func(s *Server) *int { return &s.HashMaxZiplistEntries }, 128, nil),
+		createIntConfig("hash-max-ziplist-value", "hash-max-listpack-value", MODIFIABLE_CONFIG, 0, 1<<31-1,
+			func(s *Server) *int { return &s.HashMaxZiplistValue }, 64, nil),
+		createIntConfig("set-max-intset-entries", "", MODIFIABLE_CONFIG, 0, 1<<31-1,
+			func(s *Server) *int { return &s.SetMaxIntsetEntries }, 512, nil),
+		{
+			name: "client-output-buffer-limit", flags: MODIFIABLE_CONFIG | MULTI_ARG_CONFIG,
+			defaultValue: "normal 0 0 0 pubsub 33554432 8388608 60",
+			set:          setClientOutputBufferLimit,
+			get: func(s *Server) string {
+				var values []string
+				for class := 0; class < CLIENT_TYPE_OBUF_COUNT; class++ {
+					l := s.ClientObufLimits[class]
+					values = append(values, fmt.Sprintf("%s %d %d %d", getClientTypeName(class),
+						l.HardLimitBytes, l.SoftLimitBytes, l.SoftLimitSeconds))
+				}
+				return strings.Join(values, " ")
+			},
+			rewrite: func(s *Server) []string {
+				var lines []string
+				for class := 0; class < CLIENT_TYPE_OBUF_COUNT; class++ {
+					l := s.ClientObufLimits[class]
+					lines = append(lines, fmt.Sprintf("client-output-buffer-limit %s %s %s %d", getClientTypeName(class),
+						formatMemory(l.HardLimitBytes), formatMemory(l.SoftLimitBytes), l.SoftLimitSeconds))
+				}
+				return lines
+			},
+		},
+	}
+}
+
+// createIntConfig 整数配置项 取值范围为[min, max] update在值改变后调用
+func createIntConfig(name string, alias string, flags int, min int64, max int64,
+	field func(s *Server) *int, defaultValue int, update func(s *Server)) *standardConfig {
+	return &standardConfig{
+		name: name, alias: alias, flags: flags, defaultValue: strconv.Itoa(defaultValue),
+		set: func(s *Server, argv []string) error {
+			if len(argv) != 1 {
+				return errors.New("wrong number of arguments")
+			}
+			v, ok := memtoll(argv[0])
+			if !ok {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			if v < min || v > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			*field(s) = int(v)
+			if update != nil {
+				update(s)
+			}
+			return nil
+		},
+		get: func(s *Server) string { return strconv.Itoa(*field(s)) },
+	}
+}
+
+// createBoolConfig 布尔配置项 取值为yes或no
+func createBoolConfig(name string, alias string, flags int,
+	field func(s *Server) *bool, defaultValue bool, apply func(s *Server) error) *standardConfig {
+	return &standardConfig{
+		name: name, alias: alias, flags: flags, defaultValue: boolToYesNo(defaultValue),
+		set: func(s *Server, argv []string) error {
+			if len(argv) != 1 {
+				return errors.New("wrong number of arguments")
+			}
+			v, ok := yesnotoi(argv[0])
+			if !ok {
+				return errors.New("argument must be 'yes' or 'no'")
+			}
+			*field(s) = v
+			return nil
+		},
+		get:   func(s *Server) string { return boolToYesNo(*field(s)) },
+		apply: apply,
+	}
+}
+
+// yesnotoi 解析yes/no 不区分大小写
+func yesnotoi(v string) (bool, bool) {
+	if strings.EqualFold(v, "yes") {
+		return true, true
+	}
+	if strings.EqualFold(v, "no") {
+		return false, true
+	}
+	return false, false
+}
+
+// boolToYesNo 布尔配置的字符串表示
+func boolToYesNo(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
+
+// createStringConfig 字符串配置项 validate不为nil时先校验参数
+func createStringConfig(name string, alias string, flags int,
+	field func(s *Server) *string, defaultValue string, validate func(v string) error) *standardConfig {
+	return &standardConfig{
+		name: name, alias: alias, flags: flags, defaultValue: defaultValue,
+		set: func(s *Server, argv []string) error {
+			if len(argv) != 1 {
+				return errors.New("wrong number of arguments")
+			}
+			if validate != nil {
+				if err := validate(argv[0]); err != nil {
+					return err
+				}
+			}
+			*field(s) = argv[0]
+			return nil
+		},
+		get: func(s *Server) string { return *field(s) },
+		rewrite: func(s *Server) []string {
+			return []string{name + " " + catRepr(*field(s))}
+		},
+	}
+}
+
+// createEnumConfig 枚举配置项 值为enum中的下标 名字不区分大小写
+func createEnumConfig(name string, alias string, flags int, enum []string,
+	field func(s *Server) *int, defaultValue int) *standardConfig {
+	return &standardConfig{
+		name: name, alias: alias, flags: flags, defaultValue: enum[defaultValue],
+		set: func(s *Server, argv []string) error {
+			if len(argv) != 1 {
+				return errors.New("wrong number of arguments")
+			}
+			for i, v := range enum {
+				if strings.EqualFold(v, argv[0]) {
+					*field(s) = i
+					return nil
+				}
+			}
+			return errors.New("argument(s) must be one of the following: " + strings.Join(enum, ", "))
+		},
+		get: func(s *Server) string { return enum[*field(s)] },
+	}
+}
+
+// isValidFilename 文件名中不能包含路径
+func isValidFilename(name string) func(v string) error {
+	return func(v string) error {
+		if strings.ContainsRune(v, '/') {
+			return errors.New(name + " can't be a path, just a filename")
+		}
+		return nil
+	}
+}
+
+// isValidAOFdirname appenddirname只能是当前目录下的一个目录名
+func isValidAOFdirname(v string) error {
+	if strings.ContainsRune(v, '/') {
+		return errors.New("appenddirname can't be a path, just a dirname")
+	}
+	if v == "" || v == "." || v == ".." {
+		return errors.New("appenddirname must be a valid directory name")
+	}
+	return nil
+}
+
+// updateAppendonly 运行时打开或关闭aof 打开aof时先在后台重写aof
+func updateAppendonly(s *Server) error {
+	if s.AofEnabled && s.AofState == AOF_OFF {
+		if startAppendOnly(s) == C_ERR {
+			return errors.New("Unable to turn on AOF. Check server logs.")
+		}
+	} else if !s.AofEnabled && s.AofState != AOF_OFF {
+		stopAppendOnly(s)
+	}
+	return nil
+}
+
+// updateHZ hz超出范围时取最近的有效值 事件循环在下一次执行时使用新的频率
+func updateHZ(s *Server) {
+	if s.Hz < CONFIG_MIN_HZ {
+		s.Hz = CONFIG_MIN_HZ
+	}
+	if s.Hz > CONFIG_MAX_HZ {
+		s.Hz = CONFIG_MAX_HZ
+	}
+}
+
+/* Set the save points from pairs of <seconds> <changes>. An empty argument
+ * (save "") removes all the save points, disabling the automatic saving. */
+func setSaveParams(s *Server, argv []string) error {
+	if len(argv) == 0 || (len(argv) == 1 && argv[0] == "") {
+		s.SaveParams = nil
+		return nil
+	}
+	if len(argv)%2 != 0 {
+		return errors.New("Invalid save parameters")
+	}
+	params := make([]saveParam, 0, len(argv)/2)
+	for j := 0; j < len(argv); j += 2 {
+		seconds, err1 := strconv.ParseInt(argv[j], 10, 64)
+		changes, err2 := strconv.ParseInt(argv[j+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return errors.New("Invalid save parameters")
+		}
+		params = append(params, saveParam{seconds: seconds, changes: changes})
+	}
+	s.SaveParams = params
+	return nil
+}
+
+// getClientTypeName 客户端类型的名字 用于client-output-buffer-limit
+func getClientTypeName(class int) string {
+	switch class {
+	case CLIENT_TYPE_NORMAL:
+		return "normal"
+	case CLIENT_TYPE_PUBSUB:
+		return "pubsub"
+	default:
+		return ""
+	}
+}
+
+// getClientTypeByName 根据名字得到客户端类型 不存在时返回-1
+func getClientTypeByName(name string) int {
+	for class := 0; class < CLIENT_TYPE_OBUF_COUNT; class++ {
+		if strings.EqualFold(name, getClientTypeName(class)) {
+			return class
+		}
+	}
+	return -1
+}
+
+/* client-output-buffer-limit <class> <hard> <soft> <soft_seconds>, with one
+ * or more classes. All the classes are validated before any limit is
+ * changed. */
+func setClientOutputBufferLimit(s *Server, argv []string) error {
+	if len(argv) == 0 || len(argv)%4 != 0 {
+		return errors.New("Wrong number of arguments in buffer limit configuration.")
+	}
+	limits := s.ClientObufLimits
+	for j := 0; j < len(argv); j += 4 {
+		class := getClientTypeByName(argv[j])
+		if strings.EqualFold(argv[j], "replica") || strings.EqualFold(argv[j], "slave") {
+			/* There are no replicas in godis: the limits of the replica
+			 * class are accepted and ignored, so that redis.conf can be
+			 * used as it is. */
+			class = CLIENT_TYPE_OBUF_COUNT
+		} else if class == -1 {
+			return errors.New("Invalid client class specified in buffer limit configuration.")
+		}
+		hard, ok1 := memtoll(argv[j+1])
+		soft, ok2 := memtoll(argv[j+2])
+		softSeconds, err := strconv.ParseInt(argv[j+3], 10, 64)
+		if !ok1 || !ok2 || err != nil || hard < 0 || soft < 0 || softSeconds < 0 {
+			return errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		if class < CLIENT_TYPE_OBUF_COUNT {
+			limits[class] = ClientBufferLimitsConfig{HardLimitBytes: hard, SoftLimitBytes: soft, SoftLimitSeconds: softSeconds}
+		}
+	}
+	s.ClientObufLimits = limits
+	return nil
+}
+
+// lookupConfig 根据名字或别名查找配置项 不区分大小写
+func lookupConfig(name string) *standardConfig {
+	for _, config := range configs {
+		if strings.EqualFold(config.name, name) || (config.alias != "" && strings.EqualFold(config.alias, name)) {
+			return config
+		}
+	}
+	return nil
+}
+
+// configValueArgs 将CONFIG SET的值转换为参数 接收多个参数的配置项按空格分隔
+func configValueArgs(config *standardConfig, value string) ([]string, bool) {
+	if config.flags&MULTI_ARG_CONFIG != 0 {
+		return splitArgs(value)
+	}
+	return []string{value}, true
+}
+
+// InitServerConfig 将所有配置项设为默认值 需在加载配置文件之前调用
+func (s *Server) InitServerConfig() {
+	for _, config := range configs {
+		argv, _ := configValueArgs(config, config.defaultValue)
+		if err := config.set(s, argv); err != nil {
+			panic("bad default value for config " + config.name + ": " + err.Error())
+		}
+	}
+}
+
+// serverLog 按日志级别输出日志 低于loglevel的日志被忽略
+func serverLog(s *Server, level int, format string, args ...interface{}) {
+	if level < s.Verbosity {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+/* Load the server configuration from the specified filename.
+ * The function appends the additional configuration directives stored
+ * in the 'options' string to the config file before loading.
+ *
+ * Both filename and options can be empty, in such a case are considered
+ * empty. This way loadServerConfig can be used to just load a file or
+ * just load a string. */
+func (s *Server) LoadServerConfig(filename string, configFromStdin bool, options string) error {
+	config := ""
+
+	/* Load the file content */
+	if filename != "" {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("Fatal error, can't open config file '%s': %s", filename, err)
+		}
+		config += string(content)
+	}
+	/* Append content from stdin */
+	if configFromStdin {
+		serverLog(s, LL_WARNING, "Reading config from stdin")
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			line, err := reader.ReadString('\n')
+			config += line
+			if err != nil {
+				break
+			}
+		}
+	}
+	/* Append the additional options */
+	if options != "" {
+		config += "\n" + options
+	}
+	return s.loadServerConfigFromString(config)
+}
+
+// loadServerConfigFromString 逐行解析配置 出错时返回的错误中包含出错的行
+func (s *Server) loadServerConfigFromString(config string) error {
+	lines := strings.Split(config, "\n")
+	for i, line := range lines {
+		line = strings.Trim(line, " \t\r\n")
+
+		/* Skip comments and blank lines */
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		/* Split into arguments */
+		argv, ok := splitArgs(line)
+		if !ok {
+			return configLoadError(i+1, line, "Unbalanced quotes in configuration line")
+		}
+
+		/* Skip this line if the resulting command vector is empty. */
+		if len(argv) == 0 {
+			continue
+		}
+		argv[0] = strings.ToLower(argv[0])
+
+		/* Execute config directives */
+		if argv[0] == "include" && len(argv) == 2 {
+			if err := s.LoadServerConfig(argv[1], false, ""); err != nil {
+				return err
+			}
+			continue
+		}
+		config := lookupConfig(argv[0])
+		if config == nil {
+			/* Directives godis doesn't support are skipped, so that an
+			 * existing redis.conf can be used as is. */
+			serverLog(s, LL_WARNING, "Unsupported config directive '%s' at line %d, skipping", argv[0], i+1)
+			continue
+		}
+		if len(argv) < 2 {
+			return configLoadError(i+1, line, "wrong number of arguments")
+		}
+		args := argv[1:]
+		if config.flags&MULTI_ARG_CONFIG != 0 && len(args) == 1 && args[0] != "" {
+			/* For multi-arg configs, if we only have one argument, try to
+			 * split it by spaces, as "--save '900 1'" from the command line
+			 * arrives here as a single quoted argument. */
+			if split, ok := splitArgs(args[0]); ok && len(split) > 0 {
+				args = split
+			}
+		}
+		if config.name == "save" && args[0] != "" {
+			/* Every save line in the config file adds a save point, the
+			 * first one replaces the default save points. */
+			if s.saveParamsLoaded {
+				var prev []string
+				for _, sp := range s.SaveParams {
+					prev = append(prev, strconv.FormatInt(sp.seconds, 10), strconv.FormatInt(sp.changes, 10))
+				}
+				args = append(prev, args...)
+			}
+			s.saveParamsLoaded = true
+		}
+		if err := config.set(s, args); err != nil {
+			return configLoadError(i+1, line, err.Error())
+		}
+	}
+	return nil
+}
+
+// configLoadError 加载配置出错时的错误信息 与redis的格式一致
+func configLoadError(linenum int, line string, err string) error {
+	return fmt.Errorf("\n*** FATAL CONFIG FILE ERROR (Godis %s) ***\n"+
+		"Reading the configuration file, at line %d\n"+
+		">>> '%s'\n"+
+		"%s", GODIS_VERSION, linenum, line, err)
+}
+
+/*-----------------------------------------------------------------------------
+ * CONFIG SET implementation
+ *----------------------------------------------------------------------------*/
+
+// configSetCommand CONFIG SET parameter value [parameter value ...]
+// 所有参数都设置成功才回复OK 否则恢复已经修改的配置项
+func configSetCommand(c *Client, s *Server) {
+	if c.Argc < 4 || c.Argc%2 != 0 {
+		addReplyError(c, "ERR wrong number of arguments for 'config|set' command")
+		return
+	}
+
+	/* Find all relevant configs */
+	n := (c.Argc - 2) / 2
+	setConfigs := make([]*standardConfig, n)
+	for i := 0; i < n; i++ {
+		name := c.Argv[2+i*2].Ptr.(string)
+		config := lookupConfig(name)
+		/* Fail if we couldn't find this config */
+		if config == nil {
+			addReplyError(c, fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name))
+			return
+		}
+		if config.flags&IMMUTABLE_CONFIG != 0 {
+			addReplyError(c, fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", name))
+			return
+		}
+		for j := 0; j < i; j++ {
+			if setConfigs[j] == config {
+				addReplyError(c, fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", name))
+				return
+			}
+		}
+		setConfigs[i] = config
+	}
+
+	/* Set all the configs, restoring the old values on failure. */
+	oldValues := make([]string, n)
+	restore := func(count int) {
+		for j := count - 1; j >= 0; j-- {
+			old, _ := configValueArgs(setConfigs[j], oldValues[j])
+			setConfigs[j].set(s, old)
+		}
+	}
+	for i, config := range setConfigs {
+		oldValues[i] = config.get(s)
+		argv, ok := configValueArgs(config, c.Argv[3+i*2].Ptr.(string))
+		var err error
+		if !ok {
+			err = errors.New("Unbalanced quotes")
+		} else {
+			err = config.set(s, argv)
+		}
+		if err != nil {
+			restore(i)
+			addReplyError(c, fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s",
+				c.Argv[2+i*2].Ptr.(string), err))
+			return
+		}
+	}
+
+	/* Apply the new values. A failed apply leaves the server as it was,
+	 * so the old values are restored as well. */
+	for i, config := range setConfigs {
+		if config.apply == nil {
+			continue
+		}
+		if err := config.apply(s); err != nil {
+			restore(n)
+			addReplyError(c, fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s",
+				c.Argv[2+i*2].Ptr.(string), err))
+			return
+		}
+	}
+	addReplyStatus(c, "OK")
+}
+
+/*-----------------------------------------------------------------------------
+ * CONFIG GET implementation
+ *----------------------------------------------------------------------------*/
+
+// configGetCommand CONFIG GET parameter [parameter ...] 参数支持glob风格的匹配
+func configGetCommand(c *Client, s *Server) {
+	items := []*proto.Resp{}
+	matched := make(map[*standardConfig]bool)
+	for i := 2; i < c.Argc; i++ {
+		pattern := c.Argv[i].Ptr.(string)
+		for _, config := range configs {
+			if matched[config] {
+				continue
+			}
+			/* A config matched by its alias is replied with the alias. */
+			name := ""
+			if stringmatch(pattern, config.name, true) {
+				name = config.name
+			} else if config.alias != "" && stringmatch(pattern, config.alias, true) {
+				name = config.alias
+			}
+			if name == "" {
+				continue
+			}
+			matched[config] = true
+			items = append(items, bulkString(name), bulkString(config.get(s)))
+		}
+	}
+	addReplyMap(c, items)
+}
+
+/*-----------------------------------------------------------------------------
+ * CONFIG REWRITE implementation
+ *----------------------------------------------------------------------------*/
+
+/* The config rewrite state. */
+type rewriteConfigState struct {
+	optionToLine map[string][]int // Option -> list of config file lines map
+	rewritten    map[string]bool  // Dictionary of already processed options
+	lines        []string         // Current lines as an array of strings
+	hasTail      bool             // True if we already added directives that were not present in the original config file.
+}
+
+/* Read the old file, split it into lines to populate a newly created
+ * config rewrite state, and return it to the caller.
+ *
+ * If it is impossible to read the old file, nil is returned.
+ * If the old file does not exist at all, an empty state is returned. */
+func rewriteConfigReadOldFile(path string) (*rewriteConfigState, error) {
+	state := &rewriteConfigState{
+		optionToLine: make(map[string][]int),
+		rewritten:    make(map[string]bool),
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	for _, line := range lines {
+		line = strings.Trim(line, " \t\r\n")
+
+		/* Handle comments and empty lines. */
+		if line == "" || line[0] == '#' {
+			if !state.hasTail && line == CONFIG_REWRITE_SIGNATURE {
+				state.hasTail = true
+			}
+			state.lines = append(state.lines, line)
+			continue
+		}
+
+		/* Not a comment, split into arguments. */
+		argv, ok := splitArgs(line)
+		if !ok || len(argv) == 0 {
+			/* Apparently the line is unparsable for some reason, for
+			 * instance it may have unbalanced quotes, may contain a
+			 * config that doesn't exist anymore, for instance a module that got
+			 * unloaded. Load it as a comment. */
+			state.lines = append(state.lines, "# ??? "+line)
+			continue
+		}
+
+		/* Now we populate the state according to the content of this line.
+		 * Append the line and populate the option -> line numbers map. Aliases
+		 * are mapped to the name of the option. */
+		option := strings.ToLower(argv[0])
+		if config := lookupConfig(option); config != nil {
+			option = config.name
+		}
+		state.optionToLine[option] = append(state.optionToLine[option], len(state.lines))
+		state.lines = append(state.lines, line)
+	}
+	return state, nil
+}
+
+/* Rewrite the specified configuration option with the new "line".
+ * It progressively uses lines of the file that were already used for the same
+ * configuration option in the old version of the file, removing that line from
+ * the map of options -> line numbers.
+ *
+ * If there are lines associated with a given configuration option and
+ * "force" is false, and the line is not present in the old file, the line
+ * is not written, since the option is set to its default value. */
+func rewriteConfigRewriteLine(state *rewriteConfigState, option string, line string, force bool) {
+	state.rewritten[option] = true
+	l := state.optionToLine[option]
+	if len(l) == 0 && !force {
+		/* Option not used previously, and we are not forced to use it. */
+		return
+	}
+	if len(l) > 0 {
+		/* There are still lines in the old configuration file we can reuse
+		 * for this option. Replace the line with the new one. */
+		state.lines[l[0]] = line
+		state.optionToLine[option] = l[1:]
+	} else {
+		/* Append a new line. */
+		if !state.hasTail {
+			state.lines = append(state.lines, CONFIG_REWRITE_SIGNATURE)
+			state.hasTail = true
+		}
+		state.lines = append(state.lines, line)
+	}
+}
+
+/* This function is called when a config option is rewritten but some of
+ * the old lines used by the option are no longer needed, as for the
+ * "client-output-buffer-limit" option with less classes: the lines
+ * are blanked, and removed when the file is written. */
+func rewriteConfigRemoveOrphaned(state *rewriteConfigState) {
+	for option, l := range state.optionToLine {
+		/* Don't blank lines about options the rewrite process
+		 * don't understand. */
+		if !state.rewritten[option] {
+			continue
+		}
+		for _, linenum := range l {
+			state.lines[linenum] = ""
+		}
+	}
+}
+
+/* This function returns the content of the new configuration file,
+ * skipping the blanked lines of orphaned options. */
+func rewriteConfigGetContentFromState(state *rewriteConfigState) string {
+	content := ""
+	wasEmpty := false
+	for i, line := range state.lines {
+		/* Every time we find a line that is empty, we skip it if the
+		 * previous line was already empty: the orphaned lines are
+		 * blanked and would leave holes in the file otherwise. */
+		if line == "" {
+			if wasEmpty || i == len(state.lines)-1 {
+				continue
+			}
+			wasEmpty = true
+		} else {
+			wasEmpty = false
+		}
+		content += line + "\n"
+	}
+	return content
+}
+
+/* This function replaces the old configuration file with the new content
+ * in an atomic manner: the content is written to a temporary file in the
+ * same directory, synced and renamed over the old file. */
+func rewriteConfigOverwriteFile(configfile string, content string) error {
+	tmpfile := filepath.Join(filepath.Dir(configfile), fmt.Sprintf("temp-%d.conf", os.Getpid()))
+	f, err := os.OpenFile(tmpfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(content); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpfile, configfile)
+	}
+	if err != nil {
+		os.Remove(tmpfile)
+	}
+	return err
+}
+
+/* Rewrite the configuration file at "path".
+ * If the configuration file already exists, we try at best to retain comments
+ * and overall structure.
+ *
+ * Configuration parameters that are at their default value, unless already
+ * explicitly included in the old configuration file, are not rewritten. */
+func rewriteConfig(s *Server, path string) error {
+	/* Step 1: read the old config into our rewrite state. */
+	state, err := rewriteConfigReadOldFile(path)
+	if err != nil {
+		return err
+	}
+
+	/* Step 2: rewrite every single option, replacing or appending it inside
+	 * the rewrite state. */
+	for _, config := range configs {
+		force := config.get(s) != config.defaultValue
+		var lines []string
+		if config.rewrite != nil {
+			lines = config.rewrite(s)
+		} else {
+			lines = []string{config.name + " " + config.get(s)}
+		}
+		for _, line := range lines {
+			rewriteConfigRewriteLine(state, config.name, line, force)
+		}
+	}
+
+	/* Step 3: remove all the orphaned lines in the old file, that is, lines
+	 * that were used by a config option and are no longer used. */
+	rewriteConfigRemoveOrphaned(state)
+
+	/* Step 4: generate a new configuration file from the modified state
+	 * and write it into the original file. */
+	return rewriteConfigOverwriteFile(path, rewriteConfigGetContentFromState(state))
+}
+
+/*-----------------------------------------------------------------------------
+ * CONFIG command entry point
+ *----------------------------------------------------------------------------*/
+
+// resetServerStats 重置CONFIG RESETSTAT涉及的统计信息
+func resetServerStats(s *Server) {
+	s.StatExpiredKeys = 0
+}
+
+// ConfigCommand CONFIG GET|SET|RESETSTAT|REWRITE
+func ConfigCommand(c *Client, s *Server) {
+	sub := c.Argv[1].Ptr.(string)
+	if strings.EqualFold(sub, "set") {
+		configSetCommand(c, s)
+	} else if strings.EqualFold(sub, "get") && c.Argc >= 3 {
+		configGetCommand(c, s)
+	} else if strings.EqualFold(sub, "resetstat") && c.Argc == 2 {
+		resetServerStats(s)
+		addReplyStatus(c, "OK")
+	} else if strings.EqualFold(sub, "rewrite") && c.Argc == 2 {
+		if s.ConfigFile == "" {
+			addReplyError(c, "ERR The server is running without a config file")
+			return
+		}
+		if err := rewriteConfig(s, s.ConfigFile); err != nil {
+			serverLog(s, LL_WARNING, "CONFIG REWRITE failed: %s", err)
+			addReplyError(c, "ERR Rewriting config file: "+err.Error())
+			return
+		}
+		serverLog(s, LL_WARNING, "CONFIG REWRITE executed with success.")
+		addReplyStatus(c, "OK")
+	} else {
+		addReplyError(c, fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CONFIG HELP.", sub))
+	}
+}
@@ -0,0 +1,17 @@
+package core
+
+import "hash/crc64"
+
+// rdb文件的校验和 与redis的crc64.c一致
+// 使用Jones多项式 输入输出反转 初始值为0且不取反
+// 标准库的crc64会对初始值和结果取反 因此在调用前后各取反一次
+
+/* Jones polynomial in the reversed form used by the reflected algorithm. */
+const CRC64_JONES_POLY = 0x95ac9329ac4bc9b5
+
+var crc64Table = crc64.MakeTable(CRC64_JONES_POLY)
+
+/* Test vector: crc64(0, "123456789") == 0xe9c6d914c4b8d9ca */
+func crc64Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crc64Table, p)
+}
@@ -0,0 +1,19 @@
+package core
+
+import "testing"
+
+func TestCrc64(t *testing.T) {
+	/* The test vector of the crc64.c of Redis. */
+	if crc := crc64Update(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("crc64(123456789) = %016x, want e9c6d914c4b8d9ca", crc)
+	}
+
+	/* The checksum is updated while the RDB is read in chunks. */
+	data := []byte("This is a test of the emergency broadcast system.")
+	whole := crc64Update(0, data)
+	for i := 0; i <= len(data); i++ {
+		if crc := crc64Update(crc64Update(0, data[:i]), data[i:]); crc != whole {
+			t.Fatalf("split at %d: %016x, want %016x", i, crc, whole)
+		}
+	}
+}
@@ -0,0 +1,640 @@
+package core
+
+import (
+	"godis/core/proto"
+	"strconv"
+	"strings"
+)
+
+/*-----------------------------------------------------------------------------
+ * Keyspace access API
+ *----------------------------------------------------------------------------*/
+
+/* Return a random key, in form of a Godis object.
+ * If there are no keys, nil is returned. */
+func dbRandomKey(db *GodisDb) *GodisObject {
+	for {
+		de := db.Dict.dictGetRandomKey()
+		if de == nil {
+			return nil
+		}
+		keyobj := CreateObject(ObjectTypeString, de.key)
+		/* The key may be logically expired, in that case delete it and
+		 * try with another one. */
+		if expireIfNeeded(db, keyobj) {
+			continue
+		}
+		return keyobj
+	}
+}
+
+/* Remove all keys from the database. Return the number of keys removed. */
+func emptyDb(db *GodisDb) int64 {
+	removed := int64(db.Dict.dictSize())
+	db.Dict.dictEmpty()
+	db.Expires.dictEmpty()
+	return removed
+}
+
+/* Propagate the deletion of an expired key to the AOF as a DEL. */
+func propagateDeletion(db *GodisDb, key *GodisObject) {
+	feedAppendOnlyFile(db.server, int(db.ID), []*GodisObject{CreateObject(ObjectTypeString, "del"), key})
+}
+
+/* Delete the specified expired key and propagate the deletion. */
+func deleteExpiredKeyAndPropagate(db *GodisDb, keyobj *GodisObject) {
+	dbDelete(db, keyobj)
+	propagateDeletion(db, keyobj)
+	db.server.StatExpiredKeys++
+}
+
+/* This function handles 'background' operations we are required to do
+ * incrementally in Godis databases, such as active key expiring, resizing,
+ * rehashing. */
+func databasesCron(s *Server) {
+	/* Expire keys by random sampling. */
+	activeExpireCycle(s)
+
+	for _, db := range s.Db {
+		/* Resize: if the hash tables are filled below 10% shrink them
+		 * to save memory. */
+		if htNeedsResize(db.Dict) {
+			db.Dict.dictResize()
+		}
+		if htNeedsResize(db.Expires) {
+			db.Expires.dictResize()
+		}
+
+		/* Rehash: use 1 millisecond every call of this function to perform
+		 * some rehashing, so that tables that are no longer written still
+		 * get to the new table. */
+		db.Dict.dictRehashMilliseconds(1)
+		db.Expires.dictRehashMilliseconds(1)
+	}
+}
+
+// selectDb 切换客户端当前的db id越界时返回C_ERR
+func selectDb(c *Client, s *Server, id int64) int {
+	if id < 0 || id >= int64(s.DbNum) {
+		return C_ERR
+	}
+	c.Db = s.Db[id]
+	return C_OK
+}
+
+/* Helper function for dbSwapDatabases(): scans the list of keys that have
+ * one or more blocked clients for the specified db, and if the key is now
+ * holding a list or sorted set, signals it as ready. */
+func scanDatabaseForReadyKeys(db *GodisDb) {
+	for k := range db.BlockingKeys {
+		o := db.Dict.dictFetchValue(k)
+		if o != nil && (o.ObjectType == OBJ_LIST || o.ObjectType == OBJ_ZSET) {
+			signalKeyAsReady(db, CreateObject(ObjectTypeString, k))
+		}
+	}
+}
+
+/* Swap two databases at runtime so that all clients will magically see
+ * the new database even if already connected. Note that the client
+ * structure c.Db points to a given db, so we need to be smarter and
+ * swap the underlying referenced structures, otherwise we would need
+ * to fix all the references to the Godis db structure.
+ *
+ * Returns C_ERR if at least one of the DB ids are out of range, otherwise
+ * C_OK is returned. */
+func dbSwapDatabases(s *Server, id1 int64, id2 int64) int {
+	if id1 < 0 || id1 >= int64(s.DbNum) ||
+		id2 < 0 || id2 >= int64(s.DbNum) {
+		return C_ERR
+	}
+	if id1 == id2 {
+		return C_OK
+	}
+	db1 := s.Db[id1]
+	db2 := s.Db[id2]
+
+	/* Swap hash tables. Note that we don't swap BlockingKeys and ReadyKeys,
+	 * since clients are blocked on keys of a given db id, not of a
+	 * given data set. */
+	db1.Dict, db2.Dict = db2.Dict, db1.Dict
+	db1.Expires, db2.Expires = db2.Expires, db1.Expires
+
+	/* Now we need to handle clients blocked on lists: as an effect
+	 * of swapping the two DBs, a client that was waiting for list
+	 * X in a given DB, may now actually be unblocked if X happens
+	 * to exist in the new version of the DB, after the swap.
+	 *
+	 * However normally we only do this check for efficiency reasons
+	 * in dbAdd() when a list is created. So here we need to rescan
+	 * the list of clients blocked on lists and signal lists as ready
+	 * if needed. */
+	scanDatabaseForReadyKeys(db1)
+	scanDatabaseForReadyKeys(db2)
+	return C_OK
+}
+
+/*-----------------------------------------------------------------------------
+ * Type agnostic commands operating on the key space
+ *----------------------------------------------------------------------------*/
+
+// DelCommand del key [key ...]
+func DelCommand(c *Client, s *Server) {
+	numdel := 0
+	for j := 1; j < c.Argc; j++ {
+		expireIfNeeded(c.Db, c.Argv[j])
+		if dbDelete(c.Db, c.Argv[j]) {
+			s.Dirty++
+			numdel++
+		}
+	}
+	addReplyLongLong(c, int64(numdel))
+}
+
+/* EXISTS key1 key2 ... key_N.
+ * Return value is the number of keys existing. */
+func ExistsCommand(c *Client, s *Server) {
+	count := 0
+	for j := 1; j < c.Argc; j++ {
+		if lookupKey(c.Db, c.Argv[j]) != nil {
+			count++
+		}
+	}
+	addReplyLongLong(c, int64(count))
+}
+
+/* Return the flushing mode of FLUSHALL/FLUSHDB: ASYNC and SYNC are both
+ * accepted, the keys are always released synchronously. */
+func getFlushCommandFlags(c *Client) int {
+	/* Parse the optional ASYNC option. */
+	if c.Argc == 2 {
+		opt := c.Argv[1].Ptr.(string)
+		if !strings.EqualFold(opt, "sync") && !strings.EqualFold(opt, "async") {
+			addReplyError(c, errSyntax)
+			return C_ERR
+		}
+	} else if c.Argc > 2 {
+		addReplyError(c, errSyntax)
+		return C_ERR
+	}
+	return C_OK
+}
+
+/* FLUSHDB [ASYNC]
+ *
+ * Flushes the currently SELECTed Godis DB. */
+func FlushDbCommand(c *Client, s *Server) {
+	if getFlushCommandFlags(c) == C_ERR {
+		return
+	}
+	s.Dirty += emptyDb(c.Db)
+	/* Without the forced increment an empty db would not be propagated. */
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
+
+/* FLUSHALL [ASYNC]
+ *
+ * Flushes the whole server data set. */
+func FlushAllCommand(c *Client, s *Server) {
+	if getFlushCommandFlags(c) == C_ERR {
+		return
+	}
+	for _, db := range s.Db {
+		s.Dirty += emptyDb(db)
+	}
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
+
+// KeysCommand keys pattern
+func KeysCommand(c *Client, s *Server) {
+	pattern := c.Argv[1].Ptr.(string)
+	allkeys := pattern == "*"
+	items := []*proto.Resp{}
+	c.Db.Dict.dictForEach(func(de *dictEntry) bool {
+		if allkeys || stringmatch(pattern, de.key, false) {
+			if !keyIsExpired(c.Db, CreateObject(ObjectTypeString, de.key)) {
+				items = append(items, bulkString(de.key))
+			}
+		}
+		return true
+	})
+	addReplyArray(c, items)
+}
+
+/* Try to parse a SCAN cursor stored at object 'o':
+ * if the cursor is valid, store it as unsigned integer into *cursor and
+ * returns C_OK. Otherwise return C_ERR and send an error to the
+ * client. */
+func parseScanCursorOrReply(c *Client, o *GodisObject, cursor *uint64) int {
+	/* Use strconv.ParseUint() because we need an *unsigned* 64 bit number,
+	 * and the cursor must be the whole argument. */
+	value, err := strconv.ParseUint(o.Ptr.(string), 10, 64)
+	if err != nil {
+		addReplyError(c, "ERR invalid cursor")
+		return C_ERR
+	}
+	*cursor = value
+	return C_OK
+}
+
+// getObjectTypeByName 根据类型名获取对象类型 未知类型返回-1
+func getObjectTypeByName(name string) int {
+	switch strings.ToLower(name) {
+	case "string":
+		return ObjectTypeString
+	case "list":
+		return OBJ_LIST
+	case "set":
+		return OBJ_SET
+	case "zset":
+		return OBJ_ZSET
+	case "hash":
+		return OBJ_HASH
+	}
+	return -1
+}
+
+// getObjectTypeName 对象的类型名 用于TYPE和SCAN的TYPE过滤
+func getObjectTypeName(o *GodisObject) string {
+	if o == nil {
+		return "none"
+	}
+	switch o.ObjectType {
+	case ObjectTypeString:
+		return "string"
+	case OBJ_LIST:
+		return "list"
+	case OBJ_SET:
+		return "set"
+	case OBJ_ZSET:
+		return "zset"
+	case OBJ_HASH:
+		return "hash"
+	}
+	return "unknown"
+}
+
+/* This command implements SCAN and HSCAN.
+ *
+ * The object that is scanned is the one passed as 'o', if it is nil the
+ * key space of the currently selected db is scanned. The key space is a
+ * dict so the cursor is the reverse binary cursor of dictScan(), which
+ * guarantees that every key present from the start to the end of the
+ * iteration is returned at least once even if the table is resized in
+ * the meantime. Hashes encoded as a hash table are scanned in the same way,
+ * while the small ziplist encoded ones are returned in a single call.
+ *
+ * In the case of a Hash object the function returns both the field and value
+ * of every element on the Hash. */
+func scanGenericCommand(c *Client, o *GodisObject, cursor uint64) {
+	var count int64 = 10
+	pattern := ""
+	usePattern := false
+	typename := ""
+
+	/* Step 1: Parse options. */
+	i := 2 /* Skip the key argument if needed. */
+	if o != nil {
+		i = 3
+	}
+	for i < c.Argc {
+		j := c.Argc - i
+		opt := c.Argv[i].Ptr.(string)
+		if strings.EqualFold(opt, "count") && j >= 2 {
+			if getLongLongFromObjectOrReply(c, c.Argv[i+1], &count, "") != C_OK {
+				return
+			}
+			if count < 1 {
+				addReplyError(c, errSyntax)
+				return
+			}
+			i += 2
+		} else if strings.EqualFold(opt, "match") && j >= 2 {
+			pattern = c.Argv[i+1].Ptr.(string)
+			/* The pattern may be skipped if it is just "*". */
+			usePattern = pattern != "*"
+			i += 2
+		} else if strings.EqualFold(opt, "type") && o == nil && j >= 2 {
+			typename = c.Argv[i+1].Ptr.(string)
+			if getObjectTypeByName(typename) == -1 {
+				addReplyError(c, "ERR unknown type name '"+typename+"'")
+				return
+			}
+			i += 2
+		} else {
+			addReplyError(c, errSyntax)
+			return
+		}
+	}
+
+	/* Step 2: Iterate the collection.
+	 *
+	 * Note that if the object is encoded with a ziplist we return everything
+	 * in a single call, setting the cursor to zero to signal the end of the
+	 * iteration. */
+
+	/* Handle the case of a hash table. */
+	var ht *dict
+	if o == nil {
+		ht = c.Db.Dict
+	} else if o.ObjectType == OBJ_HASH && o.Encoding == OBJ_ENCODING_HT {
+		ht = o.Ptr.(*dict)
+	}
+
+	var keys []string   /* keys or fields */
+	var values []string /* hash values, only for HSCAN */
+	if ht != nil {
+		/* We set the max number of iterations to ten times the specified
+		 * COUNT, so if the hash table is in a pathological state (very
+		 * sparsely populated) we avoid to block too much time at the cost
+		 * of returning no or very few elements. */
+		maxiterations := count * 10
+		for {
+			cursor = ht.dictScan(cursor, func(de *dictEntry) {
+				keys = append(keys, de.key)
+				if o != nil {
+					values = append(values, de.val.Ptr.(string))
+				}
+			})
+			maxiterations--
+			if cursor == 0 || maxiterations == 0 || int64(len(keys)) >= count {
+				break
+			}
+		}
+	} else if o.ObjectType == OBJ_HASH {
+		if cursor == 0 {
+			hashTypeForEach(o, func(field string, value string) bool {
+				keys = append(keys, field)
+				values = append(values, value)
+				return true
+			})
+		}
+		cursor = 0
+	} else {
+		panic("Not handled encoding in SCAN.")
+	}
+
+	/* Step 3: Filter elements. */
+	items := []*proto.Resp{}
+	for n, key := range keys {
+		/* Filter element if it does not match the pattern. */
+		if usePattern && !stringmatch(pattern, key, false) {
+			continue
+		}
+		if o == nil {
+			keyobj := CreateObject(ObjectTypeString, key)
+			/* Filter an element if it isn't the type we want. */
+			if typename != "" {
+				if !strings.EqualFold(getObjectTypeName(c.Db.Dict.dictFetchValue(key)), typename) {
+					continue
+				}
+			}
+			/* Filter element if it is an expired key. */
+			if expireIfNeeded(c.Db, keyobj) {
+				continue
+			}
+		}
+		items = append(items, bulkString(key))
+		if o != nil {
+			items = append(items, bulkString(values[n]))
+		}
+	}
+
+	/* Step 4: Reply to the client. */
+	addReplyArray(c, []*proto.Resp{
+		bulkString(strconv.FormatUint(cursor, 10)),
+		proto.NewArray(items),
+	})
+}
+
+/* The SCAN command completely relies on scanGenericCommand. */
+func ScanCommand(c *Client, s *Server) {
+	var cursor uint64
+	if parseScanCursorOrReply(c, c.Argv[1], &cursor) == C_ERR {
+		return
+	}
+	scanGenericCommand(c, nil, cursor)
+}
+
+// DbSizeCommand dbsize
+func DbSizeCommand(c *Client, s *Server) {
+	addReplyLongLong(c, int64(c.Db.Dict.dictSize()))
+}
+
+// TypeCommand type key
+func TypeCommand(c *Client, s *Server) {
+	addReplyStatus(c, getObjectTypeName(lookupKey(c.Db, c.Argv[1])))
+}
+
+// RandomKeyCommand randomkey
+func RandomKeyCommand(c *Client, s *Server) {
+	key := dbRandomKey(c.Db)
+	if key == nil {
+		addReplyNull(c)
+		return
+	}
+	addReplyBulk(c, key.Ptr.(string))
+}
+
+/* RENAME and RENAMENX. With 'nx' the destination key is never overwritten. */
+func renameGenericCommand(c *Client, s *Server, nx bool) {
+	/* When source and dest key is the same, no operation is performed,
+	 * if the key exists, however we still return an error on unexisting key. */
+	samekey := c.Argv[1].Ptr.(string) == c.Argv[2].Ptr.(string)
+
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyError(c, "ERR no such key")
+		return
+	}
+
+	if samekey {
+		if nx {
+			addReplyLongLong(c, 0)
+		} else {
+			addReplyStatus(c, "OK")
+		}
+		return
+	}
+
+	expire := getExpire(c.Db, c.Argv[1])
+	if lookupKey(c.Db, c.Argv[2]) != nil {
+		if nx {
+			addReplyLongLong(c, 0)
+			return
+		}
+		/* Overwrite: delete the old key before creating the new one
+		 * with the same name. */
+		dbDelete(c.Db, c.Argv[2])
+	}
+	dbAdd(c.Db, c.Argv[2], o)
+	if expire != -1 {
+		setExpire(c.Db, c.Argv[2], expire)
+	}
+	dbDelete(c.Db, c.Argv[1])
+	s.Dirty++
+	if nx {
+		addReplyLongLong(c, 1)
+	} else {
+		addReplyStatus(c, "OK")
+	}
+}
+
+// RenameCommand rename key newkey
+func RenameCommand(c *Client, s *Server) {
+	renameGenericCommand(c, s, false)
+}
+
+// RenameNXCommand renamenx key newkey
+func RenameNXCommand(c *Client, s *Server) {
+	renameGenericCommand(c, s, true)
+}
+
+// SelectCommand select index
+func SelectCommand(c *Client, s *Server) {
+	var id int64
+	if getLongLongFromObjectOrReply(c, c.Argv[1], &id, "ERR invalid DB index") != C_OK {
+		return
+	}
+	if selectDb(c, s, id) == C_ERR {
+		addReplyError(c, "ERR DB index is out of range")
+		return
+	}
+	addReplyStatus(c, "OK")
+}
+
+// MoveCommand move key db
+func MoveCommand(c *Client, s *Server) {
+	var dbid int64
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &dbid, "ERR invalid DB index") != C_OK {
+		return
+	}
+	if dbid < 0 || dbid >= int64(s.DbNum) {
+		addReplyError(c, "ERR DB index is out of range")
+		return
+	}
+	src := c.Db
+	dst := s.Db[dbid]
+
+	/* If the user is moving using as target the same
+	 * DB as the source DB it is probably an error. */
+	if src == dst {
+		addReplyError(c, "ERR source and destination objects are the same")
+		return
+	}
+
+	/* Check if the element exists and get a reference */
+	key := c.Argv[1]
+	o := lookupKey(src, key)
+	if o == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	expire := getExpire(src, key)
+
+	/* Return zero if the key already exists in the target DB */
+	if lookupKey(dst, key) != nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	dbAdd(dst, key, o)
+	if expire != -1 {
+		setExpire(dst, key, expire)
+	}
+
+	/* OK! key moved, free the entry in the source DB */
+	dbDelete(src, key)
+	s.Dirty++
+	addReplyLongLong(c, 1)
+}
+
+// CopyCommand copy source destination [DB destination-db] [REPLACE]
+func CopyCommand(c *Client, s *Server) {
+	src := c.Db
+	dst := c.Db
+	replace := false
+
+	/* Obtain source and target DB pointers
+	 * Default target DB is the same as the source DB
+	 * Parse the REPLACE option and targetDB option. */
+	for j := 3; j < c.Argc; j++ {
+		opt := c.Argv[j].Ptr.(string)
+		if strings.EqualFold(opt, "replace") {
+			replace = true
+		} else if strings.EqualFold(opt, "db") && j+1 < c.Argc {
+			var dbid int64
+			if getLongLongFromObjectOrReply(c, c.Argv[j+1], &dbid, "ERR invalid DB index") != C_OK {
+				return
+			}
+			if dbid < 0 || dbid >= int64(s.DbNum) {
+				addReplyError(c, "ERR DB index is out of range")
+				return
+			}
+			dst = s.Db[dbid]
+			j++
+		} else {
+			addReplyError(c, errSyntax)
+			return
+		}
+	}
+
+	/* If the user select the same DB as
+	 * the source DB and using newkey as the same key
+	 * it is probably an error. */
+	key := c.Argv[1]
+	newkey := c.Argv[2]
+	if src == dst && key.Ptr.(string) == newkey.Ptr.(string) {
+		addReplyError(c, "ERR source and destination objects are the same")
+		return
+	}
+
+	/* Check if the element exists and get a reference */
+	o := lookupKey(src, key)
+	if o == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	expire := getExpire(src, key)
+
+	/* Return zero if the key already exists in the target DB.
+	 * If REPLACE option is selected, delete newkey from targetDB. */
+	if lookupKey(dst, newkey) != nil {
+		if !replace {
+			addReplyLongLong(c, 0)
+			return
+		}
+		dbDelete(dst, newkey)
+	}
+
+	/* Duplicate object according to object's type. */
+	newobj := dupObject(o)
+
+	dbAdd(dst, newkey, newobj)
+	if expire != -1 {
+		setExpire(dst, newkey, expire)
+	}
+	s.Dirty++
+	addReplyLongLong(c, 1)
+}
+
+// SwapDbCommand swapdb index1 index2
+func SwapDbCommand(c *Client, s *Server) {
+	var id1, id2 int64
+
+	/* Get the two DBs indexes. */
+	if getLongLongFromObjectOrReply(c, c.Argv[1], &id1, "ERR invalid first DB index") != C_OK {
+		return
+	}
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &id2, "ERR invalid second DB index") != C_OK {
+		return
+	}
+
+	/* Swap... */
+	if dbSwapDatabases(s, id1, id2) == C_ERR {
+		addReplyError(c, "ERR DB index is out of range")
+		return
+	}
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
@@ -0,0 +1,724 @@
+package core
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+/* Hash Tables Implementation.
+ *
+ * This file implements in memory hash tables with insert/del/replace/find/
+ * get-random-element operations. Hash tables will auto resize if needed,
+ * tables of power of two in size are used, collisions are handled by
+ * chaining.
+ *
+ * A Go map would do most of this for us, but it can't be scanned from a
+ * cursor: SCAN and friends need the reverse binary iteration of dictScan()
+ * that only works with power of two tables that we control. */
+
+/* This is the initial size of every hash table */
+const DICT_HT_INITIAL_SIZE = 4
+
+/* Hash table parameters */
+const HASHTABLE_MIN_FILL = 10 /* Minimal hash table fill 10% */
+
+type dictEntry struct {
+	key  string
+	val  *GodisObject
+	next *dictEntry
+}
+
+/* This is our hash table structure. Every dictionary has two of this as we
+ * implement incremental rehashing, for the old to the new table. */
+type dictht struct {
+	table    []*dictEntry
+	size     uint64
+	sizemask uint64
+	used     uint64
+}
+
+type dict struct {
+	ht          [2]dictht
+	rehashidx   int64 /* rehashing not in progress if rehashidx == -1 */
+	pauserehash int   /* If >0 rehashing is paused */
+	seed        maphash.Seed
+}
+
+/* Create a new hash table */
+func dictCreate() *dict {
+	d := new(dict)
+	d.rehashidx = -1
+	d.seed = maphash.MakeSeed()
+	return d
+}
+
+func (d *dict) dictHashKey(key string) uint64 {
+	return maphash.String(d.seed, key)
+}
+
+func (d *dict) dictIsRehashing() bool {
+	return d.rehashidx != -1
+}
+
+// dictSize 元素个数
+func (d *dict) dictSize() int {
+	return int(d.ht[0].used + d.ht[1].used)
+}
+
+// dictSlots 桶的个数
+func (d *dict) dictSlots() int {
+	return int(d.ht[0].size + d.ht[1].size)
+}
+
+/* Resize the table to the minimal size that contains all the elements,
+ * but with the invariant of a USED/BUCKETS ratio near to <= 1 */
+func (d *dict) dictResize() bool {
+	if d.dictIsRehashing() {
+		return false
+	}
+	minimal := d.ht[0].used
+	if minimal < DICT_HT_INITIAL_SIZE {
+		minimal = DICT_HT_INITIAL_SIZE
+	}
+	return d.dictExpand(minimal)
+}
+
+/* Expand or create the hash table */
+func (d *dict) dictExpand(size uint64) bool {
+	/* the size is invalid if it is smaller than the number of
+	 * elements already inside the hash table */
+	if d.dictIsRehashing() || d.ht[0].used > size {
+		return false
+	}
+
+	realsize := dictNextPower(size)
+	/* Rehashing to the same table size is not useful. */
+	if realsize == d.ht[0].size {
+		return false
+	}
+
+	/* Allocate the new hash table and initialize all pointers to NULL */
+	n := dictht{
+		table:    make([]*dictEntry, realsize),
+		size:     realsize,
+		sizemask: realsize - 1,
+	}
+
+	/* Is this the first initialization? If so it's not really a rehashing
+	 * we just set the first hash table so that it can accept keys. */
+	if d.ht[0].table == nil {
+		d.ht[0] = n
+		return true
+	}
+
+	/* Prepare a second hash table for incremental rehashing */
+	d.ht[1] = n
+	d.rehashidx = 0
+	return true
+}
+
+/* Performs N steps of incremental rehashing. Returns true if there are still
+ * keys to move from the old to the new hash table, otherwise false is returned.
+ *
+ * Note that a rehashing step consists in moving a bucket (that may have more
+ * than one key as we use chaining) from the old to the new hash table, however
+ * since part of the hash table may be composed of empty spaces, it is not
+ * guaranteed that this function will rehash even a single bucket, since it
+ * will visit at max N*10 empty buckets in total, otherwise the amount of
+ * work it does would be unbound and the function may block for a long time. */
+func (d *dict) dictRehash(n int) bool {
+	emptyVisits := n * 10 /* Max number of empty buckets to visit. */
+	if !d.dictIsRehashing() {
+		return false
+	}
+
+	for ; n > 0 && d.ht[0].used != 0; n-- {
+		for d.ht[0].table[d.rehashidx] == nil {
+			d.rehashidx++
+			emptyVisits--
+			if emptyVisits == 0 {
+				return true
+			}
+		}
+		de := d.ht[0].table[d.rehashidx]
+		/* Move all the keys in this bucket from the old to the new hash HT */
+		for de != nil {
+			nextde := de.next
+			/* Get the index in the new hash table */
+			h := d.dictHashKey(de.key) & d.ht[1].sizemask
+			de.next = d.ht[1].table[h]
+			d.ht[1].table[h] = de
+			d.ht[0].used--
+			d.ht[1].used++
+			de = nextde
+		}
+		d.ht[0].table[d.rehashidx] = nil
+		d.rehashidx++
+	}
+
+	/* Check if we already rehashed the whole table... */
+	if d.ht[0].used == 0 {
+		d.ht[0] = d.ht[1]
+		d.ht[1] = dictht{}
+		d.rehashidx = -1
+		return false
+	}
+
+	/* More to rehash... */
+	return true
+}
+
+/* Rehash in ms+"delta" milliseconds. The value of "delta" is larger
+ * than 0, and is smaller than 1 in most cases. The exact upper bound
+ * depends on the running time of dictRehash(d,100). */
+func (d *dict) dictRehashMilliseconds(ms int64) int {
+	if d.pauserehash > 0 {
+		return 0
+	}
+	start := mstime()
+	rehashes := 0
+	for d.dictRehash(100) {
+		rehashes += 100
+		if mstime()-start > ms {
+			break
+		}
+	}
+	return rehashes
+}
+
+/* This function performs just a step of rehashing, and only if hashing has
+ * not been paused for our hash table. When we have iterators in the
+ * middle of a rehashing we can't mess with the two hash tables otherwise
+ * some element can be missed or duplicated. */
+func (d *dict) _dictRehashStep() {
+	if d.pauserehash == 0 {
+		d.dictRehash(1)
+	}
+}
+
+/* Add an element to the target hash table.
+ * Return false if the key already exists. */
+func (d *dict) dictAdd(key string, val *GodisObject) bool {
+	entry, _ := d.dictAddRaw(key)
+	if entry == nil {
+		return false
+	}
+	entry.val = val
+	return true
+}
+
+/* Low level add or find:
+ * This function adds the entry but instead of setting a value returns the
+ * dictEntry structure to the user, that will make sure to fill the value
+ * field as they wish.
+ *
+ * If key already exists nil is returned, and the existing entry is
+ * returned as second value. */
+func (d *dict) dictAddRaw(key string) (*dictEntry, *dictEntry) {
+	if d.dictIsRehashing() {
+		d._dictRehashStep()
+	}
+
+	/* Get the index of the new element, or -1 if
+	 * the element already exists. */
+	index, existing := d._dictKeyIndex(key, d.dictHashKey(key))
+	if index == -1 {
+		return nil, existing
+	}
+
+	/* Allocate the memory and store the new entry.
+	 * Insert the element in top, with the assumption that in a database
+	 * system it is more likely that recently added entries are accessed
+	 * more frequently. */
+	ht := &d.ht[0]
+	if d.dictIsRehashing() {
+		ht = &d.ht[1]
+	}
+	entry := &dictEntry{key: key, next: ht.table[index]}
+	ht.table[index] = entry
+	ht.used++
+	return entry, nil
+}
+
+/* Add or Overwrite:
+ * Add an element, discarding the old value if the key already exists.
+ * Return true if the key was added from scratch, false if there was already
+ * an element with such key and dictReplace() just performed a value update
+ * operation. */
+func (d *dict) dictReplace(key string, val *GodisObject) bool {
+	/* Try to add the element. If the key
+	 * does not exists dictAdd will succeed. */
+	entry, existing := d.dictAddRaw(key)
+	if entry != nil {
+		entry.val = val
+		return true
+	}
+
+	/* Set the new value, the old one is released by the GC. */
+	existing.val = val
+	return false
+}
+
+/* Search and remove an element. Return true if the key was found and
+ * removed, false otherwise. */
+func (d *dict) dictDelete(key string) bool {
+	/* dict is empty */
+	if d.dictSize() == 0 {
+		return false
+	}
+
+	if d.dictIsRehashing() {
+		d._dictRehashStep()
+	}
+	h := d.dictHashKey(key)
+
+	for table := 0; table <= 1; table++ {
+		idx := h & d.ht[table].sizemask
+		var prevHe *dictEntry
+		for he := d.ht[table].table[idx]; he != nil; he = he.next {
+			if he.key == key {
+				/* Unlink the element from the list */
+				if prevHe != nil {
+					prevHe.next = he.next
+				} else {
+					d.ht[table].table[idx] = he.next
+				}
+				d.ht[table].used--
+				return true
+			}
+			prevHe = he
+		}
+		if !d.dictIsRehashing() {
+			break
+		}
+	}
+	return false /* not found */
+}
+
+/* Clear & Release the hash table */
+func (d *dict) dictEmpty() {
+	d.ht[0] = dictht{}
+	d.ht[1] = dictht{}
+	d.rehashidx = -1
+	d.pauserehash = 0
+}
+
+// dictFind 查找key对应的entry 不存在时返回nil
+func (d *dict) dictFind(key string) *dictEntry {
+	if d.dictSize() == 0 {
+		return nil /* dict is empty */
+	}
+	if d.dictIsRehashing() {
+		d._dictRehashStep()
+	}
+	h := d.dictHashKey(key)
+	for table := 0; table <= 1; table++ {
+		idx := h & d.ht[table].sizemask
+		for he := d.ht[table].table[idx]; he != nil; he = he.next {
+			if he.key == key {
+				return he
+			}
+		}
+		if !d.dictIsRehashing() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// dictFetchValue 查找key对应的值 不存在时返回nil
+func (d *dict) dictFetchValue(key string) *GodisObject {
+	he := d.dictFind(key)
+	if he == nil {
+		return nil
+	}
+	return he.val
+}
+
+/* Iterate every entry of the dictionary. The rehashing is paused while
+ * iterating, so it is safe to delete the current entry from fn (like the
+ * safe iterator of Redis). Iteration stops when fn returns false. */
+func (d *dict) dictForEach(fn func(de *dictEntry) bool) {
+	d.pauserehash++
+	defer func() { d.pauserehash-- }()
+	for table := 0; table <= 1; table++ {
+		for idx := uint64(0); idx < d.ht[table].size; idx++ {
+			for de := d.ht[table].table[idx]; de != nil; {
+				next := de.next
+				if !fn(de) {
+					return
+				}
+				de = next
+			}
+		}
+		if !d.dictIsRehashing() {
+			break
+		}
+	}
+}
+
+/* Like dictForEach() but the rehashing is not paused, so nothing is written
+ * in the dict (like the unsafe iterator of Redis): fn must not add, delete
+ * or lookup entries. Used by the background goroutine to read the dicts it
+ * shares with the event loop. */
+func (d *dict) dictForEachUnsafe(fn func(de *dictEntry) bool) {
+	for table := 0; table <= 1; table++ {
+		for idx := uint64(0); idx < d.ht[table].size; idx++ {
+			for de := d.ht[table].table[idx]; de != nil; de = de.next {
+				if !fn(de) {
+					return
+				}
+			}
+		}
+		if !d.dictIsRehashing() {
+			break
+		}
+	}
+}
+
+/* Return a random entry from the hash table. Useful to
+ * implement randomized algorithms */
+func (d *dict) dictGetRandomKey() *dictEntry {
+	if d.dictSize() == 0 {
+		return nil
+	}
+	if d.dictIsRehashing() {
+		d._dictRehashStep()
+	}
+
+	var he *dictEntry
+	if d.dictIsRehashing() {
+		s0 := d.ht[0].size
+		for he == nil {
+			/* We are sure there are no elements in indexes from 0
+			 * to rehashidx-1 */
+			h := uint64(d.rehashidx) + rand.Uint64()%(d.ht[0].size+d.ht[1].size-uint64(d.rehashidx))
+			if h >= s0 {
+				he = d.ht[1].table[h-s0]
+			} else {
+				he = d.ht[0].table[h]
+			}
+		}
+	} else {
+		for he == nil {
+			h := rand.Uint64() & d.ht[0].sizemask
+			he = d.ht[0].table[h]
+		}
+	}
+
+	/* Now we found a non empty bucket, but it is a linked
+	 * list and we need to get a random element from the list.
+	 * The only sane way to do so is counting the elements and
+	 * select a random index. */
+	listlen := 0
+	for orighe := he; orighe != nil; orighe = orighe.next {
+		listlen++
+	}
+	for listele := rand.Intn(listlen); listele > 0; listele-- {
+		he = he.next
+	}
+	return he
+}
+
+/* This function samples the dictionary to return a few keys from random
+ * locations.
+ *
+ * It does not guarantee to return all the keys specified in 'count', nor
+ * it does guarantee to return non-duplicated elements, however it will make
+ * some effort to do both things.
+ *
+ * The function returns the sampled entries, that may be less than count
+ * if the hash table has less than 'count' elements inside, or if not enough
+ * elements were found in a reasonable amount of steps. */
+func (d *dict) dictGetSomeKeys(count int) []*dictEntry {
+	if d.dictSize() < count {
+		count = d.dictSize()
+	}
+	maxsteps := count * 10
+	des := make([]*dictEntry, 0, count)
+
+	/* Try to do a rehashing work proportional to 'count'. */
+	for j := 0; j < count; j++ {
+		if d.dictIsRehashing() {
+			d._dictRehashStep()
+		} else {
+			break
+		}
+	}
+
+	tables := 1
+	if d.dictIsRehashing() {
+		tables = 2
+	}
+	maxsizemask := d.ht[0].sizemask
+	if tables > 1 && maxsizemask < d.ht[1].sizemask {
+		maxsizemask = d.ht[1].sizemask
+	}
+
+	/* Pick a random point inside the larger table. */
+	i := rand.Uint64() & maxsizemask
+	emptylen := 0 /* Continuous empty entries so far. */
+	for len(des) < count && maxsteps > 0 {
+		maxsteps--
+		for j := 0; j < tables; j++ {
+			/* Invariant of the dict.c rehashing: up to the indexes already
+			 * visited in ht[0] during the rehashing, there are no populated
+			 * buckets, so we can skip ht[0] for indexes between 0 and idx-1. */
+			if tables == 2 && j == 0 && i < uint64(d.rehashidx) {
+				/* Moreover, if we are currently out of range in the second
+				 * table, there will be no elements in both tables up to
+				 * the current rehashing index, so we jump if possible.
+				 * (this happens when going from big to small table). */
+				if i >= d.ht[1].size {
+					i = uint64(d.rehashidx)
+				} else {
+					continue
+				}
+			}
+			if i >= d.ht[j].size {
+				continue /* Out of range for this table. */
+			}
+			he := d.ht[j].table[i]
+
+			/* Count contiguous empty buckets, and jump to other
+			 * locations if they reach 'count' (with a minimum of 5). */
+			if he == nil {
+				emptylen++
+				if emptylen >= 5 && emptylen > count {
+					i = rand.Uint64() & maxsizemask
+					emptylen = 0
+				}
+			} else {
+				emptylen = 0
+				for he != nil {
+					/* Collect all the elements of the buckets found non
+					 * empty while iterating. */
+					des = append(des, he)
+					he = he.next
+					if len(des) == count {
+						return des
+					}
+				}
+			}
+		}
+		i = (i + 1) & maxsizemask
+	}
+	return des
+}
+
+/* dictScan() is used to iterate over the elements of a dictionary.
+ *
+ * Iterating works the following way:
+ *
+ * 1) Initially you call the function using a cursor (v) value of 0.
+ * 2) The function performs one step of the iteration, and returns the
+ *    new cursor value you must use in the next call.
+ * 3) When the returned cursor is 0, the iteration is complete.
+ *
+ * The function guarantees all elements present in the
+ * dictionary get returned between the start and end of the iteration.
+ * However it is possible some elements get returned multiple times.
+ *
+ * For every element returned, the callback argument 'fn' is
+ * called with the entry.
+ *
+ * HOW IT WORKS.
+ *
+ * The iteration algorithm was designed by Pieter Noordhuis.
+ * The main idea is to increment a cursor starting from the higher order
+ * bits. That is, instead of incrementing the cursor normally, the bits
+ * of the cursor are reversed, then the cursor is incremented, and finally
+ * the bits are reversed again.
+ *
+ * This strategy is needed because the hash table may be resized between
+ * iteration calls.
+ *
+ * dict.c hash tables are always power of two in size, and they
+ * use chaining, so the position of an element in a given table is given
+ * by computing the bitwise AND between Hash(key) and SIZE-1
+ * (where SIZE-1 is always the mask that is equivalent to taking the rest
+ *  of the division between the Hash of the key and SIZE).
+ *
+ * For example if the current hash table size is 16, the mask is
+ * (in binary) 1111. The position of a key in the hash table will always be
+ * the last four bits of the hash output, and so forth.
+ *
+ * WHAT HAPPENS IF THE TABLE CHANGES IN SIZE?
+ *
+ * If the hash table grows, elements can go anywhere in one multiple of
+ * the old bucket: for example let's say we already iterated with
+ * a 4 bit cursor 1100 (the mask is 1111 because hash table size = 16).
+ *
+ * If the hash table will be resized to 64 elements, then the new mask will
+ * be 111111. The new buckets you obtain by substituting in ??1100
+ * with either 0 or 1 can be targeted only by keys we already visited
+ * when scanning the bucket 1100 in the smaller hash table.
+ *
+ * By iterating the higher bits first, because of the inverted counter, the
+ * cursor does not need to restart if the table size gets bigger. It will
+ * continue iterating using cursors without '1100' at the end, and also
+ * without any other combination of the final 4 bits already explored.
+ *
+ * Similarly when the table size shrinks over time, for example going from
+ * 16 to 8, if a combination of the lower three bits (the mask for size 8
+ * is 111) were already completely explored, it would not be visited again
+ * because we are sure we tried, for example, both 0111 and 1111 (all the
+ * variations of the higher bit) so we don't need to test it again.
+ *
+ * WAIT... YOU HAVE *TWO* TABLES DURING REHASHING!
+ *
+ * Yes, this is true, but we always iterate the smaller table first, then
+ * we test all the expansions of the current cursor into the larger
+ * table. For example if the current cursor is 101 and we also have a
+ * larger table of size 16, we also test (0)101 and (1)101 inside the larger
+ * table. This reduces the problem back to having only one table, where
+ * the larger one, if it exists, is just an expansion of the smaller one.
+ *
+ * LIMITATIONS
+ *
+ * This iterator is completely stateless, and this is a huge advantage,
+ * including no additional memory used.
+ *
+ * The disadvantages resulting from this design are:
+ *
+ * 1) It is possible we return elements more than once. However this is usually
+ *    easy to deal with in the application level.
+ * 2) The iterator must return multiple elements per call, as it needs to always
+ *    return all the keys chained in a given bucket, and all the expansions, so
+ *    we are sure we don't miss keys moving during rehashing.
+ * 3) The reverse cursor is somewhat hard to understand at first, but this
+ *    comment is supposed to help. */
+func (d *dict) dictScan(v uint64, fn func(de *dictEntry)) uint64 {
+	if d.dictSize() == 0 {
+		return 0
+	}
+
+	/* This is needed in case the scan callback tries to do dictFind or alike. */
+	d.pauserehash++
+
+	emit := func(de *dictEntry) {
+		for de != nil {
+			next := de.next
+			fn(de)
+			de = next
+		}
+	}
+
+	if !d.dictIsRehashing() {
+		t0 := &d.ht[0]
+		m0 := t0.sizemask
+
+		/* Emit entries at cursor */
+		emit(t0.table[v&m0])
+
+		/* Set unmasked bits so incrementing the reversed cursor
+		 * operates on the masked bits */
+		v |= ^m0
+
+		/* Increment the reverse cursor */
+		v = bits.Reverse64(v)
+		v++
+		v = bits.Reverse64(v)
+	} else {
+		t0 := &d.ht[0]
+		t1 := &d.ht[1]
+
+		/* Make sure t0 is the smaller and t1 is the bigger table */
+		if t0.size > t1.size {
+			t0, t1 = t1, t0
+		}
+		m0 := t0.sizemask
+		m1 := t1.sizemask
+
+		/* Emit entries at cursor */
+		emit(t0.table[v&m0])
+
+		/* Iterate over indices in larger table that are the expansion
+		 * of the index pointed to by the cursor in the smaller table */
+		for {
+			/* Emit entries at cursor */
+			emit(t1.table[v&m1])
+
+			/* Increment the reverse cursor not covered by the smaller mask.*/
+			v |= ^m1
+			v = bits.Reverse64(v)
+			v++
+			v = bits.Reverse64(v)
+
+			/* Continue while bits covered by mask difference is non-zero */
+			if v&(m0^m1) == 0 {
+				break
+			}
+		}
+	}
+
+	d.pauserehash--
+	return v
+}
+
+/* ------------------------- private functions ------------------------------ */
+
+/* Expand the hash table if needed */
+func (d *dict) _dictExpandIfNeeded() {
+	/* Incremental rehashing already in progress. Return. */
+	if d.dictIsRehashing() {
+		return
+	}
+
+	/* If the hash table is empty expand it to the initial size. */
+	if d.ht[0].size == 0 {
+		d.dictExpand(DICT_HT_INITIAL_SIZE)
+		return
+	}
+
+	/* If we reached the 1:1 ratio we grow the hash table (to the next
+	 * power of two). */
+	if d.ht[0].used >= d.ht[0].size {
+		d.dictExpand(d.ht[0].used + 1)
+	}
+}
+
+/* Our hash table capability is a power of two */
+func dictNextPower(size uint64) uint64 {
+	i := uint64(DICT_HT_INITIAL_SIZE)
+	for i < size {
+		i *= 2
+	}
+	return i
+}
+
+/* Returns the index of a free slot that can be populated with
+ * a hash entry for the given 'key'.
+ * If the key already exists, -1 is returned
+ * and the existing entry is returned as second value.
+ *
+ * Note that if we are in the process of rehashing the hash table, the
+ * index is always returned in the context of the second (new) hash table. */
+func (d *dict) _dictKeyIndex(key string, hash uint64) (int64, *dictEntry) {
+	/* Expand the hash table if needed */
+	d._dictExpandIfNeeded()
+
+	var idx uint64
+	for table := 0; table <= 1; table++ {
+		idx = hash & d.ht[table].sizemask
+		/* Search if this slot does not already contain the given key */
+		for he := d.ht[table].table[idx]; he != nil; he = he.next {
+			if he.key == key {
+				return -1, he
+			}
+		}
+		if !d.dictIsRehashing() {
+			break
+		}
+	}
+	return int64(idx), nil
+}
+
+/* Return true if the hash table is filled below HASHTABLE_MIN_FILL percent
+ * and should be shrunk to save memory. */
+func htNeedsResize(d *dict) bool {
+	size := d.dictSlots()
+	used := d.dictSize()
+	return size > DICT_HT_INITIAL_SIZE && (used*100/size < HASHTABLE_MIN_FILL)
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+// dictScanAll 用dictScan遍历整个dict 每次调用之间执行between 返回遍历到的key
+func dictScanAll(d *dict, between func(step int)) map[string]int {
+	seen := make(map[string]int)
+	var cursor uint64
+	for step := 0; ; step++ {
+		cursor = d.dictScan(cursor, func(de *dictEntry) {
+			seen[de.key]++
+		})
+		if cursor == 0 {
+			return seen
+		}
+		between(step)
+	}
+}
+
+// 遍历期间不修改dict时 每个key恰好返回一次
+func TestDictScan(t *testing.T) {
+	d := dictCreate()
+	for i := 0; i < 1000; i++ {
+		d.dictAdd(strconv.Itoa(i), nil)
+	}
+	seen := dictScanAll(d, func(int) {})
+	if len(seen) != 1000 {
+		t.Fatalf("scanned %d keys, want 1000", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("key %s returned %d times", key, n)
+		}
+	}
+}
+
+// 遍历期间dict扩容并渐进式rehash 遍历开始时就存在的key都要被返回
+func TestDictScanGrow(t *testing.T) {
+	d := dictCreate()
+	for i := 0; i < 100; i++ {
+		d.dictAdd("old:"+strconv.Itoa(i), nil)
+	}
+	added := 0
+	seen := dictScanAll(d, func(step int) {
+		/* Every add performs a rehash step, and expands the table when
+		 * it is full. Stop adding after a while, otherwise the table
+		 * grows faster than the scan progresses. */
+		if step >= 20 {
+			return
+		}
+		for j := 0; j < 50; j++ {
+			d.dictAdd("new:"+strconv.Itoa(added), nil)
+			added++
+		}
+	})
+	if d.dictSlots() <= 128 {
+		t.Fatalf("the table didn't grow during the scan: %d slots", d.dictSlots())
+	}
+	for i := 0; i < 100; i++ {
+		if seen["old:"+strconv.Itoa(i)] == 0 {
+			t.Errorf("key old:%d not returned", i)
+		}
+	}
+}
+
+// 遍历期间删除大部分key并缩容 没有被删除的key都要被返回
+func TestDictScanShrink(t *testing.T) {
+	d := dictCreate()
+	for i := 0; i < 4096; i++ {
+		d.dictAdd(strconv.Itoa(i), nil)
+	}
+	deleted := 0
+	resized := false
+	seen := dictScanAll(d, func(step int) {
+		/* Delete the keys not multiple of 16, the rehash steps of the
+		 * deletes move the keys to the smaller table. */
+		for j := 0; j < 256 && deleted < 4096; j++ {
+			if deleted%16 != 0 {
+				d.dictDelete(strconv.Itoa(deleted))
+			}
+			deleted++
+		}
+		if htNeedsResize(d) && d.dictResize() {
+			resized = true
+		}
+	})
+	if !resized {
+		t.Fatal("the table didn't shrink during the scan")
+	}
+	for i := 0; i < 4096; i += 16 {
+		if seen[strconv.Itoa(i)] == 0 {
+			t.Errorf("key %d not returned", i)
+		}
+	}
+}
+
+// 遍历期间rehash在两张表之间进行 每次调用都推进若干步
+func TestDictScanWhileRehashing(t *testing.T) {
+	d := dictCreate()
+	for i := 0; i < 1000; i++ {
+		d.dictAdd(strconv.Itoa(i), nil)
+	}
+	d.dictExpand(1 << 14)
+	if !d.dictIsRehashing() {
+		t.Fatal("expected the dict to be rehashing")
+	}
+	seen := dictScanAll(d, func(int) {
+		d.dictRehash(1)
+	})
+	for i := 0; i < 1000; i++ {
+		if seen[strconv.Itoa(i)] == 0 {
+			t.Errorf("key %d not returned", i)
+		}
+	}
+}
+
+func TestDictAddFindDelete(t *testing.T) {
+	d := dictCreate()
+	for i := 0; i < 1000; i++ {
+		if !d.dictAdd(strconv.Itoa(i), CreateObject(ObjectTypeString, strconv.Itoa(i*2))) {
+			t.Fatalf("dictAdd(%d) failed", i)
+		}
+	}
+	if d.dictAdd("10", nil) {
+		t.Error("dictAdd of an existing key succeeded")
+	}
+	if d.dictReplace("10", CreateObject(ObjectTypeString, "x")) {
+		t.Error("dictReplace of an existing key reported an insert")
+	}
+	if v := d.dictFetchValue("10"); v == nil || v.Ptr.(string) != "x" {
+		t.Errorf("dictFetchValue(10) = %v, want x", v)
+	}
+	for i := 0; i < 1000; i += 2 {
+		if !d.dictDelete(strconv.Itoa(i)) {
+			t.Fatalf("dictDelete(%d) failed", i)
+		}
+	}
+	if d.dictDelete("0") {
+		t.Error("dictDelete of a missing key succeeded")
+	}
+	if d.dictSize() != 500 {
+		t.Fatalf("dictSize() = %d, want 500", d.dictSize())
+	}
+	for i := 0; i < 1000; i++ {
+		if found := d.dictFind(strconv.Itoa(i)) != nil; found != (i%2 == 1) {
+			t.Errorf("dictFind(%d) = %v", i, found)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		de := d.dictGetRandomKey()
+		if n, _ := strconv.Atoi(de.key); n%2 != 1 {
+			t.Fatalf("dictGetRandomKey() returned the deleted key %s", de.key)
+		}
+	}
+}
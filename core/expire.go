@@ -0,0 +1,363 @@
+package core
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+/*-----------------------------------------------------------------------------
+ * Expires API
+ *----------------------------------------------------------------------------*/
+
+// setExpire 设置key的过期时间 when为毫秒时间戳
+func setExpire(db *GodisDb, key *GodisObject, when int64) {
+	db.Expires.dictReplace(key.Ptr.(string), createStringObjectFromLongLong(when))
+}
+
+// getExpire 获取key的过期毫秒时间戳 没有设置过期时间时返回-1
+func getExpire(db *GodisDb, key *GodisObject) int64 {
+	o := db.Expires.dictFetchValue(key.Ptr.(string))
+	if o == nil {
+		return -1
+	}
+	return o.Ptr.(int64)
+}
+
+// removeExpire 清除key的过期时间 返回key之前是否设置了过期时间
+func removeExpire(db *GodisDb, key *GodisObject) bool {
+	return db.Expires.dictDelete(key.Ptr.(string))
+}
+
+/* Check if the key is expired. */
+func keyIsExpired(db *GodisDb, key *GodisObject) bool {
+	/* Don't expire anything while loading. It will be done later. */
+	if db.server.Loading {
+		return false
+	}
+	when := getExpire(db, key)
+	if when < 0 {
+		return false /* No expire for this key */
+	}
+	return mstime() > when
+}
+
+/* This function is called when we are going to perform some operation
+ * in a given key, but such key may be already logically expired even if
+ * it still exists in the database. The main way this function is called
+ * is via lookupKey().
+ *
+ * The key is deleted and the deletion is propagated to the AOF as a DEL,
+ * so that a later write to the same key replays against the same state
+ * even if the AOF is loaded after the key would have expired.
+ *
+ * The return value of the function is false if the key is still valid,
+ * otherwise the function returns true if the key is expired. */
+func expireIfNeeded(db *GodisDb, key *GodisObject) bool {
+	if !keyIsExpired(db, key) {
+		return false
+	}
+	deleteExpiredKeyAndPropagate(db, key)
+	return true
+}
+
+/* Return true if the expire time 'when' is already in the past, in which
+ * case EXPIRE & co. delete the key instead of setting the expire. While
+ * loading the AOF the key is never deleted, see keyIsExpired(). */
+func checkAlreadyExpired(s *Server, when int64) bool {
+	return when <= mstime() && !s.Loading
+}
+
+/*-----------------------------------------------------------------------------
+ * Incremental collection of expired keys.
+ *
+ * When keys are accessed they are expired on-access. However we need a
+ * mechanism in order to ensure keys are eventually removed when expired even
+ * if no access is performed on them.
+ *----------------------------------------------------------------------------*/
+
+const ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP = 20    /* Keys for each DB loop. */
+const ACTIVE_EXPIRE_CYCLE_SLOW_TIME_PERC = 25   /* Max % of CPU to use. */
+const ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE = 10 /* % of stale keys after which we do extra efforts. */
+
+/* Try to expire a few timed out keys. The algorithm used is adaptive and
+ * will use few CPU cycles if there are few expiring keys, otherwise
+ * it will get more aggressive to avoid that too much memory is used by
+ * keys that can be removed from the keyspace.
+ *
+ * Every database is sampled ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP random keys
+ * with an expire set at a time. The sampling of
+ * a database is repeated while more than ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE
+ * percent of the sampled keys were expired, and the whole cycle never runs
+ * longer than ACTIVE_EXPIRE_CYCLE_SLOW_TIME_PERC percent of the cron period. */
+func activeExpireCycle(s *Server) {
+	if s.Loading {
+		return
+	}
+	start := time.Now()
+	timelimit := time.Duration(1000000*ACTIVE_EXPIRE_CYCLE_SLOW_TIME_PERC/s.Hz/100) * time.Microsecond
+
+	for _, db := range s.Db {
+		for {
+			num := db.Expires.dictSize()
+			if num == 0 {
+				break
+			}
+			if num > ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP {
+				num = ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP
+			}
+
+			now := mstime()
+			sampled, expired := 0, 0
+			for ; sampled < num; sampled++ {
+				de := db.Expires.dictGetRandomKey()
+				if de.val.Ptr.(int64) < now {
+					deleteExpiredKeyAndPropagate(db, CreateObject(ObjectTypeString, de.key))
+					expired++
+				}
+			}
+
+			/* We can't block forever here even if there are many keys to
+			 * expire. So after the given amount of time return. */
+			if time.Since(start) > timelimit {
+				return
+			}
+			/* We don't repeat the cycle for the current database if there
+			 * is an acceptable amount of stale keys (logically expired but
+			 * yet not reclaimed). */
+			if expired*100/sampled <= ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE {
+				break
+			}
+		}
+	}
+}
+
+/*-----------------------------------------------------------------------------
+ * Expires Commands
+ *----------------------------------------------------------------------------*/
+
+// EXPIRE 系列命令的选项
+const EXPIRE_NX = 1 << 0
+const EXPIRE_XX = 1 << 1
+const EXPIRE_GT = 1 << 2
+const EXPIRE_LT = 1 << 3
+
+/* Parse additional flags of expire commands
+ *
+ * Supported flags:
+ * - NX: set expiry only when the key has no expiry
+ * - XX: set expiry only when the key has an existing expiry
+ * - GT: set expiry only when the new expiry is greater than current one
+ * - LT: set expiry only when the new expiry is less than current one */
+func parseExtendedExpireArgumentsOrReply(c *Client, flags *int) int {
+	nx, xx, gt, lt := 0, 0, 0, 0
+
+	for j := 3; j < c.Argc; j++ {
+		switch strings.ToLower(c.Argv[j].Ptr.(string)) {
+		case "nx":
+			*flags |= EXPIRE_NX
+			nx = 1
+		case "xx":
+			*flags |= EXPIRE_XX
+			xx = 1
+		case "gt":
+			*flags |= EXPIRE_GT
+			gt = 1
+		case "lt":
+			*flags |= EXPIRE_LT
+			lt = 1
+		default:
+			addReplyError(c, "ERR Unsupported option "+c.Argv[j].Ptr.(string))
+			return C_ERR
+		}
+	}
+
+	if nx != 0 && xx+gt+lt != 0 {
+		addReplyError(c, "ERR NX and XX, GT or LT options at the same time are not compatible")
+		return C_ERR
+	}
+	if gt != 0 && lt != 0 {
+		addReplyError(c, "ERR GT and LT options at the same time are not compatible")
+		return C_ERR
+	}
+	return C_OK
+}
+
+/* This is the generic command implementation for EXPIRE, PEXPIRE, EXPIREAT
+ * and PEXPIREAT. Because the command second argument may be relative or absolute
+ * the "basetime" argument is used to signal what the base time is (either 0
+ * for *AT variants of the command, or the current time for relative expires).
+ *
+ * unit is either UNIT_SECONDS or UNIT_MILLISECONDS, and is only used for
+ * the argv[2] parameter. The basetime is always specified in milliseconds.
+ *
+ * Additional flags are supported and parsed via parseExtendedExpireArguments */
+func expireGenericCommand(c *Client, s *Server, basetime int64, unit int) {
+	key := c.Argv[1]
+	param := c.Argv[2]
+	var when int64 /* unix time in milliseconds when the key will expire. */
+	flag := 0
+
+	/* checking optional flags */
+	if parseExtendedExpireArgumentsOrReply(c, &flag) != C_OK {
+		return
+	}
+	if getLongLongFromObjectOrReply(c, param, &when, "") != C_OK {
+		return
+	}
+
+	/* EXPIRE allows negative numbers, but we can at least detect an
+	 * overflow by either unit conversion or basetime addition. */
+	if unit == UNIT_SECONDS {
+		if when > math.MaxInt64/1000 || when < math.MinInt64/1000 {
+			addReplyError(c, "ERR invalid expire time in '"+c.Cmd.Name+"' command")
+			return
+		}
+		when *= 1000
+	}
+	if when > math.MaxInt64-basetime {
+		addReplyError(c, "ERR invalid expire time in '"+c.Cmd.Name+"' command")
+		return
+	}
+	when += basetime
+
+	/* No key, return zero. */
+	if lookupKey(c.Db, key) == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+
+	if flag != 0 {
+		currentExpire := getExpire(c.Db, key)
+
+		/* NX option is set, check current has no expiry */
+		if flag&EXPIRE_NX != 0 && currentExpire != -1 {
+			addReplyLongLong(c, 0)
+			return
+		}
+		/* XX option is set, check current has expiry */
+		if flag&EXPIRE_XX != 0 && currentExpire == -1 {
+			addReplyLongLong(c, 0)
+			return
+		}
+		/* GT option is set, check new expiry is greater than current.
+		 * A key without an expiry is treated as an infinite TTL. */
+		if flag&EXPIRE_GT != 0 && (when <= currentExpire || currentExpire == -1) {
+			addReplyLongLong(c, 0)
+			return
+		}
+		/* LT option is set, check new expiry is less than current */
+		if flag&EXPIRE_LT != 0 && currentExpire != -1 && when >= currentExpire {
+			addReplyLongLong(c, 0)
+			return
+		}
+	}
+
+	if checkAlreadyExpired(s, when) {
+		dbDelete(c.Db, key)
+
+		/* Replicate/AOF this as an explicit DEL. */
+		rewriteClientCommandVector(c, CreateObject(ObjectTypeString, "del"), key)
+		s.Dirty++
+		addReplyLongLong(c, 1)
+		return
+	}
+
+	setExpire(c.Db, key, when)
+	addReplyLongLong(c, 1)
+
+	/* Propagate as PEXPIREAT millisecond-timestamp
+	 * Only rewrite the command arg if not already PEXPIREAT */
+	if c.Cmd.Name != "pexpireat" {
+		argv := append([]*GodisObject{CreateObject(ObjectTypeString, "pexpireat"), key,
+			createStringObjectFromLongLong(when)}, c.Argv[3:c.Argc]...)
+		rewriteClientCommandVector(c, argv...)
+	}
+	s.Dirty++
+}
+
+// ExpireCommand expire key seconds [NX|XX|GT|LT]
+func ExpireCommand(c *Client, s *Server) {
+	expireGenericCommand(c, s, mstime(), UNIT_SECONDS)
+}
+
+// ExpireAtCommand expireat key unix-time-seconds [NX|XX|GT|LT]
+func ExpireAtCommand(c *Client, s *Server) {
+	expireGenericCommand(c, s, 0, UNIT_SECONDS)
+}
+
+// PExpireCommand pexpire key milliseconds [NX|XX|GT|LT]
+func PExpireCommand(c *Client, s *Server) {
+	expireGenericCommand(c, s, mstime(), UNIT_MILLISECONDS)
+}
+
+// PExpireAtCommand pexpireat key unix-time-milliseconds [NX|XX|GT|LT]
+func PExpireAtCommand(c *Client, s *Server) {
+	expireGenericCommand(c, s, 0, UNIT_MILLISECONDS)
+}
+
+/* Implements TTL, PTTL, EXPIRETIME and PEXPIRETIME.
+ * When 'outputMs' is true the result is in milliseconds, otherwise seconds.
+ * When 'outputAbs' is true the result is the absolute unix time of the
+ * expire, otherwise the time to live. */
+func ttlGenericCommand(c *Client, outputMs bool, outputAbs bool) {
+	/* If the key does not exist at all, return -2 */
+	if lookupKey(c.Db, c.Argv[1]) == nil {
+		addReplyLongLong(c, -2)
+		return
+	}
+
+	/* The key exists. Return -1 if it has no expire, or the actual
+	 * TTL value otherwise. */
+	expire := getExpire(c.Db, c.Argv[1])
+	if expire == -1 {
+		addReplyLongLong(c, -1)
+		return
+	}
+	ttl := expire
+	if !outputAbs {
+		ttl = expire - mstime()
+		if ttl < 0 {
+			ttl = 0
+		}
+	}
+	if outputMs {
+		addReplyLongLong(c, ttl)
+	} else {
+		addReplyLongLong(c, (ttl+500)/1000)
+	}
+}
+
+// TTLCommand ttl key
+func TTLCommand(c *Client, s *Server) {
+	ttlGenericCommand(c, false, false)
+}
+
+// PTTLCommand pttl key
+func PTTLCommand(c *Client, s *Server) {
+	ttlGenericCommand(c, true, false)
+}
+
+// ExpireTimeCommand expiretime key
+func ExpireTimeCommand(c *Client, s *Server) {
+	ttlGenericCommand(c, false, true)
+}
+
+// PExpireTimeCommand pexpiretime key
+func PExpireTimeCommand(c *Client, s *Server) {
+	ttlGenericCommand(c, true, true)
+}
+
+// PersistCommand persist key
+func PersistCommand(c *Client, s *Server) {
+	if lookupKey(c.Db, c.Argv[1]) == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if removeExpire(c.Db, c.Argv[1]) {
+		s.Dirty++
+		addReplyLongLong(c, 1)
+	} else {
+		addReplyLongLong(c, 0)
+	}
+}
@@ -2,7 +2,8 @@ package core
 
 import (
 	"fmt"
-	"os"
+	"godis/core/proto"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -15,13 +16,51 @@ const SORT_NONE = 0
 const SORT_ASC = 1
 const SORT_DESC = 2
 
+/* Retrieve the longitude and latitude from the two arguments starting at
+ * argv[0] and store them into xy. On error C_ERR is returned and an error
+ * is replied to the client, otherwise C_OK is returned. */
+func extractLongLatOrReply(c *Client, argv []*GodisObject, xy *[2]float64) int {
+	for i := 0; i < 2; i++ {
+		if getDoubleFromObjectOrReply(c, argv[i], &xy[i], "") != C_OK {
+			return C_ERR
+		}
+	}
+	if xy[0] < GEO_LONG_MIN || xy[0] > GEO_LONG_MAX ||
+		xy[1] < GEO_LAT_MIN || xy[1] > GEO_LAT_MAX {
+		addReplyError(c, fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", xy[0], xy[1]))
+		return C_ERR
+	}
+	return C_OK
+}
+
+/* Input Argument Helper */
+/* Decode lat/long from a zset member's score.
+ * Returns C_OK on successful decoding, otherwise C_ERR is returned. */
+func longLatFromMember(zobj *GodisObject, member *GodisObject, xy *[2]float64) int {
+	var score float64
+	if zsetScore(zobj, member.Ptr.(string), &score) == C_ERR {
+		return C_ERR
+	}
+	if !decodeGeohash(score, xy) {
+		return C_ERR
+	}
+	return C_OK
+}
+
+// formatDistance 距离保留4位小数
+func formatDistance(d float64) string {
+	return strconv.FormatFloat(d, 'f', 4, 64)
+}
+
 // geoaddCommand 命令实现
+// GEOADD key long lat name [long2 lat2 name2 ... longN latN nameN]
 func GeoAddCommand(c *Client, s *Server) {
-	// check params numbers
+	/* Check arguments number for sanity. */
 	if (c.Argc-2)%3 != 0 {
 		/* Need an odd number of arguments if we got this far... */
-		addReplyError(c, "syntax error. Try GEOADD key [x1] [y1] [name1] "+
+		addReplyError(c, "ERR syntax error. Try GEOADD key [x1] [y1] [name1] "+
 			"[x2] [y2] [name2] ... ")
+		return
 	}
 
 	elements := (c.Argc - 2) / 3 //坐标数
@@ -30,24 +69,19 @@ func GeoAddCommand(c *Client, s *Server) {
 	argv[0] = CreateObject(ObjectTypeString, "zadd")
 	argv[1] = c.Argv[1]
 
+	/* Create the argument vector to call ZADD in order to add all
+	 * the score,value pairs to the requested zset, where score is actually
+	 * an encoded version of lat,long. */
 	for i := 0; i < elements; i++ {
 		var xy [2]float64
 		var hash GeoHashBits
 		//提取经纬度
-		if lngObj, ok1 := c.Argv[i*3+2].Ptr.(string); ok1 {
-			if latObj, ok2 := c.Argv[i*3+3].Ptr.(string); ok2 {
-				var ok error
-				xy[0], ok = strconv.ParseFloat(lngObj, 64)
-				xy[1], ok = strconv.ParseFloat(latObj, 64)
-				if ok != nil {
-					addReplyError(c, "lng lat type error")
-					os.Exit(0)
-				}
-			}
+		if extractLongLatOrReply(c, c.Argv[i*3+2:], &xy) == C_ERR {
+			return
 		}
 		geohashEncodeWGS84(xy[0], xy[1], GEO_STEP_MAX, &hash)
 		bits := geohashAlign52Bits(hash)
-		score := CreateObject(ObjectTypeString, bits)
+		score := CreateObject(ObjectTypeString, strconv.FormatUint(bits, 10))
 
 		val := c.Argv[2+i*3+2]
 		argv[2+i*2] = score // 设置有序集合元素的分值和名字
@@ -55,30 +89,39 @@ func GeoAddCommand(c *Client, s *Server) {
 	}
 	c.Argc = argc
 	c.Argv = argv
-	zaddCommand(c)
-
-	addReplyStatus(c, "OK")
+	/* Finally call ZADD that will do the work for us, the reply of
+	 * ZADD is the reply of GEOADD. */
+	ZAddCommand(c, s)
 }
 
 //获取特定位置的hash值
+// GEOHASH key ele1 ele2 ... eleN
+//
+// Returns an array with an 11 characters geohash representation of the
+// position of the specified elements.
 func GeoHashCommand(c *Client, s *Server) {
 	geoAlphabet := "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	/* Look up the requested zset */
 	zobj := lookupKey(c.Db, c.Argv[1])
-	if zobj != nil && zobj.ObjectType != OBJ_ZSET {
+	if zobj != nil && checkType(c, zobj, OBJ_ZSET) {
 		return
 	}
-	buf := ""
+
+	/* Geohash elements one after the other, using a null bulk reply for
+	 * missing elements. */
+	items := make([]*proto.Resp, 0, c.Argc-2)
 	for j := 2; j < c.Argc; j++ {
-		var score float64
-		if zobj == nil || zsetScore(zobj, c.Argv[j].Ptr.(string), &score) == C_ERR {
-			addReplyError(c, "score get error ")
-			return
-		}
 		var xy [2]float64
-		if !decodeGeohash(score, &xy) {
-			addReplyError(c, "hash get error")
+		if zobj == nil || longLatFromMember(zobj, c.Argv[j], &xy) == C_ERR {
+			items = append(items, proto.NewBulkBytes(nil))
 			continue
 		}
+		/* The internal format we use for geocoding is a bit different
+		 * than the standard, since we use as initial latitude range
+		 * -85,85, while the normal geohashing algorithm uses -90,90.
+		 * So we have to decode our position and re-encode using the
+		 * standard ranges in order to output a valid geohash string. */
 		r := [2]GeoHashRange{}
 		var hash GeoHashBits
 		r[0].min = -180
@@ -87,232 +130,283 @@ func GeoHashCommand(c *Client, s *Server) {
 		r[1].max = 90
 		geohashEncode(&r[0], &r[1], xy[0], xy[1], 26, &hash)
 
-		temp := ""
+		buf := make([]byte, 11)
 		for i := 0; i < 11; i++ {
-			count := 52 - (i+1)*5
-			idx := (hash.bits >> (uint(count))) & 0x1f
-			temp += string(geoAlphabet[idx])
+			var idx uint64
+			if i == 10 {
+				/* We have just 52 bits, but the API used to output
+				 * an 11 bytes geohash. For compatibility we assume
+				 * zero. */
+				idx = 0
+			} else {
+				idx = (hash.bits >> uint(52-((i+1)*5))) & 0x1f
+			}
+			buf[i] = geoAlphabet[idx]
 		}
-		buf += temp
-		buf += ";"
+		items = append(items, bulkString(string(buf)))
 	}
-	addReplyStatus(c, buf)
+	addReplyArray(c, items)
 }
 
 //获取经纬度
+// GEOPOS key ele1 ele2 ... eleN
+//
+// Returns an array of two-items arrays representing the x,y position of each
+// element specified in the arguments. For missing elements NULL is returned.
 func GeoPosCommand(c *Client, s *Server) {
+	/* Look up the requested zset */
 	zobj := lookupKey(c.Db, c.Argv[1])
-	if zobj != nil && zobj.ObjectType != OBJ_ZSET {
+	if zobj != nil && checkType(c, zobj, OBJ_ZSET) {
 		return
 	}
-	buf := "lng:"
 
+	/* Report elements one after the other, using a null bulk reply for
+	 * missing elements. */
+	items := make([]*proto.Resp, 0, c.Argc-2)
 	for j := 2; j < c.Argc; j++ {
-		var score float64
-		if zobj == nil || zsetScore(zobj, c.Argv[j].Ptr.(string), &score) == C_ERR {
-			addReplyError(c, "score get error ")
-			return
-		}
 		var xy [2]float64
-		if !decodeGeohash(score, &xy) {
-			addReplyError(c, "hash get error")
+		if zobj == nil || longLatFromMember(zobj, c.Argv[j], &xy) == C_ERR {
+			items = append(items, proto.NewArray(nil))
 			continue
 		}
-
-		buf += fmt.Sprint(xy[0])
-		buf += ",lat:"
-		buf += fmt.Sprint(xy[1])
-		buf += ";"
+		items = append(items, proto.NewArray([]*proto.Resp{
+			bulkString(formatHumanDouble(xy[0])),
+			bulkString(formatHumanDouble(xy[1])),
+		}))
 	}
-	addReplyStatus(c, buf)
+	addReplyArray(c, items)
 }
 
 //获取两个位置的距离
+// GEODIST key ele1 ele2 [unit]
+//
+// Return the distance, in meters by default, otherwise according to "unit",
+// between points ele1 and ele2. If one or more elements are missing NULL
+// is returned.
 func GeoDistCommand(c *Client, s *Server) {
-	if c.Argc >= 5 {
-		addReplyError(c, "params error")
+	toMeter := 1.0
+
+	/* Check if there is the unit to extract, otherwise assume meters. */
+	if c.Argc == 5 {
+		toMeter = extractUnitOrReply(c, c.Argv[4])
+		if toMeter < 0 {
+			return
+		}
+	} else if c.Argc > 5 {
+		addReplyError(c, errSyntax)
 		return
 	}
+
+	/* Look up the requested zset */
 	zobj := lookupKey(c.Db, c.Argv[1])
-	if zobj != nil && zobj.ObjectType != OBJ_ZSET {
+	if zobj == nil {
+		addReplyNull(c)
 		return
 	}
-
-	var score1, score2 float64
-	var xyxy1, xyxy2 [2]float64
-	if zsetScore(zobj, c.Argv[2].Ptr.(string), &score1) == C_ERR ||
-		zsetScore(zobj, c.Argv[3].Ptr.(string), &score2) == C_ERR {
-		addReplyError(c, "score get error ")
+	if checkType(c, zobj, OBJ_ZSET) {
 		return
 	}
 
-	if !decodeGeohash(score1, &xyxy1) || !decodeGeohash(score2, &xyxy2) {
-		addReplyError(c, "hash get error")
+	/* Get the scores. We need both otherwise NULL is returned. */
+	var xyxy1, xyxy2 [2]float64
+	if longLatFromMember(zobj, c.Argv[2], &xyxy1) == C_ERR ||
+		longLatFromMember(zobj, c.Argv[3], &xyxy2) == C_ERR {
+		addReplyNull(c)
 		return
 	}
 
-	buf := geohashGetDistance(xyxy1[0], xyxy1[1], xyxy2[0], xyxy2[1])
-	addReplyStatus(c, fmt.Sprint(buf))
+	dist := geohashGetDistance(xyxy1[0], xyxy1[1], xyxy2[0], xyxy2[1])
+	addReplyBulk(c, formatDistance(dist/toMeter))
 }
 
+// GEORADIUS key x y radius unit [WITHDIST] [WITHHASH] [WITHCOORD] [ASC|DESC]
+//
+//	[COUNT count] [STORE key] [STOREDIST key]
 func GeoRadiusCommand(c *Client, s *Server) {
-	georadiusGeneric(c, RADIUS_COORDS)
+	georadiusGeneric(c, s, RADIUS_COORDS)
 }
 
+// GEORADIUSBYMEMBER key member radius unit ... options ...
 func GeoRadiusByMemberCommand(c *Client, s *Server) {
-	georadiusGeneric(c, RADIUS_MEMBER)
+	georadiusGeneric(c, s, RADIUS_MEMBER)
 }
 
 //georadius Sicily 15 37 100 km
-func georadiusGeneric(c *Client, flags uint) {
+func georadiusGeneric(c *Client, s *Server, flags uint) {
 	var storekey *GodisObject
-	storedist := 0 /* 0 for STORE, 1 for STOREDIST. */
+	storedist := false /* false for STORE, true for STOREDIST. */
 
-	//获取有序集合
+	/* Look up the requested zset */
 	zobj := lookupKey(c.Db, c.Argv[1])
-	if zobj != nil && zobj.ObjectType != OBJ_ZSET {
+	if zobj != nil && checkType(c, zobj, OBJ_ZSET) {
 		return
 	}
 
+	/* Find long/lat to use for radius search based on inquiry type */
 	var xy [2]float64
-	var base_args int
+	var baseArgs int
 	if flags&RADIUS_COORDS > 0 {
-		base_args = 6
-		arg2, ok1 := c.Argv[2].Ptr.(string)
-		arg3, ok2 := c.Argv[3].Ptr.(string)
-		if !ok1 || !ok2 {
-			addReplyError(c, "get lng lat error")
+		baseArgs = 6
+		if extractLongLatOrReply(c, c.Argv[2:], &xy) == C_ERR {
 			return
 		}
-
-		var err error
-		xy[0], err = strconv.ParseFloat(arg2, 64)
-		xy[1], err = strconv.ParseFloat(arg3, 64)
-		if err != nil {
-			addReplyError(c, "get lng lat float error")
+	} else if flags&RADIUS_MEMBER > 0 {
+		baseArgs = 5
+		if zobj == nil || longLatFromMember(zobj, c.Argv[2], &xy) == C_ERR {
+			addReplyError(c, "ERR could not decode requested zset member")
 			return
 		}
-	} else if flags&RADIUS_MEMBER > 0 {
-		//member command
-		base_args = 7
 	} else {
-		addReplyError(c, "Unknown georadius search type")
+		addReplyError(c, "ERR Unknown georadius search type")
 		return
 	}
 
-	//获取参数单位
-	conversion := extractUnitOrReply(c, *c.Argv[base_args-1])
-	radius_meters, err := strconv.ParseFloat(c.Argv[base_args-2].Ptr.(string), 64)
-	if err != nil {
-		addReplyError(c, "radius_meters error")
+	/* Extract radius and units from arguments */
+	var radius float64
+	if getDoubleFromObjectOrReply(c, c.Argv[baseArgs-2], &radius, "ERR need numeric radius") != C_OK {
+		return
+	}
+	if radius < 0 {
+		addReplyError(c, "ERR radius cannot be negative")
 		return
 	}
-	radius_meters = radius_meters * conversion
+	conversion := extractUnitOrReply(c, c.Argv[baseArgs-1])
+	if conversion < 0 {
+		return
+	}
+	radiusMeters := radius * conversion
 
-	// 提取所有可选参数
-	withdist := 0
-	withhash := 0
-	withcoords := 0
-	sort := SORT_NONE
+	/* Discover and populate all optional parameters. */
+	withdist, withhash, withcoords := false, false, false
+	sorting := SORT_NONE
 	var count int64 = 0
-	if c.Argc > base_args {
-		remaining := c.Argc - base_args
-		for i := 0; i < remaining; i++ {
-			arg := c.Argv[base_args+i].Ptr.(string)
-			if strings.EqualFold(arg, "withdist") {
-				withdist = 1
-			} else if strings.EqualFold(arg, "withhash") {
-				withhash = 1
-			} else if strings.EqualFold(arg, "withcoord") {
-				withcoords = 1
-			} else if strings.EqualFold(arg, "asc") {
-				sort = SORT_ASC
-			} else if strings.EqualFold(arg, "desc") {
-				sort = SORT_DESC
-			} else if strings.EqualFold(arg, "count") && (i+1) < remaining {
-
-				if count < 0 {
-					addReplyError(c, "COUNT must be > 0")
-					return
-				}
-				i++
-			} else if strings.EqualFold(arg, "store") && (i+1) < remaining && (flags&RADIUS_NOSTORE == 0) {
-				storekey = c.Argv[base_args+i+1]
-				storedist = 0
-				i++
-			} else if strings.EqualFold(arg, "storedist") && (i+1) < remaining && (flags&RADIUS_NOSTORE == 0) {
-				storekey = c.Argv[base_args+i+1]
-				storedist = 1
-				i++
-			} else {
-				addReplyError(c, "params error")
+	for i := baseArgs; i < c.Argc; i++ {
+		arg := c.Argv[i].Ptr.(string)
+		remaining := c.Argc - i - 1
+		if strings.EqualFold(arg, "withdist") {
+			withdist = true
+		} else if strings.EqualFold(arg, "withhash") {
+			withhash = true
+		} else if strings.EqualFold(arg, "withcoord") {
+			withcoords = true
+		} else if strings.EqualFold(arg, "asc") {
+			sorting = SORT_ASC
+		} else if strings.EqualFold(arg, "desc") {
+			sorting = SORT_DESC
+		} else if strings.EqualFold(arg, "count") && remaining > 0 {
+			if getLongLongFromObjectOrReply(c, c.Argv[i+1], &count, "") != C_OK {
+				return
+			}
+			if count <= 0 {
+				addReplyError(c, "ERR COUNT must be > 0")
 				return
 			}
+			i++
+		} else if strings.EqualFold(arg, "store") && remaining > 0 && flags&RADIUS_NOSTORE == 0 {
+			storekey = c.Argv[i+1]
+			storedist = false
+			i++
+		} else if strings.EqualFold(arg, "storedist") && remaining > 0 && flags&RADIUS_NOSTORE == 0 {
+			storekey = c.Argv[i+1]
+			storedist = true
+			i++
+		} else {
+			addReplyError(c, errSyntax)
+			return
 		}
 	}
 
-	if storekey != nil && (withdist > 0 || withhash > 0 || withcoords > 0) {
+	/* Trap options not compatible with STORE and STOREDIST. */
+	if storekey != nil && (withdist || withhash || withcoords) {
 		addReplyError(c,
-			"STORE option in GEORADIUS is not compatible with "+
+			"ERR STORE option in GEORADIUS is not compatible with "+
 				"WITHDIST, WITHHASH and WITHCOORDS options")
 		return
 	}
 
-	// 指定排序方式
-	if count != 0 && sort == SORT_NONE {
-		sort = SORT_ASC
+	/* COUNT without ordering does not make much sense, force ASC
+	 * ordering if COUNT was specified but no sorting was requested. */
+	if count != 0 && sorting == SORT_NONE {
+		sorting = SORT_ASC
 	}
 
-	// 定位中心点所处的范围
-	georadius := geohashGetAreasByRadiusWGS84(xy[0], xy[1], radius_meters)
-
 	/* Search the zset for all matching points */
-	ga := geoArrayCreate() // 对中心点以及它的八个方向进行查找，找出所有范围内的元素
-	membersOfAllNeighbors(zobj, georadius, xy[0], xy[1], radius_meters, ga)
+	ga := geoArrayCreate()
+	if zobj != nil {
+		// 定位中心点所处的范围 对中心点以及它的八个方向进行查找，找出所有范围内的元素
+		georadius := geohashGetAreasByRadiusWGS84(xy[0], xy[1], radiusMeters)
+		membersOfAllNeighbors(zobj, georadius, xy[0], xy[1], radiusMeters, ga)
+	}
 
+	/* If no matching results, the user gets an empty reply. */
 	if ga.used == 0 && storekey == nil {
-		addReplyError(c, "emptymultibulk")
+		addReplyArray(c, nil)
 		return
 	}
 
-	result_length := ga.used
-	var returned_items int
-	if count == 0 || int64(result_length) < count {
-		returned_items = int(result_length)
-	} else {
-		returned_items = int(count)
+	returnedItems := int(ga.used)
+	if count != 0 && count < int64(returnedItems) {
+		returnedItems = int(count)
 	}
-	option_length := 0
-
-	if sort == SORT_ASC {
-
-	} else if sort == SORT_DESC {
 
+	/* Process [optional] requested sorting */
+	if sorting == SORT_ASC {
+		sort.SliceStable(ga.array, func(i, j int) bool { return ga.array[i].dist < ga.array[j].dist })
+	} else if sorting == SORT_DESC {
+		sort.SliceStable(ga.array, func(i, j int) bool { return ga.array[i].dist > ga.array[j].dist })
 	}
 
 	if storekey == nil {
-		if withdist > 0 {
-			option_length++
-		}
-		if withcoords > 0 {
-			option_length++
-		}
-		if withhash > 0 {
-			option_length++
-		}
-
 		/* Finally send results back to the caller */
-		for i := 0; i < returned_items; i++ {
+		items := make([]*proto.Resp, 0, returnedItems)
+		for i := 0; i < returnedItems; i++ {
 			gp := ga.array[i]
-			gp.dist /= conversion
-			fmt.Println(gp)
-			addReplyStatus(c, gp.member)
+			gp.dist /= conversion /* Fix according to unit. */
+
+			/* If we have options in option_length, return each sub-result
+			 * as a nested multi-bulk. Add 1 to account for result value
+			 * itself. */
+			if !withdist && !withhash && !withcoords {
+				items = append(items, bulkString(gp.member))
+				continue
+			}
+			item := []*proto.Resp{bulkString(gp.member)}
+			if withdist {
+				item = append(item, bulkString(formatDistance(gp.dist)))
+			}
+			if withhash {
+				item = append(item, proto.NewInt([]byte(strconv.FormatUint(uint64(gp.score), 10))))
+			}
+			if withcoords {
+				item = append(item, proto.NewArray([]*proto.Resp{
+					bulkString(formatHumanDouble(gp.longitude)),
+					bulkString(formatHumanDouble(gp.latitude)),
+				}))
+			}
+			items = append(items, proto.NewArray(item))
 		}
-
+		addReplyArray(c, items)
 	} else {
-		fmt.Println(storedist)
+		/* Store the results in the target key, either with the geohash
+		 * as score or the distance, according to STOREDIST. */
+		if returnedItems > 0 {
+			zsetobj := createZsetObject()
+			for i := 0; i < returnedItems; i++ {
+				gp := ga.array[i]
+				score := gp.score
+				if storedist {
+					score = gp.dist / conversion
+				}
+				zaddFlags := ZADD_NONE
+				zSetAdd(zsetobj, score, gp.member, &zaddFlags, nil)
+			}
+			setKey(c.Db, storekey, zsetobj, false)
+			s.Dirty += int64(returnedItems)
+		} else if dbDelete(c.Db, storekey) {
+			s.Dirty++
+		}
+		addReplyLongLong(c, int64(returnedItems))
 	}
-
 }
 
 func geoArrayCreate() *geoArray {
@@ -324,19 +418,21 @@ func geoArrayCreate() *geoArray {
 }
 
 //单位
-func extractUnitOrReply(c *Client, uint GodisObject) float64 {
-	u := uint.Ptr.(string)
+/* Return the meters conversion factor of the unit, or -1 replying an
+ * error to the client if the unit is not supported. */
+func extractUnitOrReply(c *Client, unit *GodisObject) float64 {
+	u := unit.Ptr.(string)
 
-	if strings.Compare(u, "m") == 0 {
+	if strings.EqualFold(u, "m") {
 		return 1
-	} else if strings.Compare(u, "km") == 0 {
+	} else if strings.EqualFold(u, "km") {
 		return 1000
-	} else if strings.Compare(u, "ft") == 0 {
+	} else if strings.EqualFold(u, "ft") {
 		return 0.3048
-	} else if strings.Compare(u, "mi") == 0 {
+	} else if strings.EqualFold(u, "mi") {
 		return 1609.34
 	} else {
-		addReplyError(c, "unsupported unit provided. please use m, km, ft, mi")
+		addReplyError(c, "ERR unsupported unit provided. please use M, KM, FT, MI")
 		return -1
 	}
 }
@@ -369,11 +465,11 @@ func membersOfAllNeighbors(zobj *GodisObject, n GeoHashRadius, lon float64, lat
 			geohashDecode(long_range, lat_range, neighbors[i], myarea)
 
 			/* Dump center square. */
-			fmt.Println("neighbors[%d]:\n", i)
-			fmt.Println("area.longitude.min: %f\n", myarea.longitude.min)
-			fmt.Println("area.longitude.max: %f\n", myarea.longitude.max)
-			fmt.Println("area.latitude.min: %f\n", myarea.latitude.min)
-			fmt.Println("area.latitude.max: %f\n", myarea.latitude.max)
+			fmt.Printf("neighbors[%d]:\n", i)
+			fmt.Printf("area.longitude.min: %f\n", myarea.longitude.min)
+			fmt.Printf("area.longitude.max: %f\n", myarea.longitude.max)
+			fmt.Printf("area.latitude.min: %f\n", myarea.latitude.min)
+			fmt.Printf("area.latitude.max: %f\n", myarea.latitude.max)
 		}
 
 		/* When a huge Radius (in the 5000 km range or more) is used,
@@ -384,7 +480,7 @@ func membersOfAllNeighbors(zobj *GodisObject, n GeoHashRadius, lon float64, lat
 			neighbors[i].bits == neighbors[last_processed].bits &&
 			neighbors[i].step == neighbors[last_processed].step {
 			if debugmsg > 0 {
-				fmt.Println("Skipping processing of %d, same as previous\n", i)
+				fmt.Printf("Skipping processing of %d, same as previous\n", i)
 			}
 			continue
 		}
@@ -1,13 +1,15 @@
 package core
 
 import (
-	"bytes"
-	"errors"
 	"fmt"
 	"godis/core/proto"
+	"io"
 	"log"
-	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 //Client 与服务端连接之后即创建一个Client结构
@@ -16,21 +18,51 @@ type Client struct {
 	Argv           []*GodisObject
 	Argc           int
 	Db             *GodisDb
-	QueryBuf       string
-	Buf            string
 	FakeFlag       bool
 	PubSubChannels *map[string]*List
 	PubSubPatterns *List
-	Flags          int //client flags
+	Flags          int            //client flags
+	Btype          int            // 阻塞类型 BLOCKED_*
+	Bpop           blockingState  // 阻塞状态
+	unblocked      chan struct{}  // 解除阻塞时通知连接
+	decoder        *proto.Decoder // 解析客户端请求的流式decoder
+	closed         chan struct{}  // 连接断开时关闭
+	ID             int64          // 客户端的唯一id
+	Name           string         // 通过HELLO SETNAME设置的名字
+	Resp           int            // 协议版本 2或3 通过HELLO协商
+
+	server                   *Server
+	conn                     io.Closer     // 客户端的连接 加载aof的伪客户端为nil
+	reply                    []*proto.Resp // 等待发送给客户端的回复
+	replyBytes               int64         // 等待发送的回复的字节数 用于输出缓冲区限制
+	obufSoftLimitReachedTime int64         // 第一次达到软限制的时间 单位秒
+	pending                  chan struct{} // 有回复等待发送时通知写goroutine
 }
 
-//flags 模式
+// GODIS_VERSION 服务端版本
+const GODIS_VERSION = "0.0.1"
+
+// 客户端读缓冲区的大小
+const PROTO_IOBUF_LEN = 1024 * 16
+
+// 已读取但尚未执行的命令的最大字节数 超过时关闭连接 与redis的client-query-buffer-limit默认值相同
+const PROTO_MAX_QUERYBUF_LEN = 1024 * 1024 * 1024
+
+// flags 模式
+const CLIENT_BLOCKED = (1 << 4)     /* The client is waiting in a blocking operation */
+const CLIENT_CLOSE_ASAP = (1 << 10) /* Close this client ASAP */
 const CLIENT_PUBSUB = (1 << 18)
 
+// 命令的标志
+const CMD_WRITE = (1 << 0) /* The command may modify the data set. */
+
 //GodisCommand redis命令结构
+//Arity 为参数个数(含命令名) 负数 -N 表示至少 N 个
 type GodisCommand struct {
-	Name string
-	Proc cmdFunc
+	Name  string
+	Proc  cmdFunc
+	Arity int
+	Flags int // CMD_*
 }
 
 //命令函数指针
@@ -41,7 +73,7 @@ type Server struct {
 	Db               []*GodisDb
 	DbNum            int
 	Start            int64
-	Port             int32
+	Port             int
 	RdbFilename      string
 	AofFilename      string
 	NextClientID     int32
@@ -50,53 +82,97 @@ type Server struct {
 	Pid              int
 	Commands         map[string]*GodisCommand
 	Dirty            int64
-	AofBuf           []string
+	AofBuf           []byte // 等待写入aof的命令 在beforeSleep中写入文件
 	PubSubChannels   *map[string]*List
 	PubSubPatterns   *List
-}
 
-//use map[string]* as type dict
-//使用Go原生数据结构map作为redis中dict结构体 暂不对dict造轮子
-type dict map[string]*GodisObject
+	HashMaxZiplistEntries int // 哈希使用紧凑编码的最大字段数
+	HashMaxZiplistValue   int // 哈希使用紧凑编码的字段/值最大长度
+	SetMaxIntsetEntries   int // 集合使用intset编码的最大成员数
 
-//GodisDb db结构体
-type GodisDb struct {
-	Dict    dict
-	Expires dict
-	ID      int32
-}
+	ConfigFile string   // 配置文件的绝对路径 没有配置文件时为空
+	Bindaddr   []string // 监听的地址
+	Logfile    string   // 日志文件 为空时输出到标准输出
+	Verbosity  int      // 日志级别 LL_*
 
-// SetCommand cmd of set
-func SetCommand(c *Client, s *Server) {
-	objKey := c.Argv[1]
-	objValue := c.Argv[2]
-	if c.Argc != 3 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'set' command")
-	}
-	if stringKey, ok1 := objKey.Ptr.(string); ok1 {
-		if stringValue, ok2 := objValue.Ptr.(string); ok2 {
-			c.Db.Dict[stringKey] = CreateObject(ObjectTypeString, stringValue)
-		}
-	}
-	s.Dirty++
-	addReplyStatus(c, "OK")
-}
+	Hz              int   // 每秒执行ServerCron的次数
+	Loading         bool  // 正在加载aof 此时不删除过期的key
+	StatExpiredKeys int64 // 过期删除的key的数量
+	AofSelectedDb   int   // aof中当前选择的db 用于判断是否需要写入SELECT
+	Cronloops       int   // ServerCron执行的次数
 
-// GetCommand get命令实现
-func GetCommand(c *Client, s *Server) {
-	o := lookupKey(c.Db, c.Argv[1])
-	if o != nil {
-		addReplyStatus(c, o.Ptr.(string))
-	} else {
-		addReplyStatus(c, "nil")
-	}
+	AofEnabled             bool     // 配置appendonly
+	AofState               int      // AOF_ON AOF_OFF或AOF_WAIT_REWRITE
+	AofFsync               int      // 配置appendfsync AOF_FSYNC_*
+	AofFd                  *os.File // 当前写入的INCR文件
+	AofDirname             string   // 配置appenddirname 保存aof文件和manifest的目录
+	AofUseRdbPreamble      bool     // 配置aof-use-rdb-preamble 重写时BASE文件使用rdb格式
+	AofCurrentSize         int64    // 所有BASE和INCR文件的总大小
+	AofLastIncrSize        int64    // 当前INCR文件的大小
+	AofFsyncOffset         int64    // 当前INCR文件中已经提交fsync的大小
+	AofLastFsync           int64    // 上一次fsync的时间 单位秒
+	AofFlushPostponedStart int64    // 因后台fsync未完成而推迟写入的开始时间
+	AofDelayedFsync        int64    // 等待后台fsync超时的次数
+	AofLastWriteStatus     int      // 上一次写入aof是否成功 C_OK或C_ERR
+	AofLastWriteErr        string   // 上一次写入aof的错误信息
+	AofNoFsyncOnRewrite    bool     // 配置no-appendfsync-on-rewrite 后台重写期间不fsync
+	AofLoadTruncated       bool     // 配置aof-load-truncated 加载时截断aof末尾不完整的命令
+	AofRewritePerc         int      // 配置auto-aof-rewrite-percentage 为0时不自动重写
+	AofRewriteMinSize      int      // 配置auto-aof-rewrite-min-size
+	AofRewriteBaseSize     int64    // 启动时BASE文件或上一次重写后aof的大小 用于计算增长的比例
+	AofRewriteScheduled    bool     // 等待其它后台任务结束后开始重写
+	AofRewriteTimeStart    int64    // 当前重写开始的时间 单位秒 没有重写时为-1
+	AofRewriteTimeLast     int64    // 上一次重写的耗时 单位秒
+	AofLastBgrewriteStatus int      // 上一次后台重写是否成功 C_OK或C_ERR
+	ChildType              int      // 正在执行的后台任务 CHILD_TYPE_*
+
+	SaveParams            []saveParam // 配置save 自动保存rdb的条件
+	RdbCompression        bool        // 配置rdbcompression 保存时压缩较长的字符串
+	RdbChecksum           bool        // 配置rdbchecksum 保存和加载时计算校验和
+	StopWritesOnBgsaveErr bool        // 配置stop-writes-on-bgsave-error
+	Lastsave              int64       // 上一次成功保存rdb的时间 单位秒
+	LastbgsaveTry         int64       // 上一次尝试后台保存的时间 单位秒
+	LastbgsaveStatus      int         // 上一次后台保存是否成功 C_OK或C_ERR
+	RdbBgsaveScheduled    bool        // 等待其它后台任务结束后开始BGSAVE
+	DirtyBeforeBgsave     int64       // 开始后台保存时的Dirty 保存成功后从Dirty中减去
+	RdbSaveTimeStart      int64       // 当前后台保存开始的时间 单位秒 没有保存时为-1
+	RdbSaveTimeLast       int64       // 上一次后台保存的耗时 单位秒
+
+	childDone        chan error    // 后台任务完成时发送结果
+	childKill        chan struct{} // 关闭时后台任务放弃保存并尽快结束
+	snapshotID       uint64        // 最近一次为后台任务创建的快照的编号
+	saveParamsLoaded bool          // 配置文件中已经出现过save 之后的save追加保存条件
+
+	aofManifest          *aofManifest // 组成aof的所有文件
+	aofLastWriteErrorLog int64        // 上一次记录写入错误日志的时间 限制日志的频率
+	aofBioFsyncStatus    int32        // 后台fsync的结果 原子读写
+	aofBioFsyncErr       atomic.Value // 后台fsync的错误信息
+	bioJobs              [BIO_WORKER_NUM]chan *bioJob
+	bioPending           [BIO_NUM_OPS]int64 // 尚未完成的后台任务数 原子读写
+
+	events chan *aeEvent // 事件循环的任务队列
+
+	ClientObufLimits [CLIENT_TYPE_OBUF_COUNT]ClientBufferLimitsConfig // 各类客户端的输出缓冲区限制
 }
 
-// addReply 添加回复
-func addReply(c *Client, o *GodisObject) {
-	c.Buf = o.Ptr.(string)
+//GodisDb db结构体
+type GodisDb struct {
+	Dict         *dict
+	Expires      *dict
+	BlockingKeys map[string]*List    // 阻塞在key上的客户端
+	ReadyKeys    map[string]struct{} // 有客户端阻塞且收到了新数据的key
+	ID           int32
+	server       *Server // 所属的服务端实例
 }
 
+// 通用错误回复
+const (
+	errWrongType  = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	errSyntax     = "ERR syntax error"
+	errNotInteger = "ERR value is not an integer or out of range"
+	errNotFloat   = "ERR value is not a valid float"
+)
+
 func addReplyStatus(c *Client, s string) {
 	r := proto.NewString([]byte(s))
 	addReplyString(c, r)
@@ -105,10 +181,78 @@ func addReplyError(c *Client, s string) {
 	r := proto.NewError([]byte(s))
 	addReplyString(c, r)
 }
+
+// addReplyString 将回复加入客户端的输出缓冲区 由写goroutine按顺序发送
+// 回复先转换为客户端协商的协议版本
 func addReplyString(c *Client, r *proto.Resp) {
-	if ret, err := proto.EncodeToBytes(r); err == nil {
-		c.Buf = string(ret)
+	if prepareClientToWrite(c) != C_OK {
+		return
+	}
+	_addReplyProtoToList(c, convertReplyProto(c, r))
+}
+
+// addReplyLongLong 整数回复
+func addReplyLongLong(c *Client, n int64) {
+	addReplyString(c, proto.NewInt([]byte(strconv.FormatInt(n, 10))))
+}
+
+// addReplyBulk 批量回复
+func addReplyBulk(c *Client, s string) {
+	addReplyString(c, bulkString(s))
+}
+
+// addReplyNull 空批量回复 即 $-1
+func addReplyNull(c *Client) {
+	addReplyString(c, proto.NewBulkBytes(nil))
+}
+
+// addReplyDouble 浮点数回复 RESP2下以批量回复的形式返回
+func addReplyDouble(c *Client, f float64) {
+	addReplyString(c, doubleValue(f))
+}
+
+// addReplyNullArray 空多条批量回复 即 *-1
+func addReplyNullArray(c *Client) {
+	addReplyString(c, proto.NewArray(nil))
+}
+
+// addReplyArray 多条批量回复
+func addReplyArray(c *Client, items []*proto.Resp) {
+	if items == nil {
+		items = []*proto.Resp{}
+	}
+	addReplyString(c, proto.NewArray(items))
+}
+
+// addReplyMap map回复 items中键值交替存放 RESP2下为多条批量回复
+func addReplyMap(c *Client, items []*proto.Resp) {
+	if items == nil {
+		items = []*proto.Resp{}
+	}
+	addReplyString(c, proto.NewMap(items))
+}
+
+// addReplySet 集合回复 RESP2下为多条批量回复
+func addReplySet(c *Client, items []*proto.Resp) {
+	if items == nil {
+		items = []*proto.Resp{}
 	}
+	addReplyString(c, proto.NewSet(items))
+}
+
+// addReplyPush 推送给客户端的数据 如pub/sub的消息 RESP2下为多条批量回复
+func addReplyPush(c *Client, items []*proto.Resp) {
+	addReplyString(c, proto.NewPush(items))
+}
+
+// doubleValue 构造一个浮点数回复项
+func doubleValue(f float64) *proto.Resp {
+	return proto.NewDouble([]byte(formatDouble(f)))
+}
+
+// bulkString 构造一个批量回复项
+func bulkString(s string) *proto.Resp {
+	return proto.NewBulkBytes([]byte(s))
 }
 
 // ProcessCommand 执行命令
@@ -120,18 +264,177 @@ func (s *Server) ProcessCommand(c *Client) {
 		os.Exit(1)
 	}
 	cmd := lookupCommand(name, s)
-	fmt.Println(cmd, name, s)
-	if cmd != nil {
-		c.Cmd = cmd
-		call(c, s)
-	} else {
-		addReplyError(c, fmt.Sprintf("(error) ERR unknown command '%s'", name))
+	if cmd == nil {
+		args := ""
+		for _, arg := range c.Argv[1:] {
+			args += fmt.Sprintf("'%v' ", arg.Ptr)
+		}
+		addReplyError(c, fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", name, args))
+		return
+	}
+	if (cmd.Arity > 0 && cmd.Arity != c.Argc) || c.Argc < -cmd.Arity {
+		addReplyError(c, fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd.Name))
+		return
+	}
+
+	/* Don't accept write commands if there are problems persisting on disk. */
+	if cmd.Flags&CMD_WRITE != 0 {
+		if denyWriteType := writeCommandsDeniedByDiskError(s); denyWriteType != DISK_ERROR_TYPE_NONE {
+			addReplyError(c, writeCommandsGetDiskErrorMessage(s, denyWriteType))
+			return
+		}
+	}
+
+	c.Cmd = cmd
+	call(c, s)
+	if !c.FakeFlag {
+		handleClientsBlockedOnKeys(s)
 	}
 }
 
-// lookupCommand查找命令
+// PopulateCommandTable 初始化服务端的命令表
+func (s *Server) PopulateCommandTable() {
+	s.Commands = map[string]*GodisCommand{
+		"get":               {Name: "get", Proc: GetCommand, Arity: 2},
+		"set":               {Name: "set", Proc: SetCommand, Arity: -3, Flags: CMD_WRITE},
+		"setnx":             {Name: "setnx", Proc: SetNXCommand, Arity: 3, Flags: CMD_WRITE},
+		"setex":             {Name: "setex", Proc: SetEXCommand, Arity: 4, Flags: CMD_WRITE},
+		"psetex":            {Name: "psetex", Proc: PSetEXCommand, Arity: 4, Flags: CMD_WRITE},
+		"getset":            {Name: "getset", Proc: GetSetCommand, Arity: 3, Flags: CMD_WRITE},
+		"setrange":          {Name: "setrange", Proc: SetRangeCommand, Arity: 4, Flags: CMD_WRITE},
+		"getrange":          {Name: "getrange", Proc: GetRangeCommand, Arity: 4},
+		"mget":              {Name: "mget", Proc: MGetCommand, Arity: -2},
+		"mset":              {Name: "mset", Proc: MSetCommand, Arity: -3, Flags: CMD_WRITE},
+		"msetnx":            {Name: "msetnx", Proc: MSetNXCommand, Arity: -3, Flags: CMD_WRITE},
+		"incr":              {Name: "incr", Proc: IncrCommand, Arity: 2, Flags: CMD_WRITE},
+		"decr":              {Name: "decr", Proc: DecrCommand, Arity: 2, Flags: CMD_WRITE},
+		"incrby":            {Name: "incrby", Proc: IncrByCommand, Arity: 3, Flags: CMD_WRITE},
+		"decrby":            {Name: "decrby", Proc: DecrByCommand, Arity: 3, Flags: CMD_WRITE},
+		"incrbyfloat":       {Name: "incrbyfloat", Proc: IncrByFloatCommand, Arity: 3, Flags: CMD_WRITE},
+		"append":            {Name: "append", Proc: AppendCommand, Arity: 3, Flags: CMD_WRITE},
+		"strlen":            {Name: "strlen", Proc: StrLenCommand, Arity: 2},
+		"del":               {Name: "del", Proc: DelCommand, Arity: -2, Flags: CMD_WRITE},
+		"expire":            {Name: "expire", Proc: ExpireCommand, Arity: -3, Flags: CMD_WRITE},
+		"pexpire":           {Name: "pexpire", Proc: PExpireCommand, Arity: -3, Flags: CMD_WRITE},
+		"expireat":          {Name: "expireat", Proc: ExpireAtCommand, Arity: -3, Flags: CMD_WRITE},
+		"pexpireat":         {Name: "pexpireat", Proc: PExpireAtCommand, Arity: -3, Flags: CMD_WRITE},
+		"ttl":               {Name: "ttl", Proc: TTLCommand, Arity: 2},
+		"pttl":              {Name: "pttl", Proc: PTTLCommand, Arity: 2},
+		"expiretime":        {Name: "expiretime", Proc: ExpireTimeCommand, Arity: 2},
+		"pexpiretime":       {Name: "pexpiretime", Proc: PExpireTimeCommand, Arity: 2},
+		"persist":           {Name: "persist", Proc: PersistCommand, Arity: 2, Flags: CMD_WRITE},
+		"exists":            {Name: "exists", Proc: ExistsCommand, Arity: -2},
+		"type":              {Name: "type", Proc: TypeCommand, Arity: 2},
+		"rename":            {Name: "rename", Proc: RenameCommand, Arity: 3, Flags: CMD_WRITE},
+		"renamenx":          {Name: "renamenx", Proc: RenameNXCommand, Arity: 3, Flags: CMD_WRITE},
+		"keys":              {Name: "keys", Proc: KeysCommand, Arity: 2},
+		"scan":              {Name: "scan", Proc: ScanCommand, Arity: -2},
+		"randomkey":         {Name: "randomkey", Proc: RandomKeyCommand, Arity: 1},
+		"dbsize":            {Name: "dbsize", Proc: DbSizeCommand, Arity: 1},
+		"flushdb":           {Name: "flushdb", Proc: FlushDbCommand, Arity: -1, Flags: CMD_WRITE},
+		"flushall":          {Name: "flushall", Proc: FlushAllCommand, Arity: -1, Flags: CMD_WRITE},
+		"select":            {Name: "select", Proc: SelectCommand, Arity: 2},
+		"move":              {Name: "move", Proc: MoveCommand, Arity: 3, Flags: CMD_WRITE},
+		"copy":              {Name: "copy", Proc: CopyCommand, Arity: -3, Flags: CMD_WRITE},
+		"swapdb":            {Name: "swapdb", Proc: SwapDbCommand, Arity: 3, Flags: CMD_WRITE},
+		"geoadd":            {Name: "geoadd", Proc: GeoAddCommand, Arity: -5, Flags: CMD_WRITE},
+		"geohash":           {Name: "geohash", Proc: GeoHashCommand, Arity: -2},
+		"geopos":            {Name: "geopos", Proc: GeoPosCommand, Arity: -2},
+		"geodist":           {Name: "geodist", Proc: GeoDistCommand, Arity: -4},
+		"georadius":         {Name: "georadius", Proc: GeoRadiusCommand, Arity: -6, Flags: CMD_WRITE},
+		"georadiusbymember": {Name: "georadiusbymember", Proc: GeoRadiusByMemberCommand, Arity: -5, Flags: CMD_WRITE},
+		"subscribe":         {Name: "subscribe", Proc: SubscribeCommand, Arity: -2},
+		"publish":           {Name: "publish", Proc: PublishCommand, Arity: 3},
+		"hello":             {Name: "hello", Proc: HelloCommand, Arity: -1},
+		"config":            {Name: "config", Proc: ConfigCommand, Arity: -2},
+		"bgrewriteaof":      {Name: "bgrewriteaof", Proc: BgrewriteaofCommand, Arity: 1},
+		"save":              {Name: "save", Proc: SaveCommand, Arity: 1},
+		"bgsave":            {Name: "bgsave", Proc: BgsaveCommand, Arity: -1},
+		"lastsave":          {Name: "lastsave", Proc: LastsaveCommand, Arity: 1},
+		"zadd":              {Name: "zadd", Proc: ZAddCommand, Arity: -4, Flags: CMD_WRITE},
+		"zincrby":           {Name: "zincrby", Proc: ZIncrByCommand, Arity: 4, Flags: CMD_WRITE},
+		"zscore":            {Name: "zscore", Proc: ZScoreCommand, Arity: 3},
+		"zcard":             {Name: "zcard", Proc: ZCardCommand, Arity: 2},
+		"zrank":             {Name: "zrank", Proc: ZRankCommand, Arity: 3},
+		"zrevrank":          {Name: "zrevrank", Proc: ZRevRankCommand, Arity: 3},
+		"zrange":            {Name: "zrange", Proc: ZRangeCommand, Arity: -4},
+		"zrevrange":         {Name: "zrevrange", Proc: ZRevRangeCommand, Arity: -4},
+		"zrangebyscore":     {Name: "zrangebyscore", Proc: ZRangeByScoreCommand, Arity: -4},
+		"zrevrangebyscore":  {Name: "zrevrangebyscore", Proc: ZRevRangeByScoreCommand, Arity: -4},
+		"zrem":              {Name: "zrem", Proc: ZRemCommand, Arity: -3, Flags: CMD_WRITE},
+		"zremrangebyscore":  {Name: "zremrangebyscore", Proc: ZRemRangeByScoreCommand, Arity: 4, Flags: CMD_WRITE},
+		"zremrangebyrank":   {Name: "zremrangebyrank", Proc: ZRemRangeByRankCommand, Arity: 4, Flags: CMD_WRITE},
+		"zremrangebylex":    {Name: "zremrangebylex", Proc: ZRemRangeByLexCommand, Arity: 4, Flags: CMD_WRITE},
+		"zrangebylex":       {Name: "zrangebylex", Proc: ZRangeByLexCommand, Arity: -4},
+		"zrevrangebylex":    {Name: "zrevrangebylex", Proc: ZRevRangeByLexCommand, Arity: -4},
+		"zlexcount":         {Name: "zlexcount", Proc: ZLexCountCommand, Arity: 4},
+		"zunionstore":       {Name: "zunionstore", Proc: ZUnionStoreCommand, Arity: -4, Flags: CMD_WRITE},
+		"zinterstore":       {Name: "zinterstore", Proc: ZInterStoreCommand, Arity: -4, Flags: CMD_WRITE},
+		"zdiffstore":        {Name: "zdiffstore", Proc: ZDiffStoreCommand, Arity: -4, Flags: CMD_WRITE},
+		"zunion":            {Name: "zunion", Proc: ZUnionCommand, Arity: -3},
+		"zinter":            {Name: "zinter", Proc: ZInterCommand, Arity: -3},
+		"zdiff":             {Name: "zdiff", Proc: ZDiffCommand, Arity: -3},
+		"zpopmin":           {Name: "zpopmin", Proc: ZPopMinCommand, Arity: -2, Flags: CMD_WRITE},
+		"zpopmax":           {Name: "zpopmax", Proc: ZPopMaxCommand, Arity: -2, Flags: CMD_WRITE},
+		"bzpopmin":          {Name: "bzpopmin", Proc: BZPopMinCommand, Arity: -3, Flags: CMD_WRITE},
+		"bzpopmax":          {Name: "bzpopmax", Proc: BZPopMaxCommand, Arity: -3, Flags: CMD_WRITE},
+		"lpush":             {Name: "lpush", Proc: LPushCommand, Arity: -3, Flags: CMD_WRITE},
+		"rpush":             {Name: "rpush", Proc: RPushCommand, Arity: -3, Flags: CMD_WRITE},
+		"lpushx":            {Name: "lpushx", Proc: LPushXCommand, Arity: -3, Flags: CMD_WRITE},
+		"rpushx":            {Name: "rpushx", Proc: RPushXCommand, Arity: -3, Flags: CMD_WRITE},
+		"linsert":           {Name: "linsert", Proc: LInsertCommand, Arity: 5, Flags: CMD_WRITE},
+		"lpop":              {Name: "lpop", Proc: LPopCommand, Arity: -2, Flags: CMD_WRITE},
+		"rpop":              {Name: "rpop", Proc: RPopCommand, Arity: -2, Flags: CMD_WRITE},
+		"llen":              {Name: "llen", Proc: LLenCommand, Arity: 2},
+		"lindex":            {Name: "lindex", Proc: LIndexCommand, Arity: 3},
+		"lset":              {Name: "lset", Proc: LSetCommand, Arity: 4, Flags: CMD_WRITE},
+		"lrange":            {Name: "lrange", Proc: LRangeCommand, Arity: 4},
+		"ltrim":             {Name: "ltrim", Proc: LTrimCommand, Arity: 4, Flags: CMD_WRITE},
+		"lpos":              {Name: "lpos", Proc: LPosCommand, Arity: -3},
+		"lrem":              {Name: "lrem", Proc: LRemCommand, Arity: 4, Flags: CMD_WRITE},
+		"rpoplpush":         {Name: "rpoplpush", Proc: RPopLPushCommand, Arity: 3, Flags: CMD_WRITE},
+		"lmove":             {Name: "lmove", Proc: LMoveCommand, Arity: 5, Flags: CMD_WRITE},
+		"blpop":             {Name: "blpop", Proc: BLPopCommand, Arity: -3, Flags: CMD_WRITE},
+		"brpop":             {Name: "brpop", Proc: BRPopCommand, Arity: -3, Flags: CMD_WRITE},
+		"brpoplpush":        {Name: "brpoplpush", Proc: BRPopLPushCommand, Arity: 4, Flags: CMD_WRITE},
+		"blmove":            {Name: "blmove", Proc: BLMoveCommand, Arity: 6, Flags: CMD_WRITE},
+		"hset":              {Name: "hset", Proc: HSetCommand, Arity: -4, Flags: CMD_WRITE},
+		"hsetnx":            {Name: "hsetnx", Proc: HSetNXCommand, Arity: 4, Flags: CMD_WRITE},
+		"hget":              {Name: "hget", Proc: HGetCommand, Arity: 3},
+		"hmset":             {Name: "hmset", Proc: HMSetCommand, Arity: -4, Flags: CMD_WRITE},
+		"hmget":             {Name: "hmget", Proc: HMGetCommand, Arity: -3},
+		"hdel":              {Name: "hdel", Proc: HDelCommand, Arity: -3, Flags: CMD_WRITE},
+		"hlen":              {Name: "hlen", Proc: HLenCommand, Arity: 2},
+		"hstrlen":           {Name: "hstrlen", Proc: HStrLenCommand, Arity: 3},
+		"hexists":           {Name: "hexists", Proc: HExistsCommand, Arity: 3},
+		"hincrby":           {Name: "hincrby", Proc: HIncrByCommand, Arity: 4, Flags: CMD_WRITE},
+		"hincrbyfloat":      {Name: "hincrbyfloat", Proc: HIncrByFloatCommand, Arity: 4, Flags: CMD_WRITE},
+		"hkeys":             {Name: "hkeys", Proc: HKeysCommand, Arity: 2},
+		"hvals":             {Name: "hvals", Proc: HValsCommand, Arity: 2},
+		"hgetall":           {Name: "hgetall", Proc: HGetAllCommand, Arity: 2},
+		"hrandfield":        {Name: "hrandfield", Proc: HRandFieldCommand, Arity: -2},
+		"hscan":             {Name: "hscan", Proc: HScanCommand, Arity: -3},
+		"sadd":              {Name: "sadd", Proc: SAddCommand, Arity: -3, Flags: CMD_WRITE},
+		"srem":              {Name: "srem", Proc: SRemCommand, Arity: -3, Flags: CMD_WRITE},
+		"smove":             {Name: "smove", Proc: SMoveCommand, Arity: 4, Flags: CMD_WRITE},
+		"sismember":         {Name: "sismember", Proc: SIsMemberCommand, Arity: 3},
+		"smismember":        {Name: "smismember", Proc: SMIsMemberCommand, Arity: -3},
+		"scard":             {Name: "scard", Proc: SCardCommand, Arity: 2},
+		"spop":              {Name: "spop", Proc: SPopCommand, Arity: -2, Flags: CMD_WRITE},
+		"srandmember":       {Name: "srandmember", Proc: SRandMemberCommand, Arity: -2},
+		"smembers":          {Name: "smembers", Proc: SMembersCommand, Arity: 2},
+		"sinter":            {Name: "sinter", Proc: SInterCommand, Arity: -2},
+		"sinterstore":       {Name: "sinterstore", Proc: SInterStoreCommand, Arity: -3, Flags: CMD_WRITE},
+		"sunion":            {Name: "sunion", Proc: SUnionCommand, Arity: -2},
+		"sunionstore":       {Name: "sunionstore", Proc: SUnionStoreCommand, Arity: -3, Flags: CMD_WRITE},
+		"sdiff":             {Name: "sdiff", Proc: SDiffCommand, Arity: -2},
+		"sdiffstore":        {Name: "sdiffstore", Proc: SDiffStoreCommand, Arity: -3, Flags: CMD_WRITE},
+	}
+}
+
+// lookupCommand查找命令 命令名不区分大小写
 func lookupCommand(name string, s *Server) *GodisCommand {
-	if cmd, ok := s.Commands[name]; ok {
+	if cmd, ok := s.Commands[strings.ToLower(name)]; ok {
 		return cmd
 	}
 	return nil
@@ -143,54 +446,383 @@ func call(c *Client, s *Server) {
 	c.Cmd.Proc(c, s)
 	dirty = s.Dirty - dirty
 	if dirty > 0 && !c.FakeFlag {
-		AppendToFile(s.AofFilename, c.QueryBuf)
+		feedAppendOnlyFile(s, int(c.Db.ID), c.Argv)
 	}
 
 }
+
+// rewriteClientCommandVector 改写客户端的命令参数 用于以确定性的形式写入aof
+func rewriteClientCommandVector(c *Client, argv ...*GodisObject) {
+	c.Argv = argv
+	c.Argc = len(argv)
+}
 func lookupKey(db *GodisDb, key *GodisObject) (ret *GodisObject) {
-	if o, ok := db.Dict[key.Ptr.(string)]; ok {
-		return o
+	expireIfNeeded(db, key)
+	return db.Dict.dictFetchValue(key.Ptr.(string))
+}
+
+/* Lookup a key for write operations. The background save and AOF rewrite
+ * read the values of their snapshot while the event loop goes on: if the
+ * value is shared with the snapshot it is duplicated before the caller
+ * modifies it, like the pages of the forked child are copied on write
+ * in Redis. */
+func lookupKeyWrite(db *GodisDb, key *GodisObject) *GodisObject {
+	o := lookupKey(db, key)
+	if o != nil && objectIsShared(db.server, o) {
+		o = dupObject(o)
+		dbOverwrite(db, key, o)
 	}
-	return nil
+	return o
+}
+
+// dbAdd 向db中添加一个key 调用方需保证key不存在
+// 列表和有序集等可阻塞的类型会通知阻塞在该key上的客户端
+func dbAdd(db *GodisDb, key *GodisObject, val *GodisObject) {
+	db.Dict.dictAdd(key.Ptr.(string), val)
+	if val.ObjectType == OBJ_LIST || val.ObjectType == OBJ_ZSET {
+		signalKeyAsReady(db, key)
+	}
+}
+
+// dbOverwrite 覆盖已存在的key的值
+func dbOverwrite(db *GodisDb, key *GodisObject, val *GodisObject) {
+	db.Dict.dictReplace(key.Ptr.(string), val)
+}
+
+// setKey 设置key的值 不存在时添加 存在时覆盖
+// 除非keepttl为true 否则会清除key原有的过期时间
+func setKey(db *GodisDb, key *GodisObject, val *GodisObject, keepttl bool) {
+	if lookupKey(db, key) == nil {
+		dbAdd(db, key, val)
+	} else {
+		dbOverwrite(db, key, val)
+	}
+	if !keepttl {
+		removeExpire(db, key)
+	}
+}
+
+// dbDelete 从db中删除key 返回key是否存在
+func dbDelete(db *GodisDb, key *GodisObject) bool {
+	k := key.Ptr.(string)
+	db.Expires.dictDelete(k)
+	return db.Dict.dictDelete(k)
+}
+
+// checkType 检查对象类型 类型不符时回复WRONGTYPE错误并返回true
+func checkType(c *Client, o *GodisObject, t int) bool {
+	if o.ObjectType != t {
+		addReplyError(c, errWrongType)
+		return true
+	}
+	return false
+}
+
+// CreateDb 创建编号为id的db
+func (s *Server) CreateDb(id int) *GodisDb {
+	db := new(GodisDb)
+	db.Dict = dictCreate()
+	db.Expires = dictCreate()
+	db.BlockingKeys = make(map[string]*List)
+	db.ReadyKeys = make(map[string]struct{})
+	db.ID = int32(id)
+	db.server = s
+	return db
+}
+
+// ServerCron 定时任务 每秒执行server.hz次
+func (s *Server) ServerCron() {
+	/* Handle background operations on Godis databases. */
+	databasesCron(s)
+
+	/* Start a scheduled AOF rewrite if this was requested by the user while
+	 * another background operation was in progress. */
+	if !hasActiveChildProcess(s) && s.AofRewriteScheduled {
+		rewriteAppendOnlyFileBackground(s)
+	}
+
+	/* Check if a background saving or AOF rewrite in progress terminated. */
+	if hasActiveChildProcess(s) {
+		checkChildrenDone(s)
+	} else {
+		/* If there is not a background saving/rewrite in progress check if
+		 * we have to save/rewrite now. */
+		now := time.Now().Unix()
+		for _, sp := range s.SaveParams {
+			/* Save if we reached the given amount of changes,
+			 * the given amount of seconds, and if the latest bgsave was
+			 * successful or if, in case of an error, at least
+			 * CONFIG_BGSAVE_RETRY_DELAY seconds already elapsed. */
+			if s.Dirty >= sp.changes && now-s.Lastsave > sp.seconds &&
+				(now-s.LastbgsaveTry > CONFIG_BGSAVE_RETRY_DELAY || s.LastbgsaveStatus == C_OK) {
+				serverLog(s, LL_NOTICE, "%d changes in %d seconds. Saving...", sp.changes, sp.seconds)
+				rdbSaveBackground(s, s.RdbFilename)
+				break
+			}
+		}
+
+		/* Trigger an AOF rewrite if needed. */
+		if !hasActiveChildProcess(s) && s.AofState == AOF_ON && s.AofRewritePerc != 0 &&
+			s.AofCurrentSize > int64(s.AofRewriteMinSize) {
+			base := s.AofRewriteBaseSize
+			if base == 0 {
+				base = 1
+			}
+			growth := (s.AofCurrentSize * 100 / base) - 100
+			if growth >= int64(s.AofRewritePerc) {
+				serverLog(s, LL_NOTICE, "Starting automatic rewriting of AOF on %d%% growth", growth)
+				rewriteAppendOnlyFileBackground(s)
+			}
+		}
+	}
+
+	/* AOF: we may have postponed buffer flush, or were not able to
+	 * write our buffer because of write(2) error. Try again here. */
+	if (s.AofState == AOF_ON || s.AofState == AOF_WAIT_REWRITE) && s.AofFlushPostponedStart != 0 {
+		flushAppendOnlyFile(s, false)
+	}
+
+	/* AOF write errors: in this case we have a buffer to flush as well and
+	 * clear the AOF error in case of success to make the DB writable again,
+	 * however to try every second is enough in case of 'hz' is set to
+	 * a higher frequency. */
+	if runWithPeriod(s, 1000) {
+		if (s.AofState == AOF_ON || s.AofState == AOF_WAIT_REWRITE) && s.AofLastWriteStatus == C_ERR {
+			flushAppendOnlyFile(s, false)
+		}
+	}
+
+	/* Start a scheduled BGSAVE if the corresponding flag is set. This is
+	 * useful when we are forced to postpone a BGSAVE because an AOF
+	 * rewrite is in progress.
+	 *
+	 * Note: this code must be after the save points check above, so that
+	 * when the latter triggers a BGSAVE the scheduled one is not started
+	 * twice. */
+	if !hasActiveChildProcess(s) && s.RdbBgsaveScheduled {
+		now := time.Now().Unix()
+		if now-s.LastbgsaveTry > CONFIG_BGSAVE_RETRY_DELAY || s.LastbgsaveStatus == C_OK {
+			if rdbSaveBackground(s, s.RdbFilename) == C_OK {
+				s.RdbBgsaveScheduled = false
+			}
+		}
+	}
+
+	s.Cronloops++
+}
+
+// 后台任务的类型 对应redis中fork出的子进程
+// godis中由goroutine完成 同一时间只能有一个后台任务
+const CHILD_TYPE_NONE = 0
+const CHILD_TYPE_RDB = 1
+const CHILD_TYPE_AOF = 2
+
+/* Return true if there are active children processes doing RDB saving,
+ * AOF rewriting, or some side process spawned by a loaded module. */
+func hasActiveChildProcess(s *Server) bool {
+	return s.ChildType != CHILD_TYPE_NONE
+}
+
+// resetChildState 后台任务结束后清除其状态
+func resetChildState(s *Server) {
+	s.ChildType = CHILD_TYPE_NONE
+	s.childDone = nil
+	s.childKill = nil
+}
+
+/* Check if the background goroutine terminated its work, calling the
+ * done handler of its type if so. */
+func checkChildrenDone(s *Server) {
+	select {
+	case err := <-s.childDone:
+		if s.ChildType == CHILD_TYPE_RDB {
+			backgroundSaveDoneHandler(s, err)
+		} else if s.ChildType == CHILD_TYPE_AOF {
+			backgroundRewriteDoneHandler(s, err)
+		}
+		resetChildState(s)
+	default:
+	}
+}
+
+// runWithPeriod 每ms毫秒返回一次true 用于ServerCron中低于hz频率执行的任务
+func runWithPeriod(s *Server, ms int) bool {
+	return ms <= 1000/s.Hz || s.Cronloops%(ms/(1000/s.Hz)) == 0
+}
+
+/* This function gets called every time the event loop processed an event,
+ * before the replies are taken by the write goroutines of the clients.
+ * Writing the AOF here guarantees that a client receives the reply of a
+ * write command only after the command reached the AOF. */
+func (s *Server) beforeSleep() {
+	/* Write the AOF buffer on disk. While waiting for the rewrite that turns
+	 * the AOF on, the commands go to a temporary INCR file. */
+	if s.AofState == AOF_ON || s.AofState == AOF_WAIT_REWRITE {
+		flushAppendOnlyFile(s, false)
+	}
+}
+
+// PrepareForShutdown 退出前将aof缓冲区写入文件并fsync 配置了save时保存rdb
+// 返回C_ERR时不能退出
+func (s *Server) PrepareForShutdown() int {
+	serverLog(s, LL_WARNING, "User requested shutdown...")
+
+	/* Kill the saving child if there is a background saving in progress.
+	 * We want to avoid race conditions, for instance our saving child may
+	 * overwrite the synchronous saving did by SHUTDOWN. */
+	if s.ChildType == CHILD_TYPE_RDB {
+		serverLog(s, LL_WARNING, "There is a child saving an .rdb. Killing it!")
+		killRDBChild(s)
+	}
+
+	/* Kill the AOF saving child as the AOF we already have may be longer
+	 * but contains the full dataset anyway. */
+	if s.ChildType == CHILD_TYPE_AOF {
+		/* If we have AOF enabled but haven't written the AOF yet, don't
+		 * shutdown or else the dataset will be lost. */
+		if s.AofState == AOF_WAIT_REWRITE {
+			serverLog(s, LL_WARNING, "Writing initial AOF, can't exit.")
+			return C_ERR
+		}
+		serverLog(s, LL_WARNING, "There is a child rewriting the AOF. Killing it!")
+		killAppendOnlyChild(s)
+	}
+
+	if s.AofState != AOF_OFF {
+		/* Append only file: flush buffers and fsync() the AOF at exit */
+		serverLog(s, LL_NOTICE, "Calling fsync() on the AOF file.")
+		flushAppendOnlyFile(s, true)
+		if err := s.AofFd.Sync(); err != nil {
+			serverLog(s, LL_WARNING, "Fail to fsync the AOF file: %s.", strerror(err))
+		}
+	}
+
+	/* Create a new RDB file before exiting. */
+	if len(s.SaveParams) > 0 {
+		serverLog(s, LL_NOTICE, "Saving the final RDB snapshot before exiting.")
+		/* Snapshotting. Perform a SYNC SAVE and exit */
+		if rdbSave(s, s.RdbFilename) != C_OK {
+			/* Ooops.. error saving! The best we can do is to continue
+			 * operating. */
+			serverLog(s, LL_WARNING, "Error trying to save the DB, can't exit.")
+			return C_ERR
+		}
+	}
+	serverLog(s, LL_WARNING, "Godis is now ready to exit, bye bye...")
+	return C_OK
 }
 
 // CreateClient 连接建立 创建client记录当前连接
-func (s *Server) CreateClient() (c *Client) {
+// conn为客户端请求的来源 加载aof的伪客户端从aof中读取
+func (s *Server) CreateClient(conn io.Reader) (c *Client) {
 	c = new(Client)
+	s.NextClientID++
+	c.ID = int64(s.NextClientID)
+	c.Resp = 2
 	c.Db = s.Db[0]
-	c.QueryBuf = ""
+	c.decoder = proto.NewDecoderSize(conn, PROTO_IOBUF_LEN)
+	if closer, ok := conn.(io.Closer); ok {
+		c.conn = closer
+	}
 	tmp := make(map[string]*List, 0)
 	c.PubSubChannels = &tmp
 	c.Flags = 0
+	c.unblocked = make(chan struct{}, 1)
+	c.closed = make(chan struct{})
+	c.server = s
+	c.pending = make(chan struct{}, 1)
 	return c
 }
 
-// ReadQueryFromClient 读取客户端请求信息
-func (c *Client) ReadQueryFromClient(conn net.Conn) (err error) {
-	buff := make([]byte, 512)
-	n, err := conn.Read(buff)
+// FreeClient 连接断开时释放客户端 解除其阻塞状态并退订所有频道
+// 已经在输出缓冲区中的回复仍会发送 之后写goroutine退出
+func (s *Server) FreeClient(c *Client) {
+	if c.Flags&CLIENT_BLOCKED != 0 {
+		unblockClient(c)
+	}
+	pubsubUnsubscribeAllChannels(c, s)
+	c.Flags |= CLIENT_CLOSE_ASAP
+	clientInstallWriteHandler(c)
+}
 
-	if err != nil {
-		log.Println("conn.Read err!=nil", err, "---len---", n, conn)
-		conn.Close()
-		return err
+// ReadQueryFromClient 从连接中解析出一条完整的命令 数据不足时阻塞等待
+// decoder在两次调用之间保留未解析的数据 因此请求可以任意大 也可以一次发送多条(pipeline)
+func (c *Client) ReadQueryFromClient() ([]*GodisObject, error) {
+	for {
+		multi, err := c.decoder.DecodeMultiBulk()
+		if err != nil {
+			return nil, err
+		}
+		/* Ignore empty inline queries. */
+		if len(multi) == 0 {
+			continue
+		}
+		argv := make([]*GodisObject, len(multi))
+		for k, r := range multi {
+			argv[k] = CreateObject(ObjectTypeString, string(r.Value))
+		}
+		return argv, nil
 	}
-	c.QueryBuf = string(buff)
-	return nil
 }
 
-// ProcessInputBuffer 处理客户端请求信息
-func (c *Client) ProcessInputBuffer() error {
-	//r := regexp.MustCompile("[^\\s]+")
-	decoder := proto.NewDecoder(bytes.NewReader([]byte(c.QueryBuf)))
-	//decoder := proto.NewDecoder(bytes.NewReader([]byte("*2\r\n$3\r\nget\r\n")))
-	if resp, err := decoder.DecodeMultiBulk(); err == nil {
-		c.Argc = len(resp)
-		c.Argv = make([]*GodisObject, c.Argc)
-		for k, s := range resp {
-			c.Argv[k] = CreateObject(ObjectTypeString, string(s.Value))
+// ReadQueries 启动一个goroutine不断读取客户端的命令 按到达的顺序发送到返回的channel
+// 上一条命令还没有被取走时(例如客户端阻塞在BLPOP)继续读取并缓存之后的命令 这样连接断开能被及时发现
+// 连接断开或出现协议错误时立即关闭closed唤醒阻塞中的客户端 已读取的命令发送完之后关闭channel
+func (c *Client) ReadQueries() <-chan []*GodisObject {
+	read := make(chan []*GodisObject)
+	go func() {
+		defer close(read)
+		for {
+			argv, err := c.ReadQueryFromClient()
+			if err != nil {
+				log.Println("readQueryFromClient err", err)
+				return
+			}
+			read <- argv
 		}
-		return nil
+	}()
+
+	queries := make(chan []*GodisObject)
+	go func() {
+		defer close(queries)
+		var pending [][]*GodisObject
+		var pendingBytes int64
+		for read != nil || len(pending) > 0 {
+			// 没有缓存的命令时out为nil 只等待读取
+			var out chan []*GodisObject
+			var next []*GodisObject
+			if len(pending) > 0 {
+				out = queries
+				next = pending[0]
+			}
+			select {
+			case argv, ok := <-read:
+				if !ok {
+					close(c.closed)
+					read = nil
+					continue
+				}
+				pending = append(pending, argv)
+				pendingBytes += argvLen(argv)
+				if pendingBytes > PROTO_MAX_QUERYBUF_LEN && c.conn != nil {
+					log.Println("Closing client that reached max query buffer length")
+					c.conn.Close()
+				}
+			case out <- next:
+				pending[0] = nil
+				pending = pending[1:]
+				pendingBytes -= argvLen(next)
+			}
+		}
+	}()
+	return queries
+}
+
+// argvLen 命令中所有参数的字节数
+func argvLen(argv []*GodisObject) int64 {
+	var n int64
+	for _, arg := range argv {
+		n += int64(len(arg.Ptr.(string)))
 	}
-	return errors.New("ProcessInputBuffer failed")
+	return n
 }
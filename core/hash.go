@@ -0,0 +1,638 @@
+package core
+
+import (
+	"godis/core/proto"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// ziplist 小哈希的紧凑编码 字段与值依次交替存放在同一个切片中
+type ziplist []string
+
+// find 查找字段所在的下标 不存在返回-1
+func (zl ziplist) find(field string) int {
+	for i := 0; i < len(zl); i += 2 {
+		if zl[i] == field {
+			return i
+		}
+	}
+	return -1
+}
+
+/*-----------------------------------------------------------------------------
+ * Hash type API
+ *----------------------------------------------------------------------------*/
+
+// createHashObject 创建哈希对象 初始使用紧凑编码
+func createHashObject() *GodisObject {
+	zl := make(ziplist, 0)
+	o := CreateObject(OBJ_HASH, &zl)
+	o.Encoding = OBJ_ENCODING_ZIPLIST
+	return o
+}
+
+/* Check the length of a number of objects to see if we need to convert a
+ * ziplist to a real hash. Note that we only check string encoded objects
+ * as their string length can be queried in constant time. */
+func hashTypeTryConversion(s *Server, o *GodisObject, argv []*GodisObject, start int, end int) {
+	if o.Encoding != OBJ_ENCODING_ZIPLIST {
+		return
+	}
+	for i := start; i <= end; i++ {
+		if len(argv[i].Ptr.(string)) > s.HashMaxZiplistValue {
+			hashTypeConvert(o, OBJ_ENCODING_HT)
+			break
+		}
+	}
+}
+
+// hashTypeConvert 将紧凑编码转换为dict 字段为dict的key 值为字符串对象
+func hashTypeConvert(o *GodisObject, enc int) {
+	if o.Encoding != OBJ_ENCODING_ZIPLIST || enc != OBJ_ENCODING_HT {
+		return
+	}
+	zl := *o.Ptr.(*ziplist)
+	d := dictCreate()
+	d.dictExpand(uint64(len(zl) / 2))
+	for i := 0; i < len(zl); i += 2 {
+		d.dictAdd(zl[i], CreateObject(ObjectTypeString, zl[i+1]))
+	}
+	o.Ptr = d
+	o.Encoding = OBJ_ENCODING_HT
+}
+
+// hashTypeGetValue 获取字段的值
+func hashTypeGetValue(o *GodisObject, field string) (string, bool) {
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		zl := *o.Ptr.(*ziplist)
+		if i := zl.find(field); i >= 0 {
+			return zl[i+1], true
+		}
+		return "", false
+	}
+	value := o.Ptr.(*dict).dictFetchValue(field)
+	if value == nil {
+		return "", false
+	}
+	return value.Ptr.(string), true
+}
+
+// hashTypeExists 字段是否存在
+func hashTypeExists(o *GodisObject, field string) bool {
+	_, ok := hashTypeGetValue(o, field)
+	return ok
+}
+
+/* Add a new field, overwrite the old with the new value if it already exists.
+ * Return false on insert and true on update. */
+func hashTypeSet(s *Server, o *GodisObject, field string, value string) bool {
+	update := false
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		zl := o.Ptr.(*ziplist)
+		if i := zl.find(field); i >= 0 {
+			(*zl)[i+1] = value
+			update = true
+		} else {
+			*zl = append(*zl, field, value)
+		}
+		/* Check if the ziplist needs to be converted to a hash table */
+		if len(*zl)/2 > s.HashMaxZiplistEntries {
+			hashTypeConvert(o, OBJ_ENCODING_HT)
+		}
+	} else {
+		update = !o.Ptr.(*dict).dictReplace(field, CreateObject(ObjectTypeString, value))
+	}
+	return update
+}
+
+/* Delete an element from a hash.
+ * Return true on deleted and false on not found. */
+func hashTypeDelete(o *GodisObject, field string) bool {
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		zl := o.Ptr.(*ziplist)
+		i := zl.find(field)
+		if i < 0 {
+			return false
+		}
+		*zl = append((*zl)[:i], (*zl)[i+2:]...)
+		return true
+	}
+	d := o.Ptr.(*dict)
+	if !d.dictDelete(field) {
+		return false
+	}
+	/* Always check if the dictionary needs a resize after a delete. */
+	if htNeedsResize(d) {
+		d.dictResize()
+	}
+	return true
+}
+
+// hashTypeLength 哈希的字段数
+func hashTypeLength(o *GodisObject) int {
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		return len(*o.Ptr.(*ziplist)) / 2
+	}
+	return o.Ptr.(*dict).dictSize()
+}
+
+// hashTypeForEach 依次访问每一个字段和值 fn返回false时停止遍历
+func hashTypeForEach(o *GodisObject, fn func(field string, value string) bool) {
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		zl := *o.Ptr.(*ziplist)
+		for i := 0; i < len(zl); i += 2 {
+			if !fn(zl[i], zl[i+1]) {
+				return
+			}
+		}
+		return
+	}
+	o.Ptr.(*dict).dictForEach(func(de *dictEntry) bool {
+		return fn(de.key, de.val.Ptr.(string))
+	})
+}
+
+/* Like hashTypeForEach() but the hash is only read, see dictForEachUnsafe(). */
+func hashTypeForEachUnsafe(o *GodisObject, fn func(field string, value string) bool) {
+	if o.Encoding == OBJ_ENCODING_HT {
+		o.Ptr.(*dict).dictForEachUnsafe(func(de *dictEntry) bool {
+			return fn(de.key, de.val.Ptr.(string))
+		})
+		return
+	}
+	hashTypeForEach(o, fn)
+}
+
+// hashTypeDup 复制哈希对象 保持原有的编码 用于COPY
+func hashTypeDup(o *GodisObject) *GodisObject {
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		zl := make(ziplist, len(*o.Ptr.(*ziplist)))
+		copy(zl, *o.Ptr.(*ziplist))
+		hobj := CreateObject(OBJ_HASH, &zl)
+		hobj.Encoding = OBJ_ENCODING_ZIPLIST
+		return hobj
+	}
+	d := dictCreate()
+	d.dictExpand(uint64(hashTypeLength(o)))
+	hashTypeForEach(o, func(field string, value string) bool {
+		d.dictAdd(field, CreateObject(ObjectTypeString, value))
+		return true
+	})
+	hobj := CreateObject(OBJ_HASH, d)
+	hobj.Encoding = OBJ_ENCODING_HT
+	return hobj
+}
+
+// hashTypeLookupWriteOrCreate 查找用于写入的哈希 不存在时创建 类型错误时回复客户端并返回nil
+func hashTypeLookupWriteOrCreate(c *Client, key *GodisObject) *GodisObject {
+	o := lookupKeyWrite(c.Db, key)
+	if o == nil {
+		o = createHashObject()
+		dbAdd(c.Db, key, o)
+		return o
+	}
+	if checkType(c, o, OBJ_HASH) {
+		return nil
+	}
+	return o
+}
+
+// hashTypeDeleteIfEmpty 字段全部删除后移除key
+func hashTypeDeleteIfEmpty(c *Client, key *GodisObject, o *GodisObject) bool {
+	if hashTypeLength(o) == 0 {
+		dbDelete(c.Db, key)
+		return true
+	}
+	return false
+}
+
+/*-----------------------------------------------------------------------------
+ * Hash type commands
+ *----------------------------------------------------------------------------*/
+
+// HSetNXCommand hsetnx key field value
+func HSetNXCommand(c *Client, s *Server) {
+	o := hashTypeLookupWriteOrCreate(c, c.Argv[1])
+	if o == nil {
+		return
+	}
+	if hashTypeExists(o, c.Argv[2].Ptr.(string)) {
+		addReplyLongLong(c, 0)
+		return
+	}
+	hashTypeTryConversion(s, o, c.Argv, 2, 3)
+	hashTypeSet(s, o, c.Argv[2].Ptr.(string), c.Argv[3].Ptr.(string))
+	s.Dirty++
+	addReplyLongLong(c, 1)
+}
+
+/* HSET key field value [field value ...]
+ * HMSET is the deprecated form that replies with a status instead of the
+ * number of created fields. */
+func hsetGenericCommand(c *Client, s *Server, hmset bool) {
+	if c.Argc%2 == 1 {
+		addReplyError(c, "ERR wrong number of arguments for '"+strings.ToLower(c.Argv[0].Ptr.(string))+"' command")
+		return
+	}
+	o := hashTypeLookupWriteOrCreate(c, c.Argv[1])
+	if o == nil {
+		return
+	}
+	hashTypeTryConversion(s, o, c.Argv, 2, c.Argc-1)
+
+	created := 0
+	for i := 2; i < c.Argc; i += 2 {
+		if !hashTypeSet(s, o, c.Argv[i].Ptr.(string), c.Argv[i+1].Ptr.(string)) {
+			created++
+		}
+	}
+	s.Dirty += int64((c.Argc - 2) / 2)
+
+	if hmset {
+		addReplyStatus(c, "OK")
+	} else {
+		addReplyLongLong(c, int64(created))
+	}
+}
+
+// HSetCommand hset key field value [field value ...]
+func HSetCommand(c *Client, s *Server) {
+	hsetGenericCommand(c, s, false)
+}
+
+// HMSetCommand hmset key field value [field value ...]
+func HMSetCommand(c *Client, s *Server) {
+	hsetGenericCommand(c, s, true)
+}
+
+// HIncrByCommand hincrby key field increment
+func HIncrByCommand(c *Client, s *Server) {
+	var incr, value int64
+	if getLongLongFromObjectOrReply(c, c.Argv[3], &incr, "") != C_OK {
+		return
+	}
+	o := hashTypeLookupWriteOrCreate(c, c.Argv[1])
+	if o == nil {
+		return
+	}
+	field := c.Argv[2].Ptr.(string)
+	if cur, ok := hashTypeGetValue(o, field); ok {
+		v, err := strconv.ParseInt(cur, 10, 64)
+		if err != nil {
+			addReplyError(c, "ERR hash value is not an integer")
+			return
+		}
+		value = v
+	}
+
+	oldvalue := value
+	if (incr < 0 && oldvalue < 0 && incr < (math.MinInt64-oldvalue)) ||
+		(incr > 0 && oldvalue > 0 && incr > (math.MaxInt64-oldvalue)) {
+		addReplyError(c, "ERR increment or decrement would overflow")
+		return
+	}
+	value += incr
+	hashTypeSet(s, o, field, strconv.FormatInt(value, 10))
+	s.Dirty++
+	addReplyLongLong(c, value)
+}
+
+// HIncrByFloatCommand hincrbyfloat key field increment
+func HIncrByFloatCommand(c *Client, s *Server) {
+	var incr, value float64
+	if getDoubleFromObjectOrReply(c, c.Argv[3], &incr, "") != C_OK {
+		return
+	}
+	if math.IsInf(incr, 0) {
+		addReplyError(c, "ERR value is NaN or Infinity")
+		return
+	}
+	o := hashTypeLookupWriteOrCreate(c, c.Argv[1])
+	if o == nil {
+		return
+	}
+	field := c.Argv[2].Ptr.(string)
+	if cur, ok := hashTypeGetValue(o, field); ok {
+		v, err := strconv.ParseFloat(cur, 64)
+		if err != nil || math.IsNaN(v) {
+			addReplyError(c, "ERR hash value is not a float")
+			return
+		}
+		value = v
+	}
+
+	value += incr
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		addReplyError(c, "ERR increment would produce NaN or Infinity")
+		return
+	}
+	newvalue := formatDouble(value)
+	hashTypeTryConversion(s, o, []*GodisObject{CreateObject(ObjectTypeString, newvalue)}, 0, 0)
+	hashTypeSet(s, o, field, newvalue)
+	s.Dirty++
+	addReplyBulk(c, newvalue)
+
+	/* Always replicate HINCRBYFLOAT as an HSET command with the final value
+	 * in order to make sure that differences in float precision or formatting
+	 * will not create differences in replicas or after an AOF restart. */
+	rewriteClientCommandVector(c, CreateObject(ObjectTypeString, "hset"),
+		c.Argv[1], c.Argv[2], CreateObject(ObjectTypeString, newvalue))
+}
+
+// HGetCommand hget key field
+func HGetCommand(c *Client, s *Server) {
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyNull(c)
+		return
+	}
+	if checkType(c, o, OBJ_HASH) {
+		return
+	}
+	value, ok := hashTypeGetValue(o, c.Argv[2].Ptr.(string))
+	if !ok {
+		addReplyNull(c)
+		return
+	}
+	addReplyBulk(c, value)
+}
+
+// HMGetCommand hmget key field [field ...]
+func HMGetCommand(c *Client, s *Server) {
+	/* Don't abort when the key cannot be found. Non-existing keys are empty
+	 * hashes, where HMGET should respond with a series of null bulks. */
+	o := lookupKey(c.Db, c.Argv[1])
+	if o != nil && checkType(c, o, OBJ_HASH) {
+		return
+	}
+	items := make([]*proto.Resp, 0, c.Argc-2)
+	for i := 2; i < c.Argc; i++ {
+		if o != nil {
+			if value, ok := hashTypeGetValue(o, c.Argv[i].Ptr.(string)); ok {
+				items = append(items, bulkString(value))
+				continue
+			}
+		}
+		items = append(items, proto.NewBulkBytes(nil))
+	}
+	addReplyArray(c, items)
+}
+
+// HDelCommand hdel key field [field ...]
+func HDelCommand(c *Client, s *Server) {
+	o := lookupKeyWrite(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, o, OBJ_HASH) {
+		return
+	}
+	deleted := 0
+	for i := 2; i < c.Argc; i++ {
+		if hashTypeDelete(o, c.Argv[i].Ptr.(string)) {
+			deleted++
+			if hashTypeDeleteIfEmpty(c, c.Argv[1], o) {
+				break
+			}
+		}
+	}
+	s.Dirty += int64(deleted)
+	addReplyLongLong(c, int64(deleted))
+}
+
+// HLenCommand hlen key
+func HLenCommand(c *Client, s *Server) {
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, o, OBJ_HASH) {
+		return
+	}
+	addReplyLongLong(c, int64(hashTypeLength(o)))
+}
+
+// HStrLenCommand hstrlen key field
+func HStrLenCommand(c *Client, s *Server) {
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, o, OBJ_HASH) {
+		return
+	}
+	value, _ := hashTypeGetValue(o, c.Argv[2].Ptr.(string))
+	addReplyLongLong(c, int64(len(value)))
+}
+
+// HExistsCommand hexists key field
+func HExistsCommand(c *Client, s *Server) {
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, o, OBJ_HASH) {
+		return
+	}
+	if hashTypeExists(o, c.Argv[2].Ptr.(string)) {
+		addReplyLongLong(c, 1)
+	} else {
+		addReplyLongLong(c, 0)
+	}
+}
+
+// 输出哈希的字段和/或值
+const OBJ_HASH_KEY = 1 << 0
+const OBJ_HASH_VALUE = 1 << 1
+
+// genericHgetallCommand HKEYS/HVALS/HGETALL
+func genericHgetallCommand(c *Client, flags int) {
+	/* HGETALL replies with a map, HKEYS and HVALS with an array. */
+	reply := addReplyArray
+	if flags&OBJ_HASH_KEY != 0 && flags&OBJ_HASH_VALUE != 0 {
+		reply = addReplyMap
+	}
+
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		reply(c, nil)
+		return
+	}
+	if checkType(c, o, OBJ_HASH) {
+		return
+	}
+	items := make([]*proto.Resp, 0, hashTypeLength(o)*2)
+	hashTypeForEach(o, func(field string, value string) bool {
+		if flags&OBJ_HASH_KEY != 0 {
+			items = append(items, bulkString(field))
+		}
+		if flags&OBJ_HASH_VALUE != 0 {
+			items = append(items, bulkString(value))
+		}
+		return true
+	})
+	reply(c, items)
+}
+
+// HKeysCommand hkeys key
+func HKeysCommand(c *Client, s *Server) {
+	genericHgetallCommand(c, OBJ_HASH_KEY)
+}
+
+// HValsCommand hvals key
+func HValsCommand(c *Client, s *Server) {
+	genericHgetallCommand(c, OBJ_HASH_VALUE)
+}
+
+// HGetAllCommand hgetall key
+func HGetAllCommand(c *Client, s *Server) {
+	genericHgetallCommand(c, OBJ_HASH_KEY|OBJ_HASH_VALUE)
+}
+
+// hashTypeRandomElement 随机返回一个字段和值 哈希不能为空
+func hashTypeRandomElement(o *GodisObject) (string, string) {
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		zl := *o.Ptr.(*ziplist)
+		i := rand.Intn(len(zl)/2) * 2
+		return zl[i], zl[i+1]
+	}
+	de := o.Ptr.(*dict).dictGetRandomKey()
+	return de.key, de.val.Ptr.(string)
+}
+
+// hrandfieldWithCountCommand hrandfield key count [WITHVALUES]
+func hrandfieldWithCountCommand(c *Client, l int64, withvalues bool) {
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyArray(c, nil)
+		return
+	}
+	if checkType(c, o, OBJ_HASH) {
+		return
+	}
+	size := int64(hashTypeLength(o))
+
+	/* If count is zero, serve it ASAP to avoid special cases later. */
+	if l == 0 {
+		addReplyArray(c, nil)
+		return
+	}
+
+	var items []*proto.Resp
+	add := func(field string, value string) {
+		items = append(items, bulkString(field))
+		if withvalues {
+			items = append(items, bulkString(value))
+		}
+	}
+
+	/* CASE 1: The count was negative, so the extraction method is just:
+	 * "return N random elements" sampling the whole set every time.
+	 * This case is trivial and can be served without auxiliary data
+	 * structures. This case is the only one that also needs to return the
+	 * elements in random order. */
+	if l < 0 {
+		count := -l
+		if count > math.MaxInt32 {
+			addReplyError(c, "ERR value is out of range")
+			return
+		}
+		for ; count > 0; count-- {
+			add(hashTypeRandomElement(o))
+		}
+		addReplyArray(c, items)
+		return
+	}
+
+	/* CASE 2:
+	 * The number of requested elements is greater than the number of
+	 * elements inside the hash: simply return the whole hash. */
+	if l >= size {
+		hashTypeForEach(o, func(field string, value string) bool {
+			add(field, value)
+			return true
+		})
+		addReplyArray(c, items)
+		return
+	}
+
+	/* CASE 3: pick 'count' distinct fields, visiting every field once and
+	 * selecting it with probability remaining/left (selection sampling). */
+	need, left := l, size
+	hashTypeForEach(o, func(field string, value string) bool {
+		if rand.Int63n(left) < need {
+			add(field, value)
+			need--
+		}
+		left--
+		return need > 0
+	})
+	/* Shuffle the result, the sampling above keeps the hash order. */
+	step := 1
+	if withvalues {
+		step = 2
+	}
+	for i := len(items)/step - 1; i > 0; i-- {
+		j := rand.Intn(i + 1)
+		for k := 0; k < step; k++ {
+			items[i*step+k], items[j*step+k] = items[j*step+k], items[i*step+k]
+		}
+	}
+	addReplyArray(c, items)
+}
+
+// HRandFieldCommand hrandfield key [count [WITHVALUES]]
+func HRandFieldCommand(c *Client, s *Server) {
+	if c.Argc >= 3 {
+		var l int64
+		if getLongLongFromObjectOrReply(c, c.Argv[2], &l, "") != C_OK {
+			return
+		}
+		withvalues := false
+		if c.Argc > 4 || (c.Argc == 4 && !strings.EqualFold(c.Argv[3].Ptr.(string), "withvalues")) {
+			addReplyError(c, errSyntax)
+			return
+		} else if c.Argc == 4 {
+			withvalues = true
+		}
+		hrandfieldWithCountCommand(c, l, withvalues)
+		return
+	}
+
+	/* Handle variant without <count> argument. Reply with simple bulk string */
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyNull(c)
+		return
+	}
+	if checkType(c, o, OBJ_HASH) {
+		return
+	}
+	field, _ := hashTypeRandomElement(o)
+	addReplyBulk(c, field)
+}
+
+/* This command implements HSCAN. Hashes encoded as a hash table are scanned
+ * incrementally with the dictScan() cursor, the ziplist encoded ones are
+ * returned in a single call with a zero cursor. */
+func HScanCommand(c *Client, s *Server) {
+	var cursor uint64
+	if parseScanCursorOrReply(c, c.Argv[2], &cursor) == C_ERR {
+		return
+	}
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyArray(c, []*proto.Resp{bulkString("0"), proto.NewArray([]*proto.Resp{})})
+		return
+	}
+	if checkType(c, o, OBJ_HASH) {
+		return
+	}
+	scanGenericCommand(c, o, cursor)
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// intset 有序整数集合 元素按升序存放 查找使用二分
+// redis中会根据元素范围选择int16/int32/int64编码 这里统一使用int64
+type intset struct {
+	contents []int64
+}
+
+/* Create an empty intset. */
+func intsetNew() *intset {
+	return &intset{contents: make([]int64, 0)}
+}
+
+/* Search for the position of "value". Return true when the value was found
+ * and the position of the value. When the value is not present, the
+ * position is where "value" can be inserted. */
+func (is *intset) intsetSearch(value int64) (int, bool) {
+	pos := sort.Search(len(is.contents), func(i int) bool {
+		return is.contents[i] >= value
+	})
+	return pos, pos < len(is.contents) && is.contents[pos] == value
+}
+
+/* Insert an integer in the intset. Return false when the value was
+ * already present. */
+func (is *intset) intsetAdd(value int64) bool {
+	pos, found := is.intsetSearch(value)
+	if found {
+		return false
+	}
+	is.contents = append(is.contents, 0)
+	copy(is.contents[pos+1:], is.contents[pos:])
+	is.contents[pos] = value
+	return true
+}
+
+/* Delete integer from intset. Return false when the value was not present. */
+func (is *intset) intsetRemove(value int64) bool {
+	pos, found := is.intsetSearch(value)
+	if !found {
+		return false
+	}
+	is.contents = append(is.contents[:pos], is.contents[pos+1:]...)
+	return true
+}
+
+/* Determine whether a value belongs to this set */
+func (is *intset) intsetFind(value int64) bool {
+	_, found := is.intsetSearch(value)
+	return found
+}
+
+/* Return random member */
+func (is *intset) intsetRandom() int64 {
+	return is.contents[rand.Intn(len(is.contents))]
+}
+
+/* Get the value at the given position. */
+func (is *intset) intsetGet(pos int) int64 {
+	return is.contents[pos]
+}
+
+/* Return intset length */
+func (is *intset) intsetLen() int {
+	return len(is.contents)
+}
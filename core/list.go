@@ -0,0 +1,728 @@
+package core
+
+import (
+	"godis/core/proto"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 列表的两端
+const LIST_HEAD = 0
+const LIST_TAIL = 1
+
+/*-----------------------------------------------------------------------------
+ * List API
+ *----------------------------------------------------------------------------*/
+
+// createListObject 创建列表对象 底层使用adlist双端链表
+func createListObject() *GodisObject {
+	return CreateObject(OBJ_LIST, listCreate())
+}
+
+// listTypeLength 列表长度
+func listTypeLength(subject *GodisObject) int {
+	return subject.Ptr.(*List).listLength()
+}
+
+// listTypePush 向列表头部或尾部添加元素
+func listTypePush(subject *GodisObject, value string, where int) {
+	l := subject.Ptr.(*List)
+	if where == LIST_HEAD {
+		l.listAddNodeHead(value)
+	} else {
+		l.listAddNodeTail(value)
+	}
+}
+
+// listTypeDup 复制列表对象 用于COPY
+func listTypeDup(o *GodisObject) *GodisObject {
+	lobj := createListObject()
+	l := lobj.Ptr.(*List)
+	for ln := o.Ptr.(*List).listFirst(); ln != nil; ln = ln.listNextNode() {
+		l.listAddNodeTail(ln.listNodeValue())
+	}
+	return lobj
+}
+
+// listTypePop 从列表头部或尾部弹出元素 调用方需保证列表非空
+func listTypePop(subject *GodisObject, where int) string {
+	l := subject.Ptr.(*List)
+	ln := l.head
+	if where == LIST_TAIL {
+		ln = l.tail
+	}
+	value := ln.value.(string)
+	l.listDelNode(ln)
+	return value
+}
+
+// getListPositionFromObjectOrReply 解析 LEFT|RIGHT 参数
+func getListPositionFromObjectOrReply(c *Client, arg *GodisObject, position *int) int {
+	switch strings.ToLower(arg.Ptr.(string)) {
+	case "right":
+		*position = LIST_TAIL
+	case "left":
+		*position = LIST_HEAD
+	default:
+		addReplyError(c, errSyntax)
+		return C_ERR
+	}
+	return C_OK
+}
+
+// listPositionObject LIST_HEAD/LIST_TAIL 对应的参数对象 用于写入aof
+func listPositionObject(where int) *GodisObject {
+	if where == LIST_HEAD {
+		return CreateObject(ObjectTypeString, "left")
+	}
+	return CreateObject(ObjectTypeString, "right")
+}
+
+/*-----------------------------------------------------------------------------
+ * List Commands
+ *----------------------------------------------------------------------------*/
+
+/* Implements LPUSH/RPUSH/LPUSHX/RPUSHX.
+ * 'xx': push if key exists. */
+func pushGenericCommand(c *Client, s *Server, where int, xx bool) {
+	lobj := lookupKeyWrite(c.Db, c.Argv[1])
+	if lobj != nil && checkType(c, lobj, OBJ_LIST) {
+		return
+	}
+	if lobj == nil {
+		if xx {
+			addReplyLongLong(c, 0)
+			return
+		}
+		lobj = createListObject()
+		dbAdd(c.Db, c.Argv[1], lobj)
+	}
+
+	for j := 2; j < c.Argc; j++ {
+		listTypePush(lobj, c.Argv[j].Ptr.(string), where)
+	}
+	s.Dirty += int64(c.Argc - 2)
+	addReplyLongLong(c, int64(listTypeLength(lobj)))
+}
+
+// LPushCommand lpush key element [element ...]
+func LPushCommand(c *Client, s *Server) {
+	pushGenericCommand(c, s, LIST_HEAD, false)
+}
+
+// RPushCommand rpush key element [element ...]
+func RPushCommand(c *Client, s *Server) {
+	pushGenericCommand(c, s, LIST_TAIL, false)
+}
+
+// LPushXCommand lpushx key element [element ...]
+func LPushXCommand(c *Client, s *Server) {
+	pushGenericCommand(c, s, LIST_HEAD, true)
+}
+
+// RPushXCommand rpushx key element [element ...]
+func RPushXCommand(c *Client, s *Server) {
+	pushGenericCommand(c, s, LIST_TAIL, true)
+}
+
+// LInsertCommand linsert key BEFORE|AFTER pivot element
+func LInsertCommand(c *Client, s *Server) {
+	after := 0
+	if strings.EqualFold(c.Argv[2].Ptr.(string), "after") {
+		after = 1
+	} else if !strings.EqualFold(c.Argv[2].Ptr.(string), "before") {
+		addReplyError(c, errSyntax)
+		return
+	}
+
+	subject := lookupKeyWrite(c.Db, c.Argv[1])
+	if subject == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, subject, OBJ_LIST) {
+		return
+	}
+
+	/* Seek pivot from head to tail */
+	l := subject.Ptr.(*List)
+	pivot := c.Argv[3].Ptr.(string)
+	for ln := l.head; ln != nil; ln = ln.next {
+		if ln.value.(string) == pivot {
+			l.listInsertNode(ln, c.Argv[4].Ptr.(string), after)
+			s.Dirty++
+			addReplyLongLong(c, int64(l.listLength()))
+			return
+		}
+	}
+
+	/* Notify client of a failed insert */
+	addReplyLongLong(c, -1)
+}
+
+// LLenCommand llen key
+func LLenCommand(c *Client, s *Server) {
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, o, OBJ_LIST) {
+		return
+	}
+	addReplyLongLong(c, int64(listTypeLength(o)))
+}
+
+// LIndexCommand lindex key index
+func LIndexCommand(c *Client, s *Server) {
+	var index int64
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &index, "") != C_OK {
+		return
+	}
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyNull(c)
+		return
+	}
+	if checkType(c, o, OBJ_LIST) {
+		return
+	}
+
+	ln := o.Ptr.(*List).listIndex(int(index))
+	if ln == nil {
+		addReplyNull(c)
+		return
+	}
+	addReplyBulk(c, ln.value.(string))
+}
+
+// LSetCommand lset key index element
+func LSetCommand(c *Client, s *Server) {
+	var index int64
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &index, "") != C_OK {
+		return
+	}
+	o := lookupKeyWrite(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyError(c, "ERR no such key")
+		return
+	}
+	if checkType(c, o, OBJ_LIST) {
+		return
+	}
+
+	ln := o.Ptr.(*List).listIndex(int(index))
+	if ln == nil {
+		addReplyError(c, "ERR index out of range")
+		return
+	}
+	ln.value = c.Argv[3].Ptr.(string)
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
+
+/* Implements the generic list pop operation for LPOP/RPOP.
+ * The where argument specifies which end of the list is operated on. An
+ * optional count may be provided as the third argument of the client's
+ * command. */
+func popGenericCommand(c *Client, s *Server, where int) {
+	hascount := c.Argc == 3
+	var count int64 = 1
+
+	/* Parse the optional count argument. */
+	if c.Argc > 3 {
+		addReplyError(c, errSyntax)
+		return
+	} else if hascount {
+		if getLongLongFromObjectOrReply(c, c.Argv[2], &count, "") != C_OK {
+			return
+		}
+		if count < 0 {
+			addReplyError(c, "ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	o := lookupKeyWrite(c.Db, c.Argv[1])
+	if o == nil {
+		if hascount {
+			addReplyNullArray(c)
+		} else {
+			addReplyNull(c)
+		}
+		return
+	}
+	if checkType(c, o, OBJ_LIST) {
+		return
+	}
+
+	if !hascount {
+		/* Pop a single element. This is POP's original behavior that replies
+		 * with a bulk string. */
+		addReplyBulk(c, listTypePop(o, where))
+		s.Dirty++
+	} else {
+		/* Pop a range of elements. An addition to the original POP command,
+		 * which replies with a multi-bulk. */
+		items := make([]*proto.Resp, 0)
+		for ; count > 0 && listTypeLength(o) > 0; count-- {
+			items = append(items, bulkString(listTypePop(o, where)))
+		}
+		s.Dirty += int64(len(items))
+		addReplyArray(c, items)
+	}
+
+	if listTypeLength(o) == 0 {
+		dbDelete(c.Db, c.Argv[1])
+	}
+}
+
+// LPopCommand lpop key [count]
+func LPopCommand(c *Client, s *Server) {
+	popGenericCommand(c, s, LIST_HEAD)
+}
+
+// RPopCommand rpop key [count]
+func RPopCommand(c *Client, s *Server) {
+	popGenericCommand(c, s, LIST_TAIL)
+}
+
+// LRangeCommand lrange key start stop
+func LRangeCommand(c *Client, s *Server) {
+	var start, end int64
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &start, "") != C_OK ||
+		getLongLongFromObjectOrReply(c, c.Argv[3], &end, "") != C_OK {
+		return
+	}
+
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyArray(c, nil)
+		return
+	}
+	if checkType(c, o, OBJ_LIST) {
+		return
+	}
+
+	/* convert negative indexes */
+	llen := int64(listTypeLength(o))
+	if start < 0 {
+		start = llen + start
+	}
+	if end < 0 {
+		end = llen + end
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	/* Invariant: start >= 0, so this test will be true when end < 0.
+	 * The range is empty when start > end or start >= length. */
+	if start > end || start >= llen {
+		addReplyArray(c, nil)
+		return
+	}
+	if end >= llen {
+		end = llen - 1
+	}
+	rangelen := end - start + 1
+
+	/* Return the result in form of a multi-bulk reply */
+	items := make([]*proto.Resp, 0, rangelen)
+	ln := o.Ptr.(*List).listIndex(int(start))
+	for ; rangelen > 0; rangelen-- {
+		items = append(items, bulkString(ln.value.(string)))
+		ln = ln.next
+	}
+	addReplyArray(c, items)
+}
+
+// LTrimCommand ltrim key start stop
+func LTrimCommand(c *Client, s *Server) {
+	var start, end int64
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &start, "") != C_OK ||
+		getLongLongFromObjectOrReply(c, c.Argv[3], &end, "") != C_OK {
+		return
+	}
+
+	o := lookupKeyWrite(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyStatus(c, "OK")
+		return
+	}
+	if checkType(c, o, OBJ_LIST) {
+		return
+	}
+
+	/* convert negative indexes */
+	llen := int64(listTypeLength(o))
+	if start < 0 {
+		start = llen + start
+	}
+	if end < 0 {
+		end = llen + end
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	/* Invariant: start >= 0, so this test will be true when end < 0.
+	 * The range is empty when start > end or start >= length. */
+	var ltrim, rtrim int64
+	if start > end || start >= llen {
+		/* Out of range start or start > end result in empty list */
+		ltrim = llen
+		rtrim = 0
+	} else {
+		if end >= llen {
+			end = llen - 1
+		}
+		ltrim = start
+		rtrim = llen - end - 1
+	}
+
+	/* Remove list elements to perform the trim */
+	l := o.Ptr.(*List)
+	for j := int64(0); j < ltrim; j++ {
+		l.listDelNode(l.head)
+	}
+	for j := int64(0); j < rtrim; j++ {
+		l.listDelNode(l.tail)
+	}
+	if l.listLength() == 0 {
+		dbDelete(c.Db, c.Argv[1])
+	}
+	s.Dirty += ltrim + rtrim
+	addReplyStatus(c, "OK")
+}
+
+// LPosCommand lpos key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+/* The rank is the position of the match, so if it is 1, the first match
+ * is returned, if it is 2 the second match is returned and so forth.
+ * It is 1 by default. If negative has the same meaning but the search is
+ * performed starting from the end of the list.
+ *
+ * If COUNT is given, instead of returning the single element, a list of
+ * all the matching elements up to "num-matches" are returned. COUNT can
+ * be combined with RANK in order to returning only the element starting
+ * from the Nth. If COUNT is zero, all the matching elements are returned.
+ *
+ * MAXLEN tells the command to scan a max of len elements. If zero (the
+ * default), all the elements in the list are scanned if needed. */
+func LPosCommand(c *Client, s *Server) {
+	ele := c.Argv[2].Ptr.(string)
+	var rank, count, maxlen int64 = 1, -1, 0
+
+	/* Parse the optional arguments. */
+	for j := 3; j < c.Argc; j++ {
+		opt := c.Argv[j].Ptr.(string)
+		moreargs := (c.Argc - 1) - j
+		if strings.EqualFold(opt, "rank") && moreargs > 0 {
+			j++
+			if getLongLongFromObjectOrReply(c, c.Argv[j], &rank, "") != C_OK {
+				return
+			}
+			if rank == 0 || rank == math.MinInt64 {
+				addReplyError(c, "ERR RANK can't be zero: use 1 to start from "+
+					"the first match, 2 from the second ... "+
+					"or use negative to start from the end of the list")
+				return
+			}
+		} else if strings.EqualFold(opt, "count") && moreargs > 0 {
+			j++
+			if getLongLongFromObjectOrReply(c, c.Argv[j], &count, "") != C_OK {
+				return
+			}
+			if count < 0 {
+				addReplyError(c, "ERR COUNT can't be negative")
+				return
+			}
+		} else if strings.EqualFold(opt, "maxlen") && moreargs > 0 {
+			j++
+			if getLongLongFromObjectOrReply(c, c.Argv[j], &maxlen, "") != C_OK {
+				return
+			}
+			if maxlen < 0 {
+				addReplyError(c, "ERR MAXLEN can't be negative")
+				return
+			}
+		} else {
+			addReplyError(c, errSyntax)
+			return
+		}
+	}
+
+	/* A negative rank means start from the tail. */
+	direction := LIST_HEAD
+	if rank < 0 {
+		rank = -rank
+		direction = LIST_TAIL
+	}
+
+	/* We return NULL or an empty array if there is no such key (or
+	 * if we find no matches, depending on the presence of the COUNT option. */
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		if count != -1 {
+			addReplyArray(c, nil)
+		} else {
+			addReplyNull(c)
+		}
+		return
+	}
+	if checkType(c, o, OBJ_LIST) {
+		return
+	}
+
+	/* Seek the element. */
+	l := o.Ptr.(*List)
+	llen := int64(l.listLength())
+	ln := l.head
+	if direction == LIST_TAIL {
+		ln = l.tail
+	}
+	var index, matches int64
+	items := make([]*proto.Resp, 0)
+	found := false
+	for ; ln != nil && (maxlen == 0 || index < maxlen); index++ {
+		if ln.value.(string) == ele {
+			matches++
+			if matches >= rank {
+				pos := index
+				if direction == LIST_TAIL {
+					pos = llen - index - 1
+				}
+				if count == -1 {
+					addReplyLongLong(c, pos)
+					return
+				}
+				items = append(items, proto.NewInt([]byte(strconv.FormatInt(pos, 10))))
+				found = true
+				if count != 0 && matches-rank+1 >= count {
+					break
+				}
+			}
+		}
+		if direction == LIST_TAIL {
+			ln = ln.prev
+		} else {
+			ln = ln.next
+		}
+	}
+
+	if count != -1 {
+		addReplyArray(c, items)
+	} else if !found {
+		addReplyNull(c)
+	}
+}
+
+// LRemCommand lrem key count element
+func LRemCommand(c *Client, s *Server) {
+	var toremove int64
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &toremove, "") != C_OK {
+		return
+	}
+
+	subject := lookupKeyWrite(c.Db, c.Argv[1])
+	if subject == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, subject, OBJ_LIST) {
+		return
+	}
+
+	l := subject.Ptr.(*List)
+	obj := c.Argv[3].Ptr.(string)
+	var removed int64
+	tail := toremove < 0
+	if tail {
+		toremove = -toremove
+	}
+
+	ln := l.head
+	if tail {
+		ln = l.tail
+	}
+	for ln != nil {
+		next := ln.next
+		if tail {
+			next = ln.prev
+		}
+		if ln.value.(string) == obj {
+			l.listDelNode(ln)
+			removed++
+			if toremove != 0 && removed == toremove {
+				break
+			}
+		}
+		ln = next
+	}
+
+	if l.listLength() == 0 {
+		dbDelete(c.Db, c.Argv[1])
+	}
+	s.Dirty += removed
+	addReplyLongLong(c, removed)
+}
+
+// lmoveHandlePush 将元素添加到目标列表 目标不存在时创建
+func lmoveHandlePush(c *Client, dstkey *GodisObject, dstobj *GodisObject, value string, where int) {
+	/* Create the list if the key does not exist */
+	if dstobj == nil {
+		dstobj = createListObject()
+		dbAdd(c.Db, dstkey, dstobj)
+	}
+	listTypePush(dstobj, value, where)
+}
+
+// lmoveGenericCommand LMOVE 和 RPOPLPUSH 的实现
+func lmoveGenericCommand(c *Client, s *Server, wherefrom int, whereto int) {
+	sobj := lookupKeyWrite(c.Db, c.Argv[1])
+	if sobj == nil {
+		addReplyNull(c)
+		return
+	}
+	if checkType(c, sobj, OBJ_LIST) {
+		return
+	}
+
+	dobj := lookupKeyWrite(c.Db, c.Argv[2])
+	if dobj != nil && checkType(c, dobj, OBJ_LIST) {
+		return
+	}
+
+	value := listTypePop(sobj, wherefrom)
+	lmoveHandlePush(c, c.Argv[2], dobj, value, whereto)
+
+	/* Delete the source list when it is empty */
+	if listTypeLength(sobj) == 0 {
+		dbDelete(c.Db, c.Argv[1])
+	}
+	s.Dirty++
+	addReplyBulk(c, value)
+}
+
+// LMoveCommand lmove source destination LEFT|RIGHT LEFT|RIGHT
+func LMoveCommand(c *Client, s *Server) {
+	var wherefrom, whereto int
+	if getListPositionFromObjectOrReply(c, c.Argv[3], &wherefrom) != C_OK ||
+		getListPositionFromObjectOrReply(c, c.Argv[4], &whereto) != C_OK {
+		return
+	}
+	lmoveGenericCommand(c, s, wherefrom, whereto)
+}
+
+// RPopLPushCommand rpoplpush source destination
+/* This is the semantic of this command:
+ *  RPOPLPUSH srclist dstlist:
+ *    IF LLEN(srclist) > 0
+ *      element = RPOP srclist
+ *      LPUSH dstlist element
+ *      RETURN element
+ *    ELSE
+ *      RETURN nil
+ *    END
+ *  END */
+func RPopLPushCommand(c *Client, s *Server) {
+	lmoveGenericCommand(c, s, LIST_TAIL, LIST_HEAD)
+}
+
+/*-----------------------------------------------------------------------------
+ * Blocking POP operations
+ *----------------------------------------------------------------------------*/
+
+/* Blocking RPOP/LPOP */
+func blockingPopGenericCommand(c *Client, s *Server, where int) {
+	var timeout int64
+	if getTimeoutFromObjectOrReply(c, c.Argv[c.Argc-1], &timeout) != C_OK {
+		return
+	}
+
+	keys := c.Argv[1 : c.Argc-1]
+	for _, key := range keys {
+		o := lookupKeyWrite(c.Db, key)
+		if o == nil {
+			continue
+		}
+		if checkType(c, o, OBJ_LIST) {
+			return
+		}
+		if listTypeLength(o) != 0 {
+			/* Non empty list, this is like a normal [LR]POP. */
+			value := listTypePop(o, where)
+			if listTypeLength(o) == 0 {
+				dbDelete(c.Db, key)
+			}
+			s.Dirty++
+			addReplyArray(c, []*proto.Resp{bulkString(key.Ptr.(string)), bulkString(value)})
+
+			/* Replicate it as an [LR]POP instead of B[LR]POP. */
+			cmdName := "lpop"
+			if where == LIST_TAIL {
+				cmdName = "rpop"
+			}
+			rewriteClientCommandVector(c, CreateObject(ObjectTypeString, cmdName), key)
+			return
+		}
+	}
+
+	/* If the keys do not exist we must block */
+	blockForKeys(c, BLOCKED_LIST, keys, timeout, nil, where, 0)
+}
+
+// BLPopCommand blpop key [key ...] timeout
+func BLPopCommand(c *Client, s *Server) {
+	blockingPopGenericCommand(c, s, LIST_HEAD)
+}
+
+// BRPopCommand brpop key [key ...] timeout
+func BRPopCommand(c *Client, s *Server) {
+	blockingPopGenericCommand(c, s, LIST_TAIL)
+}
+
+// blmoveGenericCommand BLMOVE 和 BRPOPLPUSH 的实现
+func blmoveGenericCommand(c *Client, s *Server, wherefrom int, whereto int, timeout int64) {
+	key := c.Argv[1]
+	o := lookupKeyWrite(c.Db, key)
+	if o != nil && checkType(c, o, OBJ_LIST) {
+		return
+	}
+
+	if o == nil {
+		/* The list is empty and the client blocks. */
+		blockForKeys(c, BLOCKED_LIST, c.Argv[1:2], timeout, c.Argv[2], wherefrom, whereto)
+		return
+	}
+
+	/* The list exists and has elements, so
+	 * the regular lmoveCommand is executed. */
+	lmoveGenericCommand(c, s, wherefrom, whereto)
+
+	/* Replicate it as LMOVE so that replaying never blocks. */
+	rewriteClientCommandVector(c, CreateObject(ObjectTypeString, "lmove"), c.Argv[1], c.Argv[2],
+		listPositionObject(wherefrom), listPositionObject(whereto))
+}
+
+// BLMoveCommand blmove source destination LEFT|RIGHT LEFT|RIGHT timeout
+func BLMoveCommand(c *Client, s *Server) {
+	var wherefrom, whereto int
+	var timeout int64
+	if getListPositionFromObjectOrReply(c, c.Argv[3], &wherefrom) != C_OK ||
+		getListPositionFromObjectOrReply(c, c.Argv[4], &whereto) != C_OK ||
+		getTimeoutFromObjectOrReply(c, c.Argv[5], &timeout) != C_OK {
+		return
+	}
+	blmoveGenericCommand(c, s, wherefrom, whereto, timeout)
+}
+
+// BRPopLPushCommand brpoplpush source destination timeout
+func BRPopLPushCommand(c *Client, s *Server) {
+	var timeout int64
+	if getTimeoutFromObjectOrReply(c, c.Argv[3], &timeout) != C_OK {
+		return
+	}
+	blmoveGenericCommand(c, s, LIST_TAIL, LIST_HEAD, timeout)
+}
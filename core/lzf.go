@@ -0,0 +1,159 @@
+package core
+
+import (
+	"errors"
+	"sync"
+)
+
+// LZF压缩 参考redis使用的liblzf 格式与之兼容
+// rdb中较长的字符串以LZF压缩后保存
+//
+// 压缩后的数据由若干段组成 每段以一个控制字节开始:
+//   000LLLLL                    之后是L+1个字面字节
+//   LLLooooo oooooooo           回溯引用 长度L+2 偏移o+1
+//   111ooooo LLLLLLLL oooooooo  回溯引用 长度L+9 偏移o+1
+
+const LZF_HLOG = 16
+const LZF_MAX_LIT = (1 << 5)
+const LZF_MAX_OFF = (1 << 13)
+const LZF_MAX_REF = ((1 << 8) + (1 << 3))
+
+var errLzfCorrupted = errors.New("Invalid LZF compressed string")
+
+// lzfHash 以3个字节计算哈希表中的位置
+func lzfHash(in []byte, i int) uint32 {
+	v := uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])
+	return (v * 2654435761) >> (32 - LZF_HLOG)
+}
+
+/* The hash table is reused between the calls without clearing it, like
+ * liblzf does: an entry left by a previous input is just a wrong guess,
+ * since every candidate match is checked against the input. */
+var lzfHtabPool = sync.Pool{New: func() interface{} { return new([1 << LZF_HLOG]int32) }}
+
+/* Compress in, returning nil if the compressed data would not be smaller
+ * than maxLen bytes, in this case the data should be stored uncompressed. */
+func lzfCompress(in []byte, maxLen int) []byte {
+	if len(in) < 4 {
+		return nil
+	}
+	htab := lzfHtabPool.Get().(*[1 << LZF_HLOG]int32) // 位置+1 0表示没有记录
+	defer lzfHtabPool.Put(htab)
+	out := make([]byte, 1, maxLen) // 第一个字节为字面段的控制字节 稍后填写
+	lit := 0
+	ip := 0
+	for ip < len(in)-2 {
+		h := lzfHash(in, ip)
+		ref := int(htab[h]) - 1
+		htab[h] = int32(ip + 1)
+		off := ip - ref - 1
+		if ref >= 0 && ref < ip && off < LZF_MAX_OFF &&
+			in[ref] == in[ip] && in[ref+1] == in[ip+1] && in[ref+2] == in[ip+2] {
+			/* match found at ref, find out how long it is */
+			maxlen := len(in) - ip
+			if maxlen > LZF_MAX_REF {
+				maxlen = LZF_MAX_REF
+			}
+			l := 3
+			for l < maxlen && in[ref+l] == in[ip+l] {
+				l++
+			}
+
+			/* stop the current literal run, dropping its control byte
+			 * if it is empty */
+			if lit == 0 {
+				out = out[:len(out)-1]
+			} else {
+				out[len(out)-lit-1] = byte(lit - 1)
+			}
+			l -= 2
+			if l < 7 {
+				out = append(out, byte(off>>8)|byte(l<<5))
+			} else {
+				out = append(out, byte(off>>8)|(7<<5), byte(l-7))
+			}
+			out = append(out, byte(off), 0)
+			lit = 0
+			ip += l + 2
+		} else {
+			lit++
+			out = append(out, in[ip])
+			ip++
+			if lit == LZF_MAX_LIT {
+				out[len(out)-lit-1] = byte(lit - 1)
+				out = append(out, 0)
+				lit = 0
+			}
+		}
+		if len(out) >= maxLen {
+			return nil
+		}
+	}
+
+	/* the last bytes can't start a match */
+	for ; ip < len(in); ip++ {
+		lit++
+		out = append(out, in[ip])
+		if lit == LZF_MAX_LIT {
+			out[len(out)-lit-1] = byte(lit - 1)
+			out = append(out, 0)
+			lit = 0
+		}
+	}
+	if lit == 0 {
+		out = out[:len(out)-1]
+	} else {
+		out[len(out)-lit-1] = byte(lit - 1)
+	}
+	if len(out) >= maxLen {
+		return nil
+	}
+	return out
+}
+
+/* Decompress in, that must expand to exactly outLen bytes. */
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < LZF_MAX_LIT {
+			/* literal run */
+			ctrl++
+			if i+ctrl > len(in) || len(out)+ctrl > outLen {
+				return nil, errLzfCorrupted
+			}
+			out = append(out, in[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+
+		/* back reference */
+		l := ctrl >> 5
+		ref := len(out) - ((ctrl & 0x1f) << 8) - 1
+		if l == 7 {
+			if i >= len(in) {
+				return nil, errLzfCorrupted
+			}
+			l += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLzfCorrupted
+		}
+		ref -= int(in[i])
+		i++
+		l += 2
+		if ref < 0 || len(out)+l > outLen {
+			return nil, errLzfCorrupted
+		}
+		/* the reference may overlap the bytes being copied */
+		for j := 0; j < l; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errLzfCorrupted
+	}
+	return out, nil
+}
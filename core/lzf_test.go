@@ -0,0 +1,76 @@
+package core
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestLzfRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 4096)
+	r.Read(random)
+	text := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 200))
+	/* Few distinct bytes: many short back references. The zeros are
+	 * encoded with back references longer than LZF_MAX_REF. */
+	mixed := make([]byte, 20000)
+	for i := range mixed {
+		mixed[i] = "ab"[r.Intn(2)]
+	}
+	inputs := map[string][]byte{
+		"zeros":  make([]byte, 10000),
+		"text":   text,
+		"mixed":  mixed,
+		"short":  []byte("aaaaaaaaaa"),
+		"random": random,
+		"tail":   append(bytes.Repeat([]byte("xyz"), 100), random[:100]...),
+	}
+	for name, in := range inputs {
+		comp := lzfCompress(in, len(in)+len(in)/16+64)
+		if comp == nil {
+			t.Errorf("%s: lzfCompress failed", name)
+			continue
+		}
+		out, err := lzfDecompress(comp, len(in))
+		if err != nil {
+			t.Errorf("%s: lzfDecompress: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(out, in) {
+			t.Errorf("%s: round trip mismatch", name)
+		}
+	}
+
+	/* Data that doesn't compress is stored as is. */
+	if comp := lzfCompress(random, len(random)-4); comp != nil {
+		t.Errorf("random data compressed to %d bytes", len(comp))
+	}
+	if comp := lzfCompress(text, len(text)-4); comp == nil || len(comp) >= len(text)/4 {
+		t.Errorf("text not compressed")
+	}
+}
+
+func TestLzfDecompress(t *testing.T) {
+	/* Literal run "abc" followed by a back reference of length 3 at
+	 * offset 3, as produced by liblzf. */
+	out, err := lzfDecompress([]byte{0x02, 'a', 'b', 'c', 0x20, 0x02}, 6)
+	if err != nil || string(out) != "abcabc" {
+		t.Fatalf("lzfDecompress = %q, %v, want abcabc", out, err)
+	}
+
+	corrupted := [][]byte{
+		{0x05, 'a', 'b'},                        /* literal run past the end of the input */
+		{0x00, 'a', 0x20, 0x05},                 /* back reference before the start */
+		{0x02, 'a', 'b', 'c', 0x20},             /* truncated back reference */
+		{0x02, 'a', 'b', 'c', 0xe0, 0x10, 0x02}, /* longer than outLen */
+	}
+	for _, in := range corrupted {
+		if _, err := lzfDecompress(in, 6); err == nil {
+			t.Errorf("lzfDecompress(%x) succeeded", in)
+		}
+	}
+	if _, err := lzfDecompress([]byte{0x02, 'a', 'b', 'c'}, 6); err == nil {
+		t.Error("lzfDecompress of a short output succeeded")
+	}
+}
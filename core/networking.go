@@ -0,0 +1,336 @@
+package core
+
+import (
+	"godis/core/proto"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Client classes for client limits, currently used only for
+ * the max-client-output-buffer limit implementation. */
+const CLIENT_TYPE_NORMAL = 0 /* Normal req-reply clients */
+const CLIENT_TYPE_PUBSUB = 1 /* Clients subscribed to PubSub channels. */
+const CLIENT_TYPE_OBUF_COUNT = 2
+
+// ClientBufferLimitsConfig 输出缓冲区限制 对应client-output-buffer-limit
+// 超过硬限制立即关闭客户端 持续超过软限制SoftLimitSeconds秒也会关闭 0表示不限制
+type ClientBufferLimitsConfig struct {
+	HardLimitBytes   int64
+	SoftLimitBytes   int64
+	SoftLimitSeconds int64
+}
+
+/* This function is called every time we are going to transmit new data
+ * to the client. The behavior is the following:
+ *
+ * If the client should receive new data (normal clients will) the function
+ * returns C_OK, and make sure the write goroutine is woken up so that the
+ * data is sent to the socket.
+ *
+ * If the client should not receive new data, because it is a fake client
+ * (used to load AOF in memory) or because it is going to be closed ASAP,
+ * C_ERR is returned. */
+func prepareClientToWrite(c *Client) int {
+	if c.FakeFlag {
+		return C_ERR
+	}
+	if c.Flags&CLIENT_CLOSE_ASAP != 0 {
+		return C_ERR
+	}
+	clientInstallWriteHandler(c)
+	return C_OK
+}
+
+// clientInstallWriteHandler 通知写goroutine有回复需要发送
+func clientInstallWriteHandler(c *Client) {
+	select {
+	case c.pending <- struct{}{}:
+	default:
+	}
+}
+
+/* Add the reply to the client reply list and check the output buffer
+ * limits, closing the client asynchronously if they are reached. */
+func _addReplyProtoToList(c *Client, r *proto.Resp) {
+	c.reply = append(c.reply, r)
+	c.replyBytes += getRespSize(r)
+	closeClientOnOutputBufferLimitReached(c)
+}
+
+// getRespSize 回复编码后的大致字节数 包括类型、长度以及CRLF
+func getRespSize(r *proto.Resp) int64 {
+	size := int64(len(r.Value)) + 16
+	for _, item := range r.Array {
+		size += getRespSize(item)
+	}
+	return size
+}
+
+/* Commands build their replies using the RESP3 types where they apply
+ * (maps, sets, doubles, pushes), and the reply is converted in place to
+ * the protocol the client negotiated with HELLO when it is queued. Under
+ * RESP2 the RESP3 aggregates become arrays, doubles and big numbers become
+ * bulk strings, booleans become integers and attributes are dropped; under
+ * RESP3 the RESP2 null bulk and null array become the null type. Since the
+ * conversion happens in place, a reply must not be shared among clients. */
+func convertReplyProto(c *Client, r *proto.Resp) *proto.Resp {
+	if c.Resp >= 3 {
+		replyToResp3(r)
+	} else {
+		replyToResp2(r)
+	}
+	return r
+}
+
+// replyToResp2 将RESP3类型的回复转换为RESP2中对应的类型
+func replyToResp2(r *proto.Resp) {
+	r.Attribute = nil
+	switch r.Type {
+	case proto.TypeMap, proto.TypeSet, proto.TypePush:
+		r.Type = proto.TypeArray
+	case proto.TypeDouble, proto.TypeBigNumber:
+		r.Type = proto.TypeBulkBytes
+	case proto.TypeBoolean:
+		r.Type = proto.TypeInt
+		if string(r.Value) == "t" {
+			r.Value = []byte("1")
+		} else {
+			r.Value = []byte("0")
+		}
+	case proto.TypeNull:
+		r.Type = proto.TypeBulkBytes
+		r.Value = nil
+	case proto.TypeVerbatim:
+		/* Strip the "txt:" format prefix. */
+		r.Type = proto.TypeBulkBytes
+		r.Value = r.Value[4:]
+	}
+	for _, item := range r.Array {
+		replyToResp2(item)
+	}
+}
+
+// replyToResp3 RESP2的空批量回复和空多条批量回复在RESP3中均为null
+func replyToResp3(r *proto.Resp) {
+	switch r.Type {
+	case proto.TypeBulkBytes:
+		if r.Value == nil {
+			r.Type = proto.TypeNull
+		}
+	case proto.TypeArray:
+		if r.Array == nil {
+			r.Type = proto.TypeNull
+		}
+	}
+	for _, item := range r.Array {
+		replyToResp3(item)
+	}
+}
+
+/* Get the class of a client, used in order to enforce limits to different
+ * classes of clients. */
+func getClientType(c *Client) int {
+	if c.Flags&CLIENT_PUBSUB != 0 {
+		return CLIENT_TYPE_PUBSUB
+	}
+	return CLIENT_TYPE_NORMAL
+}
+
+/* The function checks if the client reached output buffer soft or hard
+ * limit, and also update the state needed to check the soft limit as
+ * a side effect.
+ *
+ * Return value: non-zero if the client reached the soft or the hard limit.
+ *               Otherwise zero is returned. */
+func checkClientOutputBufferLimits(c *Client) bool {
+	used := c.replyBytes
+	limits := c.server.ClientObufLimits[getClientType(c)]
+
+	hard := limits.HardLimitBytes != 0 && used >= limits.HardLimitBytes
+	soft := limits.SoftLimitBytes != 0 && used >= limits.SoftLimitBytes
+
+	/* We need to check if the soft limit is reached continuously for the
+	 * specified amount of seconds. */
+	if soft {
+		now := time.Now().Unix()
+		if c.obufSoftLimitReachedTime == 0 {
+			c.obufSoftLimitReachedTime = now
+			soft = false /* First time we see the soft limit reached */
+		} else {
+			elapsed := now - c.obufSoftLimitReachedTime
+			if elapsed <= limits.SoftLimitSeconds {
+				soft = false /* The client still did not reached the max number of
+				   seconds for the soft limit to be considered
+				   reached. */
+			}
+		}
+	} else {
+		c.obufSoftLimitReachedTime = 0
+	}
+	return soft || hard
+}
+
+/* Asynchronously close a client if soft or hard limit is reached on the
+ * output buffer size. The caller can check if the client will be closed
+ * checking if the client CLIENT_CLOSE_ASAP flag is set. */
+func closeClientOnOutputBufferLimitReached(c *Client) {
+	if c.replyBytes == 0 || c.Flags&CLIENT_CLOSE_ASAP != 0 {
+		return
+	}
+	if checkClientOutputBufferLimits(c) {
+		log.Println("Client scheduled to be closed ASAP for overcoming of output buffer limits.")
+		freeClientAsync(c)
+	}
+}
+
+/* Schedule a client to be closed: the pending replies are discarded and
+ * the connection is closed, so that a write goroutine blocked on a slow
+ * client returns as well. The client is freed once its read goroutine
+ * notices the closed connection. */
+func freeClientAsync(c *Client) {
+	c.Flags |= CLIENT_CLOSE_ASAP
+	c.reply = nil
+	c.replyBytes = 0
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	clientInstallWriteHandler(c)
+}
+
+// SendReplyToClient 写goroutine 将客户端输出缓冲区中的回复编码写入w
+// 每次取出全部等待发送的回复 编码后统一flush 写入出错或客户端被关闭时返回
+func (s *Server) SendReplyToClient(c *Client, w io.Writer) error {
+	e := proto.NewEncoderSize(w, PROTO_IOBUF_LEN)
+	for {
+		<-c.pending
+		var reply []*proto.Resp
+		var closeAsap bool
+		s.Exec(func() {
+			reply = c.reply
+			c.reply = nil
+			c.replyBytes = 0
+			closeAsap = c.Flags&CLIENT_CLOSE_ASAP != 0
+		})
+		for _, r := range reply {
+			if err := e.Encode(r, false); err != nil {
+				return err
+			}
+		}
+		if err := e.Flush(); err != nil {
+			return err
+		}
+		if closeAsap {
+			return nil
+		}
+	}
+}
+
+// ServeClient 处理一个客户端连接直到连接断开
+// 读取goroutine解析命令 写goroutine发送回复 命令交给事件循环串行执行
+func (s *Server) ServeClient(conn net.Conn) {
+	defer conn.Close()
+	var c *Client
+	s.Exec(func() { c = s.CreateClient(conn) })
+	queries := c.ReadQueries()
+	go func() {
+		// 写入出错或输出缓冲区超限时关闭连接 读取goroutine随之退出
+		defer conn.Close()
+		if err := s.SendReplyToClient(c, conn); err != nil {
+			log.Println("sendReplyToClient err", err)
+		}
+	}()
+	for argv := range queries {
+		var blocked bool
+		s.Exec(func() {
+			c.Argv = argv
+			c.Argc = len(argv)
+			s.ProcessCommand(c)
+			blocked = c.Flags&CLIENT_BLOCKED > 0
+		})
+		if blocked && !s.WaitUnblocked(c) {
+			// 连接在阻塞期间断开 与redis一样丢弃之后已读取的命令
+			for range queries {
+			}
+			break
+		}
+	}
+	s.Exec(func() { s.FreeClient(c) })
+}
+
+// validateClientName 客户端名字不能包含空格、换行等特殊字符
+func validateClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// HelloCommand 协商客户端使用的协议版本 并返回服务端的信息
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func HelloCommand(c *Client, s *Server) {
+	var ver int64
+	nextArg := 1
+
+	if c.Argc >= 2 {
+		if getLongLongFromObjectOrReply(c, c.Argv[nextArg], &ver,
+			"ERR Protocol version is not an integer or out of range") != C_OK {
+			return
+		}
+		nextArg++
+		if ver < 2 || ver > 3 {
+			addReplyError(c, "NOPROTO unsupported protocol version")
+			return
+		}
+	}
+
+	var clientname string
+	for j := nextArg; j < c.Argc; j++ {
+		moreargs := c.Argc - 1 - j
+		opt := c.Argv[j].Ptr.(string)
+		if strings.EqualFold(opt, "AUTH") && moreargs >= 2 {
+			/* There are no passwords in godis: only the default user exists
+			 * and any password is accepted for it. */
+			if c.Argv[j+1].Ptr.(string) != "default" {
+				addReplyError(c, "WRONGPASS invalid username-password pair or user is disabled.")
+				return
+			}
+			j += 2
+		} else if strings.EqualFold(opt, "SETNAME") && moreargs > 0 {
+			clientname = c.Argv[j+1].Ptr.(string)
+			if !validateClientName(clientname) {
+				addReplyError(c, "ERR Client names cannot contain spaces, newlines or special characters.")
+				return
+			}
+			j++
+		} else {
+			addReplyError(c, "ERR Syntax error in HELLO option '"+opt+"'")
+			return
+		}
+	}
+
+	/* Now that we're sure the options are valid, apply them. */
+	if clientname != "" {
+		c.Name = clientname
+	}
+	if ver != 0 {
+		c.Resp = int(ver)
+	}
+
+	/* Let's switch to the specified RESP mode and reply with the
+	 * server information as a map. */
+	addReplyMap(c, []*proto.Resp{
+		bulkString("server"), bulkString("godis"),
+		bulkString("version"), bulkString(GODIS_VERSION),
+		bulkString("proto"), proto.NewInt([]byte(strconv.Itoa(c.Resp))),
+		bulkString("id"), proto.NewInt([]byte(strconv.FormatInt(c.ID, 10))),
+		bulkString("mode"), bulkString("standalone"),
+		bulkString("role"), bulkString("master"),
+		bulkString("modules"), proto.NewArray([]*proto.Resp{}),
+	})
+}
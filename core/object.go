@@ -1,10 +1,18 @@
 package core
 
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
 // GodisObject 是对特定类型的数据的包装
 type GodisObject struct {
 	ObjectType int
-	//encoding   uint
-	Ptr interface{}
+	Encoding   int
+	Ptr        interface{}
+	snapshot   uint64 // 最近一次引用该对象的后台任务快照的编号 见lookupKeyWrite
 }
 
 const C_ERR = -1
@@ -34,3 +42,158 @@ func CreateObject(t int, ptr interface{}) (o *GodisObject) {
 	o.Ptr = ptr
 	return
 }
+
+// createStringObjectFromLongLong 创建整数编码的字符串对象
+func createStringObjectFromLongLong(value int64) *GodisObject {
+	o := CreateObject(ObjectTypeString, value)
+	o.Encoding = OBJ_ENCODING_INT
+	return o
+}
+
+/* Try to encode a string object in order to save space. Strings that can
+ * be represented as a 64 bit signed integer are stored as int64 so that
+ * INCR and friends don't parse the value again. A new object is returned
+ * as the original may still be referenced by the client argument vector. */
+func tryObjectEncoding(o *GodisObject) *GodisObject {
+	if o.ObjectType != ObjectTypeString || o.Encoding != OBJ_ENCODING_RAW {
+		return o
+	}
+	str := o.Ptr.(string)
+	/* Check if we can represent this string as a long integer.
+	 * Note that we are sure that a string larger than 20 chars is not
+	 * representable as a 64 bit integer. */
+	if len(str) <= 20 {
+		if value, ok := string2ll(str); ok {
+			return createStringObjectFromLongLong(value)
+		}
+	}
+	return CreateObject(ObjectTypeString, str)
+}
+
+// dupStringObject 复制字符串对象 保持原有的编码
+func dupStringObject(o *GodisObject) *GodisObject {
+	d := CreateObject(ObjectTypeString, o.Ptr)
+	d.Encoding = o.Encoding
+	return d
+}
+
+// dupObject 按类型复制对象 保持原有的编码
+func dupObject(o *GodisObject) *GodisObject {
+	switch o.ObjectType {
+	case ObjectTypeString:
+		return dupStringObject(o)
+	case OBJ_LIST:
+		return listTypeDup(o)
+	case OBJ_SET:
+		return setTypeDup(o)
+	case OBJ_ZSET:
+		return zsetDup(o)
+	case OBJ_HASH:
+		return hashTypeDup(o)
+	default:
+		panic("Unknown object type")
+	}
+}
+
+// getStringFromObject 获取字符串对象的值 整数编码的对象转换为字符串
+func getStringFromObject(o *GodisObject) string {
+	if o.Encoding == OBJ_ENCODING_INT {
+		return strconv.FormatInt(o.Ptr.(int64), 10)
+	}
+	return o.Ptr.(string)
+}
+
+// stringObjectLen 字符串对象的长度
+func stringObjectLen(o *GodisObject) int {
+	return len(getStringFromObject(o))
+}
+
+// getDoubleFromObject 将字符串对象解析为float64 NaN视为非法
+func getDoubleFromObject(o *GodisObject) (float64, error) {
+	if o == nil {
+		return 0, nil
+	}
+	if o.Encoding == OBJ_ENCODING_INT {
+		return float64(o.Ptr.(int64)), nil
+	}
+	str, ok := o.Ptr.(string)
+	if !ok {
+		return 0, errors.New("object is not a string")
+	}
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(value) {
+		return 0, errors.New("value is NaN")
+	}
+	return value, nil
+}
+
+func getDoubleFromObjectOrReply(c *Client, o *GodisObject, target *float64, msg string) int {
+	value, err := getDoubleFromObject(o)
+	if err != nil {
+		if msg == "" {
+			msg = errNotFloat
+		}
+		addReplyError(c, msg)
+		return C_ERR
+	}
+	*target = value
+	return C_OK
+}
+
+// getLongLongFromObject 将字符串对象解析为int64
+func getLongLongFromObject(o *GodisObject) (int64, error) {
+	if o == nil {
+		return 0, nil
+	}
+	if o.Encoding == OBJ_ENCODING_INT {
+		return o.Ptr.(int64), nil
+	}
+	str, ok := o.Ptr.(string)
+	if !ok {
+		return 0, errors.New("object is not a string")
+	}
+	value, ok := string2ll(str)
+	if !ok {
+		return 0, errors.New("value is not an integer or out of range")
+	}
+	return value, nil
+}
+
+func getLongLongFromObjectOrReply(c *Client, o *GodisObject, target *int64, msg string) int {
+	value, err := getLongLongFromObject(o)
+	if err != nil {
+		if msg == "" {
+			msg = errNotInteger
+		}
+		addReplyError(c, msg)
+		return C_ERR
+	}
+	*target = value
+	return C_OK
+}
+
+// formatHumanDouble 以小数形式格式化浮点数 不使用指数形式 去掉末尾多余的0 用于GEOPOS等
+func formatHumanDouble(f float64) string {
+	str := strconv.FormatFloat(f, 'f', 17, 64)
+	if strings.Contains(str, ".") {
+		str = strings.TrimRight(str, "0")
+		str = strings.TrimSuffix(str, ".")
+	}
+	return str
+}
+
+// formatDouble 与redis的%.17g输出保持一致 整数部分不使用科学计数法
+func formatDouble(f float64) string {
+	if math.IsInf(f, 1) {
+		return "inf"
+	} else if math.IsInf(f, -1) {
+		return "-inf"
+	}
+	if a := math.Abs(f); a != 0 && (a < 1e-4 || a >= 1e17) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
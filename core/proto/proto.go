@@ -19,6 +19,12 @@ var (
 
 	ErrBadMultiBulkLen     = errors.New("bad multi-bulk len")
 	ErrBadMultiBulkContent = errors.New("bad multi-bulk content, should be bulkbytes")
+
+	ErrBadRespType = errors.New("bad resp type")
+	ErrBadCRLFEnd  = errors.New("bad CRLF end")
+	ErrBadBoolean  = errors.New("bad boolean, should be t or f")
+	ErrBadNull     = errors.New("bad null, should be empty")
+	ErrBadVerbatim = errors.New("bad verbatim string, should start with the format")
 )
 
 const (
@@ -36,6 +42,17 @@ const (
 	TypeInt       = ':'
 	TypeBulkBytes = '$'
 	TypeArray     = '*'
+
+	// RESP3 新增的类型
+	TypeMap       = '%'
+	TypeSet       = '~'
+	TypeDouble    = ','
+	TypeBoolean   = '#'
+	TypeNull      = '_'
+	TypeBigNumber = '('
+	TypeVerbatim  = '='
+	TypePush      = '>'
+	TypeAttribute = '|'
 )
 
 // Btoi64 byte to int64
@@ -134,7 +151,7 @@ func (e *Encoder) EncodeMultiBulk(multi []*Resp, flush bool) error {
 	if err := e.encodeMultiBulk(multi); err != nil {
 		e.Err = err
 	} else if flush {
-		e.Err = errorsTrace(e.Err)
+		e.Err = errorsTrace(e.bw.Flush())
 	}
 	return e.Err
 }
@@ -164,20 +181,30 @@ func EncodeToBytes(r *Resp) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-// encodeResp 编码
+// encodeResp 编码 属性在回复之前以|类型发送
 func (e *Encoder) encodeResp(r *Resp) error {
+	if r.Attribute != nil {
+		if err := e.bw.WriteByte(byte(TypeAttribute)); err != nil {
+			return errorsTrace(err)
+		}
+		if err := e.encodeMap(r.Attribute); err != nil {
+			return err
+		}
+	}
 	if err := e.bw.WriteByte(byte(r.Type)); err != nil {
 		return errorsTrace(err)
 	}
 	switch r.Type {
-	case TypeString, TypeError, TypeInt:
+	case TypeString, TypeError, TypeInt, TypeDouble, TypeBoolean, TypeBigNumber, TypeNull:
 		return e.encodeTextBytes(r.Value)
-	case TypeBulkBytes:
+	case TypeBulkBytes, TypeVerbatim:
 		return e.encodeBulkBytes(r.Value)
-	case TypeArray:
+	case TypeArray, TypeSet, TypePush:
 		return e.encodeArray(r.Array)
+	case TypeMap:
+		return e.encodeMap(r.Array)
 	default:
-		return errorsTrace(e.Err)
+		return errorsTrace(ErrBadRespType)
 	}
 }
 
@@ -245,6 +272,19 @@ func (e *Encoder) encodeArray(array []*Resp) error {
 	}
 }
 
+// encodeMap encode map 键值交替存放 长度为键值对的个数
+func (e *Encoder) encodeMap(pairs []*Resp) error {
+	if err := e.encodeInt(int64(len(pairs) / 2)); err != nil {
+		return err
+	}
+	for _, r := range pairs {
+		if err := e.encodeResp(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 /*---- Decoder ----*/
 type Decoder struct {
 	br *bufio2.Reader
@@ -291,6 +331,11 @@ func (d *Decoder) DecodeMultiBulk() ([]*Resp, error) {
 	return m, err
 }
 
+// Offset 已经解析的字节数 即下一个回复的开始位置
+func (d *Decoder) Offset() int64 {
+	return d.br.Offset()
+}
+
 // Decode api
 func Decode(r io.Reader) (*Resp, error) {
 	return NewDecoder(r).Decode()
@@ -316,13 +361,39 @@ func (d *Decoder) decodeResp() (*Resp, error) {
 	r.Type = byte(b)
 	switch r.Type {
 	default:
-		return nil, errorsTrace(err)
-	case TypeString, TypeError, TypeInt:
+		return nil, errorsTrace(ErrBadRespType)
+	case TypeString, TypeError, TypeInt, TypeDouble, TypeBigNumber:
 		r.Value, err = d.decodeTextBytes()
+	case TypeBoolean:
+		if r.Value, err = d.decodeTextBytes(); err == nil && string(r.Value) != "t" && string(r.Value) != "f" {
+			return nil, errorsTrace(ErrBadBoolean)
+		}
+	case TypeNull:
+		if r.Value, err = d.decodeTextBytes(); err == nil && len(r.Value) != 0 {
+			return nil, errorsTrace(ErrBadNull)
+		}
+		r.Value = nil
 	case TypeBulkBytes:
 		r.Value, err = d.decodeBulkBytes()
-	case TypeArray:
+	case TypeVerbatim:
+		if r.Value, err = d.decodeBulkBytes(); err == nil && (len(r.Value) < 4 || r.Value[3] != ':') {
+			return nil, errorsTrace(ErrBadVerbatim)
+		}
+	case TypeArray, TypeSet, TypePush:
 		r.Array, err = d.decodeArray()
+	case TypeMap:
+		r.Array, err = d.decodeMap()
+	case TypeAttribute:
+		/* The attribute is followed by the reply it describes. */
+		attrs, err := d.decodeMap()
+		if err != nil {
+			return nil, err
+		}
+		if r, err = d.decodeResp(); err != nil {
+			return nil, err
+		}
+		r.Attribute = attrs
+		return r, nil
 	}
 	return r, err
 }
@@ -334,7 +405,7 @@ func (d *Decoder) decodeTextBytes() ([]byte, error) {
 		return nil, errorsTrace(err)
 	}
 	if n := len(b) - 2; n < 0 || b[n] != '\r' {
-		return nil, errorsTrace(err)
+		return nil, errorsTrace(ErrBadCRLFEnd)
 	} else {
 		return b[:n], nil
 	}
@@ -347,7 +418,7 @@ func (d *Decoder) decodeInt() (int64, error) {
 		return 0, errorsTrace(err)
 	}
 	if n := len(b) - 2; n < 0 || b[n] != '\r' {
-		return 0, errorsTrace(err)
+		return 0, errorsTrace(ErrBadCRLFEnd)
 	} else {
 		return Btoi64(b[:n])
 	}
@@ -361,9 +432,9 @@ func (d *Decoder) decodeBulkBytes() ([]byte, error) {
 	}
 	switch {
 	case n < -1:
-		return nil, errorsTrace(err)
+		return nil, errorsTrace(ErrBadBulkBytesLen)
 	case n > MaxBulkBytesLen:
-		return nil, errorsTrace(err)
+		return nil, errorsTrace(ErrBadBulkBytesLenTooLong)
 	case n == -1:
 		return nil, nil
 	}
@@ -372,7 +443,7 @@ func (d *Decoder) decodeBulkBytes() ([]byte, error) {
 		return nil, errorsTrace(err)
 	}
 	if b[n] != '\r' || b[n+1] != '\n' {
-		return nil, errorsTrace(err)
+		return nil, errorsTrace(ErrBadCRLFEnd)
 	}
 	return b[:n], nil
 }
@@ -385,9 +456,9 @@ func (d *Decoder) decodeArray() ([]*Resp, error) {
 	}
 	switch {
 	case n < -1:
-		return nil, errorsTrace(err)
+		return nil, errorsTrace(ErrBadArrayLen)
 	case n > MaxArrayLen:
-		return nil, errorsTrace(err)
+		return nil, errorsTrace(ErrBadArrayLenTooLong)
 	case n == -1:
 		return nil, nil
 	}
@@ -402,6 +473,29 @@ func (d *Decoder) decodeArray() ([]*Resp, error) {
 	return array, nil
 }
 
+// decodeMap decode map 返回键值交替存放的数组
+func (d *Decoder) decodeMap() ([]*Resp, error) {
+	n, err := d.decodeInt()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case n < 0:
+		return nil, errorsTrace(ErrBadArrayLen)
+	case n > MaxArrayLen/2:
+		return nil, errorsTrace(ErrBadArrayLenTooLong)
+	}
+	pairs := make([]*Resp, n*2)
+	for i := range pairs {
+		r, err := d.decodeResp()
+		if err != nil {
+			return nil, err
+		}
+		pairs[i] = r
+	}
+	return pairs, nil
+}
+
 func (d *Decoder) decodeSingleLineMultiBulk() ([]*Resp, error) {
 	b, err := d.decodeTextBytes()
 	if err != nil {
@@ -416,9 +510,6 @@ func (d *Decoder) decodeSingleLineMultiBulk() ([]*Resp, error) {
 			l = r + 1
 		}
 	}
-	if len(multi) == 0 {
-		return nil, errorsTrace(err)
-	}
 	return multi, nil
 }
 
@@ -459,11 +550,14 @@ func (d *Decoder) decodeMultiBulk() ([]*Resp, error) {
 }
 
 /*---- Response ----*/
+// Resp 一个回复 map和属性的键值交替存放在数组中
 type Resp struct {
 	Type byte
 
 	Value []byte
 	Array []*Resp
+
+	Attribute []*Resp // 附加在回复上的属性 为nil时不发送
 }
 
 func NewString(value []byte) *Resp {
@@ -502,6 +596,74 @@ func NewArray(array []*Resp) *Resp {
 	r.Array = array
 	return r
 }
+
+// NewMap map类型 pairs中键值交替存放
+func NewMap(pairs []*Resp) *Resp {
+	r := &Resp{}
+	r.Type = TypeMap
+	r.Array = pairs
+	return r
+}
+
+// NewSet 集合类型
+func NewSet(array []*Resp) *Resp {
+	r := &Resp{}
+	r.Type = TypeSet
+	r.Array = array
+	return r
+}
+
+// NewPush 服务端主动推送的数据 如pub/sub的消息
+func NewPush(array []*Resp) *Resp {
+	r := &Resp{}
+	r.Type = TypePush
+	r.Array = array
+	return r
+}
+
+// NewDouble 浮点数类型 value为inf、-inf或十进制表示
+func NewDouble(value []byte) *Resp {
+	r := &Resp{}
+	r.Type = TypeDouble
+	r.Value = value
+	return r
+}
+
+// NewBoolean 布尔类型
+func NewBoolean(b bool) *Resp {
+	r := &Resp{}
+	r.Type = TypeBoolean
+	if b {
+		r.Value = []byte("t")
+	} else {
+		r.Value = []byte("f")
+	}
+	return r
+}
+
+// NewNull 空值类型
+func NewNull() *Resp {
+	r := &Resp{}
+	r.Type = TypeNull
+	return r
+}
+
+// NewBigNumber 大数类型
+func NewBigNumber(value []byte) *Resp {
+	r := &Resp{}
+	r.Type = TypeBigNumber
+	r.Value = value
+	return r
+}
+
+// NewVerbatim 原样输出的字符串 format为三个字符的格式 如txt、mkd
+func NewVerbatim(format string, value []byte) *Resp {
+	r := &Resp{}
+	r.Type = TypeVerbatim
+	r.Value = append([]byte(format+":"), value...)
+	return r
+}
+
 func errorsTrace(err error) error {
 	if err != nil {
 		log.Println("errors Tracing", err.Error())
@@ -1,6 +1,9 @@
 package core
 
-import "strconv"
+import (
+	"godis/core/proto"
+	"strconv"
+)
 
 func SubscribeCommand(c *Client, s *Server) {
 	for j := 1; j < c.Argc; j++ {
@@ -10,24 +13,60 @@ func SubscribeCommand(c *Client, s *Server) {
 
 }
 
-func pubsubSubscribeChannel(c *Client, obj *GodisObject, s *Server) {
-	(*c.PubSubChannels)[obj.Ptr.(string)] = nil
-	de := (*(s.PubSubChannels))[obj.Ptr.(string)]
-	var clients *List
-	if de == nil {
-		clients = listCreate()
-		(*(s.PubSubChannels))[obj.Ptr.(string)] = clients
-	} else {
-		clients = de
+// pubsubSubscriptionCount 客户端订阅的频道数
+func pubsubSubscriptionCount(c *Client) int {
+	return len(*c.PubSubChannels)
+}
+
+/* Subscribe a client to a channel. Returns 1 if the operation succeeded, or
+ * 0 if the client was already subscribed to that channel. */
+func pubsubSubscribeChannel(c *Client, obj *GodisObject, s *Server) int {
+	retval := 0
+	/* Add the channel to the client -> channels hash dict */
+	if _, ok := (*c.PubSubChannels)[obj.Ptr.(string)]; !ok {
+		retval = 1
+		(*c.PubSubChannels)[obj.Ptr.(string)] = nil
+		de := (*(s.PubSubChannels))[obj.Ptr.(string)]
+		var clients *List
+		if de == nil {
+			clients = listCreate()
+			(*(s.PubSubChannels))[obj.Ptr.(string)] = clients
+		} else {
+			clients = de
+		}
+		clients.listAddNodeTail(c)
+	}
+	/* Notify the client */
+	addReplyPush(c, []*proto.Resp{
+		bulkString("subscribe"),
+		bulkString(obj.Ptr.(string)),
+		proto.NewInt([]byte(strconv.Itoa(pubsubSubscriptionCount(c)))),
+	})
+	return retval
+}
+
+// pubsubUnsubscribeAllChannels 退订客户端订阅的所有频道 没有订阅者的频道会被删除
+func pubsubUnsubscribeAllChannels(c *Client, s *Server) {
+	for channel := range *c.PubSubChannels {
+		clients := (*s.PubSubChannels)[channel]
+		if clients != nil {
+			if ln := clients.listSearchKey(c); ln != nil {
+				clients.listDelNode(ln)
+			}
+			if clients.listLength() == 0 {
+				delete(*s.PubSubChannels, channel)
+			}
+		}
+		delete(*c.PubSubChannels, channel)
 	}
-	clients.listAddNodeTail(c)
+	c.Flags &^= CLIENT_PUBSUB
 }
 
 func PublishCommand(c *Client, s *Server) {
 	receivers := pubsubPublishMessage(c.Argv[1], c.Argv[2], s)
 	//广播到其他集群上暂不支持
 	//aof存储暂不支持
-	addReplyStatus(c, strconv.Itoa(receivers))
+	addReplyLongLong(c, int64(receivers))
 }
 
 func pubsubPublishMessage(channel *GodisObject, message *GodisObject, s *Server) int {
@@ -36,7 +75,11 @@ func pubsubPublishMessage(channel *GodisObject, message *GodisObject, s *Server)
 	if de != nil {
 		for list := de.head; list != nil; list = list.next {
 			c := list.value.(*Client)
-			addReplyStatus(c, message.Ptr.(string))
+			addReplyPush(c, []*proto.Resp{
+				bulkString("message"),
+				bulkString(channel.Ptr.(string)),
+				bulkString(message.Ptr.(string)),
+			})
 			receivers++
 		}
 	}
@@ -0,0 +1,1581 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rdb快照 参考redis的rdb.c 文件格式与redis一致 可以使用redis的工具读取
+// 保存时字符串 列表 集合 有序集合 哈希分别以STRING LIST SET ZSET_2 HASH类型写入
+// 加载时还支持redis以ziplist listpack intset等紧凑编码保存的对象
+
+/* The current RDB version. When the format changes in a way that is no longer
+ * backward compatible this number gets incremented. */
+const RDB_VERSION = 9
+
+// 可以加载的最高版本 10和11只增加了godis已经支持的紧凑编码以及stream等类型
+const RDB_LOAD_MAX_VERSION = 11
+
+/* Defines related to the dump file format. To store 32 bits lengths for short
+ * keys requires a lot of space, so we check the most significant 2 bits of
+ * the first byte to interpreter the length:
+ *
+ * 00|XXXXXX => if the two MSB are 00 the len is the 6 bits of this byte
+ * 01|XXXXXX XXXXXXXX =>  01, the len is 14 bits, 6 bits + 8 bits of next byte
+ * 10|000000 [32 bit integer] => A full 32 bit len in net byte order will follow
+ * 10|000001 [64 bit integer] => A full 64 bit len in net byte order will follow
+ * 11|OBKIND this means: specially encoded object will follow. The six bits
+ *           number specify the kind of object that follows.
+ *           See the RDB_ENC_* defines.
+ *
+ * Lengths up to 63 are stored using a single byte, most DB keys, and may
+ * values, will fit inside. */
+const RDB_6BITLEN = 0
+const RDB_14BITLEN = 1
+const RDB_32BITLEN = 0x80
+const RDB_64BITLEN = 0x81
+const RDB_ENCVAL = 3
+
+/* When a length of a string object stored on disk has the first two bits
+ * set, the remaining six bits specify a special encoding for the object
+ * accordingly to the following defines: */
+const RDB_ENC_INT8 = 0  /* 8 bit signed integer */
+const RDB_ENC_INT16 = 1 /* 16 bit signed integer */
+const RDB_ENC_INT32 = 2 /* 32 bit signed integer */
+const RDB_ENC_LZF = 3   /* string compressed with FASTLZ */
+
+/* Map object types to RDB object types. Macros starting with OBJ_ are for
+ * memory storage and may change. Instead RDB types must be fixed because
+ * we store them on disk. */
+const RDB_TYPE_STRING = 0
+const RDB_TYPE_LIST = 1
+const RDB_TYPE_SET = 2
+const RDB_TYPE_ZSET = 3
+const RDB_TYPE_HASH = 4
+const RDB_TYPE_ZSET_2 = 5 /* ZSET version 2 with doubles stored in binary. */
+
+/* Object types for encoded objects. */
+const RDB_TYPE_HASH_ZIPMAP = 9
+const RDB_TYPE_LIST_ZIPLIST = 10
+const RDB_TYPE_SET_INTSET = 11
+const RDB_TYPE_ZSET_ZIPLIST = 12
+const RDB_TYPE_HASH_ZIPLIST = 13
+const RDB_TYPE_LIST_QUICKLIST = 14
+const RDB_TYPE_STREAM_LISTPACKS = 15
+const RDB_TYPE_HASH_LISTPACK = 16
+const RDB_TYPE_ZSET_LISTPACK = 17
+const RDB_TYPE_LIST_QUICKLIST_2 = 18
+const RDB_TYPE_SET_LISTPACK = 20
+
+/* Special RDB opcodes (saved/loaded with rdbSaveType/rdbLoadType). */
+const RDB_OPCODE_FUNCTION2 = 245     /* function library data */
+const RDB_OPCODE_FUNCTION = 246      /* old function library data for 7.0 rc1 and rc2 */
+const RDB_OPCODE_MODULE_AUX = 247    /* Module auxiliary data. */
+const RDB_OPCODE_IDLE = 248          /* LRU idle time. */
+const RDB_OPCODE_FREQ = 249          /* LFU frequency. */
+const RDB_OPCODE_AUX = 250           /* RDB aux field. */
+const RDB_OPCODE_RESIZEDB = 251      /* Hash table resize hint. */
+const RDB_OPCODE_EXPIRETIME_MS = 252 /* Expire time in milliseconds. */
+const RDB_OPCODE_EXPIRETIME = 253    /* Old expire time in seconds. */
+const RDB_OPCODE_SELECTDB = 254      /* DB number of the following keys. */
+const RDB_OPCODE_EOF = 255           /* End of the RDB file. */
+
+/* Quicklist node container formats */
+const QUICKLIST_NODE_CONTAINER_PLAIN = 1
+const QUICKLIST_NODE_CONTAINER_PACKED = 2
+
+// rdb的标志
+const RDBFLAGS_NONE = 0
+const RDBFLAGS_AOF_PREAMBLE = (1 << 0) /* The RDB is part of an AOF file. */
+
+/* When CONFIG_BGSAVE_RETRY_DELAY seconds passed since the last failed
+ * BGSAVE, a new automatic BGSAVE is attempted. */
+const CONFIG_BGSAVE_RETRY_DELAY = 5
+
+// 保存时长度超过该值的字符串尝试LZF压缩
+const RDB_LZF_MIN_LEN = 20
+
+// saveParam 自动保存的条件 seconds秒内至少有changes次修改
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+/*-----------------------------------------------------------------------------
+ * Saving
+ *----------------------------------------------------------------------------*/
+
+// rdbSaveType 写入对象的类型或操作码
+func rdbSaveType(r *rio, typ byte) {
+	r.WriteByte(typ)
+}
+
+// rdbSaveMillisecondTime 以小端64位整数写入毫秒时间戳
+func rdbSaveMillisecondTime(r *rio, t int64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(t))
+	r.Write(buf[:])
+}
+
+/* Saves an encoded length. The first two bits in the first byte are used to
+ * hold the encoding type. See the RDB_* definitions for more information
+ * on the types of encoding. */
+func rdbSaveLen(r *rio, l uint64) {
+	var buf [9]byte
+	if l < (1 << 6) {
+		/* Save a 6 bit len */
+		r.WriteByte(byte(l) | (RDB_6BITLEN << 6))
+	} else if l < (1 << 14) {
+		/* Save a 14 bit len */
+		r.WriteByte(byte(l>>8) | (RDB_14BITLEN << 6))
+		r.WriteByte(byte(l))
+	} else if l <= math.MaxUint32 {
+		/* Save a 32 bit len */
+		buf[0] = RDB_32BITLEN
+		binary.BigEndian.PutUint32(buf[1:5], uint32(l))
+		r.Write(buf[:5])
+	} else {
+		/* Save a 64 bit len */
+		buf[0] = RDB_64BITLEN
+		binary.BigEndian.PutUint64(buf[1:], l)
+		r.Write(buf[:])
+	}
+}
+
+/* Encodes the "value" argument as integer when it fits in the supported ranges
+ * for encoded types. If the function successfully encodes the integer, the
+ * representation is returned, otherwise nil is returned. */
+func rdbEncodeInteger(value int64) []byte {
+	if value >= -(1<<7) && value <= (1<<7)-1 {
+		return []byte{(RDB_ENCVAL << 6) | RDB_ENC_INT8, byte(value)}
+	} else if value >= -(1<<15) && value <= (1<<15)-1 {
+		return []byte{(RDB_ENCVAL << 6) | RDB_ENC_INT16, byte(value), byte(value >> 8)}
+	} else if value >= -(1<<31) && value <= (1<<31)-1 {
+		return []byte{(RDB_ENCVAL << 6) | RDB_ENC_INT32, byte(value), byte(value >> 8),
+			byte(value >> 16), byte(value >> 24)}
+	}
+	return nil
+}
+
+/* String objects in the form "2391" "-100" without any space and with a
+ * range of values that can fit in an 8, 16 or 32 bit signed value can be
+ * encoded as integers to save space */
+func rdbTryIntegerEncoding(s string) []byte {
+	value, ok := string2ll(s)
+	if !ok {
+		return nil
+	}
+	/* If the number converted back into a string is not identical
+	 * then it's not possible to encode the string as integer */
+	if strconv.FormatInt(value, 10) != s {
+		return nil
+	}
+	return rdbEncodeInteger(value)
+}
+
+/* Save a string object as [len][data] on disk. If the object is a string
+ * representation of an integer value we try to save it in a special form */
+func rdbSaveRawString(r *rio, str string) {
+	/* Try integer encoding */
+	if len(str) <= 11 {
+		if enc := rdbTryIntegerEncoding(str); enc != nil {
+			r.Write(enc)
+			return
+		}
+	}
+
+	/* Try LZF compression - under 20 bytes it's unable to compress even
+	 * aaaaaaaaaaaaaaaaaa so skip it */
+	if r.rdbCompression && len(str) > RDB_LZF_MIN_LEN {
+		if comp := lzfCompress([]byte(str), len(str)-4); comp != nil {
+			r.WriteByte((RDB_ENCVAL << 6) | RDB_ENC_LZF)
+			rdbSaveLen(r, uint64(len(comp)))
+			rdbSaveLen(r, uint64(len(str)))
+			r.Write(comp)
+			return
+		}
+	}
+
+	/* Store verbatim */
+	rdbSaveLen(r, uint64(len(str)))
+	r.WriteString(str)
+}
+
+// rdbSaveStringObject 写入字符串对象 整数编码的对象直接以整数形式写入
+func rdbSaveStringObject(r *rio, o *GodisObject) {
+	if o.Encoding == OBJ_ENCODING_INT {
+		value := o.Ptr.(int64)
+		if enc := rdbEncodeInteger(value); enc != nil {
+			r.Write(enc)
+			return
+		}
+		rdbSaveRawString(r, strconv.FormatInt(value, 10))
+		return
+	}
+	rdbSaveRawString(r, o.Ptr.(string))
+}
+
+/* Saves a double for RDB 8 or greater, where IE754 binary64 format is assumed.
+ * We just make sure the integer is always stored in little endian. */
+func rdbSaveBinaryDoubleValue(r *rio, val float64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(val))
+	r.Write(buf[:])
+}
+
+/* Save the object type of object "o". */
+func rdbSaveObjectType(r *rio, o *GodisObject) {
+	switch o.ObjectType {
+	case ObjectTypeString:
+		rdbSaveType(r, RDB_TYPE_STRING)
+	case OBJ_LIST:
+		rdbSaveType(r, RDB_TYPE_LIST)
+	case OBJ_SET:
+		rdbSaveType(r, RDB_TYPE_SET)
+	case OBJ_ZSET:
+		rdbSaveType(r, RDB_TYPE_ZSET_2)
+	case OBJ_HASH:
+		rdbSaveType(r, RDB_TYPE_HASH)
+	default:
+		panic("Unknown object type")
+	}
+}
+
+/* Save a Godis object. */
+func rdbSaveObject(r *rio, o *GodisObject) {
+	switch o.ObjectType {
+	case ObjectTypeString:
+		/* Save a string value */
+		rdbSaveStringObject(r, o)
+	case OBJ_LIST:
+		/* Save a list value */
+		rdbSaveLen(r, uint64(listTypeLength(o)))
+		for ln := o.Ptr.(*List).listFirst(); ln != nil; ln = ln.listNextNode() {
+			rdbSaveRawString(r, ln.listNodeValue().(string))
+		}
+	case OBJ_SET:
+		/* Save a set value */
+		rdbSaveLen(r, uint64(setTypeSize(o)))
+		setTypeForEachUnsafe(o, func(ele string) bool {
+			rdbSaveRawString(r, ele)
+			return true
+		})
+	case OBJ_ZSET:
+		/* Save a sorted set value */
+		zsl := o.Ptr.(*zSet).zsl
+		rdbSaveLen(r, uint64(zsl.length))
+
+		/* We save the skiplist elements from the greatest to the smallest
+		 * (that's trivial since the elements are already ordered in the
+		 * skiplist): this improves the load process, since the next loaded
+		 * element will always be the smaller, so adding to the skiplist
+		 * will always immediately stop at the head, making the insertion
+		 * O(1) instead of O(log(N)). */
+		for zn := zsl.tail; zn != nil; zn = zn.backward {
+			rdbSaveRawString(r, zn.ele)
+			rdbSaveBinaryDoubleValue(r, zn.score)
+		}
+	case OBJ_HASH:
+		/* Save a hash value */
+		rdbSaveLen(r, uint64(hashTypeLength(o)))
+		hashTypeForEachUnsafe(o, func(field string, value string) bool {
+			rdbSaveRawString(r, field)
+			rdbSaveRawString(r, value)
+			return true
+		})
+	default:
+		panic("Unknown object type")
+	}
+}
+
+/* Save a key-value pair, with expire time, type, key, value. */
+func rdbSaveKeyValuePair(r *rio, key string, val *GodisObject, expiretime int64) {
+	/* Save the expire time */
+	if expiretime != -1 {
+		rdbSaveType(r, RDB_OPCODE_EXPIRETIME_MS)
+		rdbSaveMillisecondTime(r, expiretime)
+	}
+
+	/* Save type, key, value */
+	rdbSaveObjectType(r, val)
+	rdbSaveRawString(r, key)
+	rdbSaveObject(r, val)
+}
+
+/* Save an AUX field. */
+func rdbSaveAuxField(r *rio, key string, val string) {
+	rdbSaveType(r, RDB_OPCODE_AUX)
+	rdbSaveRawString(r, key)
+	rdbSaveRawString(r, val)
+}
+
+/* Wrapper for rdbSaveAuxField() used when the value is an integer. */
+func rdbSaveAuxFieldStrInt(r *rio, key string, val int64) {
+	rdbSaveAuxField(r, key, strconv.FormatInt(val, 10))
+}
+
+/* Save a few default AUX fields with information about the RDB generated. */
+func rdbSaveInfoAuxFields(r *rio, rdbflags int) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	aofBase := int64(0)
+	if rdbflags&RDBFLAGS_AOF_PREAMBLE != 0 {
+		aofBase = 1
+	}
+
+	/* Add a few fields about the state when the RDB was created. */
+	rdbSaveAuxField(r, "redis-ver", GODIS_VERSION)
+	rdbSaveAuxFieldStrInt(r, "redis-bits", strconv.IntSize)
+	rdbSaveAuxFieldStrInt(r, "ctime", time.Now().Unix())
+	rdbSaveAuxFieldStrInt(r, "used-mem", int64(m.HeapAlloc))
+	rdbSaveAuxFieldStrInt(r, "aof-base", aofBase)
+}
+
+// dbSnapshot 创建快照时一个db中所有的key 值和过期时间 没有过期时间时为-1
+type dbSnapshot struct {
+	id         int
+	keys       []string
+	vals       []*GodisObject
+	expires    []int64
+	expiresNum int
+}
+
+/* The point in time view of the dataset that is written by SAVE, BGSAVE and
+ * the AOF rewrite. Only the top level dicts are copied: for a background
+ * goroutine the values are marked as shared with the snapshot and the
+ * event loop duplicates them before any modification, see lookupKeyWrite().
+ * The configuration used while saving is copied as well, since the
+ * goroutine can't read the server. */
+type snapshot struct {
+	dbs            []*dbSnapshot
+	rdbCompression bool
+	rdbChecksum    bool
+	kill           chan struct{} // 关闭时放弃保存 见killRDBChild
+}
+
+// 后台任务被终止时返回的错误
+var errChildKilled = errors.New("killed by the server")
+
+/* Take the snapshot of the dataset in the event loop. When the snapshot
+ * is going to be read by a background goroutine (shared is true) the
+ * values are marked as shared with it. */
+func createSnapshot(s *Server, shared bool) *snapshot {
+	snap := &snapshot{
+		rdbCompression: s.RdbCompression,
+		rdbChecksum:    s.RdbChecksum,
+		kill:           make(chan struct{}),
+	}
+	if shared {
+		s.snapshotID++
+	}
+	for j := 0; j < s.DbNum; j++ {
+		db := s.Db[j]
+		if db.Dict.dictSize() == 0 {
+			continue
+		}
+		size := db.Dict.dictSize()
+		ds := &dbSnapshot{
+			id:         j,
+			keys:       make([]string, 0, size),
+			vals:       make([]*GodisObject, 0, size),
+			expires:    make([]int64, 0, size),
+			expiresNum: db.Expires.dictSize(),
+		}
+		db.Dict.dictForEach(func(de *dictEntry) bool {
+			if shared {
+				objectMarkShared(s, de.val)
+			}
+			expire := int64(-1)
+			if ds.expiresNum > 0 {
+				expire = getExpire(db, CreateObject(ObjectTypeString, de.key))
+			}
+			ds.keys = append(ds.keys, de.key)
+			ds.vals = append(ds.vals, de.val)
+			ds.expires = append(ds.expires, expire)
+			return true
+		})
+		snap.dbs = append(snap.dbs, ds)
+	}
+	return snap
+}
+
+/* Mark the value as shared with the snapshot of the background goroutine.
+ * The goroutine iterates the dicts of sets and hashes without writing them,
+ * while a lookup in the event loop performs a rehashing step: so the
+ * rehashing is completed now, and can't start again since a shared value
+ * is never modified. */
+func objectMarkShared(s *Server, o *GodisObject) {
+	o.snapshot = s.snapshotID
+	if d, ok := o.Ptr.(*dict); ok {
+		for d.dictRehash(100) {
+		}
+	}
+}
+
+/* Return true if the value is read by the running background goroutine,
+ * so it can't be modified in place. */
+func objectIsShared(s *Server, o *GodisObject) bool {
+	return hasActiveChildProcess(s) && o.snapshot == s.snapshotID
+}
+
+/* Produces a dump of the snapshot in RDB format writing it to r. Returns
+ * the first write error, or errChildKilled if the snapshot was killed in
+ * the meantime. */
+func rdbSaveRio(snap *snapshot, r *rio, rdbflags int) error {
+	r.updateCksum = snap.rdbChecksum
+	r.WriteString(fmt.Sprintf("REDIS%04d", RDB_VERSION))
+	rdbSaveInfoAuxFields(r, rdbflags)
+
+	for _, ds := range snap.dbs {
+		/* Write the SELECT DB opcode */
+		rdbSaveType(r, RDB_OPCODE_SELECTDB)
+		rdbSaveLen(r, uint64(ds.id))
+
+		/* Write the RESIZE DB opcode. */
+		rdbSaveType(r, RDB_OPCODE_RESIZEDB)
+		rdbSaveLen(r, uint64(len(ds.keys)))
+		rdbSaveLen(r, uint64(ds.expiresNum))
+
+		/* Iterate this DB writing every entry */
+		for i, key := range ds.keys {
+			if err := snapshotCheck(snap, r); err != nil {
+				return err
+			}
+			rdbSaveKeyValuePair(r, key, ds.vals[i], ds.expires[i])
+		}
+	}
+
+	/* EOF opcode */
+	rdbSaveType(r, RDB_OPCODE_EOF)
+
+	/* CRC64 checksum. It will be zero if checksum computation is disabled, the
+	 * loading code skips the check in this case. */
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], r.cksum)
+	r.updateCksum = false
+	r.Write(buf[:])
+	return r.err
+}
+
+/* Called for every key written: return the write error if any, so that the
+ * save stops at once, or errChildKilled when the snapshot was killed. */
+func snapshotCheck(snap *snapshot, r *rio) error {
+	if r.err != nil {
+		return r.err
+	}
+	select {
+	case <-snap.kill:
+		return errChildKilled
+	default:
+		return nil
+	}
+}
+
+// rdbTempFileName 保存rdb时使用的临时文件 写入完成后重命名为rdb文件
+func rdbTempFileName(s *Server) string {
+	return fmt.Sprintf("temp-%d.rdb", s.Pid)
+}
+
+// rdbRemoveTempFile 删除未完成的临时rdb文件
+func rdbRemoveTempFile(s *Server) {
+	os.Remove(rdbTempFileName(s))
+}
+
+/* Write the snapshot to a temp file, fsync it and rename it on the final
+ * destination, so that the RDB file is always either the old or the new
+ * snapshot. Called from the background goroutine as well, so it only uses
+ * its arguments. */
+func rdbSaveFile(snap *snapshot, filename string, tmpfile string) error {
+	f, err := os.OpenFile(tmpfile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		cwd, _ := os.Getwd()
+		return fmt.Errorf("Failed opening the temp RDB file %s (in server root dir %s) for saving: %s",
+			tmpfile, cwd, strerror(err))
+	}
+
+	r := newRio(f, snap.rdbCompression)
+	err = rdbSaveRio(snap, r, RDBFLAGS_NONE)
+
+	/* Make sure data will not remain on the OS's output buffers */
+	if err == nil {
+		err = r.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpfile)
+		if err == errChildKilled {
+			return err
+		}
+		return fmt.Errorf("Write error saving DB on disk: %s", strerror(err))
+	}
+
+	/* Use RENAME to make sure the DB file is changed atomically only
+	 * if the generate DB file is ok. */
+	if err := os.Rename(tmpfile, filename); err != nil {
+		cwd, _ := os.Getwd()
+		os.Remove(tmpfile)
+		return fmt.Errorf("Error moving temp DB file %s on the final destination %s (in server root dir %s): %s",
+			tmpfile, filename, cwd, strerror(err))
+	}
+	return nil
+}
+
+/* Save the DB on disk. Return C_ERR on error, C_OK on success. */
+func rdbSave(s *Server, filename string) int {
+	if err := rdbSaveFile(createSnapshot(s, false), filename, rdbTempFileName(s)); err != nil {
+		serverLog(s, LL_WARNING, "%s", err)
+		return C_ERR
+	}
+
+	serverLog(s, LL_NOTICE, "DB saved on disk")
+	s.Dirty = 0
+	s.Lastsave = time.Now().Unix()
+	s.LastbgsaveStatus = C_OK
+	return C_OK
+}
+
+/* Save the DB in background: the snapshot is taken in the event loop, then
+ * a goroutine writes it on disk like the child process of Redis does,
+ * while the event loop keeps serving the clients. */
+func rdbSaveBackground(s *Server, filename string) int {
+	if hasActiveChildProcess(s) {
+		return C_ERR
+	}
+	s.DirtyBeforeBgsave = s.Dirty
+	s.LastbgsaveTry = time.Now().Unix()
+
+	snap := createSnapshot(s, true)
+	serverLog(s, LL_NOTICE, "Background saving started")
+	s.RdbSaveTimeStart = time.Now().Unix()
+	s.ChildType = CHILD_TYPE_RDB
+	s.childDone = make(chan error, 1)
+	s.childKill = snap.kill
+
+	tmpfile := rdbTempFileName(s)
+	done := s.childDone
+	go func() {
+		done <- rdbSaveFile(snap, filename, tmpfile)
+	}()
+	return C_OK
+}
+
+/* A background saving goroutine (BGSAVE) terminated its work. Handle this. */
+func backgroundSaveDoneHandler(s *Server, err error) {
+	now := time.Now().Unix()
+	if err == nil {
+		serverLog(s, LL_NOTICE, "Background saving terminated with success")
+		s.Dirty = s.Dirty - s.DirtyBeforeBgsave
+		s.Lastsave = now
+		s.LastbgsaveStatus = C_OK
+	} else {
+		serverLog(s, LL_WARNING, "Background saving error: %s", err)
+		s.LastbgsaveStatus = C_ERR
+	}
+	s.RdbSaveTimeLast = now - s.RdbSaveTimeStart
+	s.RdbSaveTimeStart = -1
+}
+
+/* Kill the RDB saving child. The goroutine stops at the next key, we wait
+ * for it and remove the temp file it may have left. */
+func killRDBChild(s *Server) {
+	if s.ChildType != CHILD_TYPE_RDB {
+		return
+	}
+	close(s.childKill)
+	<-s.childDone
+	rdbRemoveTempFile(s)
+	s.RdbSaveTimeStart = -1
+	resetChildState(s)
+}
+
+// SaveCommand 在前台保存rdb 保存期间不处理其它命令
+func SaveCommand(c *Client, s *Server) {
+	if s.ChildType == CHILD_TYPE_RDB {
+		addReplyError(c, "ERR Background save already in progress")
+		return
+	}
+	if rdbSave(s, s.RdbFilename) == C_OK {
+		addReplyStatus(c, "OK")
+	} else {
+		addReplyError(c, "ERR")
+	}
+}
+
+/* BGSAVE [SCHEDULE] */
+func BgsaveCommand(c *Client, s *Server) {
+	schedule := false
+
+	/* The SCHEDULE option changes the behavior of BGSAVE when an AOF rewrite
+	 * is in progress. Instead of returning an error a BGSAVE gets scheduled. */
+	if c.Argc > 1 {
+		if c.Argc == 2 && strings.EqualFold(c.Argv[1].Ptr.(string), "schedule") {
+			schedule = true
+		} else {
+			addReplyError(c, errSyntax)
+			return
+		}
+	}
+
+	if s.ChildType == CHILD_TYPE_RDB {
+		addReplyError(c, "ERR Background save already in progress")
+	} else if hasActiveChildProcess(s) {
+		if schedule {
+			s.RdbBgsaveScheduled = true
+			addReplyStatus(c, "Background saving scheduled")
+		} else {
+			addReplyError(c, "ERR Another child process is active (AOF?): can't BGSAVE right now. "+
+				"Use BGSAVE SCHEDULE in order to schedule a BGSAVE whenever possible.")
+		}
+	} else if rdbSaveBackground(s, s.RdbFilename) == C_OK {
+		addReplyStatus(c, "Background saving started")
+	} else {
+		addReplyError(c, "ERR")
+	}
+}
+
+// LastsaveCommand 上一次成功保存rdb的时间 单位秒
+func LastsaveCommand(c *Client, s *Server) {
+	addReplyLongLong(c, s.Lastsave)
+}
+
+/*-----------------------------------------------------------------------------
+ * Loading
+ *----------------------------------------------------------------------------*/
+
+// rdbReader 读取rdb 同时计算已读取内容的校验和
+type rdbReader struct {
+	r         *bufio.Reader
+	cksum     uint64
+	processed int64 // 已读取的字节数
+}
+
+func newRdbReader(r io.Reader) *rdbReader {
+	return &rdbReader{r: bufio.NewReader(r)}
+}
+
+// 一次分配的最大长度 更长的内容边读取边扩容 避免损坏的长度导致分配过多的内存
+const RDB_LOAD_CHUNK = 1024 * 1024
+
+// read 读取n个字节 文件不完整时返回io.ErrUnexpectedEOF或io.EOF
+func (rdb *rdbReader) read(n uint64) ([]byte, error) {
+	var buf []byte
+	if n <= RDB_LOAD_CHUNK {
+		buf = make([]byte, n)
+		if _, err := io.ReadFull(rdb.r, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		var b bytes.Buffer
+		if _, err := io.CopyN(&b, rdb.r, int64(n)); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		buf = b.Bytes()
+	}
+	rdb.cksum = crc64Update(rdb.cksum, buf)
+	rdb.processed += int64(len(buf))
+	return buf, nil
+}
+
+// rdbLoadType 读取对象的类型或操作码
+func rdbLoadType(rdb *rdbReader) (byte, error) {
+	buf, err := rdb.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+/* This is only used to load old databases stored with the RDB_OPCODE_EXPIRETIME
+ * opcode. New versions of Redis store using the RDB_OPCODE_EXPIRETIME_MS
+ * opcode. */
+func rdbLoadTime(rdb *rdbReader) (int64, error) {
+	buf, err := rdb.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int64(int32(binary.LittleEndian.Uint32(buf))), nil
+}
+
+// rdbLoadMillisecondTime 读取小端64位的毫秒时间戳
+func rdbLoadMillisecondTime(rdb *rdbReader) (int64, error) {
+	buf, err := rdb.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+/* Load an encoded length. If the loaded length is a normal length as stored
+ * with rdbSaveLen(), the read length is returned, otherwise if the encoded
+ * length is a RDB_ENC_* encoding (the object is an integer or a compressed
+ * string) isencoded is set to true and the encoding type is returned. */
+func rdbLoadLenByRef(rdb *rdbReader) (uint64, bool, error) {
+	buf, err := rdb.read(1)
+	if err != nil {
+		return 0, false, err
+	}
+	typ := (buf[0] & 0xC0) >> 6
+	if typ == RDB_ENCVAL {
+		/* Read a 6 bit encoding type. */
+		return uint64(buf[0] & 0x3F), true, nil
+	} else if typ == RDB_6BITLEN {
+		/* Read a 6 bit len. */
+		return uint64(buf[0] & 0x3F), false, nil
+	} else if typ == RDB_14BITLEN {
+		/* Read a 14 bit len. */
+		next, err := rdb.read(1)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(buf[0]&0x3F)<<8 | uint64(next[0]), false, nil
+	} else if buf[0] == RDB_32BITLEN {
+		/* Read a 32 bit len. */
+		next, err := rdb.read(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(next)), false, nil
+	} else if buf[0] == RDB_64BITLEN {
+		/* Read a 64 bit len. */
+		next, err := rdb.read(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(next), false, nil
+	}
+	return 0, false, fmt.Errorf("Unknown length encoding %d in rdbLoadLen()", typ)
+}
+
+/* This is like rdbLoadLenByRef() but directly returns the value read, an
+ * encoded length is an error here. */
+func rdbLoadLen(rdb *rdbReader) (uint64, error) {
+	l, isencoded, err := rdbLoadLenByRef(rdb)
+	if err == nil && isencoded {
+		err = fmt.Errorf("Unexpected encoded length %d in rdbLoadLen()", l)
+	}
+	return l, err
+}
+
+/* Loads an integer-encoded object with the specified encoding type "enctype". */
+func rdbLoadIntegerObject(rdb *rdbReader, enctype uint64) (string, error) {
+	var val int64
+	switch enctype {
+	case RDB_ENC_INT8:
+		buf, err := rdb.read(1)
+		if err != nil {
+			return "", err
+		}
+		val = int64(int8(buf[0]))
+	case RDB_ENC_INT16:
+		buf, err := rdb.read(2)
+		if err != nil {
+			return "", err
+		}
+		val = int64(int16(binary.LittleEndian.Uint16(buf)))
+	case RDB_ENC_INT32:
+		buf, err := rdb.read(4)
+		if err != nil {
+			return "", err
+		}
+		val = int64(int32(binary.LittleEndian.Uint32(buf)))
+	default:
+		return "", fmt.Errorf("Unknown RDB integer encoding type %d", enctype)
+	}
+	return strconv.FormatInt(val, 10), nil
+}
+
+/* Load an LZF compressed string in RDB format. */
+func rdbLoadLzfStringObject(rdb *rdbReader) (string, error) {
+	clen, err := rdbLoadLen(rdb)
+	if err != nil {
+		return "", err
+	}
+	l, err := rdbLoadLen(rdb)
+	if err != nil {
+		return "", err
+	}
+	c, err := rdb.read(clen)
+	if err != nil {
+		return "", err
+	}
+	val, err := lzfDecompress(c, int(l))
+	if err != nil {
+		return "", err
+	}
+	return string(val), nil
+}
+
+/* Load a string object from an RDB file, decoding the integer and the LZF
+ * compressed encodings. */
+func rdbLoadString(rdb *rdbReader) (string, error) {
+	l, isencoded, err := rdbLoadLenByRef(rdb)
+	if err != nil {
+		return "", err
+	}
+	if isencoded {
+		switch l {
+		case RDB_ENC_INT8, RDB_ENC_INT16, RDB_ENC_INT32:
+			return rdbLoadIntegerObject(rdb, l)
+		case RDB_ENC_LZF:
+			return rdbLoadLzfStringObject(rdb)
+		default:
+			return "", fmt.Errorf("Unknown RDB string encoding type %d", l)
+		}
+	}
+	buf, err := rdb.read(l)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+/* For information about double serialization check rdbSaveDoubleValue() */
+func rdbLoadDoubleValue(rdb *rdbReader) (float64, error) {
+	buf, err := rdb.read(1)
+	if err != nil {
+		return 0, err
+	}
+	switch buf[0] {
+	case 255:
+		return math.Inf(-1), nil
+	case 254:
+		return math.Inf(1), nil
+	case 253:
+		return math.NaN(), nil
+	}
+	str, err := rdb.read(uint64(buf[0]))
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.ParseFloat(string(str), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid double value '%s'", str)
+	}
+	return val, nil
+}
+
+/* Loads a double from RDB 8 or greater. See rdbSaveBinaryDoubleValue() for
+ * more info. */
+func rdbLoadBinaryDoubleValue(rdb *rdbReader) (float64, error) {
+	buf, err := rdb.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// 紧凑编码的对象损坏时的错误
+var errRdbCorruptedEncoding = errors.New("Corrupted ziplist, listpack or intset encoded object")
+
+/* Decode the elements of an intset blob: a 32 bit encoding (the size of the
+ * integers), a 32 bit length and the integers, all little endian. */
+func intsetBlobEntries(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, errRdbCorruptedEncoding
+	}
+	enc := uint64(binary.LittleEndian.Uint32(b[0:4]))
+	count := uint64(binary.LittleEndian.Uint32(b[4:8]))
+	if (enc != 2 && enc != 4 && enc != 8) || uint64(len(b)-8) != enc*count {
+		return nil, errRdbCorruptedEncoding
+	}
+	entries := make([]string, 0, count)
+	for p := b[8:]; len(p) > 0; p = p[enc:] {
+		var v int64
+		switch enc {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(p)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(p))
+		}
+		entries = append(entries, strconv.FormatInt(v, 10))
+	}
+	return entries, nil
+}
+
+/* Decode the entries of a ziplist blob. The layout is:
+ *
+ * <zlbytes> <zltail> <zllen> <entry> <entry> ... <entry> <zlend>
+ *
+ * and every entry is <prevlen> <encoding> <entry-data>, where strings have
+ * a 6, 14 or 32 bit length in the encoding and integers are stored little
+ * endian with 8, 16, 24, 32 or 64 bits, or as 4 bits immediate values. */
+func ziplistBlobEntries(b []byte) ([]string, error) {
+	if len(b) < 11 || int(binary.LittleEndian.Uint32(b[0:4])) != len(b) {
+		return nil, errRdbCorruptedEncoding
+	}
+	var entries []string
+	p := 10
+	for {
+		if p >= len(b) {
+			return nil, errRdbCorruptedEncoding
+		}
+		if b[p] == 0xFF {
+			break
+		}
+
+		/* Skip the length of the previous entry. */
+		if b[p] == 0xFE {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(b) {
+			return nil, errRdbCorruptedEncoding
+		}
+
+		enc := b[p]
+		var strlen, intlen int
+		switch {
+		case enc>>6 == 0:
+			strlen = int(enc & 0x3F)
+			p++
+		case enc>>6 == 1:
+			if p+2 > len(b) {
+				return nil, errRdbCorruptedEncoding
+			}
+			strlen = int(enc&0x3F)<<8 | int(b[p+1])
+			p += 2
+		case enc>>6 == 2:
+			if p+5 > len(b) {
+				return nil, errRdbCorruptedEncoding
+			}
+			strlen = int(binary.BigEndian.Uint32(b[p+1 : p+5]))
+			p += 5
+		case enc == 0xC0:
+			intlen = 2
+		case enc == 0xD0:
+			intlen = 4
+		case enc == 0xE0:
+			intlen = 8
+		case enc == 0xF0:
+			intlen = 3
+		case enc == 0xFE:
+			intlen = 1
+		case enc >= 0xF1 && enc <= 0xFD:
+			/* 4 bit immediate integer from 0 to 12 */
+			entries = append(entries, strconv.Itoa(int(enc&0x0F)-1))
+			p++
+			continue
+		default:
+			return nil, errRdbCorruptedEncoding
+		}
+
+		if intlen > 0 {
+			p++
+			if p+intlen > len(b) {
+				return nil, errRdbCorruptedEncoding
+			}
+			entries = append(entries, strconv.FormatInt(littleEndianSigned(b[p:p+intlen]), 10))
+			p += intlen
+		} else {
+			if strlen < 0 || p+strlen > len(b) {
+				return nil, errRdbCorruptedEncoding
+			}
+			entries = append(entries, string(b[p:p+strlen]))
+			p += strlen
+		}
+	}
+	return entries, nil
+}
+
+/* Decode the entries of a listpack blob. The layout is:
+ *
+ * <tot-bytes> <num-elements> <element-1> ... <element-N> <listpack-end-byte>
+ *
+ * and every element is <encoding-type><element-data><element-tot-len>, the
+ * last part being the length of the first two encoded backward, that we
+ * skip since we only traverse the listpack forward. */
+func listpackBlobEntries(b []byte) ([]string, error) {
+	if len(b) < 7 || int(binary.LittleEndian.Uint32(b[0:4])) != len(b) {
+		return nil, errRdbCorruptedEncoding
+	}
+	var entries []string
+	p := 6
+	for {
+		if p >= len(b) {
+			return nil, errRdbCorruptedEncoding
+		}
+		enc := b[p]
+		if enc == 0xFF {
+			break
+		}
+
+		var hdrlen, strlen, intlen int
+		var val int64
+		isint := true
+		switch {
+		case enc&0x80 == 0:
+			/* 7 bit unsigned integer */
+			hdrlen, val = 1, int64(enc&0x7F)
+		case enc&0xC0 == 0x80:
+			/* 6 bit length string */
+			hdrlen, strlen, isint = 1, int(enc&0x3F), false
+		case enc&0xE0 == 0xC0:
+			/* 13 bit signed integer */
+			if p+2 > len(b) {
+				return nil, errRdbCorruptedEncoding
+			}
+			hdrlen = 2
+			val = int64(uint64(enc&0x1F)<<8 | uint64(b[p+1]))
+			if val >= 1<<12 {
+				val -= 1 << 13
+			}
+		case enc&0xF0 == 0xE0:
+			/* 12 bit length string */
+			if p+2 > len(b) {
+				return nil, errRdbCorruptedEncoding
+			}
+			hdrlen, strlen, isint = 2, int(enc&0x0F)<<8|int(b[p+1]), false
+		case enc == 0xF0:
+			/* 32 bit length string */
+			if p+5 > len(b) {
+				return nil, errRdbCorruptedEncoding
+			}
+			hdrlen, strlen, isint = 5, int(binary.LittleEndian.Uint32(b[p+1:p+5])), false
+		case enc == 0xF1:
+			hdrlen, intlen = 1, 2
+		case enc == 0xF2:
+			hdrlen, intlen = 1, 3
+		case enc == 0xF3:
+			hdrlen, intlen = 1, 4
+		case enc == 0xF4:
+			hdrlen, intlen = 1, 8
+		default:
+			return nil, errRdbCorruptedEncoding
+		}
+
+		datalen := strlen + intlen
+		if strlen < 0 || p+hdrlen+datalen > len(b) {
+			return nil, errRdbCorruptedEncoding
+		}
+		data := b[p+hdrlen : p+hdrlen+datalen]
+		if intlen > 0 {
+			val = littleEndianSigned(data)
+		}
+		if isint {
+			entries = append(entries, strconv.FormatInt(val, 10))
+		} else {
+			entries = append(entries, string(data))
+		}
+
+		/* Skip the backlen, stored in as many bytes as needed to hold
+		 * 7 bits of the entry length each. */
+		l := hdrlen + datalen
+		p += l
+		for {
+			p++
+			if l < 128 {
+				break
+			}
+			l >>= 7
+		}
+	}
+	/* The number of elements is only valid when lower than 65535. */
+	if numele := int(binary.LittleEndian.Uint16(b[4:6])); numele != 65535 && numele != len(entries) {
+		return nil, errRdbCorruptedEncoding
+	}
+	return entries, nil
+}
+
+// littleEndianSigned 小端有符号整数 长度为1到8字节
+func littleEndianSigned(b []byte) int64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	shift := uint(64 - 8*len(b))
+	return int64(v<<shift) >> shift
+}
+
+// rdbLoadListElements 向列表对象中添加元素
+func rdbLoadListElements(o *GodisObject, entries []string) {
+	for _, ele := range entries {
+		listTypePush(o, ele, LIST_TAIL)
+	}
+}
+
+// rdbLoadSetElements 向集合对象中添加成员 成员重复时文件已损坏
+func rdbLoadSetElements(s *Server, o *GodisObject, entries []string) (*GodisObject, error) {
+	for _, ele := range entries {
+		if o == nil {
+			o = setTypeCreate(ele)
+		}
+		if !setTypeAdd(s, o, ele) {
+			return nil, errors.New("Duplicate set members detected")
+		}
+	}
+	return o, nil
+}
+
+// rdbLoadZsetElement 向有序集合中添加成员 成员重复或分值为NaN时文件已损坏
+func rdbLoadZsetElement(o *GodisObject, ele string, score float64) error {
+	if math.IsNaN(score) {
+		return errors.New("Zset with NAN score detected")
+	}
+	flags := ZADD_NONE
+	zSetAdd(o, score, ele, &flags, nil)
+	if flags&ZADD_ADDED == 0 {
+		return errors.New("Duplicate zset fields detected")
+	}
+	return nil
+}
+
+// rdbLoadHashField 向哈希中添加字段 字段重复时文件已损坏
+func rdbLoadHashField(s *Server, o *GodisObject, field string, value string) error {
+	/* Convert to hash table if the field or the value are too long for
+	 * the compact encoding. */
+	if o.Encoding == OBJ_ENCODING_ZIPLIST &&
+		(len(field) > s.HashMaxZiplistValue || len(value) > s.HashMaxZiplistValue) {
+		hashTypeConvert(o, OBJ_ENCODING_HT)
+	}
+	if hashTypeSet(s, o, field, value) {
+		return errors.New("Duplicate hash fields detected")
+	}
+	return nil
+}
+
+// rdbLoadBlobEntries 读取一个以字符串保存的紧凑编码对象 返回其中的元素
+func rdbLoadBlobEntries(rdb *rdbReader, decode func(b []byte) ([]string, error)) ([]string, error) {
+	blob, err := rdbLoadString(rdb)
+	if err != nil {
+		return nil, err
+	}
+	return decode([]byte(blob))
+}
+
+/* Load a Godis object of the specified type from the specified file.
+ * A nil object with a nil error is returned for empty keys, that the
+ * caller skips. */
+func rdbLoadObject(s *Server, rdb *rdbReader, rdbtype byte) (*GodisObject, error) {
+	switch rdbtype {
+	case RDB_TYPE_STRING:
+		/* Read string value */
+		str, err := rdbLoadString(rdb)
+		if err != nil {
+			return nil, err
+		}
+		return tryObjectEncoding(CreateObject(ObjectTypeString, str)), nil
+
+	case RDB_TYPE_LIST:
+		/* Read list value */
+		l, err := rdbLoadLen(rdb)
+		if err != nil || l == 0 {
+			return nil, err
+		}
+		o := createListObject()
+		for ; l > 0; l-- {
+			ele, err := rdbLoadString(rdb)
+			if err != nil {
+				return nil, err
+			}
+			listTypePush(o, ele, LIST_TAIL)
+		}
+		return o, nil
+
+	case RDB_TYPE_SET:
+		/* Read Set value */
+		l, err := rdbLoadLen(rdb)
+		if err != nil || l == 0 {
+			return nil, err
+		}
+		var o *GodisObject
+		for ; l > 0; l-- {
+			ele, err := rdbLoadString(rdb)
+			if err != nil {
+				return nil, err
+			}
+			if o, err = rdbLoadSetElements(s, o, []string{ele}); err != nil {
+				return nil, err
+			}
+		}
+		return o, nil
+
+	case RDB_TYPE_ZSET, RDB_TYPE_ZSET_2:
+		/* Read sorted set value. */
+		l, err := rdbLoadLen(rdb)
+		if err != nil || l == 0 {
+			return nil, err
+		}
+		o := createZsetObject()
+		for ; l > 0; l-- {
+			ele, err := rdbLoadString(rdb)
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if rdbtype == RDB_TYPE_ZSET_2 {
+				score, err = rdbLoadBinaryDoubleValue(rdb)
+			} else {
+				score, err = rdbLoadDoubleValue(rdb)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := rdbLoadZsetElement(o, ele, score); err != nil {
+				return nil, err
+			}
+		}
+		return o, nil
+
+	case RDB_TYPE_HASH:
+		l, err := rdbLoadLen(rdb)
+		if err != nil || l == 0 {
+			return nil, err
+		}
+		o := createHashObject()
+		for ; l > 0; l-- {
+			field, err := rdbLoadString(rdb)
+			if err != nil {
+				return nil, err
+			}
+			value, err := rdbLoadString(rdb)
+			if err != nil {
+				return nil, err
+			}
+			if err := rdbLoadHashField(s, o, field, value); err != nil {
+				return nil, err
+			}
+		}
+		return o, nil
+
+	case RDB_TYPE_LIST_QUICKLIST, RDB_TYPE_LIST_QUICKLIST_2:
+		l, err := rdbLoadLen(rdb)
+		if err != nil || l == 0 {
+			return nil, err
+		}
+		o := createListObject()
+		for ; l > 0; l-- {
+			container := uint64(QUICKLIST_NODE_CONTAINER_PACKED)
+			if rdbtype == RDB_TYPE_LIST_QUICKLIST_2 {
+				if container, err = rdbLoadLen(rdb); err != nil {
+					return nil, err
+				}
+			}
+			if container == QUICKLIST_NODE_CONTAINER_PLAIN {
+				ele, err := rdbLoadString(rdb)
+				if err != nil {
+					return nil, err
+				}
+				listTypePush(o, ele, LIST_TAIL)
+				continue
+			}
+			decode := ziplistBlobEntries
+			if rdbtype == RDB_TYPE_LIST_QUICKLIST_2 {
+				decode = listpackBlobEntries
+			}
+			entries, err := rdbLoadBlobEntries(rdb, decode)
+			if err != nil {
+				return nil, err
+			}
+			rdbLoadListElements(o, entries)
+		}
+		if listTypeLength(o) == 0 {
+			return nil, nil
+		}
+		return o, nil
+
+	case RDB_TYPE_LIST_ZIPLIST, RDB_TYPE_SET_INTSET, RDB_TYPE_SET_LISTPACK,
+		RDB_TYPE_ZSET_ZIPLIST, RDB_TYPE_ZSET_LISTPACK, RDB_TYPE_HASH_ZIPLIST, RDB_TYPE_HASH_LISTPACK:
+		/* Compact encodings are stored as a single string blob, that we
+		 * decode and convert into the encodings used by godis. */
+		decode := listpackBlobEntries
+		switch rdbtype {
+		case RDB_TYPE_LIST_ZIPLIST, RDB_TYPE_ZSET_ZIPLIST, RDB_TYPE_HASH_ZIPLIST:
+			decode = ziplistBlobEntries
+		case RDB_TYPE_SET_INTSET:
+			decode = intsetBlobEntries
+		}
+		entries, err := rdbLoadBlobEntries(rdb, decode)
+		if err != nil || len(entries) == 0 {
+			return nil, err
+		}
+
+		switch rdbtype {
+		case RDB_TYPE_LIST_ZIPLIST:
+			o := createListObject()
+			rdbLoadListElements(o, entries)
+			return o, nil
+		case RDB_TYPE_SET_INTSET, RDB_TYPE_SET_LISTPACK:
+			return rdbLoadSetElements(s, nil, entries)
+		}
+
+		if len(entries)%2 != 0 {
+			return nil, errRdbCorruptedEncoding
+		}
+		if rdbtype == RDB_TYPE_ZSET_ZIPLIST || rdbtype == RDB_TYPE_ZSET_LISTPACK {
+			o := createZsetObject()
+			for j := 0; j < len(entries); j += 2 {
+				score, err := strconv.ParseFloat(entries[j+1], 64)
+				if err != nil {
+					return nil, errRdbCorruptedEncoding
+				}
+				if err := rdbLoadZsetElement(o, entries[j], score); err != nil {
+					return nil, err
+				}
+			}
+			return o, nil
+		}
+		o := createHashObject()
+		for j := 0; j < len(entries); j += 2 {
+			if err := rdbLoadHashField(s, o, entries[j], entries[j+1]); err != nil {
+				return nil, err
+			}
+		}
+		return o, nil
+	}
+	return nil, fmt.Errorf("Unknown RDB encoding type %d", rdbtype)
+}
+
+/* Load an RDB file from the reader rdb into the databases. */
+func rdbLoadRio(s *Server, rdb *rdbReader, rdbflags int) error {
+	buf, err := rdb.read(9)
+	if err != nil {
+		return err
+	}
+	if string(buf[:5]) != "REDIS" {
+		return errors.New("Wrong signature trying to load DB from file")
+	}
+	rdbver, err := strconv.Atoi(string(buf[5:]))
+	if err != nil || rdbver < 1 || rdbver > RDB_LOAD_MAX_VERSION {
+		return fmt.Errorf("Can't handle RDB format version %s", buf[5:])
+	}
+
+	db := s.Db[0]
+	now := mstime()
+	expiretime := int64(-1)
+	for {
+		/* Read type. */
+		typ, err := rdbLoadType(rdb)
+		if err != nil {
+			return err
+		}
+
+		if typ == RDB_OPCODE_EOF {
+			/* EOF: End of file, exit the main loop. */
+			break
+		}
+
+		/* Handle special types. */
+		switch typ {
+		case RDB_OPCODE_EXPIRETIME:
+			/* EXPIRETIME: load an expire associated with the next key
+			 * to load. Note that after loading an expire we need to
+			 * load the actual type, and continue. */
+			t, err := rdbLoadTime(rdb)
+			if err != nil {
+				return err
+			}
+			expiretime = t * 1000
+			continue
+		case RDB_OPCODE_EXPIRETIME_MS:
+			/* EXPIRETIME_MS: milliseconds precision expire times introduced
+			 * with RDB v3. Like EXPIRETIME but no with more precision. */
+			if expiretime, err = rdbLoadMillisecondTime(rdb); err != nil {
+				return err
+			}
+			continue
+		case RDB_OPCODE_FREQ:
+			/* FREQ: LFU frequency, not used by godis. */
+			if _, err := rdb.read(1); err != nil {
+				return err
+			}
+			continue
+		case RDB_OPCODE_IDLE:
+			/* IDLE: LRU idle time, not used by godis. */
+			if _, err := rdbLoadLen(rdb); err != nil {
+				return err
+			}
+			continue
+		case RDB_OPCODE_SELECTDB:
+			/* SELECTDB: Select the specified database. */
+			dbid, err := rdbLoadLen(rdb)
+			if err != nil {
+				return err
+			}
+			if dbid >= uint64(s.DbNum) {
+				return fmt.Errorf("FATAL: Data file was created with a Redis server configured to handle "+
+					"more than %d databases. Exiting", s.DbNum)
+			}
+			db = s.Db[dbid]
+			continue
+		case RDB_OPCODE_RESIZEDB:
+			/* RESIZEDB: Hint about the size of the keys in the currently
+			 * selected data base, in order to avoid useless rehashing. */
+			dbSize, err := rdbLoadLen(rdb)
+			if err != nil {
+				return err
+			}
+			expiresSize, err := rdbLoadLen(rdb)
+			if err != nil {
+				return err
+			}
+			db.Dict.dictExpand(dbSize)
+			db.Expires.dictExpand(expiresSize)
+			continue
+		case RDB_OPCODE_AUX:
+			/* AUX: generic string-string fields. Use to add state to RDB
+			 * which is backward compatible. Implementations of RDB loading
+			 * are required to skip AUX fields they don't understand. */
+			auxkey, err := rdbLoadString(rdb)
+			if err != nil {
+				return err
+			}
+			auxval, err := rdbLoadString(rdb)
+			if err != nil {
+				return err
+			}
+			rdbLogAuxField(s, auxkey, auxval)
+			continue
+		case RDB_OPCODE_MODULE_AUX, RDB_OPCODE_FUNCTION, RDB_OPCODE_FUNCTION2:
+			return fmt.Errorf("The RDB file contains module or function data (opcode %d), "+
+				"not supported by godis", typ)
+		}
+
+		/* Read key */
+		key, err := rdbLoadString(rdb)
+		if err != nil {
+			return err
+		}
+		/* Read value */
+		val, err := rdbLoadObject(s, rdb, typ)
+		if err != nil {
+			return err
+		}
+
+		/* Check if the key already expired. When the RDB is the preamble
+		 * of an AOF, the commands that follow it are assumed to work on
+		 * the exact keyspace it contains, so keys are never expired here
+		 * in that case. */
+		if val == nil {
+			serverLog(s, LL_VERBOSE, "rdbLoadObject: empty key '%s' skipped", key)
+		} else if expiretime != -1 && expiretime < now && rdbflags&RDBFLAGS_AOF_PREAMBLE == 0 {
+			/* Skip the key, it's already expired. */
+		} else {
+			/* Add the new object in the hash table */
+			keyobj := CreateObject(ObjectTypeString, key)
+			if !db.Dict.dictAdd(key, val) {
+				return fmt.Errorf("RDB has duplicated key '%s' in DB %d", key, db.ID)
+			}
+			/* Set the expire time if needed */
+			if expiretime != -1 {
+				setExpire(db, keyobj, expiretime)
+			}
+		}
+
+		/* Reset the state that is key-specific and is populated by
+		 * opcodes before the key, so that we start from scratch again. */
+		expiretime = -1
+	}
+
+	/* Verify the checksum if RDB version is >= 5 */
+	if rdbver >= 5 {
+		expected := rdb.cksum
+		buf, err := rdb.read(8)
+		if err != nil {
+			return err
+		}
+		cksum := binary.LittleEndian.Uint64(buf)
+		if s.RdbChecksum {
+			if cksum == 0 {
+				serverLog(s, LL_WARNING, "RDB file was saved with checksum disabled: no check performed.")
+			} else if cksum != expected {
+				return fmt.Errorf("Wrong RDB checksum expected: (%x) got (%x)", cksum, expected)
+			}
+		}
+	}
+	return nil
+}
+
+// rdbLogAuxField 记录rdb中的辅助字段 不认识的字段被忽略
+func rdbLogAuxField(s *Server, auxkey string, auxval string) {
+	if auxkey != "" && auxkey[0] == '%' {
+		/* All the fields with a name staring with '%' are considered
+		 * information fields and are logged at startup with a log
+		 * level of NOTICE. */
+		serverLog(s, LL_NOTICE, "RDB '%s': %s", auxkey, auxval)
+		return
+	}
+	switch auxkey {
+	case "redis-ver":
+		serverLog(s, LL_NOTICE, "Loading RDB produced by version %s", auxval)
+	case "ctime":
+		ctime, _ := strconv.ParseInt(auxval, 10, 64)
+		age := time.Now().Unix() - ctime
+		if age < 0 {
+			age = 0
+		}
+		serverLog(s, LL_NOTICE, "RDB age %d seconds", age)
+	case "used-mem":
+		usedmem, _ := strconv.ParseInt(auxval, 10, 64)
+		serverLog(s, LL_NOTICE, "RDB memory usage when created %.2f Mb", float64(usedmem)/(1024*1024))
+	case "aof-preamble":
+		if haspreamble, _ := strconv.Atoi(auxval); haspreamble != 0 {
+			serverLog(s, LL_NOTICE, "RDB has an AOF tail")
+		}
+	case "aof-base":
+		if isbase, _ := strconv.Atoi(auxval); isbase != 0 {
+			serverLog(s, LL_NOTICE, "RDB is base AOF")
+		}
+	default:
+		/* We ignore fields we don't understand */
+		serverLog(s, LL_DEBUG, "Unrecognized RDB AUX field: '%s'", auxkey)
+	}
+}
+
+// RdbLoad 启动时从rdb文件中加载数据 文件不存在时返回的错误满足os.IsNotExist
+func (s *Server) RdbLoad(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s.Loading = true
+	defer func() { s.Loading = false }()
+	return rdbLoadError(rdbLoadRio(s, newRdbReader(f), RDBFLAGS_NONE))
+}
+
+// rdbLoadError 将加载rdb时读取文件的错误转换为便于理解的描述
+func rdbLoadError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.New("Short read loading DB, unrecoverable error")
+	}
+	var perr *os.PathError
+	if errors.As(err, &perr) {
+		return fmt.Errorf("Error reading the RDB file: %s", strerror(err))
+	}
+	return err
+}
+
+// RdbCheck 加载r中的rdb以检查其是否完整 返回rdb结束的位置
+// 供godis-check-aof检查rdb格式的BASE文件或aof的rdb前缀 数据加载到一个临时的server中
+func RdbCheck(r io.Reader) (int64, error) {
+	s := new(Server)
+	s.InitServerConfig()
+	s.Db = make([]*GodisDb, s.DbNum)
+	for i := 0; i < s.DbNum; i++ {
+		s.Db[i] = s.CreateDb(i)
+	}
+	rdb := newRdbReader(r)
+	if err := rdbLoadRio(s, rdb, RDBFLAGS_AOF_PREAMBLE); err != nil {
+		return rdb.processed, rdbLoadError(err)
+	}
+	return rdb.processed, nil
+}
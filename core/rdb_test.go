@@ -0,0 +1,187 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// dumpObject 以可比较的形式输出对象的类型、编码和内容
+func dumpObject(o *GodisObject) string {
+	var elems []string
+	switch o.ObjectType {
+	case ObjectTypeString:
+		return fmt.Sprintf("string:%q", getStringFromObject(o))
+	case OBJ_LIST:
+		for ln := o.Ptr.(*List).listFirst(); ln != nil; ln = ln.listNextNode() {
+			elems = append(elems, fmt.Sprintf("%q", ln.listNodeValue()))
+		}
+		/* The order of the elements is kept. */
+		return fmt.Sprintf("list:%s", strings.Join(elems, ","))
+	case OBJ_SET:
+		setTypeForEach(o, func(ele string) bool {
+			elems = append(elems, fmt.Sprintf("%q", ele))
+			return true
+		})
+	case OBJ_ZSET:
+		for x := o.Ptr.(*zSet).zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			elems = append(elems, fmt.Sprintf("%q=%v", x.ele, x.score))
+		}
+	case OBJ_HASH:
+		hashTypeForEach(o, func(field string, value string) bool {
+			elems = append(elems, fmt.Sprintf("%q=%q", field, value))
+			return true
+		})
+	}
+	sort.Strings(elems)
+	return fmt.Sprintf("%d/%d:%s", o.ObjectType, o.Encoding, strings.Join(elems, ","))
+}
+
+// dumpDb 输出db中所有的key 以及它们的值和过期时间
+func dumpDb(db *GodisDb) map[string]string {
+	dump := make(map[string]string)
+	db.Dict.dictForEach(func(de *dictEntry) bool {
+		key := CreateObject(ObjectTypeString, de.key)
+		dump[de.key] = fmt.Sprintf("%s expire:%d", dumpObject(de.val), getExpire(db, key))
+		return true
+	})
+	return dump
+}
+
+// TestRdbSaveLoad 各种类型和编码的数据保存后再加载 内容和编码都不变
+func TestRdbSaveLoad(t *testing.T) {
+	s := newTestServer(t)
+	startTestServer(t, s)
+	tc := dialTestServer(s)
+	defer tc.conn.Close()
+
+	tc.do(t, "set", "str", "hello")
+	tc.do(t, "set", "empty", "")
+	tc.do(t, "set", "int", "12345")
+	tc.do(t, "set", "negative", "-9223372036854775808")
+	tc.do(t, "set", "binary", "a\r\nb\x00c\xff")
+	/* Long and compressible: saved as an LZF string. */
+	tc.do(t, "set", "lzf", strings.Repeat("compress me ", 100))
+	tc.do(t, "expire", "str", "1000")
+	tc.do(t, "lpush", "list", "1", "two", "", "a\r\nb")
+	tc.do(t, "sadd", "intset", "1", "-2", "300000", "5000000000")
+	tc.do(t, "sadd", "set", "a", "b", "", "1")
+	tc.do(t, "zadd", "zset", "1.5", "a", "-inf", "b", "inf", "c", "0", "")
+	tc.do(t, "hset", "hash", "f1", "v1", "f2", "", "", "v3")
+	/* Large enough to be converted to the hashtable encodings. */
+	for i := 0; i < 1000; i++ {
+		n := strconv.Itoa(i)
+		tc.do(t, "sadd", "bigintset", n)
+		tc.do(t, "zadd", "bigzset", n, "m"+n)
+		tc.do(t, "hset", "bighash", "f"+n, "v"+n)
+	}
+	tc.do(t, "hset", "longvalue", "f", strings.Repeat("x", 1000))
+	tc.do(t, "save")
+
+	var want map[string]string
+	var filename string
+	s.Exec(func() {
+		want = dumpDb(s.Db[0])
+		filename = s.RdbFilename
+	})
+	for key, enc := range map[string]int{
+		"intset":    OBJ_ENCODING_INTSET,
+		"set":       OBJ_ENCODING_HT,
+		"hash":      OBJ_ENCODING_ZIPLIST,
+		"bigintset": OBJ_ENCODING_HT,
+		"bighash":   OBJ_ENCODING_HT,
+		"longvalue": OBJ_ENCODING_HT,
+	} {
+		if !strings.HasPrefix(want[key], fmt.Sprintf("%d/%d:", OBJ_SET, enc)) &&
+			!strings.HasPrefix(want[key], fmt.Sprintf("%d/%d:", OBJ_HASH, enc)) {
+			t.Errorf("%s has an unexpected encoding: %.20s", key, want[key])
+		}
+	}
+
+	/* Load the RDB in a new server. */
+	s = createTestServer()
+	if err := s.RdbLoad(filename); err != nil {
+		t.Fatal(err)
+	}
+	got := dumpDb(s.Db[0])
+	if len(got) != len(want) {
+		t.Errorf("loaded %d keys, want %d", len(got), len(want))
+	}
+	for key, w := range want {
+		if got[key] != w {
+			t.Errorf("%s = %.80s, want %.80s", key, got[key], w)
+		}
+	}
+}
+
+// fillSnapshotTestDataset 每种类型各创建keys个key 集合类型的key各有200个元素
+func fillSnapshotTestDataset(t *testing.T, tc *testConn, keys int) {
+	for i := 0; i < keys; i++ {
+		n := strconv.Itoa(i)
+		args := map[string][]string{"rpush": {"list" + n}, "sadd": {"set" + n},
+			"zadd": {"zset" + n}, "hset": {"hash" + n}}
+		for j := 0; j < 200; j++ {
+			m := strconv.Itoa(j)
+			args["rpush"] = append(args["rpush"], "e"+m)
+			args["sadd"] = append(args["sadd"], "e"+m)
+			args["zadd"] = append(args["zadd"], m, "e"+m)
+			args["hset"] = append(args["hset"], "f"+m, "v"+m)
+		}
+		for cmd, a := range args {
+			tc.do(t, append([]string{cmd}, a...)...)
+		}
+		tc.do(t, "set", "str"+n, "value"+n)
+		tc.do(t, "set", "int"+n, n)
+	}
+}
+
+// modifySnapshotTestDataset 原地修改fillSnapshotTestDataset创建的每一个值
+func modifySnapshotTestDataset(t *testing.T, tc *testConn, keys int) {
+	for i := 0; i < keys; i++ {
+		n := strconv.Itoa(i)
+		tc.do(t, "rpush", "list"+n, "new")
+		tc.do(t, "lset", "list"+n, "0", "changed")
+		tc.do(t, "sadd", "set"+n, "new")
+		tc.do(t, "srem", "set"+n, "e0")
+		tc.do(t, "zadd", "zset"+n, "1000", "e0")
+		tc.do(t, "hset", "hash"+n, "f0", "changed")
+		tc.do(t, "hdel", "hash"+n, "f1")
+		tc.do(t, "append", "str"+n, "new")
+		tc.do(t, "incr", "int"+n)
+	}
+}
+
+// TestBgsaveSnapshot 后台保存期间修改数据 保存的rdb仍是BGSAVE时的数据
+func TestBgsaveSnapshot(t *testing.T) {
+	s := newTestServer(t)
+	startTestServer(t, s)
+	tc := dialTestServer(s)
+	defer tc.conn.Close()
+
+	fillSnapshotTestDataset(t, tc, 200)
+	var want map[string]string
+	var filename string
+	s.Exec(func() {
+		want = dumpDb(s.Db[0])
+		filename = s.RdbFilename
+	})
+	tc.do(t, "bgsave")
+	modifySnapshotTestDataset(t, tc, 200)
+	waitForChild(t, s)
+
+	s = createTestServer()
+	if err := s.RdbLoad(filename); err != nil {
+		t.Fatal(err)
+	}
+	got := dumpDb(s.Db[0])
+	if len(got) != len(want) {
+		t.Errorf("loaded %d keys, want %d", len(got), len(want))
+	}
+	for key, w := range want {
+		if got[key] != w {
+			t.Errorf("%s = %.80s, want %.80s", key, got[key], w)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"bufio"
+	"io"
+)
+
+// rio 保存rdb和重写aof时的输出 参考redis的rio.c
+// 带缓冲地写入文件 同时记录写入的字节数 按需计算已写入内容的校验和
+// 第一次写入出错后忽略之后的写入 错误保存在err中
+
+type rio struct {
+	w              *bufio.Writer
+	err            error  // 第一次写入时的错误
+	processed      int64  // 已写入的字节数
+	updateCksum    bool   // 是否计算校验和
+	cksum          uint64 // 已写入内容的crc64
+	rdbCompression bool   // 保存rdb时压缩较长的字符串 开始保存时从配置复制
+}
+
+// newRio 创建写入w的rio
+func newRio(w io.Writer, rdbCompression bool) *rio {
+	return &rio{w: bufio.NewWriterSize(w, 64*1024), rdbCompression: rdbCompression}
+}
+
+// Write 实现io.Writer
+func (r *rio) Write(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.updateCksum {
+		r.cksum = crc64Update(r.cksum, p)
+	}
+	n, err := r.w.Write(p)
+	r.processed += int64(n)
+	r.err = err
+	return n, err
+}
+
+// WriteByte 写入一个字节
+func (r *rio) WriteByte(c byte) error {
+	b := [1]byte{c}
+	_, err := r.Write(b[:])
+	return err
+}
+
+// WriteString 写入字符串
+func (r *rio) WriteString(s string) (int, error) {
+	if r.updateCksum {
+		return r.Write([]byte(s))
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.w.WriteString(s)
+	r.processed += int64(n)
+	r.err = err
+	return n, err
+}
+
+// Flush 将缓冲区中的内容写入文件
+func (r *rio) Flush() error {
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
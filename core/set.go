@@ -0,0 +1,662 @@
+package core
+
+import (
+	"godis/core/proto"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+/*-----------------------------------------------------------------------------
+ * Set Commands
+ *----------------------------------------------------------------------------*/
+
+/* Factory method to return a set that *can* hold "value". When the object has
+ * an integer-encodable value, an intset will be returned. Otherwise a regular
+ * hash table. */
+func setTypeCreate(value string) *GodisObject {
+	if _, ok := string2ll(value); ok {
+		return createIntsetObject()
+	}
+	return createSetObject()
+}
+
+// createSetObject 使用dict的集合对象 成员为dict的key 值为nil
+func createSetObject() *GodisObject {
+	o := CreateObject(OBJ_SET, dictCreate())
+	o.Encoding = OBJ_ENCODING_HT
+	return o
+}
+
+// createIntsetObject 使用intset的集合对象
+func createIntsetObject() *GodisObject {
+	o := CreateObject(OBJ_SET, intsetNew())
+	o.Encoding = OBJ_ENCODING_INTSET
+	return o
+}
+
+/* Add the specified value into a set.
+ *
+ * If the value was already member of the set, nothing is done and false is
+ * returned, otherwise the new element is added and true is returned. */
+func setTypeAdd(s *Server, subject *GodisObject, value string) bool {
+	if subject.Encoding == OBJ_ENCODING_HT {
+		return subject.Ptr.(*dict).dictAdd(value, nil)
+	}
+
+	if llval, ok := string2ll(value); ok {
+		is := subject.Ptr.(*intset)
+		if !is.intsetAdd(llval) {
+			return false
+		}
+		/* Convert to regular set when the intset contains
+		 * too many entries. */
+		if is.intsetLen() > s.SetMaxIntsetEntries {
+			setTypeConvert(subject, OBJ_ENCODING_HT)
+		}
+		return true
+	}
+
+	/* Failed to get integer from object, convert to regular set. */
+	setTypeConvert(subject, OBJ_ENCODING_HT)
+	subject.Ptr.(*dict).dictAdd(value, nil)
+	return true
+}
+
+// setTypeRemove 删除成员 成员不存在时返回false
+func setTypeRemove(setobj *GodisObject, value string) bool {
+	if setobj.Encoding == OBJ_ENCODING_HT {
+		d := setobj.Ptr.(*dict)
+		if !d.dictDelete(value) {
+			return false
+		}
+		if htNeedsResize(d) {
+			d.dictResize()
+		}
+		return true
+	}
+	if llval, ok := string2ll(value); ok {
+		return setobj.Ptr.(*intset).intsetRemove(llval)
+	}
+	return false
+}
+
+// setTypeIsMember 是否为集合成员
+func setTypeIsMember(set *GodisObject, value string) bool {
+	if set.Encoding == OBJ_ENCODING_HT {
+		return set.Ptr.(*dict).dictFind(value) != nil
+	}
+	if llval, ok := string2ll(value); ok {
+		return set.Ptr.(*intset).intsetFind(llval)
+	}
+	return false
+}
+
+// setTypeSize 集合的成员数
+func setTypeSize(subject *GodisObject) int {
+	if subject.Encoding == OBJ_ENCODING_HT {
+		return subject.Ptr.(*dict).dictSize()
+	}
+	return subject.Ptr.(*intset).intsetLen()
+}
+
+// setTypeForEach 依次访问集合的每个成员 fn返回false时停止遍历
+func setTypeForEach(subject *GodisObject, fn func(ele string) bool) {
+	if subject.Encoding == OBJ_ENCODING_HT {
+		subject.Ptr.(*dict).dictForEach(func(de *dictEntry) bool {
+			return fn(de.key)
+		})
+		return
+	}
+	is := subject.Ptr.(*intset)
+	for i := 0; i < is.intsetLen(); i++ {
+		if !fn(strconv.FormatInt(is.intsetGet(i), 10)) {
+			return
+		}
+	}
+}
+
+/* Like setTypeForEach() but the set is only read, see dictForEachUnsafe(). */
+func setTypeForEachUnsafe(subject *GodisObject, fn func(ele string) bool) {
+	if subject.Encoding == OBJ_ENCODING_HT {
+		subject.Ptr.(*dict).dictForEachUnsafe(func(de *dictEntry) bool {
+			return fn(de.key)
+		})
+		return
+	}
+	setTypeForEach(subject, fn)
+}
+
+/* Return random element from a non empty set. */
+func setTypeRandomElement(setobj *GodisObject) string {
+	if setobj.Encoding == OBJ_ENCODING_INTSET {
+		return strconv.FormatInt(setobj.Ptr.(*intset).intsetRandom(), 10)
+	}
+	return setobj.Ptr.(*dict).dictGetRandomKey().key
+}
+
+/* This is a helper function for the COPY command.
+ * Duplicate a set object, with the guarantee that the returned object
+ * has the same encoding as the original one. */
+func setTypeDup(o *GodisObject) *GodisObject {
+	if o.Encoding == OBJ_ENCODING_INTSET {
+		is := o.Ptr.(*intset)
+		set := createIntsetObject()
+		newis := set.Ptr.(*intset)
+		newis.contents = append(newis.contents, is.contents...)
+		return set
+	}
+	set := createSetObject()
+	d := set.Ptr.(*dict)
+	d.dictExpand(uint64(setTypeSize(o)))
+	setTypeForEach(o, func(ele string) bool {
+		d.dictAdd(ele, nil)
+		return true
+	})
+	return set
+}
+
+/* Convert the set to specified encoding. The resulting dict (when converting
+ * to a hash table) is presized to hold the number of elements in the original
+ * set. */
+func setTypeConvert(setobj *GodisObject, enc int) {
+	if setobj.Encoding != OBJ_ENCODING_INTSET || enc != OBJ_ENCODING_HT {
+		return
+	}
+	is := setobj.Ptr.(*intset)
+	d := dictCreate()
+	/* Presize the dict to avoid rehashing */
+	d.dictExpand(uint64(is.intsetLen()))
+	for i := 0; i < is.intsetLen(); i++ {
+		d.dictAdd(strconv.FormatInt(is.intsetGet(i), 10), nil)
+	}
+	setobj.Ptr = d
+	setobj.Encoding = OBJ_ENCODING_HT
+}
+
+// SAddCommand sadd key member [member ...]
+func SAddCommand(c *Client, s *Server) {
+	set := lookupKeyWrite(c.Db, c.Argv[1])
+	if set != nil && checkType(c, set, OBJ_SET) {
+		return
+	}
+	if set == nil {
+		set = setTypeCreate(c.Argv[2].Ptr.(string))
+		dbAdd(c.Db, c.Argv[1], set)
+	}
+
+	added := 0
+	for j := 2; j < c.Argc; j++ {
+		if setTypeAdd(s, set, c.Argv[j].Ptr.(string)) {
+			added++
+		}
+	}
+	s.Dirty += int64(added)
+	addReplyLongLong(c, int64(added))
+}
+
+// SRemCommand srem key member [member ...]
+func SRemCommand(c *Client, s *Server) {
+	set := lookupKeyWrite(c.Db, c.Argv[1])
+	if set == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, set, OBJ_SET) {
+		return
+	}
+
+	deleted := 0
+	for j := 2; j < c.Argc; j++ {
+		if setTypeRemove(set, c.Argv[j].Ptr.(string)) {
+			deleted++
+			if setTypeSize(set) == 0 {
+				dbDelete(c.Db, c.Argv[1])
+				break
+			}
+		}
+	}
+	s.Dirty += int64(deleted)
+	addReplyLongLong(c, int64(deleted))
+}
+
+// SMoveCommand smove source destination member
+func SMoveCommand(c *Client, s *Server) {
+	srcset := lookupKeyWrite(c.Db, c.Argv[1])
+	dstset := lookupKeyWrite(c.Db, c.Argv[2])
+	ele := c.Argv[3].Ptr.(string)
+
+	/* If the source key does not exist return 0 */
+	if srcset == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+
+	/* If the source key has the wrong type, or the destination key
+	 * is set and has the wrong type, return with an error. */
+	if checkType(c, srcset, OBJ_SET) || (dstset != nil && checkType(c, dstset, OBJ_SET)) {
+		return
+	}
+
+	/* If srcset and dstset are equal, SMOVE is a no-op */
+	if srcset == dstset {
+		if setTypeIsMember(srcset, ele) {
+			addReplyLongLong(c, 1)
+		} else {
+			addReplyLongLong(c, 0)
+		}
+		return
+	}
+
+	/* If the element cannot be removed from the src set, return 0. */
+	if !setTypeRemove(srcset, ele) {
+		addReplyLongLong(c, 0)
+		return
+	}
+
+	/* Remove the src set from the database when empty */
+	if setTypeSize(srcset) == 0 {
+		dbDelete(c.Db, c.Argv[1])
+	}
+
+	/* Create the destination set when it doesn't exist */
+	if dstset == nil {
+		dstset = setTypeCreate(ele)
+		dbAdd(c.Db, c.Argv[2], dstset)
+	}
+	s.Dirty++
+
+	/* An extra key has changed when ele was successfully added to dstset */
+	if setTypeAdd(s, dstset, ele) {
+		s.Dirty++
+	}
+	addReplyLongLong(c, 1)
+}
+
+// SIsMemberCommand sismember key member
+func SIsMemberCommand(c *Client, s *Server) {
+	set := lookupKey(c.Db, c.Argv[1])
+	if set == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, set, OBJ_SET) {
+		return
+	}
+	if setTypeIsMember(set, c.Argv[2].Ptr.(string)) {
+		addReplyLongLong(c, 1)
+	} else {
+		addReplyLongLong(c, 0)
+	}
+}
+
+// SMIsMemberCommand smismember key member [member ...]
+func SMIsMemberCommand(c *Client, s *Server) {
+	/* Don't abort when the key cannot be found. Non-existing keys are empty
+	 * sets, where SMISMEMBER should respond with a series of zeros. */
+	set := lookupKey(c.Db, c.Argv[1])
+	if set != nil && checkType(c, set, OBJ_SET) {
+		return
+	}
+	items := make([]*proto.Resp, 0, c.Argc-2)
+	for j := 2; j < c.Argc; j++ {
+		n := "0"
+		if set != nil && setTypeIsMember(set, c.Argv[j].Ptr.(string)) {
+			n = "1"
+		}
+		items = append(items, proto.NewInt([]byte(n)))
+	}
+	addReplyArray(c, items)
+}
+
+// SCardCommand scard key
+func SCardCommand(c *Client, s *Server) {
+	set := lookupKey(c.Db, c.Argv[1])
+	if set == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, set, OBJ_SET) {
+		return
+	}
+	addReplyLongLong(c, int64(setTypeSize(set)))
+}
+
+// setTypeRandomElements 随机选取count个不重复的成员 count需小于集合大小
+func setTypeRandomElements(set *GodisObject, count int) []string {
+	/* Visit every element once and select it with probability
+	 * needed/left, the result keeps the set order. */
+	result := make([]string, 0, count)
+	need, left := count, setTypeSize(set)
+	setTypeForEach(set, func(ele string) bool {
+		if rand.Intn(left) < need {
+			result = append(result, ele)
+			need--
+		}
+		left--
+		return need > 0
+	})
+	rand.Shuffle(len(result), func(i, j int) {
+		result[i], result[j] = result[j], result[i]
+	})
+	return result
+}
+
+/* Handle the "SPOP key <count>" variant. The normal version of the
+ * command is handled by the SPopCommand() function itself. */
+func spopWithCountCommand(c *Client, s *Server) {
+	var l int64
+	/* Get the count argument */
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &l, "") != C_OK {
+		return
+	}
+	if l < 0 {
+		addReplyError(c, "ERR value is out of range, must be positive")
+		return
+	}
+
+	/* Make sure a key with the name inputted exists, and that it's type is
+	 * indeed a set. Otherwise, return nil */
+	set := lookupKeyWrite(c.Db, c.Argv[1])
+	if set == nil {
+		addReplyArray(c, nil)
+		return
+	}
+	if checkType(c, set, OBJ_SET) {
+		return
+	}
+
+	/* If count is zero, serve an empty set ASAP to avoid special
+	 * cases later. */
+	if l == 0 {
+		addReplyArray(c, nil)
+		return
+	}
+
+	/* CASE 1: The number of requested elements is greater than or equal to
+	 * the number of elements inside the set: simply return the whole set,
+	 * otherwise pick 'count' random distinct elements. */
+	var popped []string
+	if l >= int64(setTypeSize(set)) {
+		setTypeForEach(set, func(ele string) bool {
+			popped = append(popped, ele)
+			return true
+		})
+	} else {
+		popped = setTypeRandomElements(set, int(l))
+	}
+
+	/* Remove the elements and transfer the SPOP into a SREM of the very
+	 * same elements, so that the AOF gets the deterministic effect. */
+	items := make([]*proto.Resp, 0, len(popped))
+	argv := []*GodisObject{CreateObject(ObjectTypeString, "srem"), c.Argv[1]}
+	for _, ele := range popped {
+		setTypeRemove(set, ele)
+		items = append(items, bulkString(ele))
+		argv = append(argv, CreateObject(ObjectTypeString, ele))
+	}
+	if setTypeSize(set) == 0 {
+		dbDelete(c.Db, c.Argv[1])
+	}
+	s.Dirty += int64(len(popped))
+	rewriteClientCommandVector(c, argv...)
+	addReplyArray(c, items)
+}
+
+// SPopCommand spop key [count]
+func SPopCommand(c *Client, s *Server) {
+	if c.Argc == 3 {
+		spopWithCountCommand(c, s)
+		return
+	} else if c.Argc > 3 {
+		addReplyError(c, errSyntax)
+		return
+	}
+
+	/* Make sure a key with the name inputted exists, and that it's type is
+	 * indeed a set */
+	set := lookupKeyWrite(c.Db, c.Argv[1])
+	if set == nil {
+		addReplyNull(c)
+		return
+	}
+	if checkType(c, set, OBJ_SET) {
+		return
+	}
+
+	/* Pop a random element from the set */
+	ele := setTypeRandomElement(set)
+	setTypeRemove(set, ele)
+
+	/* Replicate/AOF this command as an SREM operation */
+	rewriteClientCommandVector(c, CreateObject(ObjectTypeString, "srem"), c.Argv[1],
+		CreateObject(ObjectTypeString, ele))
+
+	/* Delete the set if it's empty */
+	if setTypeSize(set) == 0 {
+		dbDelete(c.Db, c.Argv[1])
+	}
+	s.Dirty++
+	addReplyBulk(c, ele)
+}
+
+/* handle the "SRANDMEMBER key <count>" variant. The normal version of the
+ * command is handled by the SRandMemberCommand() function itself. */
+func srandmemberWithCountCommand(c *Client) {
+	var l int64
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &l, "") != C_OK {
+		return
+	}
+	set := lookupKey(c.Db, c.Argv[1])
+	if set == nil {
+		addReplyArray(c, nil)
+		return
+	}
+	if checkType(c, set, OBJ_SET) {
+		return
+	}
+	size := int64(setTypeSize(set))
+
+	/* If count is zero, serve it ASAP to avoid special cases later. */
+	if l == 0 {
+		addReplyArray(c, nil)
+		return
+	}
+
+	var items []*proto.Resp
+	/* CASE 1: The count was negative, so the extraction method is just:
+	 * "return N random elements" sampling the whole set every time.
+	 * This case is trivial and can be served without auxiliary data
+	 * structures. */
+	if l < 0 {
+		count := -l
+		if count > 1<<31 {
+			addReplyError(c, "ERR value is out of range")
+			return
+		}
+		for ; count > 0; count-- {
+			items = append(items, bulkString(setTypeRandomElement(set)))
+		}
+		addReplyArray(c, items)
+		return
+	}
+
+	/* CASE 2:
+	 * The number of requested elements is greater than the number of
+	 * elements inside the set: simply return the whole set. */
+	if l >= size {
+		setTypeForEach(set, func(ele string) bool {
+			items = append(items, bulkString(ele))
+			return true
+		})
+		addReplyArray(c, items)
+		return
+	}
+
+	/* CASE 3: pick 'count' distinct random elements. */
+	for _, ele := range setTypeRandomElements(set, int(l)) {
+		items = append(items, bulkString(ele))
+	}
+	addReplyArray(c, items)
+}
+
+// SRandMemberCommand srandmember key [count]
+func SRandMemberCommand(c *Client, s *Server) {
+	if c.Argc == 3 {
+		srandmemberWithCountCommand(c)
+		return
+	} else if c.Argc > 3 {
+		addReplyError(c, errSyntax)
+		return
+	}
+
+	/* Handle variant without <count> argument. Reply with simple bulk string */
+	set := lookupKey(c.Db, c.Argv[1])
+	if set == nil {
+		addReplyNull(c)
+		return
+	}
+	if checkType(c, set, OBJ_SET) {
+		return
+	}
+	addReplyBulk(c, setTypeRandomElement(set))
+}
+
+// SMembersCommand smembers key
+func SMembersCommand(c *Client, s *Server) {
+	set := lookupKey(c.Db, c.Argv[1])
+	if set == nil {
+		addReplySet(c, nil)
+		return
+	}
+	if checkType(c, set, OBJ_SET) {
+		return
+	}
+	items := make([]*proto.Resp, 0, setTypeSize(set))
+	setTypeForEach(set, func(ele string) bool {
+		items = append(items, bulkString(ele))
+		return true
+	})
+	addReplySet(c, items)
+}
+
+/* SINTER, SUNION, SDIFF and their STORE variants. The dstkey is nil for the
+ * non-STORE variants, in which case the result is replied to the client. */
+func sinterUnionDiffGenericCommand(c *Client, s *Server, setkeys []*GodisObject, dstkey *GodisObject, op int) {
+	sets := make([]*GodisObject, len(setkeys))
+	for j, key := range setkeys {
+		setobj := lookupKey(c.Db, key)
+		if setobj != nil && checkType(c, setobj, OBJ_SET) {
+			return
+		}
+		sets[j] = setobj
+	}
+
+	dstset := createIntsetObject()
+	if op == SET_OP_INTER {
+		/* Sort sets from the smallest to largest, this will improve our
+		 * algorithm's performance */
+		sort.SliceStable(sets, func(a, b int) bool {
+			return setopsrcLength(sets[a]) < setopsrcLength(sets[b])
+		})
+
+		/* Iterate all the elements of the first (smallest) set, and test
+		 * the element against all the other sets, if at least one set does
+		 * not include the element it is discarded. If a key does not exist
+		 * the intersection is empty. */
+		if sets[0] != nil {
+			setTypeForEach(sets[0], func(ele string) bool {
+				for j := 1; j < len(sets); j++ {
+					if sets[j] == nil || !setTypeIsMember(sets[j], ele) {
+						return true
+					}
+				}
+				setTypeAdd(s, dstset, ele)
+				return true
+			})
+		}
+	} else if op == SET_OP_UNION {
+		for _, setobj := range sets {
+			if setobj == nil {
+				continue /* non existing keys are like empty sets */
+			}
+			setTypeForEach(setobj, func(ele string) bool {
+				setTypeAdd(s, dstset, ele)
+				return true
+			})
+		}
+	} else if op == SET_OP_DIFF {
+		/* Remove from the first set every element found in any of the
+		 * other sets. */
+		if sets[0] != nil {
+			setTypeForEach(sets[0], func(ele string) bool {
+				for j := 1; j < len(sets); j++ {
+					if sets[j] != nil && setTypeIsMember(sets[j], ele) {
+						return true
+					}
+				}
+				setTypeAdd(s, dstset, ele)
+				return true
+			})
+		}
+	} else {
+		panic("Unknown operator")
+	}
+
+	/* Store the resulting set into the target, if the intersection
+	 * is not an empty set. */
+	if dstkey != nil {
+		dbDelete(c.Db, dstkey)
+		if setTypeSize(dstset) > 0 {
+			dbAdd(c.Db, dstkey, dstset)
+		}
+		addReplyLongLong(c, int64(setTypeSize(dstset)))
+		s.Dirty++
+		return
+	}
+
+	items := make([]*proto.Resp, 0, setTypeSize(dstset))
+	setTypeForEach(dstset, func(ele string) bool {
+		items = append(items, bulkString(ele))
+		return true
+	})
+	addReplySet(c, items)
+}
+
+// setopsrcLength 源集合的元素个数 不存在的key视为空集
+func setopsrcLength(set *GodisObject) int {
+	if set == nil {
+		return 0
+	}
+	return setTypeSize(set)
+}
+
+// SInterCommand sinter key [key ...]
+func SInterCommand(c *Client, s *Server) {
+	sinterUnionDiffGenericCommand(c, s, c.Argv[1:c.Argc], nil, SET_OP_INTER)
+}
+
+// SInterStoreCommand sinterstore destination key [key ...]
+func SInterStoreCommand(c *Client, s *Server) {
+	sinterUnionDiffGenericCommand(c, s, c.Argv[2:c.Argc], c.Argv[1], SET_OP_INTER)
+}
+
+// SUnionCommand sunion key [key ...]
+func SUnionCommand(c *Client, s *Server) {
+	sinterUnionDiffGenericCommand(c, s, c.Argv[1:c.Argc], nil, SET_OP_UNION)
+}
+
+// SUnionStoreCommand sunionstore destination key [key ...]
+func SUnionStoreCommand(c *Client, s *Server) {
+	sinterUnionDiffGenericCommand(c, s, c.Argv[2:c.Argc], c.Argv[1], SET_OP_UNION)
+}
+
+// SDiffCommand sdiff key [key ...]
+func SDiffCommand(c *Client, s *Server) {
+	sinterUnionDiffGenericCommand(c, s, c.Argv[1:c.Argc], nil, SET_OP_DIFF)
+}
+
+// SDiffStoreCommand sdiffstore destination key [key ...]
+func SDiffStoreCommand(c *Client, s *Server) {
+	sinterUnionDiffGenericCommand(c, s, c.Argv[2:c.Argc], c.Argv[1], SET_OP_DIFF)
+}
@@ -0,0 +1,527 @@
+package core
+
+import (
+	"godis/core/proto"
+	"math"
+	"strings"
+)
+
+// 字符串的最大长度 512MB
+const PROTO_MAX_BULK_LEN = 512 * 1024 * 1024
+
+/*-----------------------------------------------------------------------------
+ * String Commands
+ *----------------------------------------------------------------------------*/
+
+// checkStringLength 检查字符串长度是否超出限制
+func checkStringLength(c *Client, size int64) int {
+	if size > PROTO_MAX_BULK_LEN {
+		addReplyError(c, "ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+		return C_ERR
+	}
+	return C_OK
+}
+
+// SET/GETEX 的参数标志
+const OBJ_NO_FLAGS = 0
+const OBJ_SET_NX = 1 << 0  /* Set if key not exists. */
+const OBJ_SET_XX = 1 << 1  /* Set if key exists. */
+const OBJ_EX = 1 << 2      /* Set if time in seconds is given */
+const OBJ_PX = 1 << 3      /* Set if time in ms in given */
+const OBJ_KEEPTTL = 1 << 4 /* Set and keep the ttl */
+const OBJ_SET_GET = 1 << 5 /* Set if want to get key before set */
+const OBJ_EXAT = 1 << 6    /* Set if timestamp in second is given */
+const OBJ_PXAT = 1 << 7    /* Set if timestamp in ms is given */
+
+// 过期时间的单位
+const UNIT_SECONDS = 0
+const UNIT_MILLISECONDS = 1
+
+/* Parse the expire argument of SET, SETEX and PSETEX. The result is the
+ * absolute unix time in milliseconds. */
+func getExpireMillisecondsOrReply(c *Client, expire *GodisObject, flags int, unit int, milliseconds *int64) int {
+	if getLongLongFromObjectOrReply(c, expire, milliseconds, "") != C_OK {
+		return C_ERR
+	}
+	if *milliseconds <= 0 || (unit == UNIT_SECONDS && *milliseconds > math.MaxInt64/1000) {
+		/* Negative value provided or multiplication is gonna overflow. */
+		addReplyError(c, "ERR invalid expire time in '"+c.Cmd.Name+"' command")
+		return C_ERR
+	}
+	if unit == UNIT_SECONDS {
+		*milliseconds *= 1000
+	}
+	if flags&OBJ_PX != 0 || flags&OBJ_EX != 0 {
+		now := mstime()
+		if *milliseconds > math.MaxInt64-now {
+			/* Overflow detected */
+			addReplyError(c, "ERR invalid expire time in '"+c.Cmd.Name+"' command")
+			return C_ERR
+		}
+		*milliseconds += now
+	}
+	return C_OK
+}
+
+/* The setGenericCommand() function implements the SET operation with different
+ * options and variants. This function is called in order to implement the
+ * following commands: SET, SETEX, PSETEX.
+ *
+ * 'flags' changes the behavior of the command (NX, XX or GET, see below).
+ *
+ * 'expire' represents an expire to set in form of a Godis object as passed
+ * by the user. It is interpreted according to the specified 'unit'.
+ *
+ * If the key is set, OK is replied. When the NX/XX condition is not met a
+ * null bulk is replied instead. With the GET flag the old value is replied
+ * in both cases. */
+func setGenericCommand(c *Client, s *Server, flags int, key *GodisObject, val *GodisObject, expire *GodisObject, unit int) {
+	var milliseconds int64
+	if expire != nil && getExpireMillisecondsOrReply(c, expire, flags, unit, &milliseconds) != C_OK {
+		return
+	}
+
+	old := lookupKey(c.Db, key)
+	if flags&OBJ_SET_GET != 0 {
+		/* GET is only allowed on string values, check it before touching
+		 * the key so that a WRONGTYPE error leaves it unchanged. */
+		if old != nil && checkType(c, old, ObjectTypeString) {
+			return
+		}
+		if old == nil {
+			addReplyNull(c)
+		} else {
+			addReplyBulk(c, getStringFromObject(old))
+		}
+	}
+
+	found := old != nil
+	if (flags&OBJ_SET_NX != 0 && found) || (flags&OBJ_SET_XX != 0 && !found) {
+		if flags&OBJ_SET_GET == 0 {
+			addReplyNull(c)
+		}
+		return
+	}
+
+	setKey(c.Db, key, tryObjectEncoding(val), flags&OBJ_KEEPTTL != 0)
+	s.Dirty++
+	if expire != nil {
+		setExpire(c.Db, key, milliseconds)
+	}
+	if flags&OBJ_SET_GET == 0 {
+		addReplyStatus(c, "OK")
+	}
+
+	/* Propagate as SET key value PXAT millisecond-timestamp if there is
+	 * an EX/PX/EXAT flag, so that the AOF doesn't depend on the time it
+	 * is loaded at. */
+	if expire != nil && flags&OBJ_PXAT == 0 {
+		rewriteClientCommandVector(c, CreateObject(ObjectTypeString, "set"), key, val,
+			CreateObject(ObjectTypeString, "pxat"), createStringObjectFromLongLong(milliseconds))
+	}
+}
+
+/* The parseExtendedStringArgumentsOrReply() function performs the validation
+ * of the extended arguments of SET: XX/NX/GET/EX/EXAT/PX/PXAT/KEEPTTL.
+ *
+ * If there are any syntax violations C_ERR is returned else C_OK is returned.
+ *
+ * Input flags are updated upon parsing the arguments. Unit and expire are
+ * updated if there are any EX/EXAT/PX/PXAT arguments. Unit is updated to
+ * millisecond if PX/PXAT is set. */
+func parseExtendedStringArgumentsOrReply(c *Client, flags *int, unit *int, expire **GodisObject) int {
+	for j := 3; j < c.Argc; j++ {
+		opt := strings.ToLower(c.Argv[j].Ptr.(string))
+		var next *GodisObject
+		if j < c.Argc-1 {
+			next = c.Argv[j+1]
+		}
+
+		if opt == "nx" && *flags&(OBJ_SET_XX) == 0 {
+			*flags |= OBJ_SET_NX
+		} else if opt == "xx" && *flags&(OBJ_SET_NX) == 0 {
+			*flags |= OBJ_SET_XX
+		} else if opt == "get" {
+			*flags |= OBJ_SET_GET
+		} else if opt == "keepttl" && *flags&(OBJ_EX|OBJ_EXAT|OBJ_PX|OBJ_PXAT) == 0 {
+			*flags |= OBJ_KEEPTTL
+		} else if opt == "ex" && *flags&(OBJ_KEEPTTL|OBJ_EXAT|OBJ_PX|OBJ_PXAT) == 0 && next != nil {
+			*flags |= OBJ_EX
+			*expire = next
+			j++
+		} else if opt == "px" && *flags&(OBJ_KEEPTTL|OBJ_EX|OBJ_EXAT|OBJ_PXAT) == 0 && next != nil {
+			*flags |= OBJ_PX
+			*unit = UNIT_MILLISECONDS
+			*expire = next
+			j++
+		} else if opt == "exat" && *flags&(OBJ_KEEPTTL|OBJ_EX|OBJ_PX|OBJ_PXAT) == 0 && next != nil {
+			*flags |= OBJ_EXAT
+			*expire = next
+			j++
+		} else if opt == "pxat" && *flags&(OBJ_KEEPTTL|OBJ_EX|OBJ_EXAT|OBJ_PX) == 0 && next != nil {
+			*flags |= OBJ_PXAT
+			*unit = UNIT_MILLISECONDS
+			*expire = next
+			j++
+		} else {
+			addReplyError(c, errSyntax)
+			return C_ERR
+		}
+	}
+	return C_OK
+}
+
+/* SET key value [NX] [XX] [KEEPTTL] [GET] [EX <seconds>] [PX <milliseconds>]
+ *     [EXAT <seconds-timestamp>][PXAT <milliseconds-timestamp>] */
+func SetCommand(c *Client, s *Server) {
+	var expire *GodisObject
+	unit := UNIT_SECONDS
+	flags := OBJ_NO_FLAGS
+
+	if parseExtendedStringArgumentsOrReply(c, &flags, &unit, &expire) != C_OK {
+		return
+	}
+	setGenericCommand(c, s, flags, c.Argv[1], c.Argv[2], expire, unit)
+}
+
+// SetEXCommand setex key seconds value
+func SetEXCommand(c *Client, s *Server) {
+	setGenericCommand(c, s, OBJ_EX, c.Argv[1], c.Argv[3], c.Argv[2], UNIT_SECONDS)
+}
+
+// PSetEXCommand psetex key milliseconds value
+func PSetEXCommand(c *Client, s *Server) {
+	setGenericCommand(c, s, OBJ_PX, c.Argv[1], c.Argv[3], c.Argv[2], UNIT_MILLISECONDS)
+}
+
+// SetNXCommand setnx key value
+func SetNXCommand(c *Client, s *Server) {
+	if lookupKey(c.Db, c.Argv[1]) != nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	dbAdd(c.Db, c.Argv[1], tryObjectEncoding(c.Argv[2]))
+	s.Dirty++
+	addReplyLongLong(c, 1)
+}
+
+// GetCommand get命令实现
+func GetCommand(c *Client, s *Server) {
+	o := lookupKey(c.Db, c.Argv[1])
+	if o != nil {
+		if checkType(c, o, ObjectTypeString) {
+			return
+		}
+		addReplyBulk(c, getStringFromObject(o))
+	} else {
+		addReplyNull(c)
+	}
+}
+
+// GetSetCommand getset key value
+func GetSetCommand(c *Client, s *Server) {
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyNull(c)
+	} else {
+		if checkType(c, o, ObjectTypeString) {
+			return
+		}
+		addReplyBulk(c, getStringFromObject(o))
+	}
+	setKey(c.Db, c.Argv[1], tryObjectEncoding(c.Argv[2]), false)
+	s.Dirty++
+}
+
+// SetRangeCommand setrange key offset value
+func SetRangeCommand(c *Client, s *Server) {
+	var offset int64
+	value := c.Argv[3].Ptr.(string)
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &offset, "") != C_OK {
+		return
+	}
+	if offset < 0 {
+		addReplyError(c, "ERR offset is out of range")
+		return
+	}
+
+	o := lookupKeyWrite(c.Db, c.Argv[1])
+	if o == nil {
+		/* Return 0 when setting nothing on a non-existing string */
+		if len(value) == 0 {
+			addReplyLongLong(c, 0)
+			return
+		}
+		/* Return when the resulting string exceeds allowed size */
+		if checkStringLength(c, offset+int64(len(value))) != C_OK {
+			return
+		}
+		o = CreateObject(ObjectTypeString, "")
+		dbAdd(c.Db, c.Argv[1], o)
+	} else {
+		/* Key exists, check type */
+		if checkType(c, o, ObjectTypeString) {
+			return
+		}
+		/* Return existing string length when setting nothing */
+		olen := stringObjectLen(o)
+		if len(value) == 0 {
+			addReplyLongLong(c, int64(olen))
+			return
+		}
+		/* Return when the resulting string exceeds allowed size */
+		if checkStringLength(c, offset+int64(len(value))) != C_OK {
+			return
+		}
+	}
+
+	/* Pad with zero bytes when the offset is past the end, then copy the
+	 * new value over the old one. */
+	buf := []byte(getStringFromObject(o))
+	if end := int(offset) + len(value); end > len(buf) {
+		buf = append(buf, make([]byte, end-len(buf))...)
+	}
+	copy(buf[offset:], value)
+	o.Ptr = string(buf)
+	o.Encoding = OBJ_ENCODING_RAW
+	s.Dirty++
+	addReplyLongLong(c, int64(len(buf)))
+}
+
+// GetRangeCommand getrange key start end
+func GetRangeCommand(c *Client, s *Server) {
+	var start, end int64
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &start, "") != C_OK {
+		return
+	}
+	if getLongLongFromObjectOrReply(c, c.Argv[3], &end, "") != C_OK {
+		return
+	}
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyBulk(c, "")
+		return
+	}
+	if checkType(c, o, ObjectTypeString) {
+		return
+	}
+	str := getStringFromObject(o)
+	strlen := int64(len(str))
+
+	/* Convert negative indexes */
+	if start < 0 && end < 0 && start > end {
+		addReplyBulk(c, "")
+		return
+	}
+	if start < 0 {
+		start = strlen + start
+	}
+	if end < 0 {
+		end = strlen + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= strlen {
+		end = strlen - 1
+	}
+
+	/* Precondition: end >= 0 && end < strlen, so the only condition where
+	 * nothing can be returned is: start > end. */
+	if start > end || strlen == 0 {
+		addReplyBulk(c, "")
+		return
+	}
+	addReplyBulk(c, str[start:end+1])
+}
+
+// MGetCommand mget key [key ...]
+func MGetCommand(c *Client, s *Server) {
+	items := make([]*proto.Resp, 0, c.Argc-1)
+	for j := 1; j < c.Argc; j++ {
+		o := lookupKey(c.Db, c.Argv[j])
+		if o == nil || o.ObjectType != ObjectTypeString {
+			items = append(items, proto.NewBulkBytes(nil))
+			continue
+		}
+		items = append(items, bulkString(getStringFromObject(o)))
+	}
+	addReplyArray(c, items)
+}
+
+// msetGenericCommand MSET/MSETNX nx为true时只要有一个key存在就不设置任何key
+func msetGenericCommand(c *Client, s *Server, nx bool) {
+	if c.Argc%2 == 0 {
+		addReplyError(c, "ERR wrong number of arguments for '"+strings.ToLower(c.Argv[0].Ptr.(string))+"' command")
+		return
+	}
+
+	/* Handle the NX flag. The MSETNX semantic is to return zero and don't
+	 * set anything if at least one key already exists. */
+	if nx {
+		for j := 1; j < c.Argc; j += 2 {
+			if lookupKey(c.Db, c.Argv[j]) != nil {
+				addReplyLongLong(c, 0)
+				return
+			}
+		}
+	}
+
+	for j := 1; j < c.Argc; j += 2 {
+		setKey(c.Db, c.Argv[j], tryObjectEncoding(c.Argv[j+1]), false)
+	}
+	s.Dirty += int64((c.Argc - 1) / 2)
+	if nx {
+		addReplyLongLong(c, 1)
+	} else {
+		addReplyStatus(c, "OK")
+	}
+}
+
+// MSetCommand mset key value [key value ...]
+func MSetCommand(c *Client, s *Server) {
+	msetGenericCommand(c, s, false)
+}
+
+// MSetNXCommand msetnx key value [key value ...]
+func MSetNXCommand(c *Client, s *Server) {
+	msetGenericCommand(c, s, true)
+}
+
+// incrDecrCommand INCR/DECR/INCRBY/DECRBY
+func incrDecrCommand(c *Client, s *Server, incr int64) {
+	var value int64
+	o := lookupKeyWrite(c.Db, c.Argv[1])
+	if o != nil && checkType(c, o, ObjectTypeString) {
+		return
+	}
+	if getLongLongFromObjectOrReply(c, o, &value, "") != C_OK {
+		return
+	}
+
+	oldvalue := value
+	if (incr < 0 && oldvalue < 0 && incr < (math.MinInt64-oldvalue)) ||
+		(incr > 0 && oldvalue > 0 && incr > (math.MaxInt64-oldvalue)) {
+		addReplyError(c, "ERR increment or decrement would overflow")
+		return
+	}
+	value += incr
+
+	if o != nil && o.Encoding == OBJ_ENCODING_INT {
+		/* Update the integer in place, no need to allocate a new object. */
+		o.Ptr = value
+	} else if o != nil {
+		dbOverwrite(c.Db, c.Argv[1], createStringObjectFromLongLong(value))
+	} else {
+		dbAdd(c.Db, c.Argv[1], createStringObjectFromLongLong(value))
+	}
+	s.Dirty++
+	addReplyLongLong(c, value)
+}
+
+// IncrCommand incr key
+func IncrCommand(c *Client, s *Server) {
+	incrDecrCommand(c, s, 1)
+}
+
+// DecrCommand decr key
+func DecrCommand(c *Client, s *Server) {
+	incrDecrCommand(c, s, -1)
+}
+
+// IncrByCommand incrby key increment
+func IncrByCommand(c *Client, s *Server) {
+	var incr int64
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &incr, "") != C_OK {
+		return
+	}
+	incrDecrCommand(c, s, incr)
+}
+
+// DecrByCommand decrby key decrement
+func DecrByCommand(c *Client, s *Server) {
+	var incr int64
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &incr, "") != C_OK {
+		return
+	}
+	/* Overflow check: negating LLONG_MIN will cause an overflow */
+	if incr == math.MinInt64 {
+		addReplyError(c, "ERR decrement would overflow")
+		return
+	}
+	incrDecrCommand(c, s, -incr)
+}
+
+// IncrByFloatCommand incrbyfloat key increment
+func IncrByFloatCommand(c *Client, s *Server) {
+	var incr, value float64
+	o := lookupKey(c.Db, c.Argv[1])
+	if o != nil && checkType(c, o, ObjectTypeString) {
+		return
+	}
+	if getDoubleFromObjectOrReply(c, o, &value, "") != C_OK ||
+		getDoubleFromObjectOrReply(c, c.Argv[2], &incr, "") != C_OK {
+		return
+	}
+
+	value += incr
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		addReplyError(c, "ERR increment would produce NaN or Infinity")
+		return
+	}
+	newvalue := formatDouble(value)
+	setKey(c.Db, c.Argv[1], CreateObject(ObjectTypeString, newvalue), true)
+	s.Dirty++
+	addReplyBulk(c, newvalue)
+
+	/* Always replicate INCRBYFLOAT as a SET command with the final value
+	 * in order to make sure that differences in float precision or formatting
+	 * will not create differences in replicas or after an AOF restart. */
+	rewriteClientCommandVector(c, CreateObject(ObjectTypeString, "set"),
+		c.Argv[1], CreateObject(ObjectTypeString, newvalue), CreateObject(ObjectTypeString, "keepttl"))
+}
+
+// AppendCommand append key value
+func AppendCommand(c *Client, s *Server) {
+	var totlen int
+	o := lookupKeyWrite(c.Db, c.Argv[1])
+	if o == nil {
+		/* Create the key */
+		dbAdd(c.Db, c.Argv[1], CreateObject(ObjectTypeString, c.Argv[2].Ptr.(string)))
+		totlen = len(c.Argv[2].Ptr.(string))
+	} else {
+		/* Key exists, check type */
+		if checkType(c, o, ObjectTypeString) {
+			return
+		}
+
+		/* "append" is an argument, so always a raw string */
+		appendStr := c.Argv[2].Ptr.(string)
+		if checkStringLength(c, int64(stringObjectLen(o)+len(appendStr))) != C_OK {
+			return
+		}
+
+		/* Append the value */
+		o.Ptr = getStringFromObject(o) + appendStr
+		o.Encoding = OBJ_ENCODING_RAW
+		totlen = len(o.Ptr.(string))
+	}
+	s.Dirty++
+	addReplyLongLong(c, int64(totlen))
+}
+
+// StrLenCommand strlen key
+func StrLenCommand(c *Client, s *Server) {
+	o := lookupKey(c.Db, c.Argv[1])
+	if o == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, o, ObjectTypeString) {
+		return
+	}
+	addReplyLongLong(c, int64(stringObjectLen(o)))
+}
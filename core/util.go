@@ -0,0 +1,426 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// toLower ASCII字符转小写
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+/* Glob-style pattern matching. */
+func stringmatchlenImpl(pattern string, str string, nocase bool, skipLongerMatches *bool) bool {
+	p, s := 0, 0
+	for p < len(pattern) && s < len(str) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p == len(pattern)-1 {
+				return true /* match */
+			}
+			for s < len(str) {
+				if stringmatchlenImpl(pattern[p+1:], str[s:], nocase, skipLongerMatches) {
+					return true /* match */
+				}
+				if *skipLongerMatches {
+					return false /* no match */
+				}
+				s++
+			}
+			/* There was no match for the rest of the pattern starting
+			 * from anywhere in the rest of the string. If there were
+			 * any '*' earlier in the pattern, we can terminate the
+			 * search early without trying to match them to longer
+			 * substrings. This is because a longer match for the
+			 * earlier part of the pattern would require the rest of the
+			 * pattern to match starting later in the string, and we
+			 * have just determined that there is no match for the rest
+			 * of the pattern starting from anywhere in the current
+			 * string. */
+			*skipLongerMatches = true
+			return false /* no match */
+		case '?':
+			s++
+		case '[':
+			p++
+			not := p < len(pattern) && pattern[p] == '^'
+			if not {
+				p++
+			}
+			match := false
+			for {
+				if p < len(pattern)-1 && pattern[p] == '\\' {
+					p++
+					if pattern[p] == str[s] {
+						match = true
+					}
+				} else if p >= len(pattern) {
+					p--
+					break
+				} else if pattern[p] == ']' {
+					break
+				} else if p+2 < len(pattern) && pattern[p+1] == '-' {
+					start, end, c := pattern[p], pattern[p+2], str[s]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = toLower(start), toLower(end), toLower(c)
+					}
+					p += 2
+					if c >= start && c <= end {
+						match = true
+					}
+				} else {
+					if !nocase {
+						if pattern[p] == str[s] {
+							match = true
+						}
+					} else {
+						if toLower(pattern[p]) == toLower(str[s]) {
+							match = true
+						}
+					}
+				}
+				p++
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false /* no match */
+			}
+			s++
+		case '\\':
+			if p < len(pattern)-1 {
+				p++
+			}
+			fallthrough
+		default:
+			if !nocase {
+				if pattern[p] != str[s] {
+					return false /* no match */
+				}
+			} else {
+				if toLower(pattern[p]) != toLower(str[s]) {
+					return false /* no match */
+				}
+			}
+			s++
+		}
+		p++
+		if s == len(str) {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			break
+		}
+	}
+	return p == len(pattern) && s == len(str)
+}
+
+// stringmatch glob风格的匹配 支持 * ? [...] 以及 \ 转义
+func stringmatch(pattern string, str string, nocase bool) bool {
+	skipLongerMatches := false
+	return stringmatchlenImpl(pattern, str, nocase, &skipLongerMatches)
+}
+
+/* Convert a string into an int64. Returns true if the string could be parsed
+ * into a (non-overflowing) int64, false otherwise. Unlike strconv.ParseInt
+ * the conversion is strict: no leading '+', no leading zeros and no spaces,
+ * so that the value formatted back is the very same string. */
+func string2ll(s string) (int64, bool) {
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(value, 10) != s {
+		return 0, false
+	}
+	return value, true
+}
+
+/* Convert a string representing an amount of memory into the number of
+ * bytes, so for instance memtoll("1Gb") will return 1073741824 that is
+ * (1024*1024*1024).
+ *
+ * On parsing error false is returned together with a zero value. */
+func memtoll(p string) (int64, bool) {
+	/* Search the first non digit character. */
+	u := 0
+	if u < len(p) && p[u] == '-' {
+		u++
+	}
+	for u < len(p) && p[u] >= '0' && p[u] <= '9' {
+		u++
+	}
+	var mul int64
+	switch strings.ToLower(p[u:]) {
+	case "", "b":
+		mul = 1
+	case "k":
+		mul = 1000
+	case "kb":
+		mul = 1024
+	case "m":
+		mul = 1000 * 1000
+	case "mb":
+		mul = 1024 * 1024
+	case "g":
+		mul = 1000 * 1000 * 1000
+	case "gb":
+		mul = 1024 * 1024 * 1024
+	default:
+		return 0, false
+	}
+	val, err := strconv.ParseInt(p[:u], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return val * mul, true
+}
+
+// formatMemory 以gb、mb或kb为单位格式化字节数 不能整除时直接使用字节数
+func formatMemory(bytes int64) string {
+	switch {
+	case bytes != 0 && bytes%(1024*1024*1024) == 0:
+		return strconv.FormatInt(bytes/(1024*1024*1024), 10) + "gb"
+	case bytes != 0 && bytes%(1024*1024) == 0:
+		return strconv.FormatInt(bytes/(1024*1024), 10) + "mb"
+	case bytes != 0 && bytes%1024 == 0:
+		return strconv.FormatInt(bytes/1024, 10) + "kb"
+	default:
+		return strconv.FormatInt(bytes, 10)
+	}
+}
+
+/* Helper function for splitArgs() that returns non zero if 'c'
+ * is a valid hex digit. */
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') ||
+		(c >= 'A' && c <= 'F')
+}
+
+/* Helper function for splitArgs() that converts a hex digit into an
+ * integer from 0 to 15 */
+func hexDigitToInt(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+/* Split a line into arguments, where every argument can be in the
+ * following programming-language REPL-alike form:
+ *
+ * foo bar "newline are supported\n" and "\xff\x00otherstuff"
+ *
+ * The number of arguments is returned together with true, or false is
+ * returned if the input contains unbalanced quotes or closed quotes
+ * followed by non space characters as in: "foo"bar or "foo'
+ *
+ * The caller should also check the resulting arguments rather than the
+ * raw line, since quotes and escapes are removed. */
+func splitArgs(line string) ([]string, bool) {
+	p := 0
+	args := []string{}
+	for {
+		/* skip blanks */
+		for p < len(line) && strings.IndexByte(" \n\r\t\v\f", line[p]) >= 0 {
+			p++
+		}
+		if p == len(line) {
+			/* we are done */
+			return args, true
+		}
+		inq := false  /* set to true if we are in "quotes" */
+		insq := false /* set to true if we are in 'single quotes' */
+		done := false
+		current := []byte{}
+		for !done {
+			if inq {
+				if p == len(line) {
+					/* unterminated quotes */
+					return nil, false
+				}
+				if line[p] == '\\' && p+3 < len(line) && line[p+1] == 'x' &&
+					isHexDigit(line[p+2]) && isHexDigit(line[p+3]) {
+					current = append(current, hexDigitToInt(line[p+2])*16+hexDigitToInt(line[p+3]))
+					p += 3
+				} else if line[p] == '\\' && p+1 < len(line) {
+					p++
+					var c byte
+					switch line[p] {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					default:
+						c = line[p]
+					}
+					current = append(current, c)
+				} else if line[p] == '"' {
+					/* closing quote must be followed by a space or
+					 * nothing at all. */
+					if p+1 < len(line) && strings.IndexByte(" \n\r\t\v\f", line[p+1]) < 0 {
+						return nil, false
+					}
+					done = true
+				} else {
+					current = append(current, line[p])
+				}
+			} else if insq {
+				if p == len(line) {
+					/* unterminated quotes */
+					return nil, false
+				}
+				if line[p] == '\\' && p+1 < len(line) && line[p+1] == '\'' {
+					p++
+					current = append(current, '\'')
+				} else if line[p] == '\'' {
+					/* closing quote must be followed by a space or
+					 * nothing at all. */
+					if p+1 < len(line) && strings.IndexByte(" \n\r\t\v\f", line[p+1]) < 0 {
+						return nil, false
+					}
+					done = true
+				} else {
+					current = append(current, line[p])
+				}
+			} else {
+				if p == len(line) {
+					break
+				}
+				switch line[p] {
+				case ' ', '\n', '\r', '\t', '\v', '\f':
+					done = true
+				case '"':
+					inq = true
+				case '\'':
+					insq = true
+				default:
+					current = append(current, line[p])
+				}
+			}
+			if p < len(line) {
+				p++
+			}
+		}
+		/* add the token to the vector */
+		args = append(args, string(current))
+	}
+}
+
+/* Return a quoted version of s, with all the non printable characters
+ * (tested with isprint()) turned into escapes in the form "\n\r\a....",
+ * so that splitArgs() can parse it back. */
+func catRepr(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString("\\n")
+		case '\r':
+			b.WriteString("\\r")
+		case '\t':
+			b.WriteString("\\t")
+		case '\a':
+			b.WriteString("\\a")
+		case '\b':
+			b.WriteString("\\b")
+		default:
+			if c >= ' ' && c <= '~' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "\\x%02x", c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+/* Return true if s contains characters that catRepr() would escape,
+ * or spaces, so that it can't be parsed back by splitArgs() as is. */
+func needsRepr(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\\' || c == '"' || c == '\'' || c <= ' ' || c > '~' {
+			return true
+		}
+	}
+	return false
+}
+
+// QuoteConfigArg 将命令行中的配置参数加上引号 使其按一个参数解析
+func QuoteConfigArg(arg string) string {
+	return catRepr(arg)
+}
+
+// strerror 错误对应的系统错误信息 如"no space left on device" 不是系统错误时返回完整的错误
+func strerror(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno.Error()
+	}
+	return err.Error()
+}
+
+// fileExist 文件存在且是普通文件时返回true
+func fileExist(filename string) bool {
+	fi, err := os.Stat(filename)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// dirExists 目录存在时返回true
+func dirExists(dname string) bool {
+	fi, err := os.Stat(dname)
+	return err == nil && fi.IsDir()
+}
+
+// dirCreateIfMissing 目录不存在时创建
+func dirCreateIfMissing(dname string) error {
+	err := os.Mkdir(dname, 0755)
+	if err != nil && os.IsExist(err) && dirExists(dname) {
+		return nil
+	}
+	return err
+}
+
+/* Fsync the directory containing the file, so that a rename or a newly
+ * created file in it survives a crash. Some file systems don't support
+ * fsync on directories, the error is ignored in this case. */
+func fsyncFileDir(filename string) error {
+	d, err := os.Open(filepath.Dir(filename))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.EBADF) {
+		return err
+	}
+	return nil
+}
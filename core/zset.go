@@ -1,24 +1,35 @@
 package core
 
 import (
+	"godis/core/proto"
+	"math"
 	"math/rand"
+	"sort"
+	"strings"
 )
 
 /* Input flags. */
 const ZADD_NONE = 0
 const ZADD_INCR = (1 << 0) /* Increment the score instead of setting it. */
 const ZADD_NX = (1 << 1)   /* Don't touch elements not already existing. */
-const ZADD_XX = (1 << 2)
+const ZADD_XX = (1 << 2)   /* Only touch elements already existing. */
+const ZADD_GT = (1 << 3)   /* Only update existing when new scores are higher. */
+const ZADD_LT = (1 << 4)   /* Only update existing when new scores are lower. */
 
 /* Output flags. */
-const ZADD_NOP = (1 << 3)     /* Operation not performed because of conditionals.*/
-const ZADD_NAN = (1 << 4)     /* Only touch elements already exisitng. */
-const ZADD_ADDED = (1 << 5)   /* The element was new and was added. */
-const ZADD_UPDATED = (1 << 6) /* The element already existed, score updated. */
+const ZADD_NOP = (1 << 5)     /* Operation not performed because of conditionals.*/
+const ZADD_NAN = (1 << 6)     /* Only touch elements already exisitng. */
+const ZADD_ADDED = (1 << 7)   /* The element was new and was added. */
+const ZADD_UPDATED = (1 << 8) /* The element already existed, score updated. */
 
 const ZSKIPLIST_MAXLEVEL = 32
 const ZSKIPLIST_P = 0.25 /* Skiplist P = 1/4 */
 
+// zremrangeGenericCommand 支持的范围类型
+const ZRANGE_RANK = 0
+const ZRANGE_SCORE = 1
+const ZRANGE_LEX = 2
+
 type zSet struct {
 	dict *dict
 	zsl  *zSkipList
@@ -51,12 +62,45 @@ type zRangeSpec struct {
 	maxEx int
 }
 
-func zaddCommand(c *Client) {
-	zaddGenericCommand(c, ZADD_NONE)
+// 弹出的位置
+const ZSET_MIN = 0
+const ZSET_MAX = 1
+
+// 集合运算类型
+const SET_OP_UNION = 0
+const SET_OP_DIFF = 1
+const SET_OP_INTER = 2
+
+// ZUNIONSTORE/ZINTERSTORE 的score聚合方式
+const AGGR_SUM = 1
+const AGGR_MIN = 2
+const AGGR_MAX = 3
+
+// zsetopsrc 参与集合运算的一个源集合及其权重
+type zsetopsrc struct {
+	zobj   *GodisObject
+	weight float64
+}
+
+// 字典序区间 ex含义同zRangeSpec
+// inf 为 -1 表示 "-" 即最小字符串 为 1 表示 "+" 即最大字符串
+type zLexRangeSpec struct {
+	min    string
+	max    string
+	minEx  int
+	maxEx  int
+	minInf int
+	maxInf int
+}
+
+// ZAddCommand zadd key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func ZAddCommand(c *Client, s *Server) {
+	zaddGenericCommand(c, s, ZADD_NONE)
 }
 
-func zincrbyCommand(c *Client) {
-	zaddGenericCommand(c, ZADD_INCR)
+// ZIncrByCommand zincrby key increment member
+func ZIncrByCommand(c *Client, s *Server) {
+	zaddGenericCommand(c, s, ZADD_INCR)
 }
 
 /*-----------------------------------------------------------------------------
@@ -64,46 +108,123 @@ func zincrbyCommand(c *Client) {
  *----------------------------------------------------------------------------*/
 
 /* This generic command implements both ZADD and ZINCRBY. */
-func zaddGenericCommand(c *Client, flags int) {
+func zaddGenericCommand(c *Client, s *Server, flags int) {
 	key := c.Argv[1]
+	ch := false
+
+	/* Parse options. At the end 'scoreIdx' is set to the argument position
+	 * of the score of the first score-element pair. */
 	scoreIdx := 2
+	for ; scoreIdx < c.Argc; scoreIdx++ {
+		opt := strings.ToLower(c.Argv[scoreIdx].Ptr.(string))
+		if opt == "nx" {
+			flags |= ZADD_NX
+		} else if opt == "xx" {
+			flags |= ZADD_XX
+		} else if opt == "gt" {
+			flags |= ZADD_GT
+		} else if opt == "lt" {
+			flags |= ZADD_LT
+		} else if opt == "ch" {
+			ch = true
+		} else if opt == "incr" {
+			flags |= ZADD_INCR
+		} else {
+			break
+		}
+	}
+
+	incr := (flags & ZADD_INCR) != 0
+	nx := (flags & ZADD_NX) != 0
+	xx := (flags & ZADD_XX) != 0
+	gt := (flags & ZADD_GT) != 0
+	lt := (flags & ZADD_LT) != 0
+
+	/* After the options, we expect to have an even number of args, since
+	 * we expect any number of score-element pairs. */
 	elements := c.Argc - scoreIdx
+	if elements%2 != 0 || elements == 0 {
+		addReplyError(c, errSyntax)
+		return
+	}
 	elements /= 2
-	scores := make([]float64, elements)
 
+	if nx && xx {
+		addReplyError(c, "ERR XX and NX options at the same time are not compatible")
+		return
+	}
+	if (gt && nx) || (lt && nx) || (gt && lt) {
+		addReplyError(c, "ERR GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	if incr && elements > 1 {
+		addReplyError(c, "ERR INCR option supports a single increment-element pair")
+		return
+	}
+
+	// 先解析全部score 任何一个非法都不做修改
+	scores := make([]float64, elements)
 	for j := 0; j < elements; j++ {
-		if value, ok := c.Argv[2+j*2].Ptr.(uint64); ok {
-			scores[j] = float64(value)
+		if getDoubleFromObjectOrReply(c, c.Argv[scoreIdx+j*2], &scores[j], "") != C_OK {
+			return
 		}
 	}
 
 	//这里首先在client对应的db中查找该key，即有序集
-	zobj := lookupKey(c.Db, key)
-	if zobj == nil {
+	zobj := lookupKeyWrite(c.Db, key)
+	if zobj != nil && checkType(c, zobj, OBJ_ZSET) {
+		return
+	}
+
+	added, updated, processed := 0, 0, 0
+	var score float64
+	if zobj == nil && !xx {
 		//hash+skiplist组合方式,后续再进行判断实现ziplist
 		zobj = createZsetObject()
 		//添加到c.db中
-		c.Db.Dict[key.Ptr.(string)] = zobj
+		dbAdd(c.Db, key, zobj)
 	}
 
-	for j := 0; j < elements; j++ {
-		var newScore float64
-		score := scores[j]
+	for j := 0; zobj != nil && j < elements; j++ {
 		retFlags := flags
-		if ele, ok := c.Argv[scoreIdx+1+j*2].Ptr.(string); ok {
-			zSetAdd(zobj, score, ele, &retFlags, &newScore)
+		ele := c.Argv[scoreIdx+1+j*2].Ptr.(string)
+		if !zSetAdd(zobj, scores[j], ele, &retFlags, &score) {
+			addReplyError(c, "ERR resulting score is not a number (NaN)")
+			return
+		}
+		if retFlags&ZADD_ADDED != 0 {
+			added++
+		}
+		if retFlags&ZADD_UPDATED != 0 {
+			updated++
+		}
+		if retFlags&ZADD_NOP == 0 {
+			processed++
 		}
-
 	}
+	s.Dirty += int64(added + updated)
 
+	if incr {
+		/* ZINCRBY or INCR option. */
+		if processed > 0 {
+			addReplyDouble(c, score)
+		} else {
+			addReplyNull(c)
+		}
+	} else {
+		/* ZADD. */
+		if ch {
+			addReplyLongLong(c, int64(added+updated))
+		} else {
+			addReplyLongLong(c, int64(added))
+		}
+	}
 }
 
 // create zset
 func createZsetObject() *GodisObject {
 	val := new(zSet)
-	val.dict = new(dict)
-	dict := make(map[string]*GodisObject)
-	*val.dict = dict
+	val.dict = dictCreate()
 
 	val.zsl = zslCreate() //这里创建节点
 	o := CreateObject(OBJ_ZSET, val)
@@ -126,57 +247,111 @@ func zslCreate() *zSkipList {
 }
 
 // 参数依次是有序集，要添加的元素的score，要添加的元素，操作模式，新的score
+// flags 既是输入也是输出 返回false表示score为NaN 未做任何修改
 func zSetAdd(zObj *GodisObject, score float64, ele string, flags *int, newScore *float64) bool {
 	incr := (*flags & ZADD_INCR) != 0
 	nx := (*flags & ZADD_NX) != 0
 	xx := (*flags & ZADD_XX) != 0
+	gt := (*flags & ZADD_GT) != 0
+	lt := (*flags & ZADD_LT) != 0
 	*flags = 0
 	var curscore float64
+
+	/* NaN as input is an error regardless of all the other parameters. */
+	if math.IsNaN(score) {
+		*flags = ZADD_NAN
+		return false
+	}
+
 	//暂只支持skiplist
 	if zObj.ObjectType == OBJ_ZSET {
 		//进行hash查找
 		zs := zObj.Ptr.(*zSet) //使用*zSet好，还是zSet好
 
 		dict := zs.dict
-		de := dictFind(dict, ele)
+		de := dict.dictFetchValue(ele)
 		if de != nil {
+			/* NX? Return, same element already exists. */
 			if nx {
-
+				*flags |= ZADD_NOP
+				return true
 			}
+			//获取存储的score
+			curscore = de.Ptr.(float64)
+
+			/* Prepare the score for the increment if needed. */
 			if incr {
+				score += curscore
+				if math.IsNaN(score) {
+					*flags |= ZADD_NAN
+					return false
+				}
+			}
 
+			/* GT/LT? Only update if score is greater/less than current. */
+			if (lt && score >= curscore) || (gt && score <= curscore) {
+				*flags |= ZADD_NOP
+				return true
 			}
-			//获取存储的score
-			if coreTemp, ok := de.Ptr.(float64); ok {
-				curscore = coreTemp
-			} else {
-				//exit
+
+			if newScore != nil {
+				*newScore = score
 			}
 
 			//remove and in-insert when score changes
 			if curscore != score {
-
+				zslUpdateScore(zs.zsl, curscore, ele, score)
+				de.Ptr = score
+				*flags |= ZADD_UPDATED
 			}
-
+			return true
 		} else if !xx {
 			//insert
 			zslInsert(zs.zsl, score, ele)
 			//插入dict
-			(*(zs.dict))[ele] = CreateObject(ObjectTypeString, score)
+			zs.dict.dictAdd(ele, CreateObject(ObjectTypeString, score))
 			*flags |= ZADD_ADDED
+			if newScore != nil {
+				*newScore = score
+			}
 			return true
 		}
-	} else {
-		//exit;
+		*flags |= ZADD_NOP
+		return true
 	}
-	return false /* Never reached. */
+	panic("Unknown sorted set encoding")
 }
 
-func dictFind(d *dict, key string) *GodisObject {
-	if (*d)[key] != nil {
-		return (*d)[key]
+/* This is a helper function for the COPY command.
+ * Duplicate a sorted set object. The elements are inserted from the tail
+ * so that every insertion happens at the head of the skiplist. */
+func zsetDup(o *GodisObject) *GodisObject {
+	zobj := createZsetObject()
+	zs := o.Ptr.(*zSet)
+	newzs := zobj.Ptr.(*zSet)
+	for ln := zs.zsl.tail; ln != nil; ln = ln.backward {
+		zslInsert(newzs.zsl, ln.score, ln.ele)
+		newzs.dict.dictAdd(ln.ele, CreateObject(ObjectTypeString, ln.score))
 	}
-	return nil
+	return zobj
+}
+
+// zsetDel 从有序集中删除成员 返回成员是否存在
+func zsetDel(zobj *GodisObject, ele string) bool {
+	zs := zobj.Ptr.(*zSet)
+	de := zs.dict.dictFetchValue(ele)
+	if de == nil {
+		return false
+	}
+	score := de.Ptr.(float64)
+	zs.dict.dictDelete(ele)
+	zslDelete(zs.zsl, score, ele, nil)
+	return true
+}
+
+// zsetLength 有序集成员个数
+func zsetLength(zobj *GodisObject) uint {
+	return zobj.Ptr.(*zSet).zsl.length
 }
 
 /*
@@ -245,6 +420,43 @@ func zslInsert(zsl *zSkipList, score float64, ele string) *zSkipListNode {
 	return x
 }
 
+/* Update the score of an element inside the sorted set skiplist.
+ * Note that the element must exist and must match 'score'.
+ *
+ * Note that this function attempts to just update the node, in case after
+ * the score update, the node would be exactly at the same position.
+ * Otherwise the skiplist is modified by removing and re-adding a new
+ * element, which is more costly. */
+func zslUpdateScore(zsl *zSkipList, curscore float64, ele string, newscore float64) *zSkipListNode {
+	update := make([]*zSkipListNode, ZSKIPLIST_MAXLEVEL)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (x.level[i].forward.score < curscore ||
+			(x.level[i].forward.score == curscore && (x.level[i].forward.ele < ele))) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	/* Jump to our element: note that this function assumes that the
+	 * element with the matching score exists. */
+	x = x.level[0].forward
+
+	/* If the node, after the score update, would be still exactly
+	 * at the same position, we can just update the score without
+	 * actually removing and re-inserting the element in the skiplist. */
+	if (x.backward == nil || x.backward.score < newscore) &&
+		(x.level[0].forward == nil || x.level[0].forward.score > newscore) {
+		x.score = newscore
+		return x
+	}
+
+	/* No way to reuse the old node: we need to remove and insert a new
+	 * one at a different place. */
+	zslDeleteNode(zsl, x, update)
+	return zslInsert(zsl, newscore, ele)
+}
+
 // 获取一个随机值作为新节点的层数
 func zslRandomLevel() int {
 	level := 1
@@ -290,6 +502,26 @@ func zslFirstInRange(zsl *zSkipList, zRange *zRangeSpec) *zSkipListNode {
 	return x
 }
 
+/* Find the last node that is contained in the specified range.
+ * Returns nil when no element is contained in the range. */
+func zslLastInRange(zsl *zSkipList, zRange *zRangeSpec) *zSkipListNode {
+	if !zslIsInRange(zsl, zRange) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && zslValueLteMax(x.level[i].forward.score, zRange) {
+			x = x.level[i].forward
+		}
+	}
+
+	/* Check if score >= min. */
+	if !zslValueGteMin(x.score, zRange) {
+		return nil
+	}
+	return x
+}
+
 func zslValueGteMin(value float64, spec *zRangeSpec) bool {
 	if spec.minEx != 0 {
 		return value > spec.min
@@ -370,15 +602,297 @@ func zslDeleteNode(zsl *zSkipList, x *zSkipListNode, update []*zSkipListNode) {
 	zsl.length--
 }
 
+/* Delete all the elements with score between min and max from the skiplist.
+ * Both min and max can be inclusive or exclusive (see range->minex and
+ * range->maxex). When inclusive a score >= min && score <= max is deleted.
+ * Note that this function takes the reference to the hash table view of the
+ * sorted set, in order to remove the elements from the hash table too. */
+func zslDeleteRangeByScore(zsl *zSkipList, zRange *zRangeSpec, d *dict) uint {
+	update := make([]*zSkipListNode, ZSKIPLIST_MAXLEVEL)
+	var removed uint
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !zslValueGteMin(x.level[i].forward.score, zRange) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	/* Current node is the last with score < or <= min. */
+	x = x.level[0].forward
+
+	/* Delete nodes while in range. */
+	for x != nil && zslValueLteMax(x.score, zRange) {
+		next := x.level[0].forward
+		zslDeleteNode(zsl, x, update)
+		d.dictDelete(x.ele)
+		removed++
+		x = next
+	}
+	return removed
+}
+
+/* Delete all the elements with rank between start and end from the skiplist.
+ * Start and end are inclusive. Note that start and end need to be 1-based */
+func zslDeleteRangeByRank(zsl *zSkipList, start uint, end uint, d *dict) uint {
+	update := make([]*zSkipListNode, ZSKIPLIST_MAXLEVEL)
+	var traversed, removed uint
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (traversed+x.level[i].span) < start {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	traversed++
+	x = x.level[0].forward
+	for x != nil && traversed <= end {
+		next := x.level[0].forward
+		zslDeleteNode(zsl, x, update)
+		d.dictDelete(x.ele)
+		removed++
+		traversed++
+		x = next
+	}
+	return removed
+}
+
+/* Find the rank for an element by both score and key.
+ * Returns 0 when the element cannot be found, rank otherwise.
+ * Note that the rank is 1-based due to the span of zsl->header to the
+ * first element. */
+func zslGetRank(zsl *zSkipList, score float64, ele string) uint {
+	var rank uint
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (x.level[i].forward.score < score ||
+			(x.level[i].forward.score == score && x.level[i].forward.ele <= ele)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		/* x might be equal to zsl->header, so test if obj is non-NULL */
+		if x != zsl.header && x.score == score && x.ele == ele {
+			return rank
+		}
+	}
+	return 0
+}
+
+/* Finds an element by its rank. The rank argument needs to be 1-based. */
+func zslGetElementByRank(zsl *zSkipList, rank uint) *zSkipListNode {
+	var traversed uint
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (traversed+x.level[i].span) <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// zslParseRangeItem 解析区间的一端 以'('开头表示开区间
+func zslParseRangeItem(item string, value *float64, ex *int) bool {
+	*ex = 0
+	if len(item) > 0 && item[0] == '(' {
+		*ex = 1
+		item = item[1:]
+	}
+	v, err := getDoubleFromObject(CreateObject(ObjectTypeString, item))
+	if err != nil {
+		return false
+	}
+	*value = v
+	return true
+}
+
+/* Populate the rangespec according to the objects min and max. */
+func zslParseRange(min *GodisObject, max *GodisObject, spec *zRangeSpec) int {
+	if !zslParseRangeItem(min.Ptr.(string), &spec.min, &spec.minEx) ||
+		!zslParseRangeItem(max.Ptr.(string), &spec.max, &spec.maxEx) {
+		return C_ERR
+	}
+	return C_OK
+}
+
+/*-----------------------------------------------------------------------------
+ * Lexicographic ranges
+ *----------------------------------------------------------------------------*/
+
+/* Parse max or min argument of ZRANGEBYLEX.
+ * (foo means foo (open interval)
+ * [foo means foo (closed interval)
+ * - means the min string possible
+ * + means the max string possible */
+func zslParseLexRangeItem(item string, dest *string, ex *int, inf *int) int {
+	if len(item) == 0 {
+		return C_ERR
+	}
+	*inf = 0
+	switch item[0] {
+	case '+':
+		if len(item) != 1 {
+			return C_ERR
+		}
+		*ex = 1
+		*inf = 1
+		*dest = ""
+	case '-':
+		if len(item) != 1 {
+			return C_ERR
+		}
+		*ex = 1
+		*inf = -1
+		*dest = ""
+	case '(':
+		*ex = 1
+		*dest = item[1:]
+	case '[':
+		*ex = 0
+		*dest = item[1:]
+	default:
+		return C_ERR
+	}
+	return C_OK
+}
+
+/* Populate the lex rangespec according to the objects min and max. */
+func zslParseLexRange(min *GodisObject, max *GodisObject, spec *zLexRangeSpec) int {
+	if zslParseLexRangeItem(min.Ptr.(string), &spec.min, &spec.minEx, &spec.minInf) != C_OK ||
+		zslParseLexRangeItem(max.Ptr.(string), &spec.max, &spec.maxEx, &spec.maxInf) != C_OK {
+		return C_ERR
+	}
+	return C_OK
+}
+
+/* This is just a wrapper to strings.Compare() that is able to
+ * handle the "-" and "+" special bounds. */
+func sdscmplex(a string, aInf int, b string, bInf int) int {
+	if aInf != 0 || bInf != 0 {
+		return aInf - bInf
+	}
+	return strings.Compare(a, b)
+}
+
+func zslLexValueGteMin(value string, spec *zLexRangeSpec) bool {
+	if spec.minEx != 0 {
+		return sdscmplex(value, 0, spec.min, spec.minInf) > 0
+	}
+	return sdscmplex(value, 0, spec.min, spec.minInf) >= 0
+}
+
+func zslLexValueLteMax(value string, spec *zLexRangeSpec) bool {
+	if spec.maxEx != 0 {
+		return sdscmplex(value, 0, spec.max, spec.maxInf) < 0
+	}
+	return sdscmplex(value, 0, spec.max, spec.maxInf) <= 0
+}
+
+/* Returns if there is a part of the zset is in the lex range. */
+func zslIsInLexRange(zsl *zSkipList, zRange *zLexRangeSpec) bool {
+	/* Test for ranges that will always be empty. */
+	cmp := sdscmplex(zRange.min, zRange.minInf, zRange.max, zRange.maxInf)
+	if cmp > 0 || (cmp == 0 && (zRange.minEx != 0 || zRange.maxEx != 0)) {
+		return false
+	}
+	x := zsl.tail
+	if x == nil || !zslLexValueGteMin(x.ele, zRange) {
+		return false
+	}
+	x = zsl.header.level[0].forward
+	if x == nil || !zslLexValueLteMax(x.ele, zRange) {
+		return false
+	}
+	return true
+}
+
+/* Find the first node that is contained in the specified lex range.
+ * Returns nil when no element is contained in the range. */
+func zslFirstInLexRange(zsl *zSkipList, zRange *zLexRangeSpec) *zSkipListNode {
+	if !zslIsInLexRange(zsl, zRange) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		/* Go forward while *OUT* of range. */
+		for x.level[i].forward != nil && !zslLexValueGteMin(x.level[i].forward.ele, zRange) {
+			x = x.level[i].forward
+		}
+	}
+
+	/* This is an inner range, so the next node cannot be NULL. */
+	x = x.level[0].forward
+
+	/* Check if score <= max. */
+	if !zslLexValueLteMax(x.ele, zRange) {
+		return nil
+	}
+	return x
+}
+
+/* Find the last node that is contained in the specified range.
+ * Returns nil when no element is contained in the range. */
+func zslLastInLexRange(zsl *zSkipList, zRange *zLexRangeSpec) *zSkipListNode {
+	if !zslIsInLexRange(zsl, zRange) {
+		return nil
+	}
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		/* Go forward while *IN* range. */
+		for x.level[i].forward != nil && zslLexValueLteMax(x.level[i].forward.ele, zRange) {
+			x = x.level[i].forward
+		}
+	}
+
+	/* Check if score >= min. */
+	if !zslLexValueGteMin(x.ele, zRange) {
+		return nil
+	}
+	return x
+}
+
+/* Delete all the elements with lex range between min and max from the
+ * skiplist, removing them from the hash table view as well. */
+func zslDeleteRangeByLex(zsl *zSkipList, zRange *zLexRangeSpec, d *dict) uint {
+	update := make([]*zSkipListNode, ZSKIPLIST_MAXLEVEL)
+	var removed uint
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !zslLexValueGteMin(x.level[i].forward.ele, zRange) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	/* Current node is the last with score < or <= min. */
+	x = x.level[0].forward
+
+	/* Delete nodes while in range. */
+	for x != nil && zslLexValueLteMax(x.ele, zRange) {
+		next := x.level[0].forward
+		zslDeleteNode(zsl, x, update)
+		d.dictDelete(x.ele)
+		removed++
+		x = next
+	}
+	return removed
+}
+
 func zsetScore(zobj *GodisObject, member string, score *float64) int {
-	if zobj == nil || member == "" {
+	if zobj == nil {
 		return C_ERR
 	}
 	// only search skiplist
 	if zobj.ObjectType == OBJ_ZSET {
 		zs := zobj.Ptr.(*zSet)
 		dict := zs.dict
-		de := dictFind(dict, member)
+		de := dict.dictFetchValue(member)
 
 		if de == nil {
 			return C_ERR
@@ -390,3 +904,917 @@ func zsetScore(zobj *GodisObject, member string, score *float64) int {
 	}
 	return C_OK
 }
+
+// ZScoreCommand zscore key member
+func ZScoreCommand(c *Client, s *Server) {
+	zobj := lookupKey(c.Db, c.Argv[1])
+	if zobj == nil {
+		addReplyNull(c)
+		return
+	}
+	if checkType(c, zobj, OBJ_ZSET) {
+		return
+	}
+	var score float64
+	if zsetScore(zobj, c.Argv[2].Ptr.(string), &score) == C_ERR {
+		addReplyNull(c)
+		return
+	}
+	addReplyDouble(c, score)
+}
+
+// ZCardCommand zcard key
+func ZCardCommand(c *Client, s *Server) {
+	zobj := lookupKey(c.Db, c.Argv[1])
+	if zobj == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, zobj, OBJ_ZSET) {
+		return
+	}
+	addReplyLongLong(c, int64(zsetLength(zobj)))
+}
+
+// ZRemCommand zrem key member [member ...]
+func ZRemCommand(c *Client, s *Server) {
+	key := c.Argv[1]
+	zobj := lookupKeyWrite(c.Db, key)
+	if zobj == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, zobj, OBJ_ZSET) {
+		return
+	}
+
+	deleted := 0
+	for j := 2; j < c.Argc; j++ {
+		if zsetDel(zobj, c.Argv[j].Ptr.(string)) {
+			deleted++
+		}
+		if zsetLength(zobj) == 0 {
+			dbDelete(c.Db, key)
+			break
+		}
+	}
+	s.Dirty += int64(deleted)
+	addReplyLongLong(c, int64(deleted))
+}
+
+// ZRemRangeByRankCommand zremrangebyrank key start stop
+func ZRemRangeByRankCommand(c *Client, s *Server) {
+	zremrangeGenericCommand(c, s, ZRANGE_RANK)
+}
+
+// ZRemRangeByScoreCommand zremrangebyscore key min max
+func ZRemRangeByScoreCommand(c *Client, s *Server) {
+	zremrangeGenericCommand(c, s, ZRANGE_SCORE)
+}
+
+// ZRemRangeByLexCommand zremrangebylex key min max
+func ZRemRangeByLexCommand(c *Client, s *Server) {
+	zremrangeGenericCommand(c, s, ZRANGE_LEX)
+}
+
+/* Implements ZREMRANGEBYRANK, ZREMRANGEBYSCORE, ZREMRANGEBYLEX commands. */
+func zremrangeGenericCommand(c *Client, s *Server, rangetype int) {
+	key := c.Argv[1]
+	var zrange zRangeSpec
+	var lexrange zLexRangeSpec
+	var start, end int64
+
+	/* Step 1: Parse the range. */
+	if rangetype == ZRANGE_RANK {
+		if getLongLongFromObjectOrReply(c, c.Argv[2], &start, "") != C_OK ||
+			getLongLongFromObjectOrReply(c, c.Argv[3], &end, "") != C_OK {
+			return
+		}
+	} else if rangetype == ZRANGE_SCORE {
+		if zslParseRange(c.Argv[2], c.Argv[3], &zrange) != C_OK {
+			addReplyError(c, "ERR min or max is not a float")
+			return
+		}
+	} else if rangetype == ZRANGE_LEX {
+		if zslParseLexRange(c.Argv[2], c.Argv[3], &lexrange) != C_OK {
+			addReplyError(c, "ERR min or max not valid string range item")
+			return
+		}
+	}
+
+	/* Step 2: Lookup & range sanity checks if needed. */
+	zobj := lookupKeyWrite(c.Db, key)
+	if zobj == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, zobj, OBJ_ZSET) {
+		return
+	}
+
+	/* Sanitize indexes. */
+	if rangetype == ZRANGE_RANK {
+		llen := int64(zsetLength(zobj))
+		if start < 0 {
+			start = llen + start
+		}
+		if end < 0 {
+			end = llen + end
+		}
+		if start < 0 {
+			start = 0
+		}
+
+		/* Invariant: start >= 0, so this test will be true when end < 0.
+		 * The range is empty when start > end or start >= length. */
+		if start > end || start >= llen {
+			addReplyLongLong(c, 0)
+			return
+		}
+		if end >= llen {
+			end = llen - 1
+		}
+	}
+
+	/* Step 3: Perform the range deletion operation. */
+	zs := zobj.Ptr.(*zSet)
+	var deleted uint
+	switch rangetype {
+	case ZRANGE_RANK:
+		/* Correct for 1-based rank. */
+		deleted = zslDeleteRangeByRank(zs.zsl, uint(start+1), uint(end+1), zs.dict)
+	case ZRANGE_SCORE:
+		deleted = zslDeleteRangeByScore(zs.zsl, &zrange, zs.dict)
+	case ZRANGE_LEX:
+		deleted = zslDeleteRangeByLex(zs.zsl, &lexrange, zs.dict)
+	}
+	if zsetLength(zobj) == 0 {
+		dbDelete(c.Db, key)
+	}
+
+	/* Step 4: Notifications and reply. */
+	s.Dirty += int64(deleted)
+	addReplyLongLong(c, int64(deleted))
+}
+
+// ZRankCommand zrank key member
+func ZRankCommand(c *Client, s *Server) {
+	zrankGenericCommand(c, false)
+}
+
+// ZRevRankCommand zrevrank key member
+func ZRevRankCommand(c *Client, s *Server) {
+	zrankGenericCommand(c, true)
+}
+
+func zrankGenericCommand(c *Client, reverse bool) {
+	zobj := lookupKey(c.Db, c.Argv[1])
+	if zobj == nil {
+		addReplyNull(c)
+		return
+	}
+	if checkType(c, zobj, OBJ_ZSET) {
+		return
+	}
+
+	llen := zsetLength(zobj)
+	var score float64
+	if zsetScore(zobj, c.Argv[2].Ptr.(string), &score) == C_ERR {
+		addReplyNull(c)
+		return
+	}
+	rank := zslGetRank(zobj.Ptr.(*zSet).zsl, score, c.Argv[2].Ptr.(string))
+	/* Existing elements always have a rank. */
+	if reverse {
+		addReplyLongLong(c, int64(llen-rank))
+	} else {
+		addReplyLongLong(c, int64(rank-1))
+	}
+}
+
+// ZRangeCommand zrange key start stop [WITHSCORES]
+func ZRangeCommand(c *Client, s *Server) {
+	zrangeGenericCommand(c, false)
+}
+
+// ZRevRangeCommand zrevrange key start stop [WITHSCORES]
+func ZRevRangeCommand(c *Client, s *Server) {
+	zrangeGenericCommand(c, true)
+}
+
+func zrangeGenericCommand(c *Client, reverse bool) {
+	var start, end int64
+	withscores := false
+
+	if getLongLongFromObjectOrReply(c, c.Argv[2], &start, "") != C_OK ||
+		getLongLongFromObjectOrReply(c, c.Argv[3], &end, "") != C_OK {
+		return
+	}
+	if c.Argc == 5 && strings.EqualFold(c.Argv[4].Ptr.(string), "withscores") {
+		withscores = true
+	} else if c.Argc >= 5 {
+		addReplyError(c, errSyntax)
+		return
+	}
+
+	zobj := lookupKey(c.Db, c.Argv[1])
+	if zobj == nil {
+		addReplyArray(c, nil)
+		return
+	}
+	if checkType(c, zobj, OBJ_ZSET) {
+		return
+	}
+
+	/* Sanitize indexes. */
+	llen := int64(zsetLength(zobj))
+	if start < 0 {
+		start = llen + start
+	}
+	if end < 0 {
+		end = llen + end
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	/* Invariant: start >= 0, so this test will be true when end < 0.
+	 * The range is empty when start > end or start >= length. */
+	if start > end || start >= llen {
+		addReplyArray(c, nil)
+		return
+	}
+	if end >= llen {
+		end = llen - 1
+	}
+	rangelen := end - start + 1
+
+	zsl := zobj.Ptr.(*zSet).zsl
+	var ln *zSkipListNode
+
+	/* Check if starting point is trivial, before doing log(N) lookup. */
+	if reverse {
+		ln = zsl.tail
+		if start > 0 {
+			ln = zslGetElementByRank(zsl, uint(llen-start))
+		}
+	} else {
+		ln = zsl.header.level[0].forward
+		if start > 0 {
+			ln = zslGetElementByRank(zsl, uint(start+1))
+		}
+	}
+
+	items := make([]*proto.Resp, 0, rangelen)
+	for ; rangelen > 0; rangelen-- {
+		items = append(items, bulkString(ln.ele))
+		if withscores {
+			items = append(items, doubleValue(ln.score))
+		}
+		if reverse {
+			ln = ln.backward
+		} else {
+			ln = ln.level[0].forward
+		}
+	}
+	addReplyArray(c, items)
+}
+
+// ZRangeByScoreCommand zrangebyscore key min max [WITHSCORES] [LIMIT offset count]
+func ZRangeByScoreCommand(c *Client, s *Server) {
+	genericZrangebyscoreCommand(c, false)
+}
+
+// ZRevRangeByScoreCommand zrevrangebyscore key max min [WITHSCORES] [LIMIT offset count]
+func ZRevRangeByScoreCommand(c *Client, s *Server) {
+	genericZrangebyscoreCommand(c, true)
+}
+
+/* This command implements ZRANGEBYSCORE, ZREVRANGEBYSCORE. */
+func genericZrangebyscoreCommand(c *Client, reverse bool) {
+	var zrange zRangeSpec
+	var offset, limit int64 = 0, -1
+	withscores := false
+
+	/* Parse the range arguments. */
+	minidx, maxidx := 2, 3
+	if reverse {
+		/* Range is given as [max,min] */
+		minidx, maxidx = 3, 2
+	}
+	if zslParseRange(c.Argv[minidx], c.Argv[maxidx], &zrange) != C_OK {
+		addReplyError(c, "ERR min or max is not a float")
+		return
+	}
+
+	/* Parse optional extra arguments. */
+	for pos := 4; pos < c.Argc; pos++ {
+		remaining := c.Argc - pos
+		opt := c.Argv[pos].Ptr.(string)
+		if strings.EqualFold(opt, "withscores") {
+			withscores = true
+		} else if remaining >= 3 && strings.EqualFold(opt, "limit") {
+			if getLongLongFromObjectOrReply(c, c.Argv[pos+1], &offset, "") != C_OK ||
+				getLongLongFromObjectOrReply(c, c.Argv[pos+2], &limit, "") != C_OK {
+				return
+			}
+			pos += 2
+		} else {
+			addReplyError(c, errSyntax)
+			return
+		}
+	}
+
+	/* Ok, lookup the key and get the range */
+	zobj := lookupKey(c.Db, c.Argv[1])
+	if zobj == nil {
+		addReplyArray(c, nil)
+		return
+	}
+	if checkType(c, zobj, OBJ_ZSET) {
+		return
+	}
+	if offset < 0 {
+		addReplyArray(c, nil)
+		return
+	}
+
+	zsl := zobj.Ptr.(*zSet).zsl
+	var ln *zSkipListNode
+
+	/* If reversed, get the last node in range as starting point. */
+	if reverse {
+		ln = zslLastInRange(zsl, &zrange)
+	} else {
+		ln = zslFirstInRange(zsl, &zrange)
+	}
+
+	/* If there is an offset, just element by element until we get
+	 * to the right offset. */
+	for ln != nil && offset > 0 {
+		if reverse {
+			ln = ln.backward
+		} else {
+			ln = ln.level[0].forward
+		}
+		offset--
+	}
+
+	items := make([]*proto.Resp, 0)
+	for ln != nil && limit != 0 {
+		/* Abort when the node is no longer in range. */
+		if reverse {
+			if !zslValueGteMin(ln.score, &zrange) {
+				break
+			}
+		} else {
+			if !zslValueLteMax(ln.score, &zrange) {
+				break
+			}
+		}
+
+		items = append(items, bulkString(ln.ele))
+		if withscores {
+			items = append(items, doubleValue(ln.score))
+		}
+
+		/* Move to next node */
+		if reverse {
+			ln = ln.backward
+		} else {
+			ln = ln.level[0].forward
+		}
+		limit--
+	}
+	addReplyArray(c, items)
+}
+
+// ZLexCountCommand zlexcount key min max
+func ZLexCountCommand(c *Client, s *Server) {
+	var zrange zLexRangeSpec
+
+	/* Parse the range arguments */
+	if zslParseLexRange(c.Argv[2], c.Argv[3], &zrange) != C_OK {
+		addReplyError(c, "ERR min or max not valid string range item")
+		return
+	}
+
+	/* Lookup the sorted set */
+	zobj := lookupKey(c.Db, c.Argv[1])
+	if zobj == nil {
+		addReplyLongLong(c, 0)
+		return
+	}
+	if checkType(c, zobj, OBJ_ZSET) {
+		return
+	}
+
+	zsl := zobj.Ptr.(*zSet).zsl
+	var count uint
+
+	/* Find first element in range */
+	zn := zslFirstInLexRange(zsl, &zrange)
+
+	/* Use rank of first element, if any, to determine preliminary count */
+	if zn != nil {
+		rank := zslGetRank(zsl, zn.score, zn.ele)
+		count = zsl.length - (rank - 1)
+
+		/* Find last element in range */
+		zn = zslLastInLexRange(zsl, &zrange)
+
+		/* Use rank of last element, if any, to determine the actual count */
+		if zn != nil {
+			rank = zslGetRank(zsl, zn.score, zn.ele)
+			count -= zsl.length - rank
+		}
+	}
+	addReplyLongLong(c, int64(count))
+}
+
+// ZRangeByLexCommand zrangebylex key min max [LIMIT offset count]
+func ZRangeByLexCommand(c *Client, s *Server) {
+	genericZrangebylexCommand(c, false)
+}
+
+// ZRevRangeByLexCommand zrevrangebylex key max min [LIMIT offset count]
+func ZRevRangeByLexCommand(c *Client, s *Server) {
+	genericZrangebylexCommand(c, true)
+}
+
+/* This command implements ZRANGEBYLEX, ZREVRANGEBYLEX. */
+func genericZrangebylexCommand(c *Client, reverse bool) {
+	var zrange zLexRangeSpec
+	var offset, limit int64 = 0, -1
+
+	/* Parse the range arguments. */
+	minidx, maxidx := 2, 3
+	if reverse {
+		/* Range is given as [max,min] */
+		minidx, maxidx = 3, 2
+	}
+	if zslParseLexRange(c.Argv[minidx], c.Argv[maxidx], &zrange) != C_OK {
+		addReplyError(c, "ERR min or max not valid string range item")
+		return
+	}
+
+	/* Parse optional extra arguments. */
+	for pos := 4; pos < c.Argc; pos++ {
+		remaining := c.Argc - pos
+		if remaining >= 3 && strings.EqualFold(c.Argv[pos].Ptr.(string), "limit") {
+			if getLongLongFromObjectOrReply(c, c.Argv[pos+1], &offset, "") != C_OK ||
+				getLongLongFromObjectOrReply(c, c.Argv[pos+2], &limit, "") != C_OK {
+				return
+			}
+			pos += 2
+		} else {
+			addReplyError(c, errSyntax)
+			return
+		}
+	}
+
+	/* Ok, lookup the key and get the range */
+	zobj := lookupKey(c.Db, c.Argv[1])
+	if zobj == nil {
+		addReplyArray(c, nil)
+		return
+	}
+	if checkType(c, zobj, OBJ_ZSET) {
+		return
+	}
+	if offset < 0 {
+		addReplyArray(c, nil)
+		return
+	}
+
+	zsl := zobj.Ptr.(*zSet).zsl
+	var ln *zSkipListNode
+
+	/* If reversed, get the last node in range as starting point. */
+	if reverse {
+		ln = zslLastInLexRange(zsl, &zrange)
+	} else {
+		ln = zslFirstInLexRange(zsl, &zrange)
+	}
+
+	/* If there is an offset, just element by element until we get
+	 * to the right offset. */
+	for ln != nil && offset > 0 {
+		if reverse {
+			ln = ln.backward
+		} else {
+			ln = ln.level[0].forward
+		}
+		offset--
+	}
+
+	items := make([]*proto.Resp, 0)
+	for ln != nil && limit != 0 {
+		/* Abort when the node is no longer in range. */
+		if reverse {
+			if !zslLexValueGteMin(ln.ele, &zrange) {
+				break
+			}
+		} else {
+			if !zslLexValueLteMax(ln.ele, &zrange) {
+				break
+			}
+		}
+
+		items = append(items, bulkString(ln.ele))
+
+		/* Move to next node */
+		if reverse {
+			ln = ln.backward
+		} else {
+			ln = ln.level[0].forward
+		}
+		limit--
+	}
+	addReplyArray(c, items)
+}
+
+/*-----------------------------------------------------------------------------
+ * Sorted set algebra: ZUNION, ZINTER, ZDIFF and their STORE variants
+ *----------------------------------------------------------------------------*/
+
+// ZUnionStoreCommand zunionstore destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func ZUnionStoreCommand(c *Client, s *Server) {
+	zunionInterDiffGenericCommand(c, s, c.Argv[1], 2, SET_OP_UNION)
+}
+
+// ZInterStoreCommand zinterstore destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func ZInterStoreCommand(c *Client, s *Server) {
+	zunionInterDiffGenericCommand(c, s, c.Argv[1], 2, SET_OP_INTER)
+}
+
+// ZDiffStoreCommand zdiffstore destination numkeys key [key ...]
+func ZDiffStoreCommand(c *Client, s *Server) {
+	zunionInterDiffGenericCommand(c, s, c.Argv[1], 2, SET_OP_DIFF)
+}
+
+// ZUnionCommand zunion numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func ZUnionCommand(c *Client, s *Server) {
+	zunionInterDiffGenericCommand(c, s, nil, 1, SET_OP_UNION)
+}
+
+// ZInterCommand zinter numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func ZInterCommand(c *Client, s *Server) {
+	zunionInterDiffGenericCommand(c, s, nil, 1, SET_OP_INTER)
+}
+
+// ZDiffCommand zdiff numkeys key [key ...] [WITHSCORES]
+func ZDiffCommand(c *Client, s *Server) {
+	zunionInterDiffGenericCommand(c, s, nil, 1, SET_OP_DIFF)
+}
+
+// zunionInterAggregate 按聚合方式合并score 结果为NaN时按0处理
+func zunionInterAggregate(target *float64, val float64, aggregate int) {
+	switch aggregate {
+	case AGGR_SUM:
+		*target = *target + val
+		/* The result of adding two doubles is NaN when one variable
+		 * is +inf and the other is -inf. When these numbers are added,
+		 * we maintain the convention of the result being 0.0. */
+		if math.IsNaN(*target) {
+			*target = 0.0
+		}
+	case AGGR_MIN:
+		if val < *target {
+			*target = val
+		}
+	case AGGR_MAX:
+		if val > *target {
+			*target = val
+		}
+	default:
+		/* safety net */
+		panic("Unknown ZUNION/INTER aggregate type")
+	}
+}
+
+// zsetWeightedScore 计算带权重的score 0*inf 的结果按0处理
+func zsetWeightedScore(weight float64, score float64) float64 {
+	value := weight * score
+	if math.IsNaN(value) {
+		return 0
+	}
+	return value
+}
+
+/* The dstkey is nil for the non-STORE variants, in which case the result
+ * is replied to the client instead of being stored. */
+func zunionInterDiffGenericCommand(c *Client, s *Server, dstkey *GodisObject, numkeysIndex int, op int) {
+	var numkeys int64
+	aggregate := AGGR_SUM
+	withscores := false
+
+	/* expect setnum input keys to be given */
+	if getLongLongFromObjectOrReply(c, c.Argv[numkeysIndex], &numkeys, "") != C_OK {
+		return
+	}
+	if numkeys < 1 {
+		addReplyError(c, "ERR at least 1 input key is needed for '"+c.Cmd.Name+"' command")
+		return
+	}
+
+	/* test if the expected number of keys would overflow */
+	if numkeys > int64(c.Argc-numkeysIndex-1) {
+		addReplyError(c, errSyntax)
+		return
+	}
+
+	/* read keys to be used for input */
+	src := make([]zsetopsrc, numkeys)
+	j := numkeysIndex + 1
+	for i := 0; i < int(numkeys); i, j = i+1, j+1 {
+		obj := lookupKey(c.Db, c.Argv[j])
+		if obj != nil && checkType(c, obj, OBJ_ZSET) {
+			return
+		}
+		src[i].zobj = obj
+		src[i].weight = 1.0
+	}
+
+	/* parse optional extra arguments */
+	for ; j < c.Argc; j++ {
+		remaining := c.Argc - j
+		opt := c.Argv[j].Ptr.(string)
+		if op != SET_OP_DIFF && remaining >= int(numkeys)+1 && strings.EqualFold(opt, "weights") {
+			j++
+			for i := 0; i < int(numkeys); i, j = i+1, j+1 {
+				if getDoubleFromObjectOrReply(c, c.Argv[j], &src[i].weight,
+					"ERR weight value is not a float") != C_OK {
+					return
+				}
+			}
+			j--
+		} else if op != SET_OP_DIFF && remaining >= 2 && strings.EqualFold(opt, "aggregate") {
+			j++
+			switch strings.ToLower(c.Argv[j].Ptr.(string)) {
+			case "sum":
+				aggregate = AGGR_SUM
+			case "min":
+				aggregate = AGGR_MIN
+			case "max":
+				aggregate = AGGR_MAX
+			default:
+				addReplyError(c, errSyntax)
+				return
+			}
+		} else if dstkey == nil && strings.EqualFold(opt, "withscores") {
+			withscores = true
+		} else {
+			addReplyError(c, errSyntax)
+			return
+		}
+	}
+
+	dstobj := createZsetObject()
+	dstzset := dstobj.Ptr.(*zSet)
+
+	if op == SET_OP_INTER {
+		/* sort sets from the smallest to largest, this will improve our
+		 * algorithm's performance */
+		sort.SliceStable(src, func(a, b int) bool {
+			return zsetopsrcLength(src[a]) < zsetopsrcLength(src[b])
+		})
+
+		/* Skip everything if the smallest input is empty. */
+		if src[0].zobj != nil && zsetLength(src[0].zobj) > 0 {
+			/* Precondition: as src[0] is non-empty and the inputs are ordered
+			 * by size, all src[i > 0] are non-empty too. */
+			for ln := src[0].zobj.Ptr.(*zSet).zsl.header.level[0].forward; ln != nil; ln = ln.level[0].forward {
+				score := zsetWeightedScore(src[0].weight, ln.score)
+				found := true
+				for i := 1; i < len(src); i++ {
+					var value float64
+					if zsetScore(src[i].zobj, ln.ele, &value) == C_ERR {
+						found = false
+						break
+					}
+					zunionInterAggregate(&score, zsetWeightedScore(src[i].weight, value), aggregate)
+				}
+
+				/* Only continue when present in every input. */
+				if found {
+					zslInsert(dstzset.zsl, score, ln.ele)
+					dstzset.dict.dictAdd(ln.ele, CreateObject(ObjectTypeString, score))
+				}
+			}
+		}
+	} else if op == SET_OP_UNION {
+		accumulator := make(map[string]float64)
+		for i := 0; i < len(src); i++ {
+			if src[i].zobj == nil {
+				continue
+			}
+			for ln := src[i].zobj.Ptr.(*zSet).zsl.header.level[0].forward; ln != nil; ln = ln.level[0].forward {
+				score := zsetWeightedScore(src[i].weight, ln.score)
+				if existing, ok := accumulator[ln.ele]; ok {
+					zunionInterAggregate(&existing, score, aggregate)
+					accumulator[ln.ele] = existing
+				} else {
+					accumulator[ln.ele] = score
+				}
+			}
+		}
+		for ele, score := range accumulator {
+			zslInsert(dstzset.zsl, score, ele)
+			dstzset.dict.dictAdd(ele, CreateObject(ObjectTypeString, score))
+		}
+	} else if op == SET_OP_DIFF {
+		if src[0].zobj != nil {
+			for ln := src[0].zobj.Ptr.(*zSet).zsl.header.level[0].forward; ln != nil; ln = ln.level[0].forward {
+				exists := false
+				for i := 1; i < len(src); i++ {
+					var value float64
+					if src[i].zobj != nil && zsetScore(src[i].zobj, ln.ele, &value) == C_OK {
+						exists = true
+						break
+					}
+				}
+				if !exists {
+					zslInsert(dstzset.zsl, ln.score, ln.ele)
+					dstzset.dict.dictAdd(ln.ele, CreateObject(ObjectTypeString, ln.score))
+				}
+			}
+		}
+	} else {
+		panic("Unknown operator")
+	}
+
+	if dstkey != nil {
+		dbDelete(c.Db, dstkey)
+		if zsetLength(dstobj) > 0 {
+			dbAdd(c.Db, dstkey, dstobj)
+		}
+		addReplyLongLong(c, int64(zsetLength(dstobj)))
+		s.Dirty++
+		return
+	}
+
+	items := make([]*proto.Resp, 0, zsetLength(dstobj))
+	for ln := dstzset.zsl.header.level[0].forward; ln != nil; ln = ln.level[0].forward {
+		items = append(items, bulkString(ln.ele))
+		if withscores {
+			items = append(items, doubleValue(ln.score))
+		}
+	}
+	addReplyArray(c, items)
+}
+
+// zsetopsrcLength 源集合的元素个数 不存在的key视为空集
+func zsetopsrcLength(op zsetopsrc) uint {
+	if op.zobj == nil {
+		return 0
+	}
+	return zsetLength(op.zobj)
+}
+
+/*-----------------------------------------------------------------------------
+ * Pop operations: ZPOPMIN, ZPOPMAX, BZPOPMIN, BZPOPMAX
+ *----------------------------------------------------------------------------*/
+
+// zsetPop 弹出score最小或最大的成员 调用方需保证有序集非空
+func zsetPop(zobj *GodisObject, where int) (string, float64) {
+	zsl := zobj.Ptr.(*zSet).zsl
+	ln := zsl.header.level[0].forward
+	if where == ZSET_MAX {
+		ln = zsl.tail
+	}
+	ele, score := ln.ele, ln.score
+	zsetDel(zobj, ele)
+	return ele, score
+}
+
+/* This command implements the generic zpop operation, used by:
+ * ZPOPMIN, ZPOPMAX, BZPOPMIN and BZPOPMAX. This function is also used
+ * inside blocked.go in the unblocking stage of BZPOPMIN and BZPOPMAX.
+ *
+ * If 'emitkey' is true also the key name is emitted, useful for the blocking
+ * behavior of BZPOP[MIN|MAX], since we can block into multiple keys.
+ *
+ * The synchronous version instead does not need to emit the key, but may
+ * use the 'count' argument to return multiple items if available. */
+func genericZpopCommand(c *Client, s *Server, keys []*GodisObject, where int, emitkey bool, countarg *GodisObject) {
+	var count int64 = 1
+	if countarg != nil {
+		if getLongLongFromObjectOrReply(c, countarg, &count, "") != C_OK {
+			return
+		}
+		if count <= 0 {
+			/* ZPOPMIN key 0 and ZPOPMIN key -1 both reply an empty array. */
+			if count < 0 {
+				addReplyError(c, "ERR value is out of range, must be positive")
+			} else {
+				addReplyArray(c, nil)
+			}
+			return
+		}
+	}
+
+	/* Check type and break on the first error, otherwise identify candidate. */
+	var key *GodisObject
+	var zobj *GodisObject
+	for _, k := range keys {
+		zobj = lookupKeyWrite(c.Db, k)
+		if zobj == nil {
+			continue
+		}
+		if checkType(c, zobj, OBJ_ZSET) {
+			return
+		}
+		key = k
+		break
+	}
+
+	/* No candidate for zpopping, return empty. */
+	if zobj == nil {
+		addReplyArray(c, nil)
+		return
+	}
+
+	items := make([]*proto.Resp, 0)
+	if emitkey {
+		items = append(items, bulkString(key.Ptr.(string)))
+	}
+	var popped int64
+	for ; popped < count && zsetLength(zobj) > 0; popped++ {
+		ele, score := zsetPop(zobj, where)
+		items = append(items, bulkString(ele), doubleValue(score))
+	}
+	if zsetLength(zobj) == 0 {
+		dbDelete(c.Db, key)
+	}
+	s.Dirty += popped
+	addReplyArray(c, items)
+}
+
+// ZPopMinCommand zpopmin key [count]
+func ZPopMinCommand(c *Client, s *Server) {
+	var count *GodisObject
+	if c.Argc > 3 {
+		addReplyError(c, errSyntax)
+		return
+	} else if c.Argc == 3 {
+		count = c.Argv[2]
+	}
+	genericZpopCommand(c, s, c.Argv[1:2], ZSET_MIN, false, count)
+}
+
+// ZPopMaxCommand zpopmax key [count]
+func ZPopMaxCommand(c *Client, s *Server) {
+	var count *GodisObject
+	if c.Argc > 3 {
+		addReplyError(c, errSyntax)
+		return
+	} else if c.Argc == 3 {
+		count = c.Argv[2]
+	}
+	genericZpopCommand(c, s, c.Argv[1:2], ZSET_MAX, false, count)
+}
+
+/* BZPOPMIN / BZPOPMAX actual implementation. */
+func blockingGenericZpopCommand(c *Client, s *Server, where int) {
+	var timeout int64
+	if getTimeoutFromObjectOrReply(c, c.Argv[c.Argc-1], &timeout) != C_OK {
+		return
+	}
+
+	keys := c.Argv[1 : c.Argc-1]
+	for _, key := range keys {
+		o := lookupKeyWrite(c.Db, key)
+		if o == nil {
+			continue
+		}
+		if checkType(c, o, OBJ_ZSET) {
+			return
+		}
+		if zsetLength(o) != 0 {
+			/* Non empty zset, this is like a normal ZPOP[MIN|MAX]. */
+			genericZpopCommand(c, s, []*GodisObject{key}, where, true, nil)
+
+			/* Replicate it as ZPOP[MIN|MAX] instead of BZPOP[MIN|MAX]. */
+			cmdName := "zpopmin"
+			if where == ZSET_MAX {
+				cmdName = "zpopmax"
+			}
+			rewriteClientCommandVector(c, CreateObject(ObjectTypeString, cmdName), key)
+			return
+		}
+	}
+
+	/* If the keys do not exist we must block */
+	blockForKeys(c, BLOCKED_ZSET, keys, timeout, nil, where, 0)
+}
+
+// BZPopMinCommand bzpopmin key [key ...] timeout
+func BZPopMinCommand(c *Client, s *Server) {
+	blockingGenericZpopCommand(c, s, ZSET_MIN)
+}
+
+// BZPopMaxCommand bzpopmax key [key ...] timeout
+func BZPopMaxCommand(c *Client, s *Server) {
+	blockingGenericZpopCommand(c, s, ZSET_MAX)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"godis/core"
+	"godis/core/proto"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// godis-check-aof 检查aof文件是否完整 参考redis-check-aof
+// 参数可以是manifest 此时按顺序检查其中的BASE和INCR文件 也可以是单个aof文件
+// 文件末尾有不完整或损坏的命令时 --fix 将文件截断到最后一条完整的命令之后 只能修复最后一个文件
+
+// 输入文件的类型
+const AOF_RESP = 0         // 只包含命令的aof
+const AOF_RDB_PREAMBLE = 1 // 以rdb开头的aof 或rdb格式的BASE文件
+const AOF_MULTI_PART = 2   // manifest
+
+// 检查单个文件的结果
+const AOF_CHECK_OK = 0
+const AOF_CHECK_EMPTY = 1
+const AOF_CHECK_TRUNCATED = 2
+
+func main() {
+	var filename string
+	fix := false
+	argv := os.Args
+	switch len(argv) {
+	case 2:
+		filename = argv[1]
+	case 3:
+		if argv[1] != "--fix" {
+			fmt.Printf("Invalid argument: %s\n", argv[1])
+			os.Exit(1)
+		}
+		filename = argv[2]
+		fix = true
+	default:
+		fmt.Printf("Usage: %s [--fix] <file.manifest|file.aof>\n", argv[0])
+		os.Exit(1)
+	}
+
+	// 解码器出错时会输出跟踪日志 加载rdb时也会输出日志 这里只需要检查的结果
+	log.SetOutput(ioutil.Discard)
+	switch getInputFileType(filename) {
+	case AOF_MULTI_PART:
+		checkMultiPartAof(filename, fix)
+	case AOF_RDB_PREAMBLE:
+		checkOldStyleAof(filename, fix, true)
+	case AOF_RESP:
+		checkOldStyleAof(filename, fix, false)
+	}
+}
+
+// getInputFileType 根据文件的内容判断输入文件的类型
+func getInputFileType(filename string) int {
+	if fileIsManifest(filename) {
+		return AOF_MULTI_PART
+	} else if fileIsRDB(filename) {
+		return AOF_RDB_PREAMBLE
+	}
+	return AOF_RESP
+}
+
+// fileIsRDB 文件以rdb的签名开头
+func fileIsRDB(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		fmt.Printf("Cannot open file %s: %s\n", filename, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	sig := make([]byte, 5)
+	n, _ := io.ReadFull(f, sig)
+	return n == len(sig) && string(sig) == "REDIS"
+}
+
+// fileIsManifest 跳过注释后第一行以file开头
+func fileIsManifest(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		fmt.Printf("Cannot open file %s: %s\n", filename, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if strings.HasPrefix(line, "#") && err == nil {
+			continue
+		}
+		return strings.HasPrefix(line, "file ")
+	}
+}
+
+// checkMultiPartAof 按顺序检查manifest中的BASE和INCR文件
+func checkMultiPartAof(manifestFilepath string, fix bool) {
+	fmt.Println("Start checking Multi Part AOF")
+	base, incrs, err := core.AofManifestFiles(manifestFilepath)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	dirpath := filepath.Dir(manifestFilepath)
+	totalNum := len(incrs)
+	if base != "" {
+		totalNum++
+	}
+	aofNum := 0
+
+	if base != "" {
+		aofFilepath := filepath.Join(dirpath, base)
+		aofNum++
+		preamble := fileIsRDB(aofFilepath)
+		format := "RESP"
+		if preamble {
+			format = "RDB"
+		}
+		fmt.Printf("Start to check BASE AOF (%s format).\n", format)
+		ret := checkSingleAof(base, aofFilepath, aofNum == totalNum, fix, preamble)
+		printAofStyle(ret, base, "BASE AOF")
+	}
+
+	if len(incrs) > 0 {
+		fmt.Println("Start to check INCR files.")
+		for _, incr := range incrs {
+			aofFilepath := filepath.Join(dirpath, incr)
+			aofNum++
+			ret := checkSingleAof(incr, aofFilepath, aofNum == totalNum, fix, false)
+			printAofStyle(ret, incr, "INCR AOF")
+		}
+	}
+	fmt.Println("All AOF files and manifest are valid")
+}
+
+// checkOldStyleAof 检查单个aof文件
+func checkOldStyleAof(filename string, fix bool, preamble bool) {
+	fmt.Println("Start checking Old-Style AOF")
+	ret := checkSingleAof(filename, filename, true, fix, preamble)
+	printAofStyle(ret, filename, "AOF")
+}
+
+// printAofStyle 输出检查单个文件的结果
+func printAofStyle(ret int, aofFileName string, aofType string) {
+	switch ret {
+	case AOF_CHECK_OK:
+		fmt.Printf("%s %s is valid\n", aofType, aofFileName)
+	case AOF_CHECK_EMPTY:
+		fmt.Printf("%s %s is empty\n", aofType, aofFileName)
+	case AOF_CHECK_TRUNCATED:
+		fmt.Printf("Successfully truncated AOF %s\n", aofFileName)
+	}
+}
+
+// checkSingleAof 检查单个文件 文件以rdb开头时先检查rdb 再检查其后的命令
+// 只有最后一个文件可以修复 其它文件损坏时退出
+func checkSingleAof(aofFilename string, aofFilepath string, lastFile bool, fix bool, preamble bool) int {
+	f, err := os.OpenFile(aofFilepath, os.O_RDWR, 0)
+	if err != nil {
+		fmt.Printf("Cannot open file %s: %s, aborting...\n", aofFilepath, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		fmt.Printf("Cannot stat file: %s, aborting...\n", aofFilename)
+		os.Exit(1)
+	}
+	size := fi.Size()
+	if size == 0 {
+		return AOF_CHECK_EMPTY
+	}
+
+	var start int64
+	if preamble {
+		start = checkRdbPreamble(f, aofFilename)
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			fmt.Printf("Cannot seek file: %s, aborting...\n", aofFilename)
+			os.Exit(1)
+		}
+	}
+
+	pos, line := process(f, start, size)
+	diff := size - pos
+	fmt.Printf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, ok_up_to_line=%d, diff=%d\n",
+		aofFilename, size, pos, line, diff)
+	if diff > 0 {
+		if fix {
+			if !lastFile {
+				fmt.Printf("Failed to truncate AOF %s because it is not the last file\n", aofFilename)
+				os.Exit(1)
+			}
+			fmt.Printf("This will shrink the AOF %s from %d bytes, with %d bytes, to %d bytes\n", aofFilename, size, diff, pos)
+			fmt.Print("Continue? [y/N]: ")
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			if !strings.HasPrefix(strings.ToLower(answer), "y") {
+				fmt.Println("Aborting...")
+				os.Exit(1)
+			}
+			if err := f.Truncate(pos); err != nil {
+				fmt.Printf("Failed to truncate AOF %s\n", aofFilename)
+				os.Exit(1)
+			}
+			return AOF_CHECK_TRUNCATED
+		}
+		fmt.Printf("AOF %s is not valid. Use the --fix option to try fixing it.\n", aofFilename)
+		os.Exit(1)
+	}
+	return AOF_CHECK_OK
+}
+
+// checkRdbPreamble 检查文件开头的rdb 返回rdb结束的位置 rdb损坏时无法修复 直接退出
+func checkRdbPreamble(f *os.File, aofFilename string) int64 {
+	fmt.Printf("The AOF appears to start with an RDB preamble.\nChecking the RDB preamble to start:\n")
+	pos, err := core.RdbCheck(f)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		fmt.Printf("RDB preamble of AOF file %s is not sane, aborting.\n", aofFilename)
+		os.Exit(1)
+	}
+	fmt.Println("RDB preamble is OK, proceeding with AOF tail...")
+	return pos
+}
+
+// process 从start开始依次解析aof中的命令 返回最后一条完整命令结束的位置以及到该位置的行数
+// 每条命令占 1+2*参数个数 行 遇到不完整或不合法的命令时输出其位置和原因
+func process(f *os.File, start int64, size int64) (int64, int64) {
+	decoder := proto.NewDecoder(f)
+	pos := start
+	var line int64
+	for {
+		r, err := decoder.Decode()
+		if err != nil {
+			if pos == size && errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				printError(pos, "Premature end of file")
+			} else {
+				printError(pos, err.Error())
+			}
+			break
+		}
+		if !isValidCommand(r) {
+			printError(pos, "Expected an array of bulk strings")
+			break
+		}
+		pos = start + decoder.Offset()
+		line += 1 + 2*int64(len(r.Array))
+	}
+	return pos, line
+}
+
+// isValidCommand aof中的命令必须是非空的批量回复数组
+func isValidCommand(r *proto.Resp) bool {
+	if r.Type != proto.TypeArray || len(r.Array) == 0 {
+		return false
+	}
+	for _, arg := range r.Array {
+		if arg.Type != proto.TypeBulkBytes || arg.Value == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// printError 输出出错的命令在文件中的位置
+func printError(pos int64, msg string) {
+	fmt.Printf("0x%16x: %s\n", pos, msg)
+}
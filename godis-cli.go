@@ -2,16 +2,22 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"godis/core/proto"
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 )
 
 func main() {
-	IPPort := "127.0.0.1:9736"
+	//-h 服务端地址 -p 服务端端口
+	host := flag.String("h", "127.0.0.1", "Server hostname")
+	port := flag.Int("p", 9736, "Server port")
+	flag.Parse()
+	IPPort := net.JoinHostPort(*host, strconv.Itoa(*port))
 
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("Hi Godis")
@@ -24,27 +30,105 @@ func main() {
 	defer conn.Close()
 	//log.Println(tcpAddr, conn.LocalAddr(), conn.RemoteAddr())
 
+	//持久的解码器 回复可能跨越多次读取
+	decoder := proto.NewDecoder(conn)
 	for {
 		fmt.Print(IPPort + "> ")
-		text, _ := reader.ReadString('\n')
+		text, err := reader.ReadString('\n')
 		//清除掉回车换行符
-		text = strings.Replace(text, "\n", "", -1)
-		send2Server(text, conn)
+		text = strings.TrimSpace(text)
+		if text == "" {
+			if err != nil {
+				return
+			}
+			continue
+		}
+		_, err = send2Server(text, conn)
+		checkError(err)
 
-		buff := make([]byte, 1024)
-		n, err := conn.Read(buff)
-		resp, er := proto.DecodeFromBytes(buff)
+		resp, err := decoder.Decode()
 		checkError(err)
-		if n == 0 {
-			fmt.Println(IPPort+"> ", "nil")
-		} else if er == nil {
-			fmt.Println(IPPort+">", string(resp.Value))
-		} else {
-			fmt.Println(IPPort+"> ", "err server response")
+		fmt.Println(formatReply(resp, ""))
+
+		//进入订阅模式后 持续打印收到的消息
+		if strings.EqualFold(strings.Fields(text)[0], "subscribe") {
+			for {
+				resp, err := decoder.Decode()
+				checkError(err)
+				fmt.Println(formatReply(resp, ""))
+			}
 		}
 	}
 
 }
+
+// formatReply 按redis-cli的格式输出回复 indent为嵌套数组的缩进
+func formatReply(r *proto.Resp, indent string) string {
+	switch r.Type {
+	case proto.TypeString:
+		return string(r.Value)
+	case proto.TypeError:
+		return "(error) " + string(r.Value)
+	case proto.TypeInt:
+		return "(integer) " + string(r.Value)
+	case proto.TypeBulkBytes:
+		if r.Value == nil {
+			return "(nil)"
+		}
+		return strconv.Quote(string(r.Value))
+	case proto.TypeArray, proto.TypeSet, proto.TypePush:
+		if r.Array == nil {
+			return "(nil)"
+		}
+		if len(r.Array) == 0 {
+			return "(empty array)"
+		}
+		var lines []string
+		width := len(strconv.Itoa(len(r.Array)))
+		for i, item := range r.Array {
+			prefix := fmt.Sprintf("%*d) ", width, i+1)
+			line := formatReply(item, indent+strings.Repeat(" ", len(prefix)))
+			if i > 0 {
+				prefix = indent + prefix
+			}
+			lines = append(lines, prefix+line)
+		}
+		return strings.Join(lines, "\n")
+	case proto.TypeMap:
+		if len(r.Array) == 0 {
+			return "(empty hash)"
+		}
+		//键值交替存放 每行输出一个键值对
+		var lines []string
+		width := len(strconv.Itoa(len(r.Array) / 2))
+		for i := 0; i+1 < len(r.Array); i += 2 {
+			prefix := fmt.Sprintf("%*d# ", width, i/2+1)
+			key := formatReply(r.Array[i], indent+strings.Repeat(" ", len(prefix)))
+			value := formatReply(r.Array[i+1], indent+strings.Repeat(" ", len(prefix)+len(key)+4))
+			if i > 0 {
+				prefix = indent + prefix
+			}
+			lines = append(lines, prefix+key+" => "+value)
+		}
+		return strings.Join(lines, "\n")
+	case proto.TypeDouble:
+		return "(double) " + string(r.Value)
+	case proto.TypeBoolean:
+		if string(r.Value) == "t" {
+			return "(true)"
+		}
+		return "(false)"
+	case proto.TypeNull:
+		return "(nil)"
+	case proto.TypeBigNumber:
+		return "(big number) " + string(r.Value)
+	case proto.TypeVerbatim:
+		//去掉格式前缀 原样输出
+		return string(r.Value[4:])
+	default:
+		return "err server response"
+	}
+}
 func send2Server(msg string, conn net.Conn) (n int, err error) {
 	p, e := proto.EncodeCmd(msg)
 	if e != nil {
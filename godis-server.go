@@ -7,22 +7,26 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
-const (
-	DefaultAofFile = "./godis.aof"
-)
-
 // 服务端实例
 var godis = new(core.Server)
 
 func main() {
 	/*---- 命令行参数处理 ----*/
+	godis.InitServerConfig()
 	argv := os.Args
 	argc := len(os.Args)
 	if argc >= 2 {
+		j := 1 /* First option to parse in argv[] */
+		options := ""
+		configFromStdin := false
+
 		/* Handle special options --help and --version */
 		if argv[1] == "-v" || argv[1] == "--version" {
 			version()
@@ -30,24 +34,81 @@ func main() {
 		if argv[1] == "--help" || argv[1] == "-h" {
 			usage()
 		}
+
+		/* First argument is the config file name? */
+		if argv[1][0] != '-' {
+			configfile, err := filepath.Abs(argv[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+			godis.ConfigFile = configfile
+			j = 2
+		}
+
+		/* All the other options are parsed and conceptually appended to the
+		 * configuration file. For instance --port 6380 will generate the
+		 * string "port 6380\n" to be parsed after the actual config file
+		 * and stdin input are parsed (if they exist). */
+		for ; j < argc; j++ {
+			if argv[j] == "-" {
+				/* Read config from stdin */
+				configFromStdin = true
+			} else if strings.HasPrefix(argv[j], "--") {
+				/* Option name */
+				if options != "" {
+					options += "\n"
+				}
+				options += argv[j][2:] + " "
+			} else {
+				/* Option argument */
+				options += core.QuoteConfigArg(argv[j]) + " "
+			}
+		}
+		if err := godis.LoadServerConfig(godis.ConfigFile, configFromStdin, options); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
+	if godis.Logfile != "" {
+		f, err := os.OpenFile(godis.Logfile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't open the log file: %s\n", err)
+			os.Exit(1)
+		}
+		log.SetOutput(f)
+	}
+
+	/*---- 初始化服务端实例 ----*/
+	initServer()
+	go godis.AeMain()
 
 	/*---- 监听信号 平滑退出 ----*/
+	// 退出前需要在事件循环中写入aof 因此在事件循环启动之后处理信号
 	c := make(chan os.Signal)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
 	go sigHandler(c)
 
-	/*---- 初始化服务端实例 ----*/
-	initServer()
-
 	/*---- 网络处理 ----*/
-	netListen, err := net.Listen("tcp", "127.0.0.1:9736")
-	if err != nil {
-		log.Print("listen err ")
+	// 在每个绑定的地址上监听 任一地址监听失败则退出
+	listeners := make([]net.Listener, 0, len(godis.Bindaddr))
+	for _, addr := range godis.Bindaddr {
+		netListen, err := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(godis.Port)))
+		if err != nil {
+			log.Fatalf("Could not create server TCP listening socket %s:%d: %s", addr, godis.Port, err)
+		}
+		defer netListen.Close()
+		listeners = append(listeners, netListen)
 	}
-	//checkError(err)
-	defer netListen.Close()
+	log.Printf("Ready to accept connections on port %d", godis.Port)
+
+	for _, netListen := range listeners[1:] {
+		go acceptLoop(netListen)
+	}
+	acceptLoop(listeners[0])
+}
 
+// acceptLoop 接受连接 每个连接由独立的goroutine处理
+func acceptLoop(netListen net.Listener) {
 	for {
 		conn, err := netListen.Accept()
 
@@ -55,101 +116,68 @@ func main() {
 			continue
 		}
 		//log.Println(conn.LocalAddr(), conn.RemoteAddr())
-		go handle(conn)
+		go godis.ServeClient(conn)
 	}
 }
 
-// 处理请求
-func handle(conn net.Conn) {
-	c := godis.CreateClient()
-	for {
-		if c.Flags&core.CLIENT_PUBSUB > 0 {
-			if c.Buf != "" {
-				responseConn(conn, c)
-				c.Buf = ""
-			}
-			time.Sleep(1)
-
-		} else {
-			err := c.ReadQueryFromClient(conn)
-
-			if err != nil {
-				log.Println("readQueryFromClient err", err)
-				return
-			}
-			err = c.ProcessInputBuffer()
-			if err != nil {
-				log.Println("ProcessInputBuffer err", err)
-				return
-			}
-			godis.ProcessCommand(c)
-			responseConn(conn, c)
-		}
-	}
-}
-
-// 响应返回给客户端
-func responseConn(conn net.Conn, c *core.Client) {
-	conn.Write([]byte(c.Buf))
-}
-
 // 初始化服务端实例
 func initServer() {
 	godis.Pid = os.Getpid()
-	godis.DbNum = 16
 	initDb()
+	godis.CreateEventLoop()
 	godis.Start = time.Now().UnixNano() / 1000000
 	//var getf server.CmdFun
-	godis.AofFilename = DefaultAofFile
-
-	getCommand := &core.GodisCommand{Name: "get", Proc: core.GetCommand}
-	setCommand := &core.GodisCommand{Name: "set", Proc: core.SetCommand}
-	subscribeCommand := &core.GodisCommand{Name: "subscribe", Proc: core.SubscribeCommand}
-	publishCommand := &core.GodisCommand{Name: "publish", Proc: core.PublishCommand}
-	geoaddCommand := &core.GodisCommand{Name: "geoadd", Proc: core.GeoAddCommand}
-	geohashCommand := &core.GodisCommand{Name: "geohash", Proc: core.GeoHashCommand}
-	geoposCommand := &core.GodisCommand{Name: "geopos", Proc: core.GeoPosCommand}
-	geodistCommand := &core.GodisCommand{Name: "geodist", Proc: core.GeoDistCommand}
-	georadiusCommand := &core.GodisCommand{Name: "georadius", Proc: core.GeoRadiusCommand}
-	georadiusbymemberCommand := &core.GodisCommand{Name: "georadiusbymember", Proc: core.GeoRadiusByMemberCommand}
-
-	godis.Commands = map[string]*core.GodisCommand{
-		"get":               getCommand,
-		"set":               setCommand,
-		"geoadd":            geoaddCommand,
-		"geohash":           geohashCommand,
-		"geopos":            geoposCommand,
-		"geodist":           geodistCommand,
-		"georadius":         georadiusCommand,
-		"georadiusbymember": georadiusbymemberCommand,
-		"subscribe":         subscribeCommand,
-		"publish":           publishCommand,
-	}
+	// aof中尚未选择db 第一条写入的命令前会先写入SELECT
+	godis.AofSelectedDb = -1
+	godis.AofRewriteTimeStart = -1
+	godis.RdbSaveTimeStart = -1
+	godis.Lastsave = time.Now().Unix() /* At startup we consider the DB saved. */
+
+	godis.PopulateCommandTable()
 	tmp := make(map[string]*core.List)
 	godis.PubSubChannels = &tmp
+	godis.BioInit()
+	if godis.AofEnabled {
+		godis.AofState = core.AOF_ON
+	}
+	godis.AofLoadManifestFromDisk()
 	LoadData()
+
+	// 加载完数据之后打开最后一个INCR文件 之后的写命令追加到其末尾
+	if err := godis.OpenAppendOnlyFile(); err != nil {
+		log.Fatalf("%s", err)
+	}
+	godis.AofDelHistoryFiles()
 }
 
 // 初始化db
 func initDb() {
 	godis.Db = make([]*core.GodisDb, godis.DbNum)
 	for i := 0; i < godis.DbNum; i++ {
-		godis.Db[i] = new(core.GodisDb)
-		godis.Db[i].Dict = make(map[string]*core.GodisObject, 100)
+		godis.Db[i] = godis.CreateDb(i)
 	}
 }
+
+// LoadData 启动时加载数据 打开aof时从aof中加载 aof不存在或没有打开aof时从rdb中加载
 func LoadData() {
-	c := godis.CreateClient()
-	c.FakeFlag = true
-	pros := core.ReadAof(godis.AofFilename)
-	for _, v := range pros {
-		c.QueryBuf = string(v)
-		err := c.ProcessInputBuffer()
-		if err != nil {
-			log.Println("ProcessInputBuffer err", err)
+	start := time.Now()
+	if godis.AofState == core.AOF_ON {
+		ret := godis.LoadAppendOnlyFiles()
+		if ret == core.AOF_OK {
+			log.Printf("DB loaded from append only file: %.3f seconds", time.Since(start).Seconds())
+		}
+		if ret != core.AOF_NOT_EXIST {
+			return
 		}
-		godis.ProcessCommand(c)
 	}
+	if err := godis.RdbLoad(godis.RdbFilename); err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Fatal error loading the DB: %s. Exiting.", err)
+			os.Exit(1)
+		}
+		return
+	}
+	log.Printf("DB loaded from disk: %.3f seconds", time.Since(start).Seconds())
 }
 
 func sigHandler(c chan os.Signal) {
@@ -165,12 +193,18 @@ func sigHandler(c chan os.Signal) {
 
 func exitHandler() {
 	fmt.Println("exiting smoothly ...")
+	var ret int
+	godis.Exec(func() { ret = godis.PrepareForShutdown() })
+	if ret != core.C_OK {
+		log.Println("Errors trying to shut down the server, check the logs for more information.")
+		return
+	}
 	fmt.Println("bye ")
 	os.Exit(0)
 }
 
 func version() {
-	println("Godis server v=0.0.1 sha=xxxxxxx:001 malloc=libc-go bits=64 ")
+	println("Godis server v=" + core.GODIS_VERSION + " sha=xxxxxxx:001 malloc=libc-go bits=64 ")
 	os.Exit(0)
 }
 
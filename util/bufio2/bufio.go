@@ -10,9 +10,10 @@ type Reader struct {
 	err error
 	buf []byte
 
-	rd   io.Reader
-	rpos int
-	wpos int
+	rd    io.Reader
+	rpos  int
+	wpos  int
+	nread int64 // 从rd中读取的总字节数
 
 	slice sliceAlloc
 }
@@ -41,12 +42,16 @@ func (b *Reader) fill() error {
 		b.wpos = n
 	}
 	n, err := b.rd.Read(b.buf[b.wpos:])
+	// 读到数据的同时返回的错误(如EOF)留到下一次读取 先使用已读到的数据
+	b.wpos += n
+	b.nread += int64(n)
+	if n > 0 {
+		return nil
+	}
 	if err != nil {
 		b.err = err
-	} else if n == 0 {
-		b.err = io.ErrNoProgress
 	} else {
-		b.wpos += n
+		b.err = io.ErrNoProgress
 	}
 	return b.err
 }
@@ -54,6 +59,11 @@ func (b *Reader) fill() error {
 func (b *Reader) buffered() int {
 	return b.wpos - b.rpos
 }
+
+// Offset 已经读出的字节数 即下一个要读的字节在rd中的位置
+func (b *Reader) Offset() int64 {
+	return b.nread - int64(b.buffered())
+}
 func (b *Reader) ReadByte() (byte, error) {
 	if b.err != nil {
 		return 0, b.err
@@ -116,16 +126,38 @@ func (b *Reader) ReadSlice(delim byte) ([]byte, error) {
 	}
 }
 
+func (b *Reader) Read(p []byte) (int, error) {
+	if b.err != nil || len(p) == 0 {
+		return 0, b.err
+	}
+	if b.buffered() == 0 {
+		// 读取的数据不小于缓冲区时直接读入p 避免多一次拷贝
+		if len(p) >= len(b.buf) {
+			n, err := b.rd.Read(p)
+			if err != nil {
+				b.err = err
+			}
+			b.nread += int64(n)
+			return n, b.err
+		}
+		if b.fill() != nil {
+			return 0, b.err
+		}
+	}
+	n := copy(p, b.buf[b.rpos:b.wpos])
+	b.rpos += n
+	return n, nil
+}
+
+// ReadFull 读取n个字节 缓冲区中的数据不足时继续从底层reader读取
 func (b *Reader) ReadFull(n int) ([]byte, error) {
-	//return b.buf[]
 	if b.err != nil || n == 0 {
 		return nil, b.err
 	}
 	var buf = b.slice.Make(n)
-	if _, err := io.ReadFull(bytes.NewReader(b.buf[b.rpos:]), buf); err != nil {
+	if _, err := io.ReadFull(b, buf); err != nil {
 		return nil, err
 	}
-	b.rpos += n
 	return buf, nil
 }
 
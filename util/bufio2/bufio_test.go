@@ -0,0 +1,73 @@
+package bufio2
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// readAll 轮流用各个读取方法读到EOF 每次读取后检查Offset
+func readAll(t *testing.T, b *Reader, data []byte) []byte {
+	t.Helper()
+	var out []byte
+	for i := 0; ; i++ {
+		var p []byte
+		var err error
+		switch i % 4 {
+		case 0:
+			var c byte
+			if c, err = b.ReadByte(); err == nil {
+				p = []byte{c}
+			}
+		case 1:
+			p, err = b.ReadBytes('\n')
+		case 2:
+			p, err = b.ReadFull(3)
+		case 3:
+			buf := make([]byte, 40)
+			var n int
+			n, err = b.Read(buf)
+			p = buf[:n]
+		}
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				t.Fatalf("read error: %v", err)
+			}
+			/* ReadFull consumes the bytes left before the EOF. */
+			if off := b.Offset(); off != int64(len(data)) {
+				t.Fatalf("Offset() = %d at EOF, want %d", off, len(data))
+			}
+			return out
+		}
+		out = append(out, p...)
+		if off := b.Offset(); off != int64(len(out)) {
+			t.Fatalf("Offset() = %d, read %d bytes", off, len(out))
+		}
+		if !bytes.Equal(out, data[:len(out)]) {
+			t.Fatalf("read %q, want %q", out, data[:len(out)])
+		}
+	}
+}
+
+func TestReaderOffset(t *testing.T) {
+	var data []byte
+	for i := 0; i < 200; i++ {
+		data = append(data, bytes.Repeat([]byte{'a' + byte(i%26)}, i%37)...)
+		data = append(data, '\n')
+	}
+	readers := map[string]func() io.Reader{
+		"plain":   func() io.Reader { return bytes.NewReader(data) },
+		"onebyte": func() io.Reader { return iotest.OneByteReader(bytes.NewReader(data)) },
+		"half":    func() io.Reader { return iotest.HalfReader(bytes.NewReader(data)) },
+		"dataerr": func() io.Reader { return iotest.DataErrReader(bytes.NewReader(data)) },
+	}
+	for name, rd := range readers {
+		for _, size := range []int{16, 64, 1024} {
+			b := NewReaderSize(rd(), size)
+			if out := readAll(t, b, data); len(data)-len(out) >= 3 {
+				t.Errorf("%s/%d: read %d bytes, want %d", name, size, len(out), len(data))
+			}
+		}
+	}
+}